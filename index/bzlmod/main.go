@@ -17,16 +17,14 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
 
-	"golang.org/x/sync/errgroup"
-
 	"github.com/EngFlow/gazelle_cc/index/internal/bcr"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer/cli"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/bazelbuild/buildtools/build"
 )
@@ -35,84 +33,76 @@ import (
 // The created index can be used as input for gazelle_cc allowing to resolve external dependenices.
 func main() {
 	moduleBazelPath := flag.String("module_bazel", "./MODULE.bazel", "Path to MODULE.bazel containg bazel_dep directives")
+	extraLibraryKinds := flag.String("extra-library-kinds", "", "Comma separated rule class patterns of custom rules defining public libraries, e.g. my_cc_library")
+	// The processing is mostly IO-bound (downloading artifacts, bazel query), by default use up-to number of available CPUs to not overschedule
+	jobs := flag.Int("jobs", runtime.GOMAXPROCS(0), "Number of modules resolved concurrently (default number of available CPUs)")
+	bcrConfig := bcr.NewBazelRegistryConfig()
+	flag.BoolVar(&bcrConfig.Offline, "offline", false, "Use existing registry checkout without fetching updates (default false)")
+	flag.StringVar(&bcrConfig.RegistryPath, "registry-path", "", "Path to a local registry used instead of cloning the Bazel Central Registry")
+	flag.Func("registry-url", "Git URL of registry used to resolve modules, can be repeated to define multiple registries in order of precedence (default Bazel Central Registry)", func(url string) error {
+		bcrConfig.RegistryURLs = append(bcrConfig.RegistryURLs, url)
+		return nil
+	})
+	flag.StringVar(&bcrConfig.CABundle, "ca-bundle", "", "Path to PEM file with additional root certificates trusted when downloading module sources")
+	flag.Func("public-api-target", "Index only headers reachable from the public API target of module, defined as <module>=<label>. Can be repeated", bcrConfig.AddPublicAPITarget)
 	flag.Parse()
 
 	callerRoot, err := cli.ResolveWorkingDir()
 	if err != nil {
-		log.Fatalf("Failed to resolve working directory for indexer")
+		logging.Fatalf("Failed to resolve working directory for indexer")
 	}
-	log.Printf("Would run in %v", callerRoot)
+	logging.Infof("Would run in %v", callerRoot)
 
 	absModuleBazelPath := *moduleBazelPath
 	if !filepath.IsAbs(absModuleBazelPath) {
 		absModuleBazelPath = filepath.Join(callerRoot, absModuleBazelPath)
 	}
 
-	bcrConfig := bcr.NewBazelRegistryConfig()
-	bcrConfig.Verbose = *cli.Verbose
+	bcrConfig.ExtraLibraryKinds = bcr.ParseLibraryKinds(*extraLibraryKinds)
 	bcrClient, err := bcr.CheckoutBazelRegistry(bcrConfig)
 	if err != nil {
-		log.Fatalf("Failed to checkout Bazel central registry: %v", err)
+		logging.Fatalf("Failed to checkout Bazel central registry: %v", err)
 	}
 
-	if bcrConfig.Verbose {
-		log.Printf("Parsing %v to find bazel_dep directives", absModuleBazelPath)
-	}
-	modules := resolveBazelDepModules(absModuleBazelPath, bcrClient)
+	logging.Debugf("Parsing %v to find bazel_dep directives", absModuleBazelPath)
+	modules := resolveBazelDepModules(absModuleBazelPath, bcrClient, *jobs)
 	indexingResult := indexer.CreateHeaderIndex(modules)
-	indexingResult.WriteToFile(cli.ResolveOutputFile())
+	indexingResult.WriteToFile(cli.ResolveOutputFile(), cli.IsDryRun(), cli.IsBinaryOutput())
 
-	if *cli.Verbose {
-		log.Println(indexingResult.String())
-	}
+	logging.Debugf("%v", indexingResult.String())
 }
 
-func resolveBazelDepModules(moduleBzlPath string, bcrClient bcr.BazelRegistry) []indexer.Module {
+func resolveBazelDepModules(moduleBzlPath string, bcrClient bcr.BazelRegistry, jobs int) []indexer.Module {
 	// Parse MODULE.bazel to extract dependencies
 	content, err := os.ReadFile(moduleBzlPath)
 	if err != nil {
-		log.Fatalf("Failed to read file: %v - %v", moduleBzlPath, err)
+		logging.Fatalf("Failed to read file: %v - %v", moduleBzlPath, err)
 	}
 	moduleFile, err := build.ParseModule(filepath.Base(moduleBzlPath), content)
 	if err != nil {
-		log.Fatalf("Failed to parse: %v - %v", moduleBzlPath, err)
+		logging.Fatalf("Failed to parse: %v - %v", moduleBzlPath, err)
 	}
 	bazelDeps := extractBazelDependencies(*moduleFile)
 
-	// The processing is mostly IO-bound (downloading artifacts, bazel query), use up-to number of available CPUs to not overschedule
-	workerCount := runtime.GOMAXPROCS(0)
-	// Use semaphore pattern for bounded concurrency
-	sem := make(chan struct{}, workerCount)
-	results := make([]bcr.ResolveModuleInfoResult, len(bazelDeps))
-
-	var eg errgroup.Group
-	for i, dep := range bazelDeps {
-		sem <- struct{}{} // acquire semaphore
-		eg.Go(func() error {
-			defer func() { <-sem }() // release semaphore
-
-			result := bcrClient.ResolveModuleInfo(dep.Name, dep.Version)
-			results[i] = result
-
-			if *cli.Verbose {
-				if result.IsResolved() {
-					fmt.Fprintf(os.Stderr, "%-50s: resolved - cc_libraries: %d\n", result.Info.Module.String(), len(result.Info.Targets))
-				} else {
-					fmt.Fprintf(os.Stderr, "%-50s: failed   - %s\n", result.Unresolved.Module.String(), result.Unresolved.Reason)
-				}
-			}
-			return nil
-		})
-	}
+	modules := collections.MapSlice(bazelDeps, func(dep bazelDependency) bcr.ModuleVersion {
+		return bcr.ModuleVersion{Name: dep.Name, Version: dep.Version}
+	})
+	results := bcrClient.ResolveModuleInfos(modules, jobs)
 
-	if err := eg.Wait(); err != nil {
-		log.Fatalf("Failed to resolve modules: %v", err)
+	resolvedModules, emptyModules, failedModules := classifyModules(results)
+	fmt.Printf("Found %d modules with non-empty cc_library defs: %v\n", len(resolvedModules), collections.MapSlice(resolvedModules, func(m indexer.Module) string { return m.Repository }))
+	if len(emptyModules) > 0 {
+		fmt.Printf("Found %d modules without cc_library defs: %v\n", len(emptyModules), emptyModules)
+	}
+	if len(failedModules) > 0 {
+		fmt.Printf("Failed to gather module information for %d modules: %v\n", len(failedModules), failedModules)
 	}
 
-	// Collect results
-	var resolvedModules []indexer.Module
-	var emptyModules []string
-	var failedModules []string
+	return resolvedModules
+}
+
+// Splits module resolution results into indexable modules, names of modules resolved without any cc_library targets and names of unresolved modules.
+func classifyModules(results []bcr.ResolveModuleInfoResult) (resolvedModules []indexer.Module, emptyModules []string, failedModules []string) {
 	for _, result := range results {
 		switch {
 		case result.IsResolved() && len(result.Info.Targets) > 0:
@@ -120,22 +110,13 @@ func resolveBazelDepModules(moduleBzlPath string, bcrClient bcr.BazelRegistry) [
 				resolvedModules,
 				result.Info.ToIndexerModule().WithAmbiguousTargetsResolved(),
 			)
-		case result.IsResolved() && len(result.Info.Targets) == 0:
+		case result.IsResolved():
 			emptyModules = append(emptyModules, result.Info.Module.Name)
 		case result.IsUnresolved():
 			failedModules = append(failedModules, result.Unresolved.Module.Name)
 		}
 	}
-
-	fmt.Printf("Found %d modules with non-empty cc_library defs: %v\n", len(resolvedModules), collections.MapSlice(resolvedModules, func(m indexer.Module) string { return m.Repository }))
-	if len(emptyModules) > 0 {
-		fmt.Printf("Found %d modules without cc_library defs: %v\n", len(emptyModules), emptyModules)
-	}
-	if len(failedModules) > 0 {
-		fmt.Printf("Failed to gather module information for %d modules: %v\n", len(failedModules), failedModules)
-	}
-
-	return resolvedModules
+	return resolvedModules, emptyModules, failedModules
 }
 
 type bazelDependency struct {
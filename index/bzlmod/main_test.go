@@ -0,0 +1,51 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/bcr"
+)
+
+func TestClassifyModules(t *testing.T) {
+	resolved := func(name string, targets ...bcr.ModuleTarget) bcr.ResolveModuleInfoResult {
+		return bcr.ResolveModuleInfoResult{Info: &bcr.ModuleInfo{
+			Module:  bcr.ModuleVersion{Name: name, Version: "1.0"},
+			Targets: targets,
+		}}
+	}
+	failed := bcr.ResolveModuleInfoResult{Unresolved: &struct {
+		Module bcr.ModuleVersion `json:"module"`
+		Reason string            `json:"reason"`
+	}{Module: bcr.ModuleVersion{Name: "broken"}, Reason: "No metadata.json"}}
+
+	resolvedModules, emptyModules, failedModules := classifyModules([]bcr.ResolveModuleInfoResult{
+		resolved("fmt", bcr.ModuleTarget{
+			Name: label.New("", "", "fmt"),
+			Hdrs: []label.Label{label.New("", "", "include/fmt/core.h")},
+		}),
+		resolved("rules_cc"),
+		failed,
+	})
+
+	assert.Len(t, resolvedModules, 1)
+	assert.Equal(t, "fmt", resolvedModules[0].Repository)
+	assert.Equal(t, []string{"rules_cc"}, emptyModules)
+	assert.Equal(t, []string{"broken"}, failedModules)
+}
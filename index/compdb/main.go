@@ -0,0 +1,302 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
+	"github.com/EngFlow/gazelle_cc/index/internal/indexer/cli"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/language/cc/api"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Creates an index defining mapping between headers and the Bazel rules expected to define them, based on the
+// compilation database (compile_commands.json) of an existing build, e.g. exported by CMake.
+// Headers included by the compiled sources are assigned to a target named after the deepest include directory
+// containing them, or after their own directory if they're reachable only relative to the including file.
+// The created index can be used as input for gazelle_cc when migrating the project to Bazel.
+func main() {
+	compdbPath := flag.String("compdb", "compile_commands.json", "Path to the compilation database, relative paths are resolved against the repository directory")
+	// Other flags registered implicitlly by import of indexer/cli
+	flag.Parse()
+	workdir, err := cli.ResolveWorkingDir()
+	if err != nil {
+		logging.Fatalf("Failed to resolve working directory, %v", err)
+	}
+	outputFile := cli.ResolveOutputFile()
+
+	compdbFile := *compdbPath
+	if !filepath.IsAbs(compdbFile) {
+		compdbFile = filepath.Join(workdir, compdbFile)
+	}
+	commands, err := loadCompilationDatabase(compdbFile)
+	if err != nil {
+		logging.Fatalf("Failed to load compilation database: %v", err)
+	}
+	logging.Infof("Found %d compile commands in %v", len(commands), compdbFile)
+
+	indexingResult := indexer.CreateHeaderIndex([]indexer.Module{createModule(workdir, commands)})
+	indexingResult.WriteToFile(outputFile, cli.IsDryRun(), cli.IsBinaryOutput())
+
+	logging.Debugf("%v", indexingResult.String())
+}
+
+// compileCommand is a single entry of the compilation database, see
+// https://clang.llvm.org/docs/JSONCompilationDatabase.html
+type compileCommand struct {
+	Directory string   `json:"directory"`
+	File      string   `json:"file"`
+	Command   string   `json:"command"`
+	Arguments []string `json:"arguments"`
+}
+
+func loadCompilationDatabase(compdbFile string) ([]compileCommand, error) {
+	data, err := os.ReadFile(compdbFile)
+	if err != nil {
+		return nil, err
+	}
+	var commands []compileCommand
+	if err := json.Unmarshal(data, &commands); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", compdbFile, err)
+	}
+	return commands, nil
+}
+
+// args returns the arguments of the compiler invocation, splitting the command
+// if the arguments are not listed explicitly.
+func (cmd compileCommand) args() []string {
+	if len(cmd.Arguments) > 0 {
+		return cmd.Arguments
+	}
+	return splitCommand(cmd.Command)
+}
+
+// resolve returns the cleaned absolute path, relative paths are resolved against the working directory of the command.
+func (cmd compileCommand) resolve(p string) string {
+	if !filepath.IsAbs(p) {
+		p = filepath.Join(cmd.Directory, p)
+	}
+	return filepath.Clean(p)
+}
+
+// Compiler flags adding a directory to the search path of includes, the value
+// can be either attached to the flag or passed as the next argument.
+var includeDirFlags = []string{"-I", "-iquote", "-isystem", "-idirafter"}
+
+// includeDirs returns absolute paths of the directories searched for included headers, in order of occurrence.
+func (cmd compileCommand) includeDirs() []string {
+	args := cmd.args()
+	var dirs []string
+	for i := 0; i < len(args); i++ {
+		for _, includeFlag := range includeDirFlags {
+			value, ok := strings.CutPrefix(args[i], includeFlag)
+			if !ok {
+				continue
+			}
+			if value == "" && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if value != "" {
+				dirs = append(dirs, cmd.resolve(value))
+			}
+			break
+		}
+	}
+	return dirs
+}
+
+// splitCommand splits the shell command into arguments, handling quoted and
+// escaped characters.
+func splitCommand(command string) []string {
+	var args []string
+	var current strings.Builder
+	inArg, escaped := false, false
+	var quote rune
+	for _, r := range command {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case unicode.IsSpace(r):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(r)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args
+}
+
+// createModule finds headers transitively included by the compiled sources and
+// assigns them to targets inferred from their location in the repository.
+func createModule(repoRoot string, commands []compileCommand) indexer.Module {
+	repoRoot = filepath.Clean(repoRoot)
+	includeDirs := make(collections.Set[string]) // repository-relative include directories
+	headers := make(collections.Set[string])     // repository-relative paths of included headers
+	for _, cmd := range commands {
+		dirs := cmd.includeDirs()
+		for _, dir := range dirs {
+			if rel, ok := repoRelative(repoRoot, dir); ok {
+				includeDirs.Add(rel)
+			}
+		}
+		collectIncludedHeaders(repoRoot, cmd.resolve(cmd.File), dirs, headers)
+	}
+
+	targets := make(map[string]*indexer.Target)
+	for _, header := range headers.SortedValues(strings.Compare) {
+		pkg, isIncludeDir := owningDirectory(header, includeDirs)
+		target, exists := targets[pkg]
+		if !exists {
+			target = &indexer.Target{
+				Name:     label.New("", pkg, targetName(repoRoot, pkg)),
+				Hdrs:     make(collections.Set[label.Label]),
+				Includes: make(collections.Set[string]),
+			}
+			targets[pkg] = target
+		}
+		if isIncludeDir {
+			target.Includes.Add(".")
+		}
+		target.Hdrs.Add(label.New("", pkg, strings.TrimPrefix(header, pkg+"/")))
+	}
+
+	var module indexer.Module
+	for _, pkg := range slices.Sorted(maps.Keys(targets)) {
+		module.Targets = append(module.Targets, *targets[pkg])
+	}
+	return module
+}
+
+// collectIncludedHeaders adds repository-relative paths of headers transitively
+// included by the source file. Quoted includes are searched in the directory of
+// the including file first, then in the include directories. Headers which
+// can't be found or are defined outside of the repository are skipped.
+func collectIncludedHeaders(repoRoot, sourceFile string, includeDirs []string, headers collections.Set[string]) {
+	visited := make(collections.Set[string])
+	queue := []string{sourceFile}
+	for len(queue) > 0 {
+		file := queue[0]
+		queue = queue[1:]
+		if visited.Contains(file) {
+			continue
+		}
+		visited.Add(file)
+
+		content, err := os.ReadFile(file)
+		if err != nil {
+			logging.Warnf("Failed to read %v, its includes would be skipped: %v", file, err)
+			continue
+		}
+		quoted, system := api.Includes(content)
+		addIncludes := func(includes []string, searchDirs []string) {
+			for _, include := range includes {
+				found, ok := findHeader(include, searchDirs)
+				if !ok {
+					continue
+				}
+				if rel, ok := repoRelative(repoRoot, found); ok {
+					headers.Add(rel)
+					queue = append(queue, found)
+				}
+			}
+		}
+		addIncludes(quoted, slices.Concat([]string{filepath.Dir(file)}, includeDirs))
+		addIncludes(system, includeDirs)
+	}
+}
+
+// findHeader returns the path of the included file found in the first of the
+// directories containing it.
+func findHeader(include string, dirs []string) (string, bool) {
+	for _, dir := range dirs {
+		candidate := filepath.Join(dir, filepath.FromSlash(include))
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// repoRelative returns the slash-separated path relative to the repository
+// root, or false if the path is outside of the repository.
+func repoRelative(repoRoot, p string) (string, bool) {
+	rel, err := filepath.Rel(repoRoot, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	if rel == "." {
+		return "", true
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// owningDirectory returns the deepest include directory containing the header,
+// or the directory of the header if it's not reachable from any include directory.
+func owningDirectory(header string, includeDirs collections.Set[string]) (string, bool) {
+	owner, found := "", false
+	for dir := range includeDirs {
+		contains := dir == "" || strings.HasPrefix(header, dir+"/")
+		if contains && (!found || len(dir) > len(owner)) {
+			owner, found = dir, true
+		}
+	}
+	if found {
+		return owner, true
+	}
+	if dir := path.Dir(header); dir != "." {
+		return dir, false
+	}
+	return "", false
+}
+
+// targetName returns the name of the target inferred for the package, named
+// after its directory or the repository directory for the root package.
+func targetName(repoRoot, pkg string) string {
+	if pkg == "" {
+		return filepath.Base(repoRoot)
+	}
+	return path.Base(pkg)
+}
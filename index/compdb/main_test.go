@@ -0,0 +1,113 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommand(t *testing.T) {
+	testCases := []struct {
+		command  string
+		expected []string
+	}{
+		{command: "c++ -Iinclude -c main.cc", expected: []string{"c++", "-Iinclude", "-c", "main.cc"}},
+		{command: "  c++   -I include  ", expected: []string{"c++", "-I", "include"}},
+		{command: `c++ "-Idir with spaces" -DNAME='"value"'`, expected: []string{"c++", "-Idir with spaces", `-DNAME="value"`}},
+		{command: `c++ -Idir\ with\ spaces -DEMPTY=""`, expected: []string{"c++", "-Idir with spaces", "-DEMPTY="}},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.expected, splitCommand(tc.command), "command: %s", tc.command)
+	}
+}
+
+func TestIncludeDirs(t *testing.T) {
+	cmd := compileCommand{
+		Directory: "/repo/build",
+		Arguments: []string{"c++", "-I../include", "-I", "/opt/include", "-isystem", "../external", "-iquote../src", "-idirafter/usr/local/include", "-include", "config.h", "-c", "../src/main.cc"},
+	}
+	assert.Equal(t, []string{"/repo/include", "/opt/include", "/repo/external", "/repo/src", "/usr/local/include"}, cmd.includeDirs())
+
+	// Arguments are split from the command if not defined
+	cmd = compileCommand{Directory: "/repo", Command: "c++ -Iinclude -c main.cc"}
+	assert.Equal(t, []string{"/repo/include"}, cmd.includeDirs())
+}
+
+func TestCreateModule(t *testing.T) {
+	repoRoot := t.TempDir()
+	files := map[string]string{
+		"src/main.cc": `
+#include "util.h"
+#include <mylib/api.h>
+#include "ext/ext.h"
+#include <vector>
+`,
+		"src/util.h":                 "",
+		"lib/include/mylib/api.h":    `#include "mylib/detail.h"`,
+		"lib/include/mylib/detail.h": "",
+		"lib/include/mylib/unused.h": "",
+		"external/ext/ext.h":         "",
+		"compile_commands.json": `[
+  {
+    "directory": "` + filepath.ToSlash(filepath.Join(repoRoot, "build")) + `",
+    "command": "c++ -I../lib/include -isystem ../external -isystem /usr/include -c ../src/main.cc -o main.o",
+    "file": "../src/main.cc"
+  }
+]`,
+	}
+	for name, content := range files {
+		path := filepath.Join(repoRoot, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(strings.TrimPrefix(content, "\n")), 0o644))
+	}
+
+	commands, err := loadCompilationDatabase(filepath.Join(repoRoot, "compile_commands.json"))
+	require.NoError(t, err)
+	module := createModule(repoRoot, commands)
+
+	assert.Equal(t, []label.Label{
+		label.New("", "external", "external"),
+		label.New("", "lib/include", "include"),
+		label.New("", "src", "src"),
+	}, collectTargetNames(module))
+
+	result := indexer.CreateHeaderIndex([]indexer.Module{module})
+	assert.Equal(t, map[string]label.Label{
+		"ext/ext.h":                  label.New("", "external", "external"),
+		"external/ext/ext.h":         label.New("", "external", "external"),
+		"mylib/api.h":                label.New("", "lib/include", "include"),
+		"mylib/detail.h":             label.New("", "lib/include", "include"),
+		"lib/include/mylib/api.h":    label.New("", "lib/include", "include"),
+		"lib/include/mylib/detail.h": label.New("", "lib/include", "include"),
+		"src/util.h":                 label.New("", "src", "src"),
+	}, result.HeaderToRule)
+	assert.Empty(t, result.Ambiguous)
+}
+
+func collectTargetNames(module indexer.Module) []label.Label {
+	var names []label.Label
+	for _, target := range module.Targets {
+		names = append(names, target.Name)
+	}
+	return names
+}
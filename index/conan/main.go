@@ -18,7 +18,6 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -27,6 +26,7 @@ import (
 	"github.com/EngFlow/gazelle_cc/index/internal/bazel/proto"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer/cli"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 
 	"github.com/bazelbuild/bazel-gazelle/label"
@@ -41,7 +41,7 @@ func main() {
 
 	callerRoot, err := cli.ResolveWorkingDir()
 	if err != nil {
-		log.Fatalf("Failed to resolve working directory for indexer")
+		logging.Fatalf("Failed to resolve working directory for indexer")
 	}
 
 	outputFile := cli.ResolveOutputFile()
@@ -70,19 +70,19 @@ func main() {
 			cmd := exec.Command("conan", command.args...)
 			cmd.Dir = callerRoot
 			var buf bytes.Buffer
-			if *cli.Verbose {
+			if logging.Enabled(logging.LevelDebug) {
 				cmd.Stdout = os.Stdout
 				cmd.Stderr = os.Stderr
 			} else {
 				cmd.Stdout = &buf
 				cmd.Stderr = &buf
 			}
-			log.Printf("Exec %v in %v", cmd.Args, cmd.Dir)
+			logging.Infof("Exec %v in %v", cmd.Args, cmd.Dir)
 			if cmd.Run() != nil {
-				log.Println(buf.String())
 				if !command.canFail {
-					log.Fatalf("Failed to install conan dependenices")
+					logging.Fatalf("Failed to install conan dependenices: %v", buf.String())
 				}
+				logging.Warnf("Command %v failed: %v", cmd.Args, buf.String())
 			}
 		}
 	}
@@ -92,7 +92,7 @@ func main() {
 	// Rules in ./conan directory have no sources, that's why we need to query on the external repository instead.
 	subdirs, err := listSubdirectories(conanDirectory)
 	if err != nil {
-		log.Fatalf("Failed to list subdirectories in %s: %v", conanDirectory, err)
+		logging.Fatalf("Failed to list subdirectories in %s: %v", conanDirectory, err)
 	}
 
 	modules := []indexer.Module{}
@@ -101,18 +101,17 @@ func main() {
 		// Search for cc_library in external repository
 		result, err := bazel.Query(callerRoot, fmt.Sprintf("kind(cc_library, @%s//...)", repoName))
 		if err != nil {
-			fmt.Errorf("Bazel query failed: %w", err)
+			logging.Errorf("Bazel query failed for repository %v: %v", repoName, err)
+			continue
 		}
 		m := extractIndexerModule(result, repoName).WithAmbiguousTargetsResolved()
 		modules = append(modules, m)
 	}
 
 	indexingResult := indexer.CreateHeaderIndex(modules)
-	indexingResult.WriteToFile(outputFile)
+	indexingResult.WriteToFile(outputFile, cli.IsDryRun(), cli.IsBinaryOutput())
 
-	if *cli.Verbose {
-		log.Println(indexingResult.String())
-	}
+	logging.Debugf("%v", indexingResult.String())
 }
 
 // Processes bazel query result to extrct cc_library targets as a module
@@ -121,7 +120,7 @@ func extractIndexerModule(query proto.QueryResult, moduleName string) indexer.Mo
 	for _, info := range query.GetTarget() {
 		name, err := label.Parse(info.GetRule().GetName())
 		if err != nil {
-			log.Printf("Failed to parse queried target label: %v", info.GetRule().GetName())
+			logging.Warnf("Failed to parse queried target label: %v", info.GetRule().GetName())
 			continue
 		}
 
@@ -33,21 +33,17 @@ func Query(cwd string, query string) (proto.QueryResult, error) {
 
 type QueryConfig struct {
 	KeepGoing bool
+	// Startup options passed before the command, e.g. --output_base=<dir>
+	StartupFlags []string
+	// Additional options of the query command, e.g. --noshow_progress
+	Flags []string
 }
 
 // Execute given bazel query inside directory. Returns nil if query fails
 func ConfiguredQuery(cwd string, query string, opts QueryConfig) (proto.QueryResult, error) {
 	var bufStdout bytes.Buffer
 	var bufStderr bytes.Buffer
-	args := []string{"query", query,
-		"--output=proto",
-		"--incompatible_disallow_empty_glob=false",
-	}
-	if opts.KeepGoing {
-		args = append(args, "--keep_going")
-	}
-	cmd := exec.Command("bazel", args...)
-	cmd.Dir = cwd
+	cmd := queryCommand(cwd, query, opts)
 	cmd.Stdout = &bufStdout
 	cmd.Stderr = &bufStderr
 	if err := cmd.Run(); err != nil {
@@ -63,6 +59,21 @@ func ConfiguredQuery(cwd string, query string, opts QueryConfig) (proto.QueryRes
 	return result, nil
 }
 
+// queryCommand creates the bazel command executing the query inside directory.
+func queryCommand(cwd string, query string, opts QueryConfig) *exec.Cmd {
+	args := slices.Concat(opts.StartupFlags, []string{"query", query,
+		"--output=proto",
+		"--incompatible_disallow_empty_glob=false",
+	})
+	if opts.KeepGoing {
+		args = append(args, "--keep_going")
+	}
+	args = append(args, opts.Flags...)
+	cmd := exec.Command("bazel", args...)
+	cmd.Dir = cwd
+	return cmd
+}
+
 // Select attribute that defined with given name. Returns nil if no such attribute can be found
 func GetNamedAttribute(target *proto.Target, name string) *proto.Attribute {
 	attrs := target.GetRule().GetAttribute()
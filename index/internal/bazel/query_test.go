@@ -0,0 +1,38 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bazel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryCommand(t *testing.T) {
+	cmd := queryCommand("/work/module", "kind(cc_library, //...)", QueryConfig{})
+	assert.Equal(t, "/work/module", cmd.Dir)
+	assert.Equal(t, []string{"bazel", "query", "kind(cc_library, //...)", "--output=proto", "--incompatible_disallow_empty_glob=false"}, cmd.Args)
+
+	cmd = queryCommand("/work/module", "//...", QueryConfig{
+		KeepGoing:    true,
+		StartupFlags: []string{"--output_base=/cache/output_bases/worker-1", "--max_idle_secs=60"},
+		Flags:        []string{"--noshow_progress", "--curses=no"},
+	})
+	assert.Equal(t, []string{
+		"bazel", "--output_base=/cache/output_bases/worker-1", "--max_idle_secs=60",
+		"query", "//...", "--output=proto", "--incompatible_disallow_empty_glob=false", "--keep_going",
+		"--noshow_progress", "--curses=no",
+	}, cmd.Args)
+}
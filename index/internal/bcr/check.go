@@ -0,0 +1,131 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// EnvironmentCheck is the outcome of verifying a single requirement of the indexer.
+type EnvironmentCheck struct {
+	Name   string // Checked requirement, e.g. name of the binary
+	Detail string // Version of the binary or other details of satisfied requirement
+	Err    error  // Reason of failure, nil if the requirement is satisfied
+}
+
+func (c EnvironmentCheck) String() string {
+	if c.Err != nil {
+		return fmt.Sprintf("FAIL %-12s %v", c.Name, c.Err)
+	}
+	return fmt.Sprintf("OK   %-12s %v", c.Name, c.Detail)
+}
+
+// CheckEnvironment verifies that binaries used to index modules are available
+// and working, the cache directory is writable and the registries are
+// reachable. Allows to detect problems before a long indexing run.
+func CheckEnvironment(config BazelRegistryConfig) []EnvironmentCheck {
+	return checkEnvironment(config, runCommand)
+}
+
+func checkEnvironment(config BazelRegistryConfig, run commandRunner) []EnvironmentCheck {
+	checks := []EnvironmentCheck{
+		checkBinary(run, "git"),
+		checkBinary(run, patchBinary()),
+		checkBinary(run, "bazel"),
+		checkCacheDir(config.CacheDir),
+	}
+	return append(checks, checkRegistries(config, run)...)
+}
+
+// Hints shown when a required binary is missing.
+var missingBinaryHints = map[string]string{
+	"gpatch": "GNU patch is required on macOS, install it using 'brew install gpatch'",
+	"bazel":  "install Bazelisk as 'bazel', see https://github.com/bazelbuild/bazelisk",
+}
+
+// checkBinary verifies the binary can be executed and reports its version.
+func checkBinary(run commandRunner, binary string) EnvironmentCheck {
+	var stdout bytes.Buffer
+	cmd := exec.Command(binary, "--version")
+	cmd.Stdout = &stdout
+	cmd.Stderr = io.Discard
+	if err := run(cmd); err != nil {
+		if hint, ok := missingBinaryHints[binary]; ok {
+			err = fmt.Errorf("%w, %v", err, hint)
+		}
+		return EnvironmentCheck{Name: binary, Err: err}
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(stdout.String()), "\n")
+	return EnvironmentCheck{Name: binary, Detail: version}
+}
+
+// checkCacheDir verifies that files can be created in the cache directory.
+func checkCacheDir(cacheDir string) EnvironmentCheck {
+	check := EnvironmentCheck{Name: "cache", Detail: cacheDir}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		check.Err = fmt.Errorf("cache directory %v cannot be created: %w", cacheDir, err)
+		return check
+	}
+	f, err := os.CreateTemp(cacheDir, ".check-")
+	if err != nil {
+		check.Err = fmt.Errorf("cache directory %v is not writable: %w", cacheDir, err)
+		return check
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return check
+}
+
+// checkRegistries verifies that each registry is reachable. Local registries
+// and checkouts used in offline mode need to exist.
+func checkRegistries(config BazelRegistryConfig, run commandRunner) []EnvironmentCheck {
+	if config.RegistryPath != "" {
+		check := EnvironmentCheck{Name: "registry", Detail: config.RegistryPath}
+		if _, err := os.Stat(filepath.Join(config.RegistryPath, "modules")); err != nil {
+			check.Err = fmt.Errorf("invalid registry path %v: %w", config.RegistryPath, err)
+		}
+		return []EnvironmentCheck{check}
+	}
+
+	registryURLs := config.RegistryURLs
+	if len(registryURLs) == 0 {
+		registryURLs = []string{DefaultRegistryURL}
+	}
+	var checks []EnvironmentCheck
+	for _, registryURL := range registryURLs {
+		check := EnvironmentCheck{Name: "registry", Detail: registryURL}
+		if config.Offline {
+			repoDir := registryCheckoutDir(config.CacheDir, registryURL)
+			if _, err := os.Stat(repoDir); err != nil {
+				check.Err = fmt.Errorf("registry checkout %v not found, it cannot be cloned in offline mode", repoDir)
+			}
+		} else {
+			cmd := exec.Command("git", "ls-remote", "--exit-code", registryURL, "HEAD")
+			cmd.Stdout = io.Discard
+			cmd.Stderr = io.Discard
+			if err := run(cmd); err != nil {
+				check.Err = fmt.Errorf("registry %v is not reachable: %w", registryURL, err)
+			}
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
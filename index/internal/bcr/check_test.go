@@ -0,0 +1,114 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	// Fake runner executing only the given binaries and reachable registries
+	newRunner := func(binaries []string, reachable bool) commandRunner {
+		return func(cmd *exec.Cmd) error {
+			binary := cmd.Args[0]
+			if !slices.Contains(binaries, binary) {
+				return &exec.Error{Name: binary, Err: exec.ErrNotFound}
+			}
+			if slices.Contains(cmd.Args, "ls-remote") {
+				if !reachable {
+					return errors.New("exit status 128")
+				}
+				return nil
+			}
+			fmt.Fprintf(cmd.Stdout, "%s version 1.0\nmore details\n", binary)
+			return nil
+		}
+	}
+	checkNames := func(checks []EnvironmentCheck) []string {
+		var names []string
+		for _, check := range checks {
+			names = append(names, check.Name)
+		}
+		return names
+	}
+	failedChecks := func(checks []EnvironmentCheck) []string {
+		var names []string
+		for _, check := range checks {
+			if check.Err != nil {
+				names = append(names, check.Name)
+			}
+		}
+		return names
+	}
+
+	t.Run("all binaries available", func(t *testing.T) {
+		config := BazelRegistryConfig{CacheDir: filepath.Join(t.TempDir(), "cache")}
+		checks := checkEnvironment(config, newRunner([]string{"git", patchBinary(), "bazel"}, true))
+		assert.Equal(t, []string{"git", patchBinary(), "bazel", "cache", "registry"}, checkNames(checks))
+		assert.Empty(t, failedChecks(checks))
+		assert.Equal(t, "git version 1.0", checks[0].Detail)
+		assert.Equal(t, DefaultRegistryURL, checks[4].Detail)
+		assert.DirExists(t, config.CacheDir)
+	})
+
+	t.Run("missing binaries", func(t *testing.T) {
+		config := BazelRegistryConfig{CacheDir: t.TempDir()}
+		checks := checkEnvironment(config, newRunner([]string{"git"}, true))
+		assert.Equal(t, []string{patchBinary(), "bazel"}, failedChecks(checks))
+		assert.ErrorIs(t, checks[1].Err, exec.ErrNotFound)
+		assert.ErrorContains(t, checks[2].Err, "install Bazelisk")
+	})
+
+	t.Run("unreachable registry", func(t *testing.T) {
+		config := BazelRegistryConfig{CacheDir: t.TempDir(), RegistryURLs: []string{"https://git.example.com/registry", DefaultRegistryURL}}
+		checks := checkEnvironment(config, newRunner([]string{"git", patchBinary(), "bazel"}, false))
+		assert.Equal(t, []string{"registry", "registry"}, failedChecks(checks))
+		assert.ErrorContains(t, checks[4].Err, "https://git.example.com/registry is not reachable")
+	})
+
+	t.Run("offline and local registries", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		run := newRunner([]string{"git", patchBinary(), "bazel"}, false)
+		checks := checkEnvironment(BazelRegistryConfig{CacheDir: cacheDir, Offline: true}, run)
+		assert.ErrorContains(t, checks[4].Err, "cannot be cloned in offline mode")
+
+		require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "bazel-central-registry"), 0o755))
+		checks = checkEnvironment(BazelRegistryConfig{CacheDir: cacheDir, Offline: true}, run)
+		assert.Empty(t, failedChecks(checks))
+
+		registryPath := t.TempDir()
+		checks = checkEnvironment(BazelRegistryConfig{CacheDir: cacheDir, RegistryPath: registryPath}, run)
+		assert.Equal(t, []string{"registry"}, failedChecks(checks))
+		require.NoError(t, os.Mkdir(filepath.Join(registryPath, "modules"), 0o755))
+		checks = checkEnvironment(BazelRegistryConfig{CacheDir: cacheDir, RegistryPath: registryPath}, run)
+		assert.Empty(t, failedChecks(checks))
+	})
+
+	t.Run("cache directory not writable", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(file, nil, 0o644))
+		checks := checkEnvironment(BazelRegistryConfig{CacheDir: filepath.Join(file, "cache")}, newRunner([]string{"git", patchBinary(), "bazel"}, true))
+		assert.Equal(t, []string{"cache"}, failedChecks(checks))
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+)
+
+// Name of the file in the cache directory storing commits of registries used by the last indexing
+const lastIndexedCommitsFile = "last-indexed-commits.json"
+
+// RegistryCommits maps the path of registry checkout to the git commit it was indexed at.
+type RegistryCommits map[string]string
+
+// LoadLastIndexedCommits reads commits of registries used by the last indexing from the cache directory.
+func LoadLastIndexedCommits(cacheDir string) (RegistryCommits, error) {
+	data, err := os.ReadFile(filepath.Join(cacheDir, lastIndexedCommitsFile))
+	if err != nil {
+		return nil, err
+	}
+	var commits RegistryCommits
+	if err := json.Unmarshal(data, &commits); err != nil {
+		return nil, fmt.Errorf("invalid %v: %w", lastIndexedCommitsFile, err)
+	}
+	return commits, nil
+}
+
+// SaveLastIndexedCommits stores commits of indexed registries in the cache directory, used as a base of the next incremental indexing.
+func SaveLastIndexedCommits(cacheDir string, commits RegistryCommits) error {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(cacheDir, lastIndexedCommitsFile), mustWriteJSON(commits), 0o644)
+}
+
+// HeadCommits returns the current commit of each registry checkout.
+func (bcr *BazelRegistry) HeadCommits() (RegistryCommits, error) {
+	commits := make(RegistryCommits, len(bcr.RepositoryPaths))
+	for _, repoDir := range bcr.RepositoryPaths {
+		output, err := bcr.gitOutput(repoDir, "rev-parse", "HEAD")
+		if err != nil {
+			return nil, fmt.Errorf("failed to read current commit of registry %v: %w", repoDir, err)
+		}
+		commits[repoDir] = strings.TrimSpace(output)
+	}
+	return commits, nil
+}
+
+// ChangedModules returns names of modules whose modules/<name> directory was
+// added, modified or removed in any of the registries since the given commits.
+// Fails if the commit of any registry is unknown, all modules need to be
+// indexed in such case.
+func (bcr *BazelRegistry) ChangedModules(since RegistryCommits) (collections.Set[string], error) {
+	changed := make(collections.Set[string])
+	for _, repoDir := range bcr.RepositoryPaths {
+		commit, ok := since[repoDir]
+		if !ok {
+			return nil, fmt.Errorf("registry %v was not indexed before", repoDir)
+		}
+		output, err := bcr.gitOutput(repoDir, "diff", "--name-only", commit, "HEAD", "--", "modules")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list changes of registry %v since %v: %w", repoDir, commit, err)
+		}
+		for line := range strings.Lines(output) {
+			path, ok := strings.CutPrefix(strings.TrimSpace(line), "modules/")
+			if !ok {
+				continue
+			}
+			if moduleName, _, ok := strings.Cut(path, "/"); ok && moduleName != "" {
+				changed.Add(moduleName)
+			}
+		}
+	}
+	return changed, nil
+}
+
+func (bcr *BazelRegistry) gitOutput(repoDir string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := bcr.run(cmd); err != nil {
+		return "", err
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLastIndexedCommits(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	_, err := LoadLastIndexedCommits(cacheDir)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	commits := RegistryCommits{"/cache/bazel-central-registry": "abc123"}
+	require.NoError(t, SaveLastIndexedCommits(cacheDir, commits))
+	loaded, err := LoadLastIndexedCommits(cacheDir)
+	require.NoError(t, err)
+	assert.Equal(t, commits, loaded)
+}
+
+func TestChangedModules(t *testing.T) {
+	registryDir := t.TempDir()
+	var commands [][]string
+	registry := BazelRegistry{
+		RepositoryPaths: []string{registryDir},
+		run: func(cmd *exec.Cmd) error {
+			commands = append(commands, cmd.Args)
+			switch {
+			case slices.Contains(cmd.Args, "rev-parse"):
+				fmt.Fprintln(cmd.Stdout, "def456")
+			case slices.Contains(cmd.Args, "diff"):
+				fmt.Fprint(cmd.Stdout, "modules/fmt/11.0.0/MODULE.bazel\nmodules/fmt/11.0.0/source.json\nmodules/fmt/metadata.json\nmodules/removed/metadata.json\n")
+			}
+			return nil
+		},
+	}
+
+	heads, err := registry.HeadCommits()
+	require.NoError(t, err)
+	assert.Equal(t, RegistryCommits{registryDir: "def456"}, heads)
+
+	changed, err := registry.ChangedModules(RegistryCommits{registryDir: "abc123"})
+	require.NoError(t, err)
+	assert.Equal(t, collections.SetOf("fmt", "removed"), changed)
+	assert.Contains(t, commands, []string{"git", "diff", "--name-only", "abc123", "HEAD", "--", "modules"})
+
+	_, err = registry.ChangedModules(RegistryCommits{"/other/registry": "abc123"})
+	assert.Error(t, err)
+}
+
+func TestResolveModuleInfoRecomputesChangedModules(t *testing.T) {
+	cacheDir := t.TempDir()
+	registryDir := registryCheckoutDir(cacheDir, DefaultRegistryURL)
+	for _, moduleName := range []string{"changed", "unchanged"} {
+		moduleDir := filepath.Join(registryDir, "modules", moduleName)
+		require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "1.0"), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "metadata.json"), []byte(`{"versions": ["1.0"]}`), 0o644))
+		// Fails without accessing network if the module sources would be prepared
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "1.0", "source.json"), []byte(`{"type": "git_repository"}`), 0o644))
+
+		cached := ResolveModuleInfoResult{Info: &ModuleInfo{Module: ModuleVersion{Name: moduleName, Version: "1.0"}}}
+		cacheFile := filepath.Join(cacheDir, "modules", moduleName, "1.0", "module-info.json")
+		require.NoError(t, os.MkdirAll(filepath.Dir(cacheFile), 0o755))
+		require.NoError(t, os.WriteFile(cacheFile, mustWriteJSON(cached), 0o644))
+	}
+
+	registry, err := checkoutBazelRegistry(BazelRegistryConfig{
+		CacheDir:         cacheDir,
+		Offline:          true,
+		RecomputeModules: collections.SetOf("changed"),
+	}, func(cmd *exec.Cmd) error { return nil })
+	require.NoError(t, err)
+
+	results := registry.ResolveModuleInfos([]ModuleVersion{{Name: "changed"}, {Name: "unchanged"}}, 1)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].IsUnresolved(), "changed module should be reprocessed instead of using the cache")
+	assert.Contains(t, results[0].Unresolved.Reason, "git_repository modules not supported yet")
+	assert.True(t, results[1].IsResolved(), "unchanged module should be loaded from the cache")
+}
+
+func TestIndexModulesIncrementallyMatchesFullIndexing(t *testing.T) {
+	unchanged := ModuleInfo{
+		Module: ModuleVersion{Name: "unchanged", Version: "1.0"},
+		Targets: []ModuleTarget{{
+			Name: label.New("unchanged", "", "unchanged"),
+			Hdrs: []label.Label{label.New("unchanged", "", "shared.h"), label.New("unchanged", "", "unchanged.h")},
+		}},
+	}
+	// Information cached before the module was changed, it can no longer be resolved
+	staleChanged := ModuleInfo{
+		Module: ModuleVersion{Name: "changed", Version: "1.0"},
+		Targets: []ModuleTarget{{
+			Name: label.New("changed", "", "changed"),
+			Hdrs: []label.Label{label.New("changed", "", "shared.h"), label.New("changed", "", "changed.h")},
+		}},
+	}
+	indexModules := func(cached []ModuleInfo, recompute collections.Set[string]) indexer.IndexingResult {
+		cacheDir := t.TempDir()
+		registryDir := registryCheckoutDir(cacheDir, DefaultRegistryURL)
+		for _, moduleName := range []string{"changed", "unchanged"} {
+			moduleDir := filepath.Join(registryDir, "modules", moduleName)
+			require.NoError(t, os.MkdirAll(filepath.Join(moduleDir, "1.0"), 0o755))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "metadata.json"), []byte(`{"versions": ["1.0"]}`), 0o644))
+			require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "1.0", "source.json"), []byte(`{"type": "git_repository"}`), 0o644))
+		}
+		for _, info := range cached {
+			cacheFile := filepath.Join(cacheDir, "modules", info.Module.Name, info.Module.Version, "module-info.json")
+			require.NoError(t, os.MkdirAll(filepath.Dir(cacheFile), 0o755))
+			require.NoError(t, os.WriteFile(cacheFile, mustWriteJSON(ResolveModuleInfoResult{Info: &info}), 0o644))
+		}
+		registry, err := checkoutBazelRegistry(BazelRegistryConfig{
+			CacheDir:         cacheDir,
+			Offline:          true,
+			RecomputeModules: recompute,
+		}, func(cmd *exec.Cmd) error { return nil })
+		require.NoError(t, err)
+		result, results, err := registry.IndexModules(1)
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		return result
+	}
+
+	full := indexModules([]ModuleInfo{unchanged}, nil)
+	incremental := indexModules([]ModuleInfo{unchanged, staleChanged}, collections.SetOf("changed"))
+	assert.Equal(t, full, incremental)
+	// Header is no longer ambiguous after the other module defining it was changed
+	assert.Equal(t, map[string]label.Label{
+		"shared.h":    label.New("unchanged", "", "unchanged"),
+		"unchanged.h": label.New("unchanged", "", "unchanged"),
+	}, incremental.HeaderToRule)
+}
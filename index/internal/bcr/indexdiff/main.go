@@ -0,0 +1,81 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
+	"github.com/EngFlow/gazelle_cc/internal/index"
+)
+
+// Compares two header mapping files created by the indexers, e.g. header-mappings.json
+// created by the BCR indexer before and after updating it, and reports headers which
+// were added, removed or are now defined by different targets.
+//
+// Usage: indexdiff [-json] [-exit-code] <old index> <new index>
+func main() {
+	jsonOutput := flag.Bool("json", false, "Print the differences as JSON instead of human-readable summary")
+	exitCode := flag.Bool("exit-code", false, "Exit with status 1 if the indexes differ")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] <old index> <new index>\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	oldIndex, err := readIndex(flag.Arg(0))
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+	newIndex, err := readIndex(flag.Arg(1))
+	if err != nil {
+		logging.Fatalf("%v", err)
+	}
+
+	diff := index.Diff(oldIndex, newIndex)
+	if *jsonOutput {
+		data, err := json.MarshalIndent(diff, "", "  ")
+		if err != nil {
+			logging.Fatalf("Failed to serialize the differences to JSON: %v", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Print(diff.String())
+	}
+	if *exitCode && !diff.IsEmpty() {
+		os.Exit(1)
+	}
+}
+
+// readIndex reads the index file, relative paths are resolved against the
+// directory from which the binary was started using 'bazel run'.
+func readIndex(path string) (index.DependencyIndex, error) {
+	if dir := os.Getenv("BUILD_WORKING_DIRECTORY"); dir != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	result, err := index.LoadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index file: %w", err)
+	}
+	return result, nil
+}
@@ -17,23 +17,18 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
-	"sort"
-
-	"golang.org/x/sync/errgroup"
 
 	"github.com/EngFlow/gazelle_cc/index/internal/bcr"
-	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 )
 
 func main() {
 	if err := run(); err != nil {
-		fmt.Fprintf(os.Stderr, "error: %v\n", err)
-		os.Exit(1)
+		logging.Fatalf("%v", err)
 	}
 }
 
@@ -56,36 +51,88 @@ func main() {
 // the excluded files would be written in textual file on the disk.
 // Mapping contains only headers that are assigned to exactly 1 rule.
 // Headers with ambiguous rule definitions are also written in textual format for manual inspection.
-// It does also use system binaries: git, patch (gpatch is required on MacOs instead to correctly apply patches to Bazel modules) and bazel (bazelisk preferred)
+// It does also use system binaries: git, bazel (bazelisk preferred) and patch, used only for module patches in formats other than
+// unified diff (gpatch is required on MacOs instead to correctly apply them)
 func run() error {
 	cfg := parseFlags()
+	if cfg.check {
+		return checkEnvironment(cfg.bcrConfig)
+	}
 
 	bcrClient, err := bcr.CheckoutBazelRegistry(cfg.bcrConfig)
 	if err != nil {
 		return fmt.Errorf("failed to checkout bazel registry: %w", err)
 	}
 
-	modules, err := gatherModuleInfos(bcrClient)
+	// Commits are stored after indexing to allow the next incremental indexing
+	commits, err := bcrClient.HeadCommits()
+	if err != nil {
+		logging.Warnf("Registry commits unknown, next indexing can't be incremental: %v", err)
+	}
+
+	if cfg.incremental {
+		if changed, err := prepareIncrementalIndexing(bcrClient); err != nil {
+			logging.Warnf("Incremental indexing not possible, changed modules would not be recomputed: %v", err)
+		} else {
+			logging.Infof("Recomputing %d modules changed since the last indexing", len(changed))
+			bcrClient.Config.RecomputeModules = changed
+		}
+	}
+
+	result, results, err := bcrClient.IndexModules(cfg.jobs)
 	if err != nil {
 		return fmt.Errorf("failed to resolve modules info: %w", err)
 	}
+	printModulesSummary(results)
 
-	index := indexer.CreateHeaderIndex(modules)
-	fmt.Fprintf(os.Stderr, "Direct mapping created for %d headers\n", len(index.HeaderToRule))
-	fmt.Fprintf(os.Stderr, "Ambiguous header assignment for %d entries\n", len(index.Ambiguous))
-	if err := index.WriteToFile(cfg.outputPath); err != nil {
+	fmt.Printf("Direct mapping created for %d headers\n", len(result.HeaderToRule))
+	fmt.Printf("Ambiguous header assignment for %d entries\n", len(result.Ambiguous))
+	if err := result.WriteToFile(cfg.outputPath, cfg.dryRun, false); err != nil {
 		return fmt.Errorf("failed to write index file: %w", err)
 	}
-	if cfg.verbose {
-		log.Println(index.String())
+	logging.Debugf("%v", result.String())
+
+	if commits != nil && !cfg.dryRun {
+		if err := bcr.SaveLastIndexedCommits(cfg.bcrConfig.CacheDir, commits); err != nil {
+			logging.Warnf("Failed to store indexed registry commits: %v", err)
+		}
+	}
+	return nil
+}
+
+// checkEnvironment reports the state of each requirement of the indexer and
+// fails if any of them is not satisfied.
+func checkEnvironment(config bcr.BazelRegistryConfig) error {
+	failed := 0
+	for _, check := range bcr.CheckEnvironment(config) {
+		fmt.Println(check)
+		if check.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d environment checks failed", failed)
 	}
 	return nil
 }
 
+// prepareIncrementalIndexing returns names of modules changed since the last
+// indexing, which can't be loaded from the cache.
+func prepareIncrementalIndexing(bcrClient bcr.BazelRegistry) (collections.Set[string], error) {
+	lastCommits, err := bcr.LoadLastIndexedCommits(bcrClient.Config.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load last indexed commits: %w", err)
+	}
+	return bcrClient.ChangedModules(lastCommits)
+}
+
 type Config struct {
-	outputPath string
-	verbose    bool
-	bcrConfig  bcr.BazelRegistryConfig
+	outputPath  string
+	dryRun      bool
+	incremental bool
+	check       bool
+	jobs        int
+	bcrConfig   bcr.BazelRegistryConfig
 }
 
 func parseFlags() Config {
@@ -93,82 +140,59 @@ func parseFlags() Config {
 	pwd, _ := os.Getwd()
 	defaultCache := filepath.Join(pwd, ".cache")
 	flag.StringVar(&cfg.outputPath, "output-mappings", filepath.Join(defaultCache, "header-mappings.json"), "Output path for header mappings")
+	flag.BoolVar(&cfg.dryRun, "dry-run", false, "Log the number of header mappings and the output path instead of writing them (default false)")
+	flag.BoolVar(&cfg.incremental, "incremental", false, "Recompute only modules changed in the registry since the last indexing, using cached information of other modules (default false)")
+	flag.BoolVar(&cfg.check, "check", false, "Verify that required binaries are installed, the cache directory is writable and registries are reachable, then exit (default false)")
 	flag.StringVar(&cfg.bcrConfig.CacheDir, "cache-dir", defaultCache, "Path to cache directory")
-	flag.BoolVar(&cfg.verbose, "v", false, "Verbose")
+	logging.RegisterFlags(flag.CommandLine, "v")
+	flag.IntVar(&cfg.jobs, "jobs", runtime.GOMAXPROCS(0), "Number of modules resolved concurrently (default number of available CPUs)")
 	flag.BoolVar(&cfg.bcrConfig.KeepSources, "keep-sources", false, "Keep fetched sources (default false)")
 	flag.BoolVar(&cfg.bcrConfig.RecomputeBad, "recompute-unresolved", false, "Recompute previously unresolved modules (default false)")
 	flag.BoolVar(&cfg.bcrConfig.CacheBad, "cache-unresolved", true, "Cache unresolved module results (default true)")
+	flag.BoolVar(&cfg.bcrConfig.IgnorePatchFailures, "ignore-patch-failures", false, "Index sources of modules left unpatched when their patches cannot be applied, instead of skipping these modules (default false)")
+	extraLibraryKinds := flag.String("extra-library-kinds", "", "Comma separated rule class patterns of custom rules defining public libraries, e.g. my_cc_library")
+	flag.BoolVar(&cfg.bcrConfig.Offline, "offline", false, "Use existing registry checkout without fetching updates (default false)")
+	flag.StringVar(&cfg.bcrConfig.RegistryPath, "registry-path", "", "Path to a local registry used instead of cloning the Bazel Central Registry")
+	flag.Func("registry-url", "Git URL of registry used to resolve modules, can be repeated to define multiple registries in order of precedence (default Bazel Central Registry)", func(url string) error {
+		cfg.bcrConfig.RegistryURLs = append(cfg.bcrConfig.RegistryURLs, url)
+		return nil
+	})
+	flag.Func("bazel-startup-flag", "Bazel startup option used when querying modules, e.g. --max_idle_secs=60. Can be repeated", func(value string) error {
+		cfg.bcrConfig.BazelStartupFlags = append(cfg.bcrConfig.BazelStartupFlags, value)
+		return nil
+	})
+	flag.Func("bazel-query-flag", "Additional option of bazel query used to resolve module targets, e.g. --noshow_progress. Can be repeated", func(value string) error {
+		cfg.bcrConfig.BazelQueryFlags = append(cfg.bcrConfig.BazelQueryFlags, value)
+		return nil
+	})
+	flag.StringVar(&cfg.bcrConfig.CABundle, "ca-bundle", "", "Path to PEM file with additional root certificates trusted when downloading module sources")
+	flag.Func("public-api-target", "Index only headers reachable from the public API target of module, defined as <module>=<label>. Can be repeated", cfg.bcrConfig.AddPublicAPITarget)
 	flag.Parse()
-	cfg.bcrConfig.Verbose = cfg.verbose
+	cfg.bcrConfig.ExtraLibraryKinds = bcr.ParseLibraryKinds(*extraLibraryKinds)
 	return cfg
 }
 
-func gatherModuleInfos(bcrClient bcr.BazelRegistry) ([]indexer.Module, error) {
-	modulesDir := filepath.Join(bcrClient.RepositoryPath, "modules")
-	entries, err := os.ReadDir(modulesDir)
-	if err != nil {
-		return nil, err
-	}
-
-	// Filter to only directories
-	var moduleNames []string
-	for _, e := range entries {
-		if e.IsDir() {
-			moduleNames = append(moduleNames, e.Name())
-		}
-	}
-	fmt.Fprintf(os.Stderr, "Scanning %d modules for cc_rules\n", len(moduleNames))
-
-	// Use semaphore pattern for bounded concurrency
-	workerCount := runtime.GOMAXPROCS(0)
-	sem := make(chan struct{}, workerCount)
-	results := make([]bcr.ResolveModuleInfoResult, len(moduleNames))
-
-	var eg errgroup.Group
-	for i, moduleName := range moduleNames {
-		sem <- struct{}{} // acquire semaphore
-		eg.Go(func() error {
-			defer func() { <-sem }() // release semaphore
-
-			rr := bcrClient.ResolveModuleInfo(moduleName, "") // implicitly latest version
-			results[i] = rr
-
-			if bcrClient.Config.Verbose {
-				if rr.Info != nil {
-					fmt.Fprintf(os.Stderr, "%-50s: resolved - cc_libraries: %d\n", rr.Info.Module.String(), len(rr.Info.Targets))
-				} else {
-					fmt.Fprintf(os.Stderr, "%-50s: failed   - %s\n", rr.Unresolved.Module.String(), rr.Unresolved.Reason)
-				}
-			}
-			return nil
-		})
-	}
-
-	if err := eg.Wait(); err != nil {
-		return nil, err
-	}
-
-	// Collect successful results
-	var infos []bcr.ModuleInfo
-	var failed int
+// printModulesSummary prints the number of resolved and unresolved modules.
+func printModulesSummary(results []bcr.ResolveModuleInfoResult) {
+	var found, failed int
 	for _, r := range results {
 		if r.IsResolved() && len(r.Info.Targets) > 0 {
-			infos = append(infos, *r.Info)
+			found++
 		} else if r.IsUnresolved() {
 			failed++
 		}
 	}
+	fmt.Printf("Found %d modules with non-empty cc_library defs\n", found)
+	fmt.Printf("Failed to gather module information in %d modules\n", failed)
+	logUnresolvedSummary(results)
+}
 
-	fmt.Fprintf(os.Stderr, "Found %d modules with non-empty cc_library defs\n", len(infos))
-	fmt.Fprintf(os.Stderr, "Failed to gather module information in %d modules\n", failed)
-	sort.Slice(infos, func(i, j int) bool {
-		if infos[i].Module.Name == infos[j].Module.Name {
-			return infos[i].Module.Version < infos[j].Module.Version
-		}
-		return infos[i].Module.Name < infos[j].Module.Name
-	})
-	modules := collections.MapSlice(infos, func(m bcr.ModuleInfo) indexer.Module {
-		return m.ToIndexerModule().WithAmbiguousTargetsResolved()
-	})
-	return modules, nil
+// Logs categories of unresolved modules, helps to prioritize improvements of the indexer
+func logUnresolvedSummary(results []bcr.ResolveModuleInfoResult) {
+	if !logging.Enabled(logging.LevelDebug) {
+		return
+	}
+	for _, category := range bcr.SummarizeUnresolved(results, 5) {
+		logging.Debugf("Unresolved modules - %v", category)
+	}
 }
@@ -0,0 +1,411 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// errUnsupportedPatch is returned for patches using features not handled by
+// applyPatch, these need to be applied using the external patch binary.
+var errUnsupportedPatch = errors.New("unsupported patch format")
+
+// filePatch is a set of changes to a single file in the unified diff format.
+type filePatch struct {
+	oldName, newName string // "/dev/null" when the file is created or deleted
+	hunks            []hunk
+}
+
+// hunk is a single continuous change of the file.
+type hunk struct {
+	oldStart int      // 1-based line number in the original file
+	oldLines []string // context and removed lines
+	newLines []string // context and added lines
+	ops      []byte   // kind of each line in order of the hunk: ' ', '-' or '+'
+	// Whether the last line of the old or new content is missing a terminating newline
+	oldNoNewline, newNoNewline bool
+}
+
+const devNull = "/dev/null"
+
+var hunkHeader = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// parsePatch parses the patch in the unified diff format, including the
+// extended headers of git diffs which don't rename, copy or change binary files.
+func parsePatch(content []byte) ([]filePatch, error) {
+	lines := strings.Split(string(content), "\n")
+	var patches []filePatch
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		switch {
+		case strings.HasPrefix(line, "rename from "), strings.HasPrefix(line, "copy from "),
+			strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, fmt.Errorf("%w: %q", errUnsupportedPatch, line)
+		case strings.HasPrefix(line, "*** "), strings.HasPrefix(line, "***************"):
+			return nil, fmt.Errorf("%w: context diffs are not supported", errUnsupportedPatch)
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			patch := filePatch{
+				oldName: patchFileName(strings.TrimPrefix(line, "--- ")),
+				newName: patchFileName(strings.TrimPrefix(lines[i+1], "+++ ")),
+			}
+			i += 2
+			for i < len(lines) && strings.HasPrefix(lines[i], "@@ ") {
+				h, next, err := parseHunk(lines, i)
+				if err != nil {
+					return nil, err
+				}
+				patch.hunks = append(patch.hunks, h)
+				i = next
+			}
+			i--
+			patches = append(patches, patch)
+		case strings.HasPrefix(line, "@@ "):
+			return nil, fmt.Errorf("line %d: hunk without file header", i+1)
+		}
+	}
+	if len(patches) == 0 && len(bytes.TrimSpace(content)) > 0 {
+		return nil, fmt.Errorf("%w: no unified diff file headers found", errUnsupportedPatch)
+	}
+	return patches, nil
+}
+
+// patchFileName returns the file name from the header line, skipping the optional timestamp.
+func patchFileName(header string) string {
+	name, _, _ := strings.Cut(strings.TrimSuffix(header, "\r"), "\t")
+	name = strings.TrimSpace(name)
+	if unquoted, err := strconv.Unquote(name); err == nil {
+		name = unquoted
+	}
+	return name
+}
+
+// parseHunk parses the hunk starting at the given line and returns it
+// together with the index of the first line following it.
+func parseHunk(lines []string, start int) (hunk, int, error) {
+	m := hunkHeader.FindStringSubmatch(lines[start])
+	if m == nil {
+		return hunk{}, 0, fmt.Errorf("line %d: invalid hunk header %q", start+1, lines[start])
+	}
+	count := func(s string) int {
+		if s == "" {
+			return 1
+		}
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	oldStart, _ := strconv.Atoi(m[1])
+	oldCount, newCount := count(m[2]), count(m[4])
+	h := hunk{oldStart: oldStart}
+	i := start + 1
+	lastOld, lastNew := false, false // whether the previous line belongs to the old or new content
+	for ; i < len(lines) && (len(h.oldLines) < oldCount || len(h.newLines) < newCount); i++ {
+		line := strings.TrimSuffix(lines[i], "\r")
+		if line == "" {
+			// Context line with the leading space stripped by an editor
+			line = " "
+		}
+		op := line[0]
+		switch op {
+		case ' ', '-', '+':
+			lastOld, lastNew = op != '+', op != '-'
+			if lastOld {
+				h.oldLines = append(h.oldLines, line[1:])
+			}
+			if lastNew {
+				h.newLines = append(h.newLines, line[1:])
+			}
+			h.ops = append(h.ops, op)
+		case '\\':
+			h.markNoNewline(lastOld, lastNew)
+		default:
+			return hunk{}, 0, fmt.Errorf("line %d: unexpected line in hunk %q", i+1, line)
+		}
+	}
+	if len(h.oldLines) != oldCount || len(h.newLines) != newCount {
+		return hunk{}, 0, fmt.Errorf("line %d: truncated hunk", start+1)
+	}
+	// Marker of missing newline following the last line of the hunk
+	if i < len(lines) && strings.HasPrefix(lines[i], `\`) {
+		h.markNoNewline(lastOld, lastNew)
+		i++
+	}
+	return h, i, nil
+}
+
+func (h *hunk) markNoNewline(inOld, inNew bool) {
+	h.oldNoNewline = h.oldNoNewline || inOld
+	h.newNoNewline = h.newNoNewline || inNew
+}
+
+// position returns the expected 0-based position of the hunk in the original file.
+func (h hunk) position() int {
+	if len(h.oldLines) == 0 {
+		// Lines are added after the line at the start position
+		return h.oldStart
+	}
+	return h.oldStart - 1
+}
+
+// applyPatch applies the patch in the unified diff format to files in the
+// root directory, stripping the given number of leading components from file
+// names the same as 'patch -p<strip>'. Whitespace differences between the
+// patch and the patched file are ignored, the same as 'patch -l', and hunks
+// not matching the file are applied with fuzz, the same as 'patch' does by
+// default. Files are modified only if all hunks of the patch can be applied.
+func applyPatch(content []byte, root string, strip int) error {
+	patches, err := parsePatch(content)
+	if err != nil {
+		return err
+	}
+	type patchedFile struct {
+		lines     []string
+		noNewline bool // Whether the last line is missing a terminating newline
+		mode      os.FileMode
+		deleted   bool
+	}
+	files := make(map[string]*patchedFile)
+	var order []string
+	for _, patch := range patches {
+		path, err := patch.targetPath(root, strip, func(p string) bool {
+			if _, ok := files[p]; ok {
+				return true
+			}
+			_, err := os.Stat(p)
+			return err == nil
+		})
+		if err != nil {
+			return err
+		}
+		file, ok := files[path]
+		if !ok {
+			file = &patchedFile{mode: 0o644}
+			if patch.oldName != devNull {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					return err
+				}
+				file.mode = info.Mode().Perm()
+				file.lines, file.noNewline = splitLines(string(data))
+			}
+			files[path] = file
+			order = append(order, path)
+		}
+		offset := 0
+		for i, h := range patch.hunks {
+			applied, pos, ok := h.locate(file.lines, h.position()+offset)
+			if !ok {
+				rel, _ := filepath.Rel(root, path)
+				return fmt.Errorf("hunk #%d of %v does not apply", i+1, rel)
+			}
+			offset = pos - applied.position() + len(applied.newLines) - len(applied.oldLines)
+			atEnd := pos+len(applied.oldLines) == len(file.lines)
+			file.lines = applied.apply(file.lines, pos)
+			if atEnd {
+				file.noNewline = applied.newNoNewline
+			}
+		}
+		file.deleted = patch.newName == devNull && len(file.lines) == 0
+	}
+
+	for _, path := range order {
+		file := files[path]
+		if file.deleted {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			continue
+		}
+		data := strings.Join(file.lines, "\n")
+		if len(file.lines) > 0 && !file.noNewline {
+			data += "\n"
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(data), file.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// targetPath returns the path of the patched file, preferring the old name of
+// the file if it exists.
+func (patch filePatch) targetPath(root string, strip int, exists func(string) bool) (string, error) {
+	var candidates []string
+	for _, name := range []string{patch.oldName, patch.newName} {
+		if name == devNull {
+			continue
+		}
+		stripped, err := stripPath(name, strip)
+		if err != nil {
+			return "", err
+		}
+		candidates = append(candidates, filepath.Join(root, stripped))
+	}
+	if len(candidates) == 0 {
+		return "", errors.New("patched file name not defined")
+	}
+	if patch.oldName == devNull {
+		return candidates[0], nil
+	}
+	for _, candidate := range candidates {
+		if exists(candidate) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("patched file %v not found", candidates[0])
+}
+
+// stripPath removes the given number of leading components of the slash-separated path.
+func stripPath(name string, strip int) (string, error) {
+	components := strings.Split(name, "/")
+	if strip >= len(components) {
+		return "", fmt.Errorf("cannot strip %d components from %v", strip, name)
+	}
+	stripped := filepath.FromSlash(strings.Join(components[strip:], "/"))
+	if !filepath.IsLocal(stripped) {
+		return "", fmt.Errorf("patched file %v is outside of the patched directory", name)
+	}
+	return stripped, nil
+}
+
+// maxFuzz is the maximum number of leading and trailing context lines of the
+// hunk ignored when it doesn't match the file, the default fuzz factor of 'patch'.
+const maxFuzz = 2
+
+// locate returns the hunk to apply and its position in the file lines. When
+// the hunk doesn't match the file, it's searched again with an increasing
+// number of its leading and trailing context lines ignored, up to maxFuzz.
+func (h hunk) locate(lines []string, expected int) (hunk, int, bool) {
+	searched := -1 // number of lines of the last searched hunk
+	for fuzz := 0; fuzz <= maxFuzz; fuzz++ {
+		fuzzy, skipped := h.withoutContext(fuzz)
+		if len(fuzzy.ops) == searched {
+			// No more context lines to ignore
+			break
+		}
+		searched = len(fuzzy.ops)
+		if pos, ok := fuzzy.find(lines, expected+skipped); ok {
+			return fuzzy, pos, true
+		}
+	}
+	return h, 0, false
+}
+
+// withoutContext returns the hunk without up to the given number of its
+// leading and trailing context lines, together with the number of removed
+// leading context lines.
+func (h hunk) withoutContext(count int) (hunk, int) {
+	leading, trailing := 0, 0
+	for leading < count && leading < len(h.ops) && h.ops[leading] == ' ' {
+		leading++
+	}
+	for trailing < count && trailing < len(h.ops)-leading && h.ops[len(h.ops)-1-trailing] == ' ' {
+		trailing++
+	}
+	if leading == 0 && trailing == 0 {
+		return h, 0
+	}
+	fuzzy := hunk{
+		oldStart:     h.oldStart + leading,
+		oldLines:     h.oldLines[leading : len(h.oldLines)-trailing],
+		newLines:     h.newLines[leading : len(h.newLines)-trailing],
+		ops:          h.ops[leading : len(h.ops)-trailing],
+		oldNoNewline: h.oldNoNewline && trailing == 0,
+		newNoNewline: h.newNoNewline && trailing == 0,
+	}
+	if len(fuzzy.oldLines) == 0 {
+		// Keep the position of added lines, see position
+		fuzzy.oldStart--
+	}
+	return fuzzy, leading
+}
+
+// find returns the position of the hunk in the file lines, searching outwards
+// from the expected position to handle lines added or removed before it.
+func (h hunk) find(lines []string, expected int) (int, bool) {
+	matches := func(pos int) bool {
+		if pos < 0 || pos+len(h.oldLines) > len(lines) {
+			return false
+		}
+		for i, line := range h.oldLines {
+			if !equalIgnoringWhitespace(line, lines[pos+i]) {
+				return false
+			}
+		}
+		return true
+	}
+	expected = min(max(expected, 0), len(lines))
+	for delta := 0; delta <= len(lines); delta++ {
+		if matches(expected - delta) {
+			return expected - delta, true
+		}
+		if matches(expected + delta) {
+			return expected + delta, true
+		}
+	}
+	return 0, false
+}
+
+// apply returns the lines with the hunk applied at the given position. Context
+// lines are preserved as defined in the file, which might differ in whitespace.
+func (h hunk) apply(lines []string, pos int) []string {
+	result := slices.Clip(lines[:pos])
+	oldIdx, newIdx := pos, 0
+	for _, op := range h.ops {
+		switch op {
+		case ' ':
+			result = append(result, lines[oldIdx])
+			oldIdx++
+			newIdx++
+		case '-':
+			oldIdx++
+		case '+':
+			result = append(result, h.newLines[newIdx])
+			newIdx++
+		}
+	}
+	return append(result, lines[oldIdx:]...)
+}
+
+func equalIgnoringWhitespace(a, b string) bool {
+	return a == b || strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// splitLines splits the content into lines and reports whether the last line
+// is missing a terminating newline.
+func splitLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, false
+	}
+	lines := strings.Split(content, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1], false
+	}
+	return lines, true
+}
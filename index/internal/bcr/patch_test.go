@@ -0,0 +1,231 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readTree returns the content of all files in the directory keyed by their relative path.
+func readTree(t *testing.T, dir string) map[string]string {
+	files := make(map[string]string)
+	require.NoError(t, filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		files[filepath.ToSlash(rel)] = string(content)
+		return err
+	}))
+	return files
+}
+
+// copyOriginal copies the sources of patch fixture to a temporary directory.
+func copyOriginal(t *testing.T) string {
+	dir := t.TempDir()
+	require.NoError(t, os.CopyFS(dir, os.DirFS(filepath.Join("testdata", "patch", "original"))))
+	return dir
+}
+
+func TestApplyPatch(t *testing.T) {
+	patch, err := os.ReadFile(filepath.Join("testdata", "patch", "fix.patch"))
+	require.NoError(t, err)
+	// Output of 'patch -p1 -f -l' applied to the original sources
+	expected := readTree(t, filepath.Join("testdata", "patch", "expected"))
+
+	dir := copyOriginal(t)
+	require.NoError(t, applyPatch(patch, dir, 1))
+	assert.Equal(t, expected, readTree(t, dir))
+
+	t.Run("same as external patch", func(t *testing.T) {
+		binary, err := exec.LookPath(patchBinary())
+		if err != nil {
+			t.Skipf("%v not available", patchBinary())
+		}
+		patchFile, err := filepath.Abs(filepath.Join("testdata", "patch", "fix.patch"))
+		require.NoError(t, err)
+		dir := copyOriginal(t)
+		cmd := exec.Command(binary, "-p1", "-f", "-l", "--no-backup-if-mismatch", "-i", patchFile)
+		cmd.Dir = dir
+		require.NoError(t, cmd.Run())
+		assert.Equal(t, expected, readTree(t, dir))
+	})
+}
+
+func TestApplyPatchStrip(t *testing.T) {
+	patch := []byte(`--- lib/config.h
++++ lib/config.h
+@@ -1 +1 @@
+-#define DEBUG 1
++#define DEBUG 0
+`)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.h"), []byte("#define DEBUG 1\n"), 0o644))
+	require.NoError(t, applyPatch(patch, dir, 1))
+	assert.Equal(t, map[string]string{"config.h": "#define DEBUG 0\n"}, readTree(t, dir))
+
+	assert.ErrorContains(t, applyPatch(patch, dir, 2), "cannot strip 2 components")
+	assert.ErrorContains(t, applyPatch([]byte("--- a/../x.h\n+++ b/../x.h\n@@ -0,0 +1 @@\n+x\n"), dir, 1), "outside of the patched directory")
+}
+
+func TestApplyPatchDeleteFile(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "BUILD"), []byte("exports_files([\"LICENSE\"])\n"), 0o644))
+	patch := []byte(`diff --git a/BUILD b/BUILD
+deleted file mode 100644
+index 3b18e51..0000000
+--- a/BUILD
++++ /dev/null
+@@ -1 +0,0 @@
+-exports_files(["LICENSE"])
+`)
+	require.NoError(t, applyPatch(patch, dir, 1))
+	assert.NoFileExists(t, filepath.Join(dir, "BUILD"))
+}
+
+func TestApplyPatchFailure(t *testing.T) {
+	dir := copyOriginal(t)
+	original := readTree(t, dir)
+	// The first file can be patched, but changes are not written if any hunk fails
+	patch := []byte(`--- a/src/version.c
++++ b/src/version.c
+@@ -2 +2 @@
+-  return 1;
++  return 2;
+--- a/BUILD.in
++++ b/BUILD.in
+@@ -1 +1 @@
+-load("@rules_cc//cc:cc_library.bzl", "cc_library")
++load("@rules_cc//cc:defs.bzl", "cc_library")
+`)
+	assert.ErrorContains(t, applyPatch(patch, dir, 1), "hunk #1 of")
+	assert.Equal(t, original, readTree(t, dir))
+}
+
+func TestApplyPatchFuzz(t *testing.T) {
+	original := `// Copyright 2024 Example
+#include <stdio.h>
+
+int main() {
+  printf("hello\n");
+  return 0;
+}
+`
+	// The first two context lines don't match the file, the same as 'patch' it's applied with fuzz 2
+	patch := `--- a/main.c
++++ b/main.c
+@@ -1,7 +1,7 @@
+ // Copyright 2020 Example
+ #include <stdlib.h>
+
+ int main() {
+-  printf("hello\n");
++  printf("hello, world\n");
+   return 0;
+ }
+`
+	expected := map[string]string{"main.c": strings.Replace(original, `"hello\n"`, `"hello, world\n"`, 1)}
+	writeOriginal := func(t *testing.T) string {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "main.c"), []byte(original), 0o644))
+		return dir
+	}
+
+	dir := writeOriginal(t)
+	require.NoError(t, applyPatch([]byte(patch), dir, 1))
+	assert.Equal(t, expected, readTree(t, dir))
+
+	// Context lines are ignored only up to the maximum fuzz
+	dir = writeOriginal(t)
+	tooFuzzy := strings.Replace(patch, " int main() {", " int main(void) {", 1)
+	assert.ErrorContains(t, applyPatch([]byte(tooFuzzy), dir, 1), "hunk #1 of main.c does not apply")
+
+	t.Run("same as external patch", func(t *testing.T) {
+		binary, err := exec.LookPath(patchBinary())
+		if err != nil {
+			t.Skipf("%v not available", patchBinary())
+		}
+		dir := writeOriginal(t)
+		cmd := exec.Command(binary, "-p1", "-f", "-l", "--no-backup-if-mismatch")
+		cmd.Dir = dir
+		cmd.Stdin = strings.NewReader(patch)
+		require.NoError(t, cmd.Run())
+		assert.Equal(t, expected, readTree(t, dir))
+	})
+}
+
+func TestApplyPatchUnsupportedFormat(t *testing.T) {
+	patches := map[string]string{
+		"git rename": `diff --git a/old.h b/new.h
+similarity index 100%
+rename from old.h
+rename to new.h
+`,
+		"git binary": `diff --git a/logo.png b/logo.png
+GIT binary patch
+literal 0
+`,
+		"context diff": `*** a/config.h
+--- b/config.h
+***************
+*** 1 ****
+! #define DEBUG 1
+--- 1 ----
+! #define DEBUG 0
+`,
+		"normal diff": `1c1
+< #define DEBUG 1
+---
+> #define DEBUG 0
+`,
+	}
+	for name, patch := range patches {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, applyPatch([]byte(patch), t.TempDir(), 1), errUnsupportedPatch)
+		})
+	}
+}
+
+func TestApplyPatchFileFallback(t *testing.T) {
+	dir := t.TempDir()
+	patchFile := filepath.Join(dir, "context.patch")
+	require.NoError(t, os.WriteFile(patchFile, []byte("*** a/config.h\n--- b/config.h\n"), 0o644))
+
+	var commands [][]string
+	registry := BazelRegistry{run: func(cmd *exec.Cmd) error {
+		commands = append(commands, cmd.Args)
+		return nil
+	}}
+	require.NoError(t, registry.applyPatchFile(patchFile, dir, 2))
+	assert.Equal(t, [][]string{{patchBinary(), "-p2", "-f", "-l", "-i", patchFile}}, commands)
+
+	// Patches in unified diff format are never applied using the external binary
+	require.NoError(t, os.WriteFile(patchFile, []byte("--- /dev/null\n+++ b/config.h\n@@ -0,0 +1 @@\n+#define DEBUG 0\n"), 0o644))
+	require.NoError(t, registry.applyPatchFile(patchFile, dir, 1))
+	assert.Len(t, commands, 1)
+	assert.FileExists(t, filepath.Join(dir, "config.h"))
+}
@@ -17,14 +17,18 @@ package bcr
 import (
 	"archive/tar"
 	"archive/zip"
+	"bytes"
 	"compress/bzip2"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"math/rand"
 	"net/http"
 	"os"
@@ -38,25 +42,50 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bmatcuk/doublestar/v4"
 	"github.com/ulikunitz/xz"
+	"golang.org/x/sync/errgroup"
 
 	bzl "github.com/EngFlow/gazelle_cc/index/internal/bazel"
 	qproto "github.com/EngFlow/gazelle_cc/index/internal/bazel/proto"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 )
 
+// DefaultRegistryURL is the URL of the Bazel Central Registry
+const DefaultRegistryURL = "https://github.com/bazelbuild/bazel-central-registry"
+
 type BazelRegistry struct {
-	Config         BazelRegistryConfig
-	RepositoryPath string
-	httpClient     http.Client
+	Config BazelRegistryConfig
+	// Checkouts of registries in order of precedence, modules are resolved using the first registry containing them
+	RepositoryPaths []string
+	httpClient      http.Client
+	run             commandRunner
 }
 
 type BazelRegistryConfig struct {
 	CacheDir     string
-	Verbose      bool
 	KeepSources  bool
 	RecomputeBad bool
 	CacheBad     bool
+	// Use existing registry checkout without network git operations
+	Offline bool
+	// Path to a local registry used as-is instead of the checkout in CacheDir
+	RegistryPath string
+	// Git URLs of registries in order of precedence, defaults to DefaultRegistryURL
+	RegistryURLs []string
+	// Path to PEM file with additional root certificates trusted when downloading module sources
+	CABundle string
+	// Additional rule class patterns of public library rules, e.g. custom rules wrapping cc_library
+	ExtraLibraryKinds []string
+	// Public API targets of modules, when defined only these targets and their transitive deps are indexed
+	PublicAPITargets map[string][]label.Label
+	// Modules resolved without using cached results, e.g. modules changed since the last indexing
+	RecomputeModules collections.Set[string]
+	// Continue with sources left unpatched when patches of a module cannot be applied, instead of failing the module
+	IgnorePatchFailures bool
+	// Bazel startup options and additional options of queries resolving targets of modules, e.g. --noshow_progress
+	BazelStartupFlags []string
+	BazelQueryFlags   []string
 }
 
 func NewBazelRegistryConfig() BazelRegistryConfig {
@@ -67,8 +96,28 @@ func NewBazelRegistryConfig() BazelRegistryConfig {
 	}
 }
 
-func newBazelRegistryClient(config BazelRegistryConfig, repositoryPath string) BazelRegistry {
-	httpTransport := &http.Transport{
+func newBazelRegistryClient(config BazelRegistryConfig, repositoryPaths ...string) (BazelRegistry, error) {
+	httpTransport, err := newHTTPTransport(config.CABundle)
+	if err != nil {
+		return BazelRegistry{}, err
+	}
+	httpClient := http.Client{
+		Transport: httpTransport,
+		Timeout:   5 * time.Minute, // overall per request
+	}
+	return BazelRegistry{
+		Config:          config,
+		RepositoryPaths: repositoryPaths,
+		httpClient:      httpClient,
+		run:             runCommand,
+	}, nil
+}
+
+// newHTTPTransport creates transport used for downloads, honoring HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment variables.
+// Certificates from caBundle (PEM) are trusted in addition to the system roots.
+func newHTTPTransport(caBundle string) (*http.Transport, error) {
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
 		TLSHandshakeTimeout:   15 * time.Second,
 		ResponseHeaderTimeout: 30 * time.Second,
 		ExpectContinueTimeout: 1 * time.Second,
@@ -77,20 +126,66 @@ func newBazelRegistryClient(config BazelRegistryConfig, repositoryPath string) B
 		MaxConnsPerHost:       8,
 		MaxIdleConnsPerHost:   8,
 	}
-	httpClient := http.Client{
-		Transport: httpTransport,
-		Timeout:   5 * time.Minute, // overall per request
-	}
-	return BazelRegistry{
-		Config:         config,
-		RepositoryPath: repositoryPath,
-		httpClient:     httpClient,
+	if caBundle != "" {
+		pemCerts, err := os.ReadFile(caBundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			rootCAs = x509.NewCertPool()
+		}
+		if !rootCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("no certificates found in CA bundle %v", caBundle)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: rootCAs}
 	}
+	return transport, nil
 }
 
+// commandRunner executes external commands, replaceable in tests.
+type commandRunner func(cmd *exec.Cmd) error
+
+func runCommand(cmd *exec.Cmd) error { return cmd.Run() }
+
+// CheckoutBazelRegistry clones or refreshes the registries in the cache directory.
+// When RegistryPath is set, the local registry is used as-is. In Offline mode existing checkouts are used without any network operations.
 func CheckoutBazelRegistry(config BazelRegistryConfig) (BazelRegistry, error) {
-	repoDir := filepath.Join(config.CacheDir, "bazel-central-registry")
+	return checkoutBazelRegistry(config, runCommand)
+}
+
+func checkoutBazelRegistry(config BazelRegistryConfig, run commandRunner) (BazelRegistry, error) {
+	if config.RegistryPath != "" {
+		if _, err := os.Stat(filepath.Join(config.RegistryPath, "modules")); err != nil {
+			return BazelRegistry{}, fmt.Errorf("invalid registry path %v: %w", config.RegistryPath, err)
+		}
+		registry, err := newBazelRegistryClient(config, config.RegistryPath)
+		registry.run = run
+		return registry, err
+	}
+
+	registryURLs := config.RegistryURLs
+	if len(registryURLs) == 0 {
+		registryURLs = []string{DefaultRegistryURL}
+	}
+	var repoDirs []string
+	for _, registryURL := range registryURLs {
+		repoDir := registryCheckoutDir(config.CacheDir, registryURL)
+		if err := checkoutRegistry(config, registryURL, repoDir, run); err != nil {
+			return BazelRegistry{}, fmt.Errorf("failed to checkout registry %v: %w", registryURL, err)
+		}
+		repoDirs = append(repoDirs, repoDir)
+	}
+	registry, err := newBazelRegistryClient(config, repoDirs...)
+	registry.run = run
+	return registry, err
+}
+
+func checkoutRegistry(config BazelRegistryConfig, registryURL string, repoDir string, run commandRunner) error {
 	if _, err := os.Stat(repoDir); err == nil {
+		if config.Offline {
+			return nil
+		}
 		cmds := [][]string{
 			{"git", "reset", "--hard"},
 			{"git", "checkout", "main"},
@@ -102,30 +197,188 @@ func CheckoutBazelRegistry(config BazelRegistryConfig) (BazelRegistry, error) {
 			cmd.Dir = repoDir
 			cmd.Stdout = io.Discard
 			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				return BazelRegistry{}, fmt.Errorf("git refresh failed: %w", err)
+			if err := run(cmd); err != nil {
+				return fmt.Errorf("git refresh failed: %w", err)
 			}
 		}
-		return newBazelRegistryClient(config, repoDir), nil
+		return nil
+	}
+	if config.Offline {
+		return fmt.Errorf("registry checkout %v not found, it cannot be cloned in offline mode", repoDir)
 	}
 
-	if err := os.MkdirAll(config.CacheDir, 0o755); err != nil {
-		return BazelRegistry{}, err
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0o755); err != nil {
+		return err
 	}
-	cmd := exec.Command("git", "clone", "https://github.com/bazelbuild/bazel-central-registry", "--depth=1", repoDir)
+	cmd := exec.Command("git", "clone", registryURL, "--depth=1", repoDir)
 	cmd.Stdout = io.Discard
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
-		return BazelRegistry{}, fmt.Errorf("git clone failed: %w", err)
+	if err := run(cmd); err != nil {
+		return fmt.Errorf("git clone failed: %w", err)
 	}
-	return newBazelRegistryClient(config, repoDir), nil
+	return nil
+}
+
+// registryCheckoutDir returns the directory of registry checkout in the cache.
+// The Bazel Central Registry keeps its historical location, other registries are stored under registries/ using sanitized URL.
+func registryCheckoutDir(cacheDir string, registryURL string) string {
+	if registryURL == DefaultRegistryURL {
+		return filepath.Join(cacheDir, "bazel-central-registry")
+	}
+	if _, afterScheme, ok := strings.Cut(registryURL, "://"); ok {
+		registryURL = afterScheme
+	}
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '.':
+			return r
+		default:
+			return '_'
+		}
+	}, strings.TrimSuffix(registryURL, ".git"))
+	return filepath.Join(cacheDir, "registries", name)
+}
+
+// findModuleDir returns the directory of module in the first registry containing it.
+func (bcr *BazelRegistry) findModuleDir(moduleName string) (string, bool) {
+	for _, repoDir := range bcr.RepositoryPaths {
+		moduleDir := filepath.Join(repoDir, "modules", moduleName)
+		if _, err := os.Stat(filepath.Join(moduleDir, "metadata.json")); err == nil {
+			return moduleDir, true
+		}
+	}
+	return "", false
+}
+
+// ModuleNames returns sorted names of modules defined in any of the registries.
+func (bcr *BazelRegistry) ModuleNames() ([]string, error) {
+	moduleNames := collections.Set[string]{}
+	for _, repoDir := range bcr.RepositoryPaths {
+		entries, err := os.ReadDir(filepath.Join(repoDir, "modules"))
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				moduleNames.Add(e.Name())
+			}
+		}
+	}
+	return moduleNames.SortedValues(strings.Compare), nil
+}
+
+// AddPublicAPITarget registers the public API target of a module defined as <module>=<label>, e.g. fmt=//:fmt
+func (config *BazelRegistryConfig) AddPublicAPITarget(value string) error {
+	moduleName, target, ok := strings.Cut(value, "=")
+	if !ok || moduleName == "" {
+		return fmt.Errorf("expected <module>=<label>, got %q", value)
+	}
+	targetLabel, err := label.Parse(target)
+	if err != nil {
+		return fmt.Errorf("invalid public API target of module %v: %w", moduleName, err)
+	}
+	if config.PublicAPITargets == nil {
+		config.PublicAPITargets = map[string][]label.Label{}
+	}
+	config.PublicAPITargets[moduleName] = append(config.PublicAPITargets[moduleName], targetLabel.Abs("", ""))
+	return nil
 }
 
 func (bcr *BazelRegistry) ResolveModuleInfo(moduleName string, version string) ResolveModuleInfoResult {
-	modulesDir := filepath.Join(bcr.RepositoryPath, "modules")
+	return bcr.resolveModuleInfoUsingOutputBase(moduleName, version, "")
+}
+
+// resolveModuleInfoUsingOutputBase resolves the module querying its targets using the given Bazel output base,
+// or the default output base of the module workspace if empty.
+func (bcr *BazelRegistry) resolveModuleInfoUsingOutputBase(moduleName string, version string, outputBase string) ResolveModuleInfoResult {
+	rr := bcr.resolveModuleInfo(moduleName, version, outputBase)
+	if roots, ok := bcr.Config.PublicAPITargets[moduleName]; ok && rr.IsResolved() {
+		info := rr.Info.PublicAPI(roots)
+		rr.Info = &info
+	}
+	return rr
+}
+
+// ResolveModuleInfos resolves modules using at most jobs concurrent workers, results are in the order of modules.
+// Modules without version are resolved using their latest version.
+// Each worker uses a distinct Bazel output base, so concurrent queries don't contend for the same Bazel server.
+func (bcr *BazelRegistry) ResolveModuleInfos(modules []ModuleVersion, jobs int) []ResolveModuleInfoResult {
+	outputBases := make(chan string, max(jobs, 1))
+	for worker := range max(jobs, 1) {
+		outputBases <- filepath.Join(bcr.Config.CacheDir, "output_bases", fmt.Sprintf("worker-%d", worker))
+	}
+	return resolveConcurrently(modules, jobs, func(module ModuleVersion) ResolveModuleInfoResult {
+		outputBase := <-outputBases
+		defer func() { outputBases <- outputBase }()
+		rr := bcr.resolveModuleInfoUsingOutputBase(module.Name, module.Version, outputBase)
+		if rr.IsResolved() {
+			logging.Debugf("%-50s: resolved - cc_libraries: %d", rr.Info.Module.String(), len(rr.Info.Targets))
+			for _, warning := range rr.Warnings {
+				logging.Warnf("%-50s: %s", rr.Info.Module.String(), warning)
+			}
+		} else {
+			logging.Debugf("%-50s: failed   - %s", rr.Unresolved.Module.String(), rr.Unresolved.Reason)
+		}
+		return rr
+	})
+}
+
+// IndexModules resolves the latest version of all modules defined in the registries and creates the index of headers
+// defined by their libraries. Returns the index together with results of resolving each module.
+// Modules are resolved the same way in each indexing, results of modules not listed in Config.RecomputeModules
+// are loaded from the cache when available. Ambiguous headers are detected using all modules in every indexing.
+func (bcr *BazelRegistry) IndexModules(jobs int) (indexer.IndexingResult, []ResolveModuleInfoResult, error) {
+	names, err := bcr.ModuleNames()
+	if err != nil {
+		return indexer.IndexingResult{}, nil, err
+	}
+	logging.Infof("Scanning %d modules for cc_rules", len(names))
+
+	results := bcr.ResolveModuleInfos(
+		collections.MapSlice(names, func(name string) ModuleVersion {
+			return ModuleVersion{Name: name} // implicitly latest version
+		}),
+		jobs,
+	)
+
+	var infos []ModuleInfo
+	for _, r := range results {
+		if r.IsResolved() && len(r.Info.Targets) > 0 {
+			infos = append(infos, *r.Info)
+		}
+	}
+	slices.SortFunc(infos, func(a, b ModuleInfo) int {
+		if c := strings.Compare(a.Module.Name, b.Module.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Module.Version, b.Module.Version)
+	})
+	modules := collections.MapSlice(infos, func(m ModuleInfo) indexer.Module {
+		return m.ToIndexerModule().WithAmbiguousTargetsResolved()
+	})
+	return indexer.CreateHeaderIndex(modules), results, nil
+}
 
-	metaPath := filepath.Join(modulesDir, moduleName, "metadata.json")
-	b, err := os.ReadFile(metaPath)
+func resolveConcurrently(modules []ModuleVersion, jobs int, resolve func(ModuleVersion) ResolveModuleInfoResult) []ResolveModuleInfoResult {
+	results := make([]ResolveModuleInfoResult, len(modules))
+	var eg errgroup.Group
+	eg.SetLimit(max(jobs, 1))
+	for i, module := range modules {
+		eg.Go(func() error {
+			results[i] = resolve(module)
+			return nil
+		})
+	}
+	_ = eg.Wait()
+	return results
+}
+
+func (bcr *BazelRegistry) resolveModuleInfo(moduleName string, version string, outputBase string) ResolveModuleInfoResult {
+	moduleDir, ok := bcr.findModuleDir(moduleName)
+	if !ok {
+		return unresolved(moduleName, "No metadata.json")
+	}
+	b, err := os.ReadFile(filepath.Join(moduleDir, "metadata.json"))
 	if err != nil {
 		return unresolved(moduleName, "No metadata.json")
 	}
@@ -148,35 +401,38 @@ func (bcr *BazelRegistry) ResolveModuleInfo(moduleName string, version string) R
 	}
 
 	cacheFile := filepath.Join(bcr.Config.CacheDir, "modules", moduleName, version, "module-info.json")
-	if cached, err := bcr.tryLoadCached(cacheFile); err == nil {
-		if cached.IsResolved() || !bcr.Config.RecomputeBad {
-			return cached
+	if !bcr.Config.RecomputeModules.Contains(moduleName) {
+		if cached, err := bcr.tryLoadCached(cacheFile); err == nil {
+			if cached.IsResolved() || !bcr.Config.RecomputeBad {
+				return cached
+			}
 		}
 	}
 
-	sourcesDir := filepath.Join(modulesDir, moduleName, version)
-	srcRootDir, projectRoot, err := bcr.prepareModuleSources(sourcesDir)
+	rr := bcr.resolveModuleSources(mv, filepath.Join(moduleDir, version), func(projectRoot string) ([]ModuleTarget, error) {
+		return bcr.resolveTargets(projectRoot, bcr.queryConfig(outputBase))
+	})
+	bcr.saveMaybe(cacheFile, rr)
+	return rr
+}
+
+// resolveModuleSources prepares sources of the module version defined in the registry directory and resolves their targets.
+func (bcr *BazelRegistry) resolveModuleSources(mv ModuleVersion, moduleVersionDir string, resolveTargets func(projectRoot string) ([]ModuleTarget, error)) ResolveModuleInfoResult {
+	srcRootDir, projectRoot, warnings, err := bcr.prepareModuleSources(moduleVersionDir)
 	if err != nil {
-		rr := unresolvedMV(mv, "Failed to prepare project sources: "+err.Error())
-		bcr.saveMaybe(cacheFile, rr)
-		return rr
+		return unresolvedMV(mv, "Failed to prepare project sources: "+err.Error())
 	}
 
-	targets, err := bcr.resolveTargets(projectRoot)
+	targets, err := resolveTargets(projectRoot)
 	if !bcr.Config.KeepSources {
 		_ = os.RemoveAll(srcRootDir)
 	}
 	if err != nil {
-		rr := unresolvedMV(mv, "Failed to resolve module targets: "+err.Error())
-		bcr.saveMaybe(cacheFile, rr)
-		return rr
+		return unresolvedMV(mv, "Failed to resolve module targets: "+err.Error())
 	}
 
 	info := ModuleInfo{Module: mv, Targets: targets}
-	rr := ResolveModuleInfoResult{Info: &info}
-	bcr.saveMaybe(cacheFile, rr)
-
-	return rr
+	return ResolveModuleInfoResult{Info: &info, Warnings: warnings}
 }
 
 // =====================================================================================
@@ -205,6 +461,46 @@ type ModuleInfo struct {
 	Targets []ModuleTarget `json:"targets"`
 }
 
+// PublicAPI returns the module info restricted to the given root targets and targets transitively reachable using their deps.
+// Roots might refer to either the name of the target or its alias. Private targets are never part of the module info,
+// so these are skipped together with public targets not reachable from the roots.
+func (m ModuleInfo) PublicAPI(roots []label.Label) ModuleInfo {
+	byName := make(map[label.Label]int, len(m.Targets))
+	for i, target := range m.Targets {
+		byName[target.Name] = i
+		if target.Alias != nil {
+			byName[*target.Alias] = i
+		}
+	}
+
+	reachable := collections.Set[int]{}
+	var queue []int
+	visit := func(name label.Label) {
+		if i, ok := byName[name]; ok && !reachable.Contains(i) {
+			reachable.Add(i)
+			queue = append(queue, i)
+		}
+	}
+	for _, root := range roots {
+		visit(root)
+	}
+	for len(queue) > 0 {
+		target := m.Targets[queue[0]]
+		queue = queue[1:]
+		for _, dep := range target.Deps {
+			visit(dep.Abs(target.Name.Repo, target.Name.Pkg))
+		}
+	}
+
+	var targets []ModuleTarget
+	for i, target := range m.Targets {
+		if reachable.Contains(i) {
+			targets = append(targets, target)
+		}
+	}
+	return ModuleInfo{Module: m.Module, Targets: targets}
+}
+
 func (m ModuleInfo) ToIndexerModule() indexer.Module {
 	targets := make([]indexer.Target, 0, len(m.Targets))
 	for _, target := range m.Targets {
@@ -242,6 +538,8 @@ type ResolveModuleInfoResult struct {
 		Module ModuleVersion `json:"module"`
 		Reason string        `json:"reason"`
 	} `json:"unresolved,omitempty"`
+	// Problems which didn't prevent resolving the module, e.g. patches which could not be applied
+	Warnings []string `json:"warnings,omitempty"`
 }
 
 func (r ResolveModuleInfoResult) IsResolved() bool   { return r.Info != nil }
@@ -258,6 +556,7 @@ type metadataJSON struct {
 type sourceJSON struct {
 	Type        string            `json:"type"` // "" => archive
 	URL         string            `json:"url"`
+	Integrity   string            `json:"integrity"`
 	StripPrefix string            `json:"strip_prefix"`
 	PatchStrip  int               `json:"patch_strip"`
 	Patches     map[string]string `json:"patches"`
@@ -300,33 +599,32 @@ func (bcr *BazelRegistry) saveMaybe(path string, rr ResolveModuleInfoResult) {
 // Sources: download / extract / patch
 // =====================================================================================
 
-func (bcr *BazelRegistry) prepareModuleSources(moduleVersionDir string) (sourcesDir, projectRoot string, err error) {
+// prepareModuleSources downloads, extracts and patches sources of the module version. When IgnorePatchFailures is set
+// patches which cannot be applied are skipped and reported in the returned warnings.
+func (bcr *BazelRegistry) prepareModuleSources(moduleVersionDir string) (sourcesDir, projectRoot string, warnings []string, err error) {
 	rel, err := filepath.Rel(filepath.Join(moduleVersionDir, "..", ".."), moduleVersionDir)
 	if err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 	targetDir := filepath.Join(bcr.Config.CacheDir, "modules", filepath.FromSlash(rel), "sources")
 	_ = os.RemoveAll(targetDir)
 	if err := os.MkdirAll(targetDir, 0o755); err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 
 	var src sourceJSON
 	if err := json.Unmarshal(mustRead(filepath.Join(moduleVersionDir, "source.json")), &src); err != nil {
-		return "", "", err
+		return "", "", nil, err
 	}
 	if src.Type == "git_repository" {
-		return "", "", errors.New("git_repository modules not supported yet")
+		return "", "", nil, errors.New("git_repository modules not supported yet")
 	}
 
-	archivePath, err := bcr.downloadWithRetries(src.URL)
-	if err != nil {
-		return "", "", err
+	if err := bcr.downloadArchive(src.URL, src.Integrity, func(archivePath string) error {
+		return extractArchive(archivePath, targetDir)
+	}); err != nil {
+		return "", "", nil, err
 	}
-	if err := extractArchive(archivePath, targetDir); err != nil {
-		return "", "", err
-	}
-	_ = os.Remove(archivePath)
 	root := targetDir
 	if src.StripPrefix != "" {
 		root = filepath.Join(targetDir, filepath.FromSlash(src.StripPrefix))
@@ -335,17 +633,11 @@ func (bcr *BazelRegistry) prepareModuleSources(moduleVersionDir string) (sources
 	patchesDir := filepath.Join(moduleVersionDir, "patches")
 	if st, err := os.Stat(patchesDir); err == nil && st.IsDir() && len(src.Patches) > 0 {
 		for name := range src.Patches {
-			patchFile := filepath.Join(patchesDir, name)
-			patchBin := "patch"
-			if isMacOS() {
-				patchBin = "gpatch"
-			}
-			cmd := exec.Command(patchBin, fmt.Sprintf("-p%d", src.PatchStrip), "-f", "-l", "-i", patchFile)
-			cmd.Dir = root
-			cmd.Stdout = io.Discard
-			cmd.Stderr = os.Stderr
-			if err := cmd.Run(); err != nil {
-				return "", "", fmt.Errorf("applying patch %s failed: %w", name, err)
+			if err := bcr.applyPatchFile(filepath.Join(patchesDir, name), root, src.PatchStrip); err != nil {
+				if !bcr.Config.IgnorePatchFailures {
+					return "", "", nil, fmt.Errorf("applying patch %s failed: %w", name, err)
+				}
+				warnings = append(warnings, fmt.Sprintf("patch %s not applied: %v", name, err))
 			}
 		}
 	}
@@ -364,13 +656,57 @@ func (bcr *BazelRegistry) prepareModuleSources(moduleVersionDir string) (sources
 		})
 	}
 
-	return targetDir, root, nil
+	return targetDir, root, warnings, nil
 }
 
-func (bcr *BazelRegistry) downloadWithRetries(url string) (string, error) {
-	tmpDir, _ := os.MkdirTemp("", "bcr-dl-")
+// applyPatchFile applies the patch to sources in the root directory. Patches
+// using formats unsupported by applyPatch are applied using the external patch binary.
+func (bcr *BazelRegistry) applyPatchFile(patchFile string, root string, strip int) error {
+	content, err := os.ReadFile(patchFile)
+	if err != nil {
+		return err
+	}
+	err = applyPatch(content, root, strip)
+	if !errors.Is(err, errUnsupportedPatch) {
+		return err
+	}
+	logging.Debugf("Applying %v using %v: %v", patchFile, patchBinary(), err)
+	cmd := exec.Command(patchBinary(), fmt.Sprintf("-p%d", strip), "-f", "-l", "-i", patchFile)
+	cmd.Dir = root
+	cmd.Stdout = io.Discard
+	cmd.Stderr = os.Stderr
+	return bcr.run(cmd)
+}
+
+// patchBinary returns the binary used to apply patches of modules, GNU patch is required on macOS.
+func patchBinary() string {
+	if isMacOS() {
+		return "gpatch"
+	}
+	return "patch"
+}
+
+// downloadArchive downloads the archive to a temporary directory and passes its path to use.
+// The temporary directory is always removed afterwards, regardless of the download result.
+func (bcr *BazelRegistry) downloadArchive(url string, integrity string, use func(archivePath string) error) error {
+	tmpDir, err := os.MkdirTemp("", "bcr-dl-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath, _, err := bcr.downloadWithRetries(url, integrity, tmpDir)
+	if err != nil {
+		return err
+	}
+	return use(archivePath)
+}
+
+// downloadWithRetries downloads the file into dstDir and returns its path together with SHA-256 digest computed while downloading.
+// When integrity is given in the SRI format (e.g. sha256-<base64>) the digest is verified against it, integrity using other algorithms is not verified.
+func (bcr *BazelRegistry) downloadWithRetries(url string, integrity string, dstDir string) (string, []byte, error) {
 	name := filepath.Base(strings.Split(url, "?")[0])
-	dst := filepath.Join(tmpDir, name)
+	dst := filepath.Join(dstDir, name)
 
 	var last error
 	const maxAttempts = 3
@@ -387,7 +723,7 @@ func (bcr *BazelRegistry) downloadWithRetries(url string) (string, error) {
 		if err != nil {
 			// Do NOT retry on timeouts
 			if isTimeoutErr(err) {
-				return "", fmt.Errorf("download aborted due to timeout: %w", err)
+				return "", nil, fmt.Errorf("download aborted due to timeout: %w", err)
 			}
 			last = err
 			// retry (non-timeout failure)
@@ -399,7 +735,7 @@ func (bcr *BazelRegistry) downloadWithRetries(url string) (string, error) {
 			// Treat timeout-like HTTP statuses as non-retryable
 			if isTimeoutStatus(resp.StatusCode) {
 				resp.Body.Close()
-				return "", fmt.Errorf("download aborted due to server timeout (HTTP %d)", resp.StatusCode)
+				return "", nil, fmt.Errorf("download aborted due to server timeout (HTTP %d)", resp.StatusCode)
 			}
 			last = fmt.Errorf("http %d", resp.StatusCode)
 			resp.Body.Close()
@@ -411,16 +747,17 @@ func (bcr *BazelRegistry) downloadWithRetries(url string) (string, error) {
 		f, err := os.Create(dst)
 		if err != nil {
 			resp.Body.Close()
-			return "", err
+			return "", nil, err
 		}
 
-		_, err = io.Copy(f, resp.Body)
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
 		resp.Body.Close()
 		f.Close()
 		if err != nil {
 			// Fail fast on read timeouts too
 			if isTimeoutErr(err) {
-				return "", fmt.Errorf("download aborted due to timeout while reading body: %w", err)
+				return "", nil, fmt.Errorf("download aborted due to timeout while reading body: %w", err)
 			}
 			last = err
 			// retry (non-timeout copy failure)
@@ -428,10 +765,31 @@ func (bcr *BazelRegistry) downloadWithRetries(url string) (string, error) {
 			continue
 		}
 
-		return dst, nil
+		digest := hash.Sum(nil)
+		if err := verifyIntegrity(integrity, digest); err != nil {
+			return "", nil, fmt.Errorf("downloaded %v: %w", url, err)
+		}
+		return dst, digest, nil
 	}
 
-	return "", fmt.Errorf("download failed after retries: %w", last)
+	return "", nil, fmt.Errorf("download failed after retries: %w", last)
+}
+
+// verifyIntegrity compares SHA-256 digest with the expected integrity in the SRI format.
+// Empty integrity and integrity using other algorithms are accepted.
+func verifyIntegrity(integrity string, sha256Digest []byte) error {
+	algorithm, encoded, ok := strings.Cut(integrity, "-")
+	if !ok || algorithm != "sha256" {
+		return nil
+	}
+	expected, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("invalid integrity %v: %w", integrity, err)
+	}
+	if !bytes.Equal(expected, sha256Digest) {
+		return fmt.Errorf("integrity mismatch, expected %v, got sha256-%v", integrity, base64.StdEncoding.EncodeToString(sha256Digest))
+	}
+	return nil
 }
 
 // =====================================================================================
@@ -558,9 +916,23 @@ func unzip(zipPath, outDir string) error {
 // Bazel query via protobuf
 // =====================================================================================
 
+// queryConfig returns the configuration of queries resolving module targets using the given Bazel output base,
+// or the default output base of the module workspace if empty.
+func (bcr *BazelRegistry) queryConfig(outputBase string) bzl.QueryConfig {
+	config := bzl.QueryConfig{
+		KeepGoing:    true,
+		StartupFlags: slices.Clone(bcr.Config.BazelStartupFlags),
+		Flags:        bcr.Config.BazelQueryFlags,
+	}
+	if outputBase != "" {
+		config.StartupFlags = append(config.StartupFlags, "--output_base="+outputBase)
+	}
+	return config
+}
+
 // resolveTargets runs a single protobuf-based bazel query and converts it into ModuleTarget[].
 // Mirrors the XML path logic (aliases, filegroups, expand_template, public cc_*library).
-func (_ *BazelRegistry) resolveTargets(projectRoot string) ([]ModuleTarget, error) {
+func (bcr *BazelRegistry) resolveTargets(projectRoot string, queryConfig bzl.QueryConfig) ([]ModuleTarget, error) {
 	// Find nested repositories, these might need to be excluded
 	innerModules, _ := doublestar.FilepathGlob(projectRoot + "/*/**/{MODULE,MODULE.bazel,WORKSPACE,WORKSPACE.bazel}")
 	excludeConditions := collections.MapSlice(innerModules, func(modulePath string) string {
@@ -570,11 +942,10 @@ func (_ *BazelRegistry) resolveTargets(projectRoot string) ([]ModuleTarget, erro
 		return fmt.Sprintf("except //%v/...", filepath.ToSlash(relDirectory))
 	})
 
-	// Single query composing the same selector set as before.
-	query := `(kind("cc_.*library|alias", //...:*) intersect attr(visibility, //visibility:public, //...:*)) union kind("expand_template|filegroup", //...:*) ` + strings.Join(excludeConditions, " ")
-	result, err := bzl.ConfiguredQuery(projectRoot, query, bzl.QueryConfig{KeepGoing: true})
+	query := libraryTargetsQuery(bcr.Config.ExtraLibraryKinds, excludeConditions)
+	result, err := bzl.ConfiguredQuery(projectRoot, query, queryConfig)
 	if err != nil {
-		log.Printf("query failed: %v, query:%v", err, query)
+		logging.Errorf("query failed: %v, query:%v", err, query)
 		return nil, err
 	}
 
@@ -679,6 +1050,27 @@ func (_ *BazelRegistry) resolveTargets(projectRoot string) ([]ModuleTarget, erro
 	return targets, nil
 }
 
+// libraryTargetsQuery composes a single query selecting public library rules and helpers used to resolve their sources.
+// Rules defined using macros are matched by the expanded rule class, extraLibraryKinds allows to select custom rule classes.
+func libraryTargetsQuery(extraLibraryKinds []string, excludeConditions []string) string {
+	libraryKinds := append([]string{"cc_.*library", "alias"}, extraLibraryKinds...)
+	return fmt.Sprintf(
+		`(kind("%s", //...:*) intersect attr(visibility, //visibility:public, //...:*)) union kind("expand_template|filegroup", //...:*) %s`,
+		strings.Join(libraryKinds, "|"),
+		strings.Join(excludeConditions, " "))
+}
+
+// ParseLibraryKinds splits a comma separated list of rule class patterns, e.g. "my_cc_library,cc_lib_.*".
+func ParseLibraryKinds(value string) []string {
+	var kinds []string
+	for kind := range strings.SplitSeq(value, ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	return kinds
+}
+
 // shouldExcludeTarget determines if the given target (label) is possibly internal.
 func shouldExcludeTarget(label label.Label) bool {
 	// Check target's path segments: if any segment (split on non-word characters and filtered to letters)
@@ -15,10 +15,27 @@
 package bcr
 
 import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	bzl "github.com/EngFlow/gazelle_cc/index/internal/bazel"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestShouldExcludeTarget(t *testing.T) {
@@ -40,3 +57,431 @@ func TestShouldExcludeTarget(t *testing.T) {
 		})
 	}
 }
+
+func TestLibraryTargetsQuery(t *testing.T) {
+	tests := []struct {
+		name              string
+		extraLibraryKinds []string
+		excludeConditions []string
+		expected          string
+	}{
+		{
+			name:     "default kinds",
+			expected: `(kind("cc_.*library|alias", //...:*) intersect attr(visibility, //visibility:public, //...:*)) union kind("expand_template|filegroup", //...:*) `,
+		},
+		{
+			name:              "custom library rule class",
+			extraLibraryKinds: []string{"my_cc_library", "cc_lib_.*"},
+			excludeConditions: []string{"except //examples/...", "except //tools/..."},
+			expected:          `(kind("cc_.*library|alias|my_cc_library|cc_lib_.*", //...:*) intersect attr(visibility, //visibility:public, //...:*)) union kind("expand_template|filegroup", //...:*) except //examples/... except //tools/...`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, libraryTargetsQuery(tt.extraLibraryKinds, tt.excludeConditions))
+		})
+	}
+}
+
+func TestParseLibraryKinds(t *testing.T) {
+	assert.Nil(t, ParseLibraryKinds(""))
+	assert.Equal(t, []string{"my_cc_library"}, ParseLibraryKinds("my_cc_library"))
+	assert.Equal(t, []string{"my_cc_library", "cc_lib_.*"}, ParseLibraryKinds(" my_cc_library, ,cc_lib_.* "))
+}
+
+func TestPublicAPI(t *testing.T) {
+	umbrellaAlias := label.New("", "", "mylib")
+	info := ModuleInfo{
+		Module: ModuleVersion{Name: "mylib", Version: "1.0"},
+		Targets: []ModuleTarget{
+			{
+				Name:  label.New("", "", "umbrella"),
+				Alias: &umbrellaAlias,
+				Hdrs:  []label.Label{label.New("", "", "mylib.h")},
+				Deps:  []label.Label{label.New("", "core", "core")},
+			},
+			{
+				Name: label.New("", "core", "core"),
+				Hdrs: []label.Label{label.New("", "", "core.h")},
+				Deps: []label.Label{{Name: "types", Relative: true}},
+			},
+			{
+				Name: label.New("", "core", "types"),
+				Hdrs: []label.Label{label.New("", "", "types.h")},
+			},
+			{
+				Name: label.New("", "", "internal"),
+				Hdrs: []label.Label{label.New("", "", "internal.h")},
+				Deps: []label.Label{label.New("", "core", "core")},
+			},
+		},
+	}
+	targetNames := func(info ModuleInfo) []label.Label {
+		var names []label.Label
+		for _, target := range info.Targets {
+			names = append(names, target.Name)
+		}
+		return names
+	}
+
+	tests := []struct {
+		name     string
+		roots    []label.Label
+		expected []label.Label
+	}{
+		{
+			name:     "umbrella target",
+			roots:    []label.Label{label.New("", "", "umbrella")},
+			expected: []label.Label{label.New("", "", "umbrella"), label.New("", "core", "core"), label.New("", "core", "types")},
+		},
+		{
+			name:     "umbrella alias",
+			roots:    []label.Label{umbrellaAlias},
+			expected: []label.Label{label.New("", "", "umbrella"), label.New("", "core", "core"), label.New("", "core", "types")},
+		},
+		{
+			name:     "inner target",
+			roots:    []label.Label{label.New("", "core", "types")},
+			expected: []label.Label{label.New("", "core", "types")},
+		},
+		{
+			name:     "unknown target",
+			roots:    []label.Label{label.New("", "", "unknown")},
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := info.PublicAPI(tt.roots)
+			assert.Equal(t, info.Module, result.Module)
+			assert.Equal(t, tt.expected, targetNames(result))
+		})
+	}
+}
+
+func TestAddPublicAPITarget(t *testing.T) {
+	var config BazelRegistryConfig
+	assert.NoError(t, config.AddPublicAPITarget("fmt=//:fmt"))
+	assert.NoError(t, config.AddPublicAPITarget("fmt=//extra:fmt_extra"))
+	assert.NoError(t, config.AddPublicAPITarget("zlib=:zlib"))
+	assert.Equal(t, map[string][]label.Label{
+		"fmt":  {label.New("", "", "fmt"), label.New("", "extra", "fmt_extra")},
+		"zlib": {label.New("", "", "zlib")},
+	}, config.PublicAPITargets)
+
+	assert.Error(t, config.AddPublicAPITarget("//:fmt"))
+	assert.Error(t, config.AddPublicAPITarget("=//:fmt"))
+	assert.Error(t, config.AddPublicAPITarget("fmt=//:fmt:invalid"))
+}
+
+func TestCheckoutBazelRegistry(t *testing.T) {
+	type recordingRunner struct{ commands [][]string }
+	newRunner := func() (*recordingRunner, commandRunner) {
+		recorder := &recordingRunner{}
+		return recorder, func(cmd *exec.Cmd) error {
+			recorder.commands = append(recorder.commands, cmd.Args)
+			return nil
+		}
+	}
+	withCheckout := func(t *testing.T) string {
+		cacheDir := t.TempDir()
+		require.NoError(t, os.MkdirAll(filepath.Join(cacheDir, "bazel-central-registry", "modules"), 0o755))
+		return cacheDir
+	}
+
+	t.Run("refresh existing checkout", func(t *testing.T) {
+		cacheDir := withCheckout(t)
+		recorder, run := newRunner()
+		registry, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: cacheDir}, run)
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(cacheDir, "bazel-central-registry")}, registry.RepositoryPaths)
+		assert.Contains(t, recorder.commands, []string{"git", "fetch", "origin"})
+	})
+
+	t.Run("offline mode uses existing checkout", func(t *testing.T) {
+		cacheDir := withCheckout(t)
+		recorder, run := newRunner()
+		registry, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: cacheDir, Offline: true}, run)
+		require.NoError(t, err)
+		assert.Equal(t, []string{filepath.Join(cacheDir, "bazel-central-registry")}, registry.RepositoryPaths)
+		assert.Empty(t, recorder.commands)
+	})
+
+	t.Run("offline mode without checkout", func(t *testing.T) {
+		recorder, run := newRunner()
+		_, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: t.TempDir(), Offline: true}, run)
+		assert.Error(t, err)
+		assert.Empty(t, recorder.commands)
+	})
+
+	t.Run("clone missing checkout", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		recorder, run := newRunner()
+		_, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: cacheDir}, run)
+		require.NoError(t, err)
+		assert.Equal(t, [][]string{
+			{"git", "clone", DefaultRegistryURL, "--depth=1", filepath.Join(cacheDir, "bazel-central-registry")},
+		}, recorder.commands)
+	})
+
+	t.Run("clone custom registry", func(t *testing.T) {
+		cacheDir := t.TempDir()
+		recorder, run := newRunner()
+		registryURL := "https://git.example.com/mirrors/bcr.git"
+		registry, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: cacheDir, RegistryURLs: []string{registryURL}}, run)
+		require.NoError(t, err)
+		expectedDir := filepath.Join(cacheDir, "registries", "git.example.com_mirrors_bcr")
+		assert.Equal(t, []string{expectedDir}, registry.RepositoryPaths)
+		assert.Equal(t, [][]string{{"git", "clone", registryURL, "--depth=1", expectedDir}}, recorder.commands)
+	})
+
+	t.Run("local registry path", func(t *testing.T) {
+		registryPath := t.TempDir()
+		require.NoError(t, os.Mkdir(filepath.Join(registryPath, "modules"), 0o755))
+		recorder, run := newRunner()
+		registry, err := checkoutBazelRegistry(BazelRegistryConfig{CacheDir: t.TempDir(), RegistryPath: registryPath}, run)
+		require.NoError(t, err)
+		assert.Equal(t, []string{registryPath}, registry.RepositoryPaths)
+		assert.Empty(t, recorder.commands)
+
+		_, err = checkoutBazelRegistry(BazelRegistryConfig{RegistryPath: filepath.Join(registryPath, "missing")}, run)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveModuleInfoUsingMultipleRegistries(t *testing.T) {
+	writeModule := func(t *testing.T, registryDir string, moduleName string, metadata string) {
+		moduleDir := filepath.Join(registryDir, "modules", moduleName)
+		require.NoError(t, os.MkdirAll(moduleDir, 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(moduleDir, "metadata.json"), []byte(metadata), 0o644))
+	}
+	cacheDir := t.TempDir()
+	privateURL := "https://git.example.com/private-registry"
+	privateDir := registryCheckoutDir(cacheDir, privateURL)
+	centralDir := registryCheckoutDir(cacheDir, DefaultRegistryURL)
+	// Invalid metadata allows to distinguish which registry was used without preparing module sources
+	writeModule(t, privateDir, "fmt", `{"versions": []}`)
+	writeModule(t, centralDir, "fmt", `{"versions": ["1.0"]}`)
+	writeModule(t, centralDir, "zlib", `{"versions": ["1.0"], "yanked_versions": {"1.0": "broken"}}`)
+
+	registry, err := checkoutBazelRegistry(BazelRegistryConfig{
+		CacheDir:     cacheDir,
+		RegistryURLs: []string{privateURL, DefaultRegistryURL},
+		Offline:      true,
+	}, func(cmd *exec.Cmd) error { return nil })
+	require.NoError(t, err)
+	assert.Equal(t, []string{privateDir, centralDir}, registry.RepositoryPaths)
+
+	moduleNames, err := registry.ModuleNames()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fmt", "zlib"}, moduleNames)
+
+	// Resolved using the first registry defining the module
+	assert.Equal(t, "Invalid metadata.json", registry.ResolveModuleInfo("fmt", "").Unresolved.Reason)
+	// Fallback to next registry
+	assert.Equal(t, "latest version is yanked - ignore", registry.ResolveModuleInfo("zlib", "").Unresolved.Reason)
+	assert.Equal(t, "No metadata.json", registry.ResolveModuleInfo("unknown", "").Unresolved.Reason)
+}
+
+func TestDownloadWithRetriesDigest(t *testing.T) {
+	content := []byte("module archive content")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer server.Close()
+	registry, err := newBazelRegistryClient(NewBazelRegistryConfig())
+	require.NoError(t, err)
+
+	path, digest, err := registry.downloadWithRetries(server.URL+"/archive.tar.gz", "", t.TempDir())
+	require.NoError(t, err)
+	downloaded, err := os.ReadFile(path)
+	require.NoError(t, err)
+	expected := sha256.Sum256(downloaded)
+	assert.Equal(t, expected[:], digest)
+	assert.Equal(t, content, downloaded)
+
+	integrity := "sha256-" + base64.StdEncoding.EncodeToString(expected[:])
+	_, _, err = registry.downloadWithRetries(server.URL+"/archive.tar.gz", integrity, t.TempDir())
+	assert.NoError(t, err)
+
+	otherDigest := sha256.Sum256([]byte("other content"))
+	_, _, err = registry.downloadWithRetries(server.URL+"/archive.tar.gz", "sha256-"+base64.StdEncoding.EncodeToString(otherDigest[:]), t.TempDir())
+	assert.ErrorContains(t, err, "integrity mismatch")
+}
+
+func TestDownloadArchiveCleanup(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+	tmpDir := t.TempDir()
+	t.Setenv("TMPDIR", tmpDir)
+	registry, err := newBazelRegistryClient(NewBazelRegistryConfig())
+	require.NoError(t, err)
+	invalidIntegrity := "sha256-" + base64.StdEncoding.EncodeToString(make([]byte, sha256.Size))
+
+	for i := range 5 {
+		url := fmt.Sprintf("%v/archive-%d.tar.gz", server.URL, i)
+		err := registry.downloadArchive(url, "", func(archivePath string) error {
+			assert.FileExists(t, archivePath)
+			return nil
+		})
+		assert.NoError(t, err)
+		// Failed downloads
+		assert.Error(t, registry.downloadArchive(url, invalidIntegrity, func(string) error { return nil }))
+		assert.Error(t, registry.downloadArchive(url, "", func(string) error { return errors.New("extraction failed") }))
+	}
+
+	leftovers, err := os.ReadDir(tmpDir)
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	digest := sha256.Sum256([]byte("content"))
+	encoded := base64.StdEncoding.EncodeToString(digest[:])
+	assert.NoError(t, verifyIntegrity("", digest[:]))
+	assert.NoError(t, verifyIntegrity("sha256-"+encoded, digest[:]))
+	// Other algorithms cannot be verified using SHA-256 digest
+	assert.NoError(t, verifyIntegrity("sha512-"+encoded, digest[:]))
+	assert.Error(t, verifyIntegrity("sha256-"+base64.StdEncoding.EncodeToString([]byte("invalid")), digest[:]))
+	assert.Error(t, verifyIntegrity("sha256-not base64", digest[:]))
+}
+
+func TestNewHTTPTransport(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	t.Run("proxy from environment", func(t *testing.T) {
+		transport, err := newHTTPTransport("")
+		require.NoError(t, err)
+		// http.ProxyFromEnvironment caches the environment on first use, compare the function instead
+		assert.Equal(t, reflect.ValueOf(http.ProxyFromEnvironment).Pointer(), reflect.ValueOf(transport.Proxy).Pointer())
+	})
+
+	t.Run("server with unknown CA", func(t *testing.T) {
+		transport, err := newHTTPTransport("")
+		require.NoError(t, err)
+		_, err = (&http.Client{Transport: transport}).Get(server.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("extra CA bundle", func(t *testing.T) {
+		caBundle := filepath.Join(t.TempDir(), "ca.pem")
+		pemCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+		require.NoError(t, os.WriteFile(caBundle, pemCert, 0o644))
+
+		transport, err := newHTTPTransport(caBundle)
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("invalid CA bundle", func(t *testing.T) {
+		caBundle := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(caBundle, []byte("not a certificate"), 0o644))
+		_, err := newHTTPTransport(caBundle)
+		assert.Error(t, err)
+		_, err = newHTTPTransport(filepath.Join(t.TempDir(), "missing.pem"))
+		assert.Error(t, err)
+	})
+}
+
+func TestResolveConcurrently(t *testing.T) {
+	modules := make([]ModuleVersion, 20)
+	for i := range modules {
+		modules[i] = ModuleVersion{Name: fmt.Sprintf("module_%d", i)}
+	}
+
+	for _, jobs := range []int{1, 3, 8} {
+		t.Run(fmt.Sprintf("jobs=%d", jobs), func(t *testing.T) {
+			var running, maxRunning atomic.Int32
+			results := resolveConcurrently(modules, jobs, func(module ModuleVersion) ResolveModuleInfoResult {
+				current := running.Add(1)
+				for {
+					observed := maxRunning.Load()
+					if current <= observed || maxRunning.CompareAndSwap(observed, current) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				running.Add(-1)
+				return ResolveModuleInfoResult{Info: &ModuleInfo{Module: module}}
+			})
+
+			assert.LessOrEqual(t, maxRunning.Load(), int32(jobs))
+			require.Len(t, results, len(modules))
+			for i, result := range results {
+				assert.Equal(t, modules[i], result.Info.Module)
+			}
+		})
+	}
+}
+
+func TestResolveModuleSourcesIgnoringPatchFailures(t *testing.T) {
+	header := "int lib();\n"
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "lib-1.0/include/lib.h", Mode: 0o644, Size: int64(len(header))}))
+	_, err := tw.Write([]byte(header))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(archive.Bytes())
+	}))
+	defer server.Close()
+
+	moduleVersionDir := filepath.Join(t.TempDir(), "modules", "lib", "1.0")
+	require.NoError(t, os.MkdirAll(filepath.Join(moduleVersionDir, "patches"), 0o755))
+	source := fmt.Sprintf(`{"url": "%v/lib-1.0.tar", "strip_prefix": "lib-1.0", "patch_strip": 1, "patches": {"broken.patch": ""}}`, server.URL)
+	require.NoError(t, os.WriteFile(filepath.Join(moduleVersionDir, "source.json"), []byte(source), 0o644))
+	brokenPatch := "--- a/include/lib.h\n+++ b/include/lib.h\n@@ -1 +1 @@\n-int missing();\n+int lib(void);\n"
+	require.NoError(t, os.WriteFile(filepath.Join(moduleVersionDir, "patches", "broken.patch"), []byte(brokenPatch), 0o644))
+
+	mv := ModuleVersion{Name: "lib", Version: "1.0"}
+	libTarget := ModuleTarget{Name: label.New("lib", "", "lib"), Hdrs: []label.Label{label.New("lib", "", "include/lib.h")}}
+	resolveTargets := func(projectRoot string) ([]ModuleTarget, error) {
+		content, err := os.ReadFile(filepath.Join(projectRoot, "include", "lib.h"))
+		require.NoError(t, err)
+		assert.Equal(t, header, string(content), "sources should be left unpatched")
+		return []ModuleTarget{libTarget}, nil
+	}
+
+	registry, err := newBazelRegistryClient(BazelRegistryConfig{CacheDir: t.TempDir()})
+	require.NoError(t, err)
+	rr := registry.resolveModuleSources(mv, moduleVersionDir, resolveTargets)
+	require.True(t, rr.IsUnresolved())
+	assert.Contains(t, rr.Unresolved.Reason, "applying patch broken.patch failed")
+
+	registry.Config.IgnorePatchFailures = true
+	rr = registry.resolveModuleSources(mv, moduleVersionDir, resolveTargets)
+	require.True(t, rr.IsResolved())
+	assert.Equal(t, []ModuleTarget{libTarget}, rr.Info.Targets)
+	require.Len(t, rr.Warnings, 1)
+	assert.Contains(t, rr.Warnings[0], "patch broken.patch not applied: hunk #1 of include/lib.h does not apply")
+}
+
+func TestQueryConfig(t *testing.T) {
+	registry := BazelRegistry{Config: BazelRegistryConfig{
+		BazelStartupFlags: []string{"--max_idle_secs=60"},
+		BazelQueryFlags:   []string{"--noshow_progress", "--curses=no"},
+	}}
+	assert.Equal(t, bzl.QueryConfig{
+		KeepGoing:    true,
+		StartupFlags: []string{"--max_idle_secs=60", "--output_base=/cache/output_bases/worker-0"},
+		Flags:        []string{"--noshow_progress", "--curses=no"},
+	}, registry.queryConfig("/cache/output_bases/worker-0"))
+	assert.Equal(t, bzl.QueryConfig{
+		KeepGoing:    true,
+		StartupFlags: []string{"--max_idle_secs=60"},
+		Flags:        []string{"--noshow_progress", "--curses=no"},
+	}, registry.queryConfig(""))
+	// Configured startup flags are not modified
+	assert.Equal(t, []string{"--max_idle_secs=60"}, registry.Config.BazelStartupFlags)
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// UnresolvedCategory groups unresolved modules failing for a similar reason.
+type UnresolvedCategory struct {
+	Name  string
+	Count int
+	// Up to maxExamples names of modules in this category
+	Examples []string
+}
+
+func (c UnresolvedCategory) String() string {
+	return fmt.Sprintf("%-30s: %d, e.g. %v", c.Name, c.Count, strings.Join(c.Examples, ", "))
+}
+
+// SummarizeUnresolved groups unresolved results by category of their reason, ordered by descending number of modules.
+func SummarizeUnresolved(results []ResolveModuleInfoResult, maxExamples int) []UnresolvedCategory {
+	byName := map[string]*UnresolvedCategory{}
+	for _, result := range results {
+		if !result.IsUnresolved() {
+			continue
+		}
+		name := categorizeUnresolvedReason(result.Unresolved.Reason)
+		category, exists := byName[name]
+		if !exists {
+			category = &UnresolvedCategory{Name: name}
+			byName[name] = category
+		}
+		category.Count++
+		if len(category.Examples) < maxExamples {
+			category.Examples = append(category.Examples, result.Unresolved.Module.Name)
+		}
+	}
+
+	categories := make([]UnresolvedCategory, 0, len(byName))
+	for _, category := range byName {
+		categories = append(categories, *category)
+	}
+	slices.SortFunc(categories, func(l, r UnresolvedCategory) int {
+		return cmp.Or(cmp.Compare(r.Count, l.Count), strings.Compare(l.Name, r.Name))
+	})
+	return categories
+}
+
+// categorizeUnresolvedReason maps the reason of unresolved module created in ResolveModuleInfo to its category.
+func categorizeUnresolvedReason(reason string) string {
+	switch {
+	case reason == "No metadata.json":
+		return "no metadata"
+	case reason == "Invalid metadata.json":
+		return "invalid metadata"
+	case strings.Contains(reason, "yanked"):
+		return "yanked version"
+	case reason == "metadata not found for given version":
+		return "unknown version"
+	case strings.Contains(reason, "git_repository modules not supported"):
+		return "git_repository unsupported"
+	case strings.HasPrefix(reason, "Failed to prepare project sources: "):
+		switch {
+		case strings.Contains(reason, "download"):
+			return "download failed"
+		case strings.Contains(reason, "applying patch"):
+			return "patch failed"
+		case strings.Contains(reason, "unsupported archive"):
+			return "unsupported archive"
+		default:
+			return "sources preparation failed"
+		}
+	case strings.HasPrefix(reason, "Failed to resolve module targets: "):
+		return "query failed"
+	default:
+		return "other"
+	}
+}
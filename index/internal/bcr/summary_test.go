@@ -0,0 +1,55 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package bcr
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSummarizeUnresolved(t *testing.T) {
+	results := []ResolveModuleInfoResult{
+		unresolved("a", "No metadata.json"),
+		unresolved("b", "Invalid metadata.json"),
+		unresolved("c", "latest version is yanked - ignore"),
+		unresolved("d", "metadata not found for given version"),
+		unresolved("e", "Failed to prepare project sources: git_repository modules not supported yet"),
+		unresolved("f", "Failed to prepare project sources: download failed after retries: http 404"),
+		unresolved("g", "Failed to prepare project sources: download aborted due to timeout: context deadline exceeded"),
+		unresolved("h", "Failed to prepare project sources: downloaded https://example.com/h.tar.gz: integrity mismatch"),
+		unresolved("i", "Failed to prepare project sources: applying patch fix.patch failed: exit status 1"),
+		unresolved("j", "Failed to prepare project sources: unsupported archive: j.7z"),
+		unresolved("k", "Failed to prepare project sources: open source.json: no such file or directory"),
+		unresolved("l", "Failed to resolve module targets: exit status 7"),
+		unresolved("m", "Failed to resolve module targets: exit status 1"),
+		unresolved("n", "something unexpected"),
+		{Info: &ModuleInfo{Module: ModuleVersion{Name: "resolved"}}},
+	}
+
+	assert.Equal(t, []UnresolvedCategory{
+		{Name: "download failed", Count: 3, Examples: []string{"f", "g"}},
+		{Name: "query failed", Count: 2, Examples: []string{"l", "m"}},
+		{Name: "git_repository unsupported", Count: 1, Examples: []string{"e"}},
+		{Name: "invalid metadata", Count: 1, Examples: []string{"b"}},
+		{Name: "no metadata", Count: 1, Examples: []string{"a"}},
+		{Name: "other", Count: 1, Examples: []string{"n"}},
+		{Name: "patch failed", Count: 1, Examples: []string{"i"}},
+		{Name: "sources preparation failed", Count: 1, Examples: []string{"k"}},
+		{Name: "unknown version", Count: 1, Examples: []string{"d"}},
+		{Name: "unsupported archive", Count: 1, Examples: []string{"j"}},
+		{Name: "yanked version", Count: 1, Examples: []string{"c"}},
+	}, SummarizeUnresolved(results, 2))
+}
@@ -0,0 +1,3 @@
+int version() {
+  return 1;
+}
\ No newline at end of file
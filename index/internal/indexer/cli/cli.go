@@ -20,15 +20,22 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 )
 
 // Common flags available in all indexers, added as sideeffect of importing package
 var (
-	Verbose       = flag.Bool("verbose", false, "Enable verbose logging")
 	output        = flag.String("output", "output.ccidx", "Output file path for index")
 	repositoryDir = flag.String("repository", "", "Explicit path to bazel repository, if ommited BUILD_WORKSPACE_DIRECTORY env variable or current working directory is used")
+	dryRun        = flag.Bool("dry-run", false, "Log the number of header mappings and the output path instead of writing the index file")
+	binary        = flag.Bool("binary", false, "Write the index in the compact binary format instead of JSON")
 )
 
+func init() {
+	logging.RegisterFlags(flag.CommandLine, "verbose")
+}
+
 // Resolve working directory for indexer, uses either explicit --repository path, BUILD_WORKSPACE_DIRECTORY env variable or current working directory
 func ResolveWorkingDir() (string, error) {
 	if !flag.Parsed() {
@@ -63,3 +70,19 @@ func ResolveOutputFile() string {
 	}
 	return outputFile
 }
+
+// IsDryRun returns true if the index should not be written to the output file, see --dry-run flag.
+func IsDryRun() bool {
+	if !flag.Parsed() {
+		log.Panicln("Flags not parsed yet")
+	}
+	return *dryRun
+}
+
+// IsBinaryOutput returns true if the index should be written in the compact binary format, see --binary flag.
+func IsBinaryOutput() bool {
+	if !flag.Parsed() {
+		log.Panicln("Flags not parsed yet")
+	}
+	return *binary
+}
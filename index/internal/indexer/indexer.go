@@ -28,13 +28,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"maps"
-	"os"
 	"path"
 	"path/filepath"
 	"slices"
 	"strings"
 
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/includepath"
 	"github.com/EngFlow/gazelle_cc/internal/index"
 	"github.com/bazelbuild/bazel-gazelle/label"
 )
@@ -124,7 +125,10 @@ func CreateHeaderIndex(modules []Module) IndexingResult {
 
 // Writes the mapping of IndexingResult.HeaderToRule to disk in JSON format.
 // Labels are stored as renered strings
-func (result IndexingResult) WriteToFile(outputFile string) error {
+// Files with .gz extension, e.g. deps.ccindex.gz, are compressed using gzip.
+// In binary mode the compact binary format is used instead of JSON, see index.DependencyIndex.MarshalBinary.
+// In dry run mode nothing is written, the number of mappings and the output path are logged instead.
+func (result IndexingResult) WriteToFile(outputFile string, dryRun, binary bool) error {
 	// TODO: Temporary conversion to the new index.DependencyIndex format, so
 	// "//index:integration_tests" can pass for PR #182. The real migration to
 	// index.DependencyIndex will be done in another PR.
@@ -133,18 +137,38 @@ func (result IndexingResult) WriteToFile(outputFile string) error {
 		mappings[hdr] = []label.Label{dep}
 	}
 
-	data, err := json.MarshalIndent(mappings, "", "  ")
+	data, err := serializeDependencyIndex(mappings, binary)
 	if err != nil {
-		return fmt.Errorf("failed to serialize header index to json: %w", err)
+		return err
+	}
+
+	if dryRun {
+		logging.Infof("Dry run: would write %d header mappings (%d bytes) to %v", len(mappings), len(data), outputFile)
+		return nil
 	}
 
-	os.MkdirAll(filepath.Dir(outputFile), 0777)
-	if err := os.WriteFile(outputFile, data, 0666); err != nil {
+	if err := index.WriteFile(outputFile, data); err != nil {
 		return fmt.Errorf("failed to write index file: %w", err)
 	}
 	return nil
 }
 
+// Serializes the index using either the binary or the JSON format.
+func serializeDependencyIndex(mappings index.DependencyIndex, binary bool) ([]byte, error) {
+	if binary {
+		data, err := mappings.MarshalBinary()
+		if err != nil {
+			return nil, fmt.Errorf("failed to serialize header index to binary format: %w", err)
+		}
+		return data, nil
+	}
+	data, err := json.MarshalIndent(mappings, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize header index to json: %w", err)
+	}
+	return data, nil
+}
+
 // String returns a human-readable string representation of the IndexingResult.
 func (result IndexingResult) String() string {
 	var sb strings.Builder
@@ -197,13 +221,14 @@ func shouldExcludeHeader(path string) bool {
 // Returned paths reflect all valid compiler-visible forms for the header within the target’s package.
 // They are useful for detecting which targets may expose a given header or for header-to-target indexing.
 // It does expose possible include paths introduced as sideffects by other targets
+// The returned paths are sorted lexicographically.
 func IndexableIncludePaths(header label.Label, target Target) []string {
 	packagePath := target.Name.Pkg
 	targetRelHdr := header.Rel(target.Name.Repo, target.Name.Pkg)
-	hdr := filepath.ToSlash(filepath.Join(targetRelHdr.Pkg, targetRelHdr.Name))
+	hdr := includepath.Normalize(path.Join(targetRelHdr.Pkg, targetRelHdr.Name))
 
 	// Always include full path relative to workspace root
-	headerPath := filepath.ToSlash(filepath.Join(packagePath, hdr))
+	headerPath := includepath.Normalize(path.Join(packagePath, hdr))
 	possibleIncludes := collections.SetOf(headerPath)
 
 	// 1. Handle strip_include_prefix
@@ -215,8 +240,8 @@ func IndexableIncludePaths(header label.Label, target Target) []string {
 		}
 		fullHdrPath := path.Join(header.Pkg, header.Name)
 
-		if rel, err := filepath.Rel(stripPrefix, fullHdrPath); err == nil && !strings.HasPrefix(rel, "..") {
-			stripped = filepath.ToSlash(rel)
+		if rel, ok := includepath.TrimPrefix(fullHdrPath, stripPrefix); ok {
+			stripped = rel
 			// Only add the stripped path if it’s not prefixed later
 			if target.IncludePrefix == "" {
 				possibleIncludes.Add(stripped)
@@ -226,24 +251,18 @@ func IndexableIncludePaths(header label.Label, target Target) []string {
 
 	// 2. Apply include_prefix (only valid when include_prefix is set)
 	if target.IncludePrefix != "" && stripped != "" {
-		withPrefix := filepath.ToSlash(path.Join(target.IncludePrefix, stripped))
+		withPrefix := includepath.Normalize(path.Join(target.IncludePrefix, stripped))
 		possibleIncludes.Add(withPrefix)
 	}
 
-	// 3. Derive paths from `includes`
-	for include := range target.Includes {
-		includePath := include
-		if includePath == "." {
-			includePath = ""
-		}
-		fullIncludePath := path.Join(packagePath, includePath)
-		fullHdrPath := path.Join(packagePath, hdr)
+	// 3. Derive paths from `includes`, every matching include contributes its own path.
+	// Iterate in sorted order to make the result independent of set ordering.
+	for _, include := range target.Includes.SortedValues(strings.Compare) {
+		fullIncludePath := includepath.Normalize(path.Join(packagePath, include))
+		fullHdrPath := includepath.Normalize(path.Join(packagePath, hdr))
 
-		if rel, err := filepath.Rel(fullIncludePath, fullHdrPath); err == nil && !strings.HasPrefix(rel, "..") {
-			rel = filepath.ToSlash(rel)
-			if rel != "" {
-				possibleIncludes.Add(rel)
-			}
+		if rel, ok := includepath.TrimPrefix(fullHdrPath, fullIncludePath); ok && rel != "" {
+			possibleIncludes.Add(rel)
 		}
 	}
 
@@ -253,6 +272,6 @@ func IndexableIncludePaths(header label.Label, target Target) []string {
 		possibleIncludes.Add(path.Join(packagePath, hdr))
 	}
 
-	// Final collection
-	return possibleIncludes.Values()
+	// Final collection, sorted to keep indexing results deterministic
+	return possibleIncludes.SortedValues(strings.Compare)
 }
@@ -15,9 +15,14 @@
 package indexer
 
 import (
+	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/index"
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/stretchr/testify/assert"
 )
@@ -53,6 +58,14 @@ func TestIndexableIncludePaths(t *testing.T) {
 			},
 			expected: []string{"include/subdir/header.h", "subdir/header.h", "header.h"},
 		},
+		{
+			name:    "non normalized include paths",
+			hdrPath: "include/subdir/header.h",
+			target: Target{
+				Includes: collections.SetOf("./include/", "include//subdir/"),
+			},
+			expected: []string{"include/subdir/header.h", "subdir/header.h", "header.h"},
+		},
 		{
 			name:    "use package path when no includes",
 			hdrPath: "header.h",
@@ -181,12 +194,60 @@ func TestIndexableIncludePaths(t *testing.T) {
 				"lib/pkg/subdir/pkg3.h",
 			},
 		},
+		{
+			name:    "equal length includes both matching",
+			hdrPath: "inc1/inc2/header.h",
+			target: Target{
+				Name:     label.Label{Pkg: "lib"},
+				Includes: collections.SetOf("inc1/inc2", "inc1", "inc2"),
+			},
+			expected: []string{
+				"header.h",
+				"inc2/header.h",
+				"lib/inc1/inc2/header.h",
+			},
+		},
+		{
+			name:    "include being a substring but not a path prefix",
+			hdrPath: "include/foo.h",
+			target: Target{
+				Name:     label.Label{Pkg: "lib"},
+				Includes: collections.SetOf("inc"),
+			},
+			expected: []string{
+				"lib/include/foo.h",
+			},
+		},
+		{
+			name:    "strip include prefix being a substring but not a path prefix",
+			hdrPath: "include/foo.h",
+			target: Target{
+				Name:               label.Label{Pkg: "lib"},
+				StripIncludePrefix: "inc",
+			},
+			expected: []string{
+				"lib/include/foo.h",
+			},
+		},
+		{
+			name:    "include matching the header path exactly",
+			hdrPath: "include/foo.h",
+			target: Target{
+				Name:     label.Label{Pkg: "lib"},
+				Includes: collections.SetOf("include", "include/foo.h"),
+			},
+			expected: []string{
+				"foo.h",
+				"lib/include/foo.h",
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			result := IndexableIncludePaths(label.Label{Name: tt.hdrPath}, tt.target)
 			assert.ElementsMatch(t, tt.expected, result)
+			assert.IsIncreasing(t, result)
 		})
 	}
 }
@@ -283,3 +344,64 @@ func TestCreateHeaderIndex(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteToFileDryRun(t *testing.T) {
+	var logs bytes.Buffer
+	logging.SetOutput(&logs)
+	defer logging.SetOutput(os.Stderr)
+
+	result := IndexingResult{
+		HeaderToRule: map[string]label.Label{
+			"foo.h": {Repo: "foo", Name: "foo"},
+			"bar.h": {Repo: "bar", Pkg: "lib", Name: "bar"},
+		},
+	}
+	outputFile := filepath.Join(t.TempDir(), "out", "index.ccidx")
+
+	assert.NoError(t, result.WriteToFile(outputFile, true, false))
+	assert.NoFileExists(t, outputFile)
+	assert.NoDirExists(t, filepath.Dir(outputFile))
+	assert.Contains(t, logs.String(), "would write 2 header mappings")
+	assert.Contains(t, logs.String(), outputFile)
+
+	assert.NoError(t, result.WriteToFile(outputFile, false, false))
+	assert.FileExists(t, outputFile)
+}
+
+func TestWriteToFileCompressed(t *testing.T) {
+	result := IndexingResult{
+		HeaderToRule: map[string]label.Label{
+			"foo.h": {Repo: "foo", Name: "foo"},
+		},
+	}
+	outputFile := filepath.Join(t.TempDir(), "index.ccindex.gz")
+	assert.NoError(t, result.WriteToFile(outputFile, false, false))
+
+	loaded, err := index.LoadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, index.DependencyIndex{"foo.h": {label.New("foo", "", "foo")}}, loaded)
+}
+
+func TestWriteToFileBinary(t *testing.T) {
+	result := IndexingResult{
+		HeaderToRule: map[string]label.Label{
+			"foo.h": {Repo: "foo", Name: "foo"},
+		},
+	}
+	outputFile := filepath.Join(t.TempDir(), "index.ccidx")
+
+	// The format is selected explicitly, never based on the extension
+	assert.NoError(t, result.WriteToFile(outputFile, false, false))
+	data, err := os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("{")))
+
+	assert.NoError(t, result.WriteToFile(outputFile, false, true))
+	data, err = os.ReadFile(outputFile)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasPrefix(data, []byte("CCIDX")))
+
+	loaded, err := index.LoadFile(outputFile)
+	assert.NoError(t, err)
+	assert.Equal(t, index.DependencyIndex{"foo.h": {label.New("foo", "", "foo")}}, loaded)
+}
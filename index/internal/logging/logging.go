@@ -0,0 +1,114 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Leveled logging used by the indexer CLIs.
+// Diagnostics are always written to stderr, machine readable summaries should be written to stdout instead.
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarn
+	LevelInfo
+	LevelDebug
+)
+
+var levelNames = []string{"error", "warn", "info", "debug"}
+
+func (l Level) String() string {
+	if l < LevelError || l > LevelDebug {
+		return fmt.Sprintf("Level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses case-insensitive level name: error, warn, info or debug.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q, expected one of %v", name, strings.Join(levelNames, ", "))
+}
+
+// Logger writes messages with level not more verbose than the configured one.
+type Logger struct {
+	level  Level
+	output *log.Logger
+}
+
+func New(w io.Writer, level Level) *Logger {
+	return &Logger{level: level, output: log.New(w, "", log.LstdFlags)}
+}
+
+func (l *Logger) SetLevel(level Level)              { l.level = level }
+func (l *Logger) SetOutput(w io.Writer)             { l.output.SetOutput(w) }
+func (l *Logger) Enabled(level Level) bool          { return level <= l.level }
+func (l *Logger) Errorf(format string, args ...any) { l.logf(LevelError, format, args...) }
+func (l *Logger) Warnf(format string, args ...any)  { l.logf(LevelWarn, format, args...) }
+func (l *Logger) Infof(format string, args ...any)  { l.logf(LevelInfo, format, args...) }
+func (l *Logger) Debugf(format string, args ...any) { l.logf(LevelDebug, format, args...) }
+
+// Fatalf logs the error and terminates the process.
+func (l *Logger) Fatalf(format string, args ...any) {
+	l.logf(LevelError, format, args...)
+	os.Exit(1)
+}
+
+func (l *Logger) logf(level Level, format string, args ...any) {
+	if l.Enabled(level) {
+		l.output.Print(strings.ToUpper(level.String()) + ": " + fmt.Sprintf(format, args...))
+	}
+}
+
+// Default logger writing to stderr, used by the package level functions.
+var std = New(os.Stderr, LevelInfo)
+
+func SetLevel(level Level)              { std.SetLevel(level) }
+func SetOutput(w io.Writer)             { std.SetOutput(w) }
+func Enabled(level Level) bool          { return std.Enabled(level) }
+func Errorf(format string, args ...any) { std.Errorf(format, args...) }
+func Warnf(format string, args ...any)  { std.Warnf(format, args...) }
+func Infof(format string, args ...any)  { std.Infof(format, args...) }
+func Debugf(format string, args ...any) { std.Debugf(format, args...) }
+func Fatalf(format string, args ...any) { std.Fatalf(format, args...) }
+
+// RegisterFlags registers the -log-level flag configuring the default logger
+// and a boolean flag with the given name being an alias of -log-level=debug.
+func RegisterFlags(fs *flag.FlagSet, verboseFlagName string) {
+	fs.Func("log-level", "Logging level, one of: error, warn, info, debug (default info)", func(value string) error {
+		level, err := ParseLevel(value)
+		if err == nil {
+			SetLevel(level)
+		}
+		return err
+	})
+	fs.BoolFunc(verboseFlagName, "Enable verbose logging, same as -log-level=debug", func(value string) error {
+		if value == "true" {
+			SetLevel(LevelDebug)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerLevels(t *testing.T) {
+	var output strings.Builder
+	logger := New(&output, LevelInfo)
+	logger.Debugf("debug %d", 1)
+	logger.Infof("info %d", 2)
+	logger.Warnf("warn %d", 3)
+	logger.Errorf("error %d", 4)
+
+	assert.NotContains(t, output.String(), "debug 1")
+	assert.Contains(t, output.String(), "INFO: info 2")
+	assert.Contains(t, output.String(), "WARN: warn 3")
+	assert.Contains(t, output.String(), "ERROR: error 4")
+
+	output.Reset()
+	logger.SetLevel(LevelDebug)
+	logger.Debugf("debug %d", 1)
+	assert.Contains(t, output.String(), "DEBUG: debug 1")
+
+	output.Reset()
+	logger.SetLevel(LevelError)
+	logger.Warnf("warn %d", 3)
+	assert.Empty(t, output.String())
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Level
+	}{
+		{"error", LevelError},
+		{"warn", LevelWarn},
+		{"info", LevelInfo},
+		{"DEBUG", LevelDebug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := ParseLevel(tt.name)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, level)
+		})
+	}
+
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestRegisterFlags(t *testing.T) {
+	defer SetLevel(std.level)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs, "verbose")
+	assert.NoError(t, fs.Parse([]string{"-log-level=warn"}))
+	assert.True(t, Enabled(LevelWarn))
+	assert.False(t, Enabled(LevelInfo))
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs, "verbose")
+	assert.NoError(t, fs.Parse([]string{"-verbose"}))
+	assert.True(t, Enabled(LevelDebug))
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(&strings.Builder{})
+	RegisterFlags(fs, "verbose")
+	assert.Error(t, fs.Parse([]string{"-log-level=verbose"}))
+}
@@ -31,5 +31,5 @@ func main() {
 		HeaderToRule: map[string]label.Label{
 			"example.h": {Repo: "example", Pkg: "some/lib", Name: "target"},
 		},
-	}.WriteToFile(outputFile)
+	}.WriteToFile(outputFile, cli.IsDryRun(), cli.IsBinaryOutput())
 }
@@ -17,13 +17,13 @@ package main
 import (
 	"flag"
 	"fmt"
-	"log"
 	"strings"
 
 	"github.com/EngFlow/gazelle_cc/index/internal/bazel"
 	"github.com/EngFlow/gazelle_cc/index/internal/bazel/proto"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer"
 	"github.com/EngFlow/gazelle_cc/index/internal/indexer/cli"
+	"github.com/EngFlow/gazelle_cc/index/internal/logging"
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/bazelbuild/bazel-gazelle/label"
 )
@@ -35,13 +35,13 @@ func main() {
 	flag.Parse()
 	workdir, err := cli.ResolveWorkingDir()
 	if err != nil {
-		log.Fatalf("Failed to resolve working directory, %w", err)
+		logging.Fatalf("Failed to resolve working directory, %v", err)
 	}
 	outputFile := cli.ResolveOutputFile()
 
 	defsQuery, err := bazel.Query(workdir, "kind('cmake|configure_make|make|ninja', //...)")
 	if err != nil {
-		log.Fatal("Bazel query failed, unable to index foreign_cc rules")
+		logging.Fatalf("Bazel query failed, unable to index foreign_cc rules: %v", err)
 	}
 	modules := []indexer.Module{}
 	for _, foreignDefn := range defsQuery.GetTarget() {
@@ -51,22 +51,18 @@ func main() {
 	}
 
 	indexingResult := indexer.CreateHeaderIndex(modules)
-	indexingResult.WriteToFile(outputFile)
+	indexingResult.WriteToFile(outputFile, cli.IsDryRun(), cli.IsBinaryOutput())
 
-	if *cli.Verbose {
-		log.Println(indexingResult.String())
-	}
+	logging.Debugf("%v", indexingResult.String())
 }
 
 func collectModuleInfo(workdir string, foreignDefn *proto.Target) *indexer.Module {
 	targets := []indexer.Target{}
 	libSource := bazel.GetNamedAttribute(foreignDefn, "lib_source").GetStringValue()
 	includeDir := bazel.GetNamedAttribute(foreignDefn, "out_include_dir").GetStringValue()
-	if *cli.Verbose {
-		log.Printf("Processing foreign_cc rule %v: %v", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
-	}
+	logging.Debugf("Processing foreign_cc rule %v: %v", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
 	if libSource == "" {
-		log.Printf("Cannot resolve 'lib_source' attr in %v: %v, target would be skipped", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
+		logging.Warnf("Cannot resolve 'lib_source' attr in %v: %v, target would be skipped", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
 		return nil
 	}
 
@@ -80,7 +76,7 @@ func collectModuleInfo(workdir string, foreignDefn *proto.Target) *indexer.Modul
 
 	hdrs := collections.Set[label.Label]{}
 	if sourcesQuery, err := bazel.Query(workdir, libSource); err != nil {
-		log.Printf("Failed to query for details for lib_source %v: %w", libSource, err)
+		logging.Errorf("Failed to query for details for lib_source %v: %v", libSource, err)
 	} else {
 		for _, sourcesTarget := range sourcesQuery.GetTarget() {
 			switch sourcesTarget.GetRule().GetRuleClass() {
@@ -91,7 +87,7 @@ func collectModuleInfo(workdir string, foreignDefn *proto.Target) *indexer.Modul
 					}
 				}
 			default:
-				log.Printf("Unsupported kind of lib_source attribute %v:%v referenced in %v:%v, this target would not be indexed",
+				logging.Warnf("Unsupported kind of lib_source attribute %v:%v referenced in %v:%v, this target would not be indexed",
 					sourcesTarget.GetRule().GetRuleClass(), sourcesTarget.GetRule().GetName(),
 					foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
 			}
@@ -102,7 +98,7 @@ func collectModuleInfo(workdir string, foreignDefn *proto.Target) *indexer.Modul
 		fmt.Sprintf("kind(cc_library, rdeps(//..., %s, 1))", foreignDefn.GetRule().GetName()),
 		bazel.QueryConfig{KeepGoing: true},
 	); err != nil {
-		log.Printf("Failed to found direct dependanant of %v:%v", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
+		logging.Errorf("Failed to found direct dependanant of %v:%v", foreignDefn.GetRule().GetRuleClass(), foreignDefn.GetRule().GetName())
 		return nil
 	} else {
 		for _, ccLib := range depsQuery.GetTarget() {
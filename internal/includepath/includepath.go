@@ -0,0 +1,68 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package includepath provides helpers for comparing paths used in #include
+// directives and exposed by libraries, so that different spellings of the same
+// path, e.g. "./foo.h" and "foo.h", are handled consistently.
+package includepath
+
+import (
+	"path"
+	"strings"
+)
+
+// Normalize returns the canonical form of the include path: backslashes are
+// replaced with slashes, redundant separators, trailing slashes and "."
+// elements are removed and ".." elements are resolved where possible, see
+// path.Clean. The empty path and "." are normalized to the empty path.
+func Normalize(p string) string {
+	if p == "" {
+		return ""
+	}
+	p = path.Clean(strings.ReplaceAll(p, `\`, "/"))
+	if p == "." {
+		return ""
+	}
+	return p
+}
+
+// Equal reports whether both include paths refer to the same file after
+// normalization.
+func Equal(a, b string) bool {
+	return Normalize(a) == Normalize(b)
+}
+
+// TrimPrefix returns the path relative to the given prefix directory and true
+// if the prefix matches whole leading path segments, e.g. "inc" is a prefix of
+// "inc/foo.h", but not of "include/foo.h". An empty prefix matches any path.
+// Both paths are expected to be normalized.
+func TrimPrefix(p, prefix string) (string, bool) {
+	switch {
+	case prefix == "" || prefix == ".":
+		return p, true
+	case p == prefix:
+		return "", true
+	case strings.HasPrefix(p, prefix+"/"):
+		return p[len(prefix)+1:], true
+	default:
+		return "", false
+	}
+}
+
+// EscapesRoot reports whether the include path, after normalization, refers to
+// a file outside of the directory it is relative to, e.g. "../foo.h".
+func EscapesRoot(p string) bool {
+	p = Normalize(p)
+	return p == ".." || strings.HasPrefix(p, "../")
+}
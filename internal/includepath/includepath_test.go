@@ -0,0 +1,93 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package includepath
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{path: "foo.h", expected: "foo.h"},
+		{path: "./foo.h", expected: "foo.h"},
+		{path: "././foo.h", expected: "foo.h"},
+		{path: "dir/", expected: "dir"},
+		{path: "dir//foo.h", expected: "dir/foo.h"},
+		{path: "dir/./foo.h", expected: "dir/foo.h"},
+		{path: "dir/sub/../foo.h", expected: "dir/foo.h"},
+		{path: "../foo.h", expected: "../foo.h"},
+		{path: `dir\sub\foo.h`, expected: "dir/sub/foo.h"},
+		{path: "/usr/include/stdio.h", expected: "/usr/include/stdio.h"},
+		{path: ".", expected: ""},
+		{path: "./", expected: ""},
+		{path: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.expected, Normalize(tt.path))
+		})
+	}
+}
+
+func TestEqual(t *testing.T) {
+	assert.True(t, Equal("foo.h", "./foo.h"))
+	assert.True(t, Equal("dir/", "dir"))
+	assert.True(t, Equal("dir/sub/../foo.h", `dir\foo.h`))
+	assert.True(t, Equal("", "."))
+	assert.False(t, Equal("foo.h", "dir/foo.h"))
+	assert.False(t, Equal("../foo.h", "foo.h"))
+}
+
+func TestTrimPrefix(t *testing.T) {
+	tests := []struct {
+		path     string
+		prefix   string
+		expected string
+		ok       bool
+	}{
+		{path: "include/foo.h", prefix: "include", expected: "foo.h", ok: true},
+		{path: "include/foo.h", prefix: "inc", ok: false},
+		{path: "include/foo.h", prefix: "include/foo.h", expected: "", ok: true},
+		{path: "include/foo.h", prefix: "", expected: "include/foo.h", ok: true},
+		{path: "include/foo.h", prefix: ".", expected: "include/foo.h", ok: true},
+		{path: "include/foo.h", prefix: "include/foo", ok: false},
+		{path: "include", prefix: "include/foo", ok: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path+" "+tt.prefix, func(t *testing.T) {
+			rel, ok := TrimPrefix(tt.path, tt.prefix)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.expected, rel)
+		})
+	}
+}
+
+func TestEscapesRoot(t *testing.T) {
+	assert.False(t, EscapesRoot("foo.h"))
+	assert.False(t, EscapesRoot("dir/../foo.h"))
+	assert.False(t, EscapesRoot("..foo.h"))
+	assert.False(t, EscapesRoot(""))
+	assert.True(t, EscapesRoot(".."))
+	assert.True(t, EscapesRoot("../foo.h"))
+	assert.True(t, EscapesRoot("dir/../../foo.h"))
+	assert.True(t, EscapesRoot(`..\foo.h`))
+}
@@ -0,0 +1,196 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Version of the binary format written by MarshalBinary, incremented on each
+// incompatible change of the format.
+const binaryVersion = 1
+
+// Magic number starting each index in the binary format, followed by the version
+var binaryMagic = []byte("CCIDX")
+
+// ErrUnsupportedVersion is returned when loading an index written using
+// a different version of the binary format.
+var ErrUnsupportedVersion = errors.New("unsupported version of the binary index format")
+
+var (
+	_ encoding.BinaryMarshaler   = (*DependencyIndex)(nil)
+	_ encoding.BinaryUnmarshaler = (*DependencyIndex)(nil)
+)
+
+// MarshalBinary serializes the index in the compact binary format, intended
+// for large indexes which are slow to parse from JSON. JSON remains the
+// interchange format, e.g. for inspecting or diffing the indexes.
+//
+// The format consists of the magic number and the version, followed by the
+// table of distinct labels and the entries of headers sorted by their path,
+// each referencing its labels by their position in the table. Strings are
+// prefixed with their length, all integers are encoded as unsigned varints.
+// Each label is parsed only once when loading, as labels are typically shared
+// by many headers.
+func (index DependencyIndex) MarshalBinary() ([]byte, error) {
+	labelIds := make(map[label.Label]int)
+	var labels []string
+	for _, header := range slices.Sorted(maps.Keys(index)) {
+		for _, lbl := range index[header] {
+			if _, exists := labelIds[lbl]; !exists {
+				labelIds[lbl] = len(labels)
+				labels = append(labels, lbl.String())
+			}
+		}
+	}
+
+	data := append([]byte(nil), binaryMagic...)
+	data = binary.AppendUvarint(data, binaryVersion)
+	data = binary.AppendUvarint(data, uint64(len(labels)))
+	for _, lbl := range labels {
+		data = appendString(data, lbl)
+	}
+	data = binary.AppendUvarint(data, uint64(len(index)))
+	for _, header := range slices.Sorted(maps.Keys(index)) {
+		data = appendString(data, header)
+		data = binary.AppendUvarint(data, uint64(len(index[header])))
+		for _, lbl := range index[header] {
+			data = binary.AppendUvarint(data, uint64(labelIds[lbl]))
+		}
+	}
+	return data, nil
+}
+
+func appendString(data []byte, s string) []byte {
+	data = binary.AppendUvarint(data, uint64(len(s)))
+	return append(data, s...)
+}
+
+// UnmarshalBinary deserializes the index written using MarshalBinary. Returns
+// ErrUnsupportedVersion if the index was written using a different version of
+// the format.
+func (index *DependencyIndex) UnmarshalBinary(data []byte) error {
+	reader := binaryReader{data: data}
+	if !reader.readMagic() {
+		return errors.New("missing magic number of the binary index format")
+	}
+	if version := reader.readUvarint(); reader.err == nil && version != binaryVersion {
+		return fmt.Errorf("%w: %d, expected %d", ErrUnsupportedVersion, version, binaryVersion)
+	}
+
+	labels := make([]label.Label, reader.readLength())
+	for i := range labels {
+		s := reader.readString()
+		if reader.err != nil {
+			break
+		}
+		lbl, err := label.Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid label %q: %w", s, err)
+		}
+		labels[i] = lbl
+	}
+
+	headers := reader.readLength()
+	result := make(DependencyIndex, headers)
+	for range headers {
+		header := reader.readString()
+		refs := make([]label.Label, reader.readLength())
+		for i := range refs {
+			id := reader.readUvarint()
+			if reader.err != nil {
+				break
+			}
+			if id >= uint64(len(labels)) {
+				return fmt.Errorf("label #%d of header %q is out of range, found %d labels", id, header, len(labels))
+			}
+			refs[i] = labels[id]
+		}
+		if reader.err != nil {
+			break
+		}
+		result[header] = refs
+	}
+	if reader.err != nil {
+		return reader.err
+	}
+	if reader.offset != len(data) {
+		return fmt.Errorf("unexpected %d bytes after the end of the index", len(data)-reader.offset)
+	}
+	*index = result
+	return nil
+}
+
+// binaryReader decodes values of the binary format, the first encountered
+// error is kept and following reads return zero values.
+type binaryReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+var errTruncated = errors.New("binary index is truncated")
+
+func (r *binaryReader) readMagic() bool {
+	if !bytes.HasPrefix(r.data, binaryMagic) {
+		return false
+	}
+	r.offset = len(binaryMagic)
+	return true
+}
+
+func (r *binaryReader) readUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.data[r.offset:])
+	if n <= 0 {
+		r.err = errTruncated
+		return 0
+	}
+	r.offset += n
+	return value
+}
+
+// Reads the length of a string or list, which never exceeds the number of
+// remaining bytes, as each element is encoded using at least one byte.
+func (r *binaryReader) readLength() int {
+	length := r.readUvarint()
+	if r.err == nil && length > uint64(len(r.data)-r.offset) {
+		r.err = errTruncated
+	}
+	if r.err != nil {
+		return 0
+	}
+	return int(length)
+}
+
+func (r *binaryReader) readString() string {
+	length := r.readLength()
+	if r.err != nil {
+		return ""
+	}
+	s := string(r.data[r.offset : r.offset+length])
+	r.offset += length
+	return s
+}
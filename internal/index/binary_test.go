@@ -0,0 +1,136 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	input := DependencyIndex{
+		"foo.h":      {label.New("foo", "", "foo")},
+		"bar/bar.h":  {label.New("bar", "lib", "bar_a"), label.New("bar", "lib", "bar_b")},
+		"bar/impl.h": {label.New("bar", "lib", "bar_b")},
+		"local.h":    {label.New("", "local", "local")},
+	}
+	data, err := input.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, binaryMagic, data[:len(binaryMagic)])
+
+	var loaded DependencyIndex
+	require.NoError(t, loaded.UnmarshalBinary(data))
+	assert.Equal(t, input, loaded)
+
+	// Output does not depend on the iteration order of the index
+	again, err := input.MarshalBinary()
+	require.NoError(t, err)
+	assert.Equal(t, data, again)
+
+	var empty DependencyIndex
+	data, err = DependencyIndex{}.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, empty.UnmarshalBinary(data))
+	assert.Equal(t, DependencyIndex{}, empty)
+}
+
+func TestBinaryVersionMismatch(t *testing.T) {
+	data := binary.AppendUvarint([]byte("CCIDX"), binaryVersion+1)
+	data = append(data, 0, 0)
+	var loaded DependencyIndex
+	err := loaded.UnmarshalBinary(data)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.Nil(t, loaded)
+
+	path := filepath.Join(t.TempDir(), "future.ccidx")
+	require.NoError(t, WriteFile(path, data))
+	_, err = LoadFile(path)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestBinaryInvalidData(t *testing.T) {
+	data, err := DependencyIndex{"foo.h": {label.New("foo", "", "foo")}}.MarshalBinary()
+	require.NoError(t, err)
+
+	var loaded DependencyIndex
+	for size := range len(data) {
+		assert.Error(t, loaded.UnmarshalBinary(data[:size]), "truncated to %d bytes", size)
+	}
+	assert.ErrorContains(t, loaded.UnmarshalBinary(append(data, 0)), "unexpected 1 bytes after the end")
+	assert.ErrorContains(t, loaded.UnmarshalBinary([]byte(`{"foo.h": ["@foo"]}`)), "missing magic number")
+	assert.Nil(t, loaded)
+}
+
+func TestWriteAndLoadBinaryFile(t *testing.T) {
+	input := DependencyIndex{
+		"foo.h":     {label.New("foo", "", "foo")},
+		"bar/bar.h": {label.New("bar", "lib", "bar_a"), label.New("bar", "lib", "bar_b")},
+	}
+	data, err := input.MarshalBinary()
+	require.NoError(t, err)
+
+	// The format is detected based on the content, regardless of the extension
+	for _, name := range []string{"deps.ccindex", "deps.ccindex.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			require.NoError(t, WriteFile(path, data))
+			loaded, err := LoadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, input, loaded)
+		})
+	}
+}
+
+// Index of 50k headers defined by 5k libraries of 500 modules
+func benchmarkIndex() DependencyIndex {
+	index := make(DependencyIndex, 50_000)
+	for i := range 50_000 {
+		module := fmt.Sprintf("module_%d", i%500)
+		lib := label.New(module, fmt.Sprintf("lib/pkg_%d", i%10), fmt.Sprintf("lib_%d", i%10))
+		index[fmt.Sprintf("%s/include/pkg_%d/header_%d.h", module, i%10, i)] = []label.Label{lib}
+	}
+	return index
+}
+
+func BenchmarkLoadJSON(b *testing.B) {
+	data, err := json.Marshal(benchmarkIndex())
+	require.NoError(b, err)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		var index DependencyIndex
+		if err := json.Unmarshal(data, &index); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoadBinary(b *testing.B) {
+	data, err := benchmarkIndex().MarshalBinary()
+	require.NoError(b, err)
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		var index DependencyIndex
+		if err := index.UnmarshalBinary(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+type (
+	// IndexDiff describes how the mapping of headers changed between two
+	// versions of a DependencyIndex. Serializable to JSON.
+	IndexDiff struct {
+		Added   DependencyIndex        `json:"added"`   // Headers defined only in the new index
+		Removed DependencyIndex        `json:"removed"` // Headers defined only in the old index
+		Changed map[string]LabelChange `json:"changed"` // Headers mapped to different targets in both indexes
+	}
+
+	// LabelChange describes the targets defining a header in the old and new
+	// version of the index.
+	LabelChange struct {
+		Old []label.Label
+		New []label.Label
+	}
+)
+
+var _ json.Marshaler = LabelChange{}
+
+// Diff compares the old and new version of the index. Targets defining the
+// header are compared as parsed labels regardless of their order, so different
+// spellings of the same label, e.g. "@foo//foo" and "@foo//foo:foo", are not
+// reported as a change.
+func Diff(oldIndex, newIndex DependencyIndex) IndexDiff {
+	diff := IndexDiff{
+		Added:   make(DependencyIndex),
+		Removed: make(DependencyIndex),
+		Changed: make(map[string]LabelChange),
+	}
+	for header, oldLabels := range oldIndex {
+		newLabels, exists := newIndex[header]
+		switch {
+		case !exists:
+			diff.Removed[header] = oldLabels
+		case !sameLabels(oldLabels, newLabels):
+			diff.Changed[header] = LabelChange{Old: oldLabels, New: newLabels}
+		}
+	}
+	for header, newLabels := range newIndex {
+		if _, exists := oldIndex[header]; !exists {
+			diff.Added[header] = newLabels
+		}
+	}
+	return diff
+}
+
+// sameLabels returns true if both lists contain the same labels, ignoring their order.
+func sameLabels(a, b []label.Label) bool {
+	compare := func(l, r label.Label) int { return strings.Compare(l.String(), r.String()) }
+	return slices.Equal(slices.SortedFunc(slices.Values(a), compare), slices.SortedFunc(slices.Values(b), compare))
+}
+
+// IsEmpty returns true if both indexes define the same mapping.
+func (diff IndexDiff) IsEmpty() bool {
+	return len(diff.Added) == 0 && len(diff.Removed) == 0 && len(diff.Changed) == 0
+}
+
+// String returns a human-readable summary of the changes, listing headers in
+// lexicographical order.
+func (diff IndexDiff) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Added headers: %d\n", len(diff.Added))
+	for _, header := range slices.Sorted(maps.Keys(diff.Added)) {
+		fmt.Fprintf(&sb, "  + %s: %v\n", header, labelsString(diff.Added[header]))
+	}
+	fmt.Fprintf(&sb, "Removed headers: %d\n", len(diff.Removed))
+	for _, header := range slices.Sorted(maps.Keys(diff.Removed)) {
+		fmt.Fprintf(&sb, "  - %s: %v\n", header, labelsString(diff.Removed[header]))
+	}
+	fmt.Fprintf(&sb, "Changed headers: %d\n", len(diff.Changed))
+	for _, header := range slices.Sorted(maps.Keys(diff.Changed)) {
+		change := diff.Changed[header]
+		fmt.Fprintf(&sb, "  ~ %s: %v -> %v\n", header, labelsString(change.Old), labelsString(change.New))
+	}
+	return sb.String()
+}
+
+func labelsString(labels []label.Label) string {
+	return strings.Join(collections.MapSlice(labels, func(lbl label.Label) string { return lbl.String() }), ", ")
+}
+
+func (change LabelChange) MarshalJSON() ([]byte, error) {
+	toStrings := func(labels []label.Label) []string {
+		return collections.MapSlice(labels, func(lbl label.Label) string { return lbl.String() })
+	}
+	return json.Marshal(struct {
+		Old []string `json:"old"`
+		New []string `json:"new"`
+	}{Old: toStrings(change.Old), New: toStrings(change.New)})
+}
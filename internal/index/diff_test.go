@@ -0,0 +1,132 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	foo := label.New("foo", "", "foo")
+	bar := label.New("bar", "lib", "bar")
+	baz := label.New("baz", "", "baz")
+
+	testCases := []struct {
+		name     string
+		old      string
+		new      string
+		expected IndexDiff
+	}{
+		{
+			name: "added header",
+			old:  `{"foo.h": ["@foo//:foo"]}`,
+			new:  `{"foo.h": ["@foo//:foo"], "bar.h": ["@bar//lib:bar"]}`,
+			expected: IndexDiff{
+				Added:   DependencyIndex{"bar.h": {bar}},
+				Removed: DependencyIndex{},
+				Changed: map[string]LabelChange{},
+			},
+		},
+		{
+			name: "removed header",
+			old:  `{"foo.h": ["@foo//:foo"], "bar.h": ["@bar//lib:bar"]}`,
+			new:  `{"foo.h": ["@foo//:foo"]}`,
+			expected: IndexDiff{
+				Added:   DependencyIndex{},
+				Removed: DependencyIndex{"bar.h": {bar}},
+				Changed: map[string]LabelChange{},
+			},
+		},
+		{
+			name: "changed label",
+			old:  `{"foo.h": ["@foo//:foo"], "bar.h": ["@bar//lib:bar"]}`,
+			new:  `{"foo.h": ["@baz//:baz"], "bar.h": ["@bar//lib:bar", "@foo//:foo"]}`,
+			expected: IndexDiff{
+				Added:   DependencyIndex{},
+				Removed: DependencyIndex{},
+				Changed: map[string]LabelChange{
+					"foo.h": {Old: []label.Label{foo}, New: []label.Label{baz}},
+					"bar.h": {Old: []label.Label{bar}, New: []label.Label{bar, foo}},
+				},
+			},
+		},
+		{
+			name: "same labels in different form or order",
+			old:  `{"foo.h": ["@foo//:foo"], "baz.h": ["@baz//baz", "@foo//:foo"]}`,
+			new:  `{"foo.h": ["@foo//:foo"], "baz.h": ["@foo//:foo", "@baz//baz:baz"]}`,
+			expected: IndexDiff{
+				Added:   DependencyIndex{},
+				Removed: DependencyIndex{},
+				Changed: map[string]LabelChange{},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var oldIndex, newIndex DependencyIndex
+			assert.NoError(t, json.Unmarshal([]byte(tc.old), &oldIndex))
+			assert.NoError(t, json.Unmarshal([]byte(tc.new), &newIndex))
+
+			diff := Diff(oldIndex, newIndex)
+			assert.Equal(t, tc.expected, diff)
+			assert.Equal(t, len(tc.expected.Added)+len(tc.expected.Removed)+len(tc.expected.Changed) == 0, diff.IsEmpty())
+		})
+	}
+}
+
+func TestIndexDiffString(t *testing.T) {
+	diff := IndexDiff{
+		Added:   DependencyIndex{"new.h": {label.New("new", "", "new")}},
+		Removed: DependencyIndex{"old.h": {label.New("old", "", "old")}},
+		Changed: map[string]LabelChange{
+			"changed.h": {
+				Old: []label.Label{label.New("a", "", "a")},
+				New: []label.Label{label.New("a", "", "a"), label.New("b", "pkg", "b")},
+			},
+		},
+	}
+	assert.Equal(t, `Added headers: 1
+  + new.h: @new//:new
+Removed headers: 1
+  - old.h: @old//:old
+Changed headers: 1
+  ~ changed.h: @a//:a -> @a//:a, @b//pkg:b
+`, diff.String())
+}
+
+func TestIndexDiffMarshalJSON(t *testing.T) {
+	diff := IndexDiff{
+		Added:   DependencyIndex{"new.h": {label.New("new", "", "new")}},
+		Removed: DependencyIndex{},
+		Changed: map[string]LabelChange{
+			"changed.h": {
+				Old: []label.Label{label.New("a", "", "a")},
+				New: []label.Label{label.New("b", "pkg", "b")},
+			},
+		},
+	}
+	data, err := json.Marshal(diff)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{
+		"added": {"new.h": ["@new//:new"]},
+		"removed": {},
+		"changed": {"changed.h": {"old": ["@a//:a"], "new": ["@b//pkg:b"]}}
+	}`, string(data))
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extension of index files compressed using gzip, e.g. deps.ccindex.gz or deps.json.gz
+const gzipExtension = ".gz"
+
+// Magic number starting each gzip stream, see RFC 1952
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// LoadFile reads the DependencyIndex from the JSON file, or from the file in
+// the binary format detected based on its magic number. Files compressed
+// using gzip are decompressed transparently, regardless of their extension.
+func LoadFile(path string) (DependencyIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, gzipMagic) {
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress %v: %w", path, err)
+		}
+		defer reader.Close()
+		if data, err = io.ReadAll(reader); err != nil {
+			return nil, fmt.Errorf("failed to decompress %v: %w", path, err)
+		}
+	}
+	var result DependencyIndex
+	if bytes.HasPrefix(data, binaryMagic) {
+		if err := result.UnmarshalBinary(data); err != nil {
+			return nil, fmt.Errorf("failed to parse %v: %w", path, err)
+		}
+		return result, nil
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse %v: %w", path, err)
+	}
+	return result, nil
+}
+
+// WriteFile writes the serialized index to the file, creating its parent
+// directories if needed. Content is compressed using gzip if the path ends
+// with the .gz extension, otherwise it's written as is.
+func WriteFile(path string, data []byte) error {
+	if strings.HasSuffix(path, gzipExtension) {
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return fmt.Errorf("failed to compress index: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("failed to compress index: %w", err)
+		}
+		data = buf.Bytes()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0666)
+}
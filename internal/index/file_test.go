@@ -0,0 +1,77 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package index
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteAndLoadFile(t *testing.T) {
+	input := DependencyIndex{
+		"foo.h":     {label.New("foo", "", "foo")},
+		"bar/bar.h": {label.New("bar", "lib", "bar_a"), label.New("bar", "lib", "bar_b")},
+	}
+	// Large enough to be compressed below its original size
+	for i := range 50 {
+		input[fmt.Sprintf("baz/baz_%d.h", i)] = []label.Label{label.New("baz", "", "baz")}
+	}
+	data, err := json.MarshalIndent(input, "", "  ")
+	require.NoError(t, err)
+
+	for _, name := range []string{"deps.ccindex", "deps.ccindex.gz", "deps.json.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out", name)
+			require.NoError(t, WriteFile(path, data))
+
+			written, err := os.ReadFile(path)
+			require.NoError(t, err)
+			if filepath.Ext(name) == ".gz" {
+				assert.Equal(t, gzipMagic, written[:2])
+				assert.Less(t, len(written), len(data))
+			} else {
+				assert.Equal(t, data, written)
+			}
+
+			loaded, err := LoadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, input, loaded)
+		})
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := LoadFile(filepath.Join(dir, "missing.ccindex"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	truncated := filepath.Join(dir, "truncated.ccindex.gz")
+	require.NoError(t, os.WriteFile(truncated, append(gzipMagic, 0x08), 0o644))
+	_, err = LoadFile(truncated)
+	assert.ErrorContains(t, err, "failed to decompress")
+
+	invalid := filepath.Join(dir, "invalid.ccindex")
+	require.NoError(t, os.WriteFile(invalid, []byte("not json"), 0o644))
+	_, err = LoadFile(invalid)
+	assert.ErrorContains(t, err, "failed to parse")
+}
@@ -0,0 +1,36 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package api provides a stable entry point to the C/C++ source analysis
+// used by gazelle_cc, meant for external tools which should not depend on the
+// internal representation of parsed sources.
+package api
+
+import "github.com/EngFlow/gazelle_cc/language/internal/cc/parser"
+
+// Includes parses C/C++ source code and returns paths of all included files, in
+// order of their occurrence. Quoted includes (#include "foo.h") and system
+// includes (#include <foo.h>) are returned separately. Includes placed in
+// conditional compilation blocks are always reported, regardless of their
+// conditions. Malformed directives are skipped.
+func Includes(input []byte) (quoted []string, system []string) {
+	for _, include := range parser.ParseSource(input).CollectIncludes() {
+		if include.IsSystem {
+			system = append(system, include.Path)
+		} else {
+			quoted = append(quoted, include.Path)
+		}
+	}
+	return quoted, system
+}
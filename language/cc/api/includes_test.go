@@ -0,0 +1,83 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package api
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIncludes(t *testing.T) {
+	testCases := []struct {
+		description    string
+		input          string
+		expectedQuoted []string
+		expectedSystem []string
+	}{
+		{
+			description: "quoted and system includes",
+			input: `
+#include <stdio.h>
+#include "myheader.h"
+# include <math.h>
+`,
+			expectedQuoted: []string{"myheader.h"},
+			expectedSystem: []string{"stdio.h", "math.h"},
+		},
+		{
+			description: "malformed includes",
+			input: `
+#include "valid.h"
+#include "stdio.h
+#include stdlib.h"
+#include <math.h
+#include <other_valid>
+`,
+			expectedQuoted: []string{"valid.h"},
+			expectedSystem: []string{"other_valid"},
+		},
+		{
+			description: "includes in conditional blocks",
+			input: `
+#ifdef _WIN32
+#  include <windows.h>
+#elif defined(__APPLE__)
+#  include "apple/platform.h"
+#else
+#  if __GNUC__ >= 5
+#    include "gnu/platform.h"
+#  endif
+#  include <unistd.h>
+#endif
+#include "common.h"
+`,
+			expectedQuoted: []string{"apple/platform.h", "gnu/platform.h", "common.h"},
+			expectedSystem: []string{"windows.h", "unistd.h"},
+		},
+		{
+			description: "no includes",
+			input:       "int main() { return 0; }",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			quoted, system := Includes([]byte(tc.input))
+			assert.Equal(t, tc.expectedQuoted, quoted)
+			assert.Equal(t, tc.expectedSystem, system)
+		})
+	}
+}
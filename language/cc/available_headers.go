@@ -0,0 +1,68 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"slices"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+)
+
+// System headers always provided by the toolchain or the platform SDK, which
+// should never be resolved to a dependency. Extended using gazelle:cc_available_header.
+var defaultAvailableHeaders = collections.ToSet(slices.Concat(stdHeaders, posixHeaders, sdkHeaders))
+
+// Headers of the C and C++ standard libraries
+var stdHeaders = []string{
+	// C standard library
+	"assert.h", "complex.h", "ctype.h", "errno.h", "fenv.h", "float.h", "inttypes.h", "iso646.h",
+	"limits.h", "locale.h", "math.h", "setjmp.h", "signal.h", "stdalign.h", "stdarg.h", "stdatomic.h",
+	"stdbit.h", "stdbool.h", "stdckdint.h", "stddef.h", "stdint.h", "stdio.h", "stdlib.h", "stdnoreturn.h",
+	"string.h", "tgmath.h", "threads.h", "time.h", "uchar.h", "wchar.h", "wctype.h",
+	// C++ standard library
+	"algorithm", "any", "array", "atomic", "barrier", "bit", "bitset", "cassert", "cctype", "cerrno",
+	"cfenv", "cfloat", "charconv", "chrono", "cinttypes", "climits", "clocale", "cmath", "codecvt",
+	"compare", "complex", "concepts", "condition_variable", "coroutine", "csetjmp", "csignal",
+	"cstdarg", "cstddef", "cstdint", "cstdio", "cstdlib", "cstring", "ctime", "cuchar", "cwchar",
+	"cwctype", "deque", "exception", "execution", "expected", "filesystem", "flat_map", "flat_set",
+	"format", "forward_list", "fstream", "functional", "future", "generator", "initializer_list",
+	"iomanip", "ios", "iosfwd", "iostream", "istream", "iterator", "latch", "limits", "list", "locale",
+	"map", "mdspan", "memory", "memory_resource", "mutex", "new", "numbers", "numeric", "optional",
+	"ostream", "print", "queue", "random", "ranges", "ratio", "regex", "scoped_allocator", "semaphore",
+	"set", "shared_mutex", "source_location", "span", "spanstream", "sstream", "stack", "stacktrace",
+	"stdexcept", "stdfloat", "stop_token", "streambuf", "string", "string_view", "strstream",
+	"syncstream", "system_error", "thread", "tuple", "type_traits", "typeindex", "typeinfo",
+	"unordered_map", "unordered_set", "utility", "valarray", "variant", "vector", "version",
+}
+
+// Commonly used headers of POSIX systems
+var posixHeaders = []string{
+	"arpa/inet.h", "dirent.h", "dlfcn.h", "fcntl.h", "getopt.h", "glob.h", "grp.h", "netdb.h",
+	"netinet/in.h", "netinet/tcp.h", "poll.h", "pthread.h", "pwd.h", "sched.h", "semaphore.h",
+	"spawn.h", "strings.h", "sys/file.h", "sys/ioctl.h", "sys/mman.h", "sys/resource.h",
+	"sys/select.h", "sys/socket.h", "sys/stat.h", "sys/syscall.h", "sys/time.h", "sys/types.h",
+	"sys/uio.h", "sys/un.h", "sys/utsname.h", "sys/wait.h", "syslog.h", "termios.h", "unistd.h",
+}
+
+// Commonly used headers of the Windows and Apple platform SDKs
+var sdkHeaders = []string{
+	// Windows SDK
+	"windows.h", "winsock2.h", "ws2tcpip.h", "winternl.h", "shlobj.h", "shellapi.h", "tchar.h",
+	"io.h", "direct.h", "process.h", "intrin.h", "objbase.h", "combaseapi.h", "psapi.h", "dbghelp.h",
+	// Apple SDKs
+	"CoreFoundation/CoreFoundation.h", "CoreServices/CoreServices.h", "Foundation/Foundation.h",
+	"Security/Security.h", "TargetConditionals.h", "mach/mach.h", "mach/mach_time.h", "mach-o/dyld.h",
+	"libkern/OSByteOrder.h", "os/log.h",
+}
@@ -21,10 +21,13 @@ import (
 	"maps"
 	"path"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"unicode"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/includepath"
 	"github.com/EngFlow/gazelle_cc/internal/index"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/parser"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/platform"
@@ -35,46 +38,145 @@ import (
 )
 
 // config.Configurer methods
-func (*ccLanguage) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {}
-func (*ccLanguage) CheckFlags(fs *flag.FlagSet, c *config.Config) error          { return nil }
+func (lang *ccLanguage) RegisterFlags(fs *flag.FlagSet, cmd string, c *config.Config) {
+	fs.StringVar(&lang.sourceGraphDumpDir, "cc_dump_source_graph", "", "debug: directory to which dependency graphs of sources grouped using 'cc_group unit' are written as JSON files")
+	fs.BoolVar(&lang.traceResolve, "cc_trace_resolve", false, "debug: log each strategy attempted when resolving includes to labels")
+	fs.BoolVar(&lang.printResolveStats, "cc_resolve_stats", false, "print a summary of how many includes were resolved by each source and how many remained unresolved")
+	fs.BoolVar(&lang.verify, "cc_verify", false, "print the diff and fail without writing build files if any of them would be changed by generating C/C++ rules")
+}
+
+// Load statements are fixed using the same loads for the whole repository, which
+// are defined before any directory is configured. Directives affecting them are
+// read from the repository root build file here instead of in Configure.
+func (lang *ccLanguage) CheckFlags(fs *flag.FlagSet, c *config.Config) error {
+	for _, name := range c.ValidBuildFileNames {
+		f, err := rule.LoadFile(filepath.Join(c.RepoRoot, name), "")
+		if err != nil {
+			continue
+		}
+		for _, d := range f.Directives {
+			if d.Key == cc_rules_load {
+				selectDirectiveChoice(&lang.rulesLoad, rulesLoadModes, d)
+			}
+		}
+		break
+	}
+	return nil
+}
 
 const (
 	cc_group                      = "cc_group"
 	cc_group_unit_cycles          = "cc_group_unit_cycles"
+	cc_group_unit_min_size        = "cc_group_unit_min_size"
+	cc_preserve_rule_names        = "cc_preserve_rule_names"
+	cc_shard_srcs                 = "cc_shard_srcs"
 	cc_group_subdirectory_src     = "cc_group_subdirectory_src"
 	cc_group_subdirectory_include = "cc_group_subdirectory_include"
 	cc_group_subdirectory_test    = "cc_group_subdirectory_test"
 	cc_indexfile                  = "cc_indexfile"
+	cc_resolve_file               = "cc_resolve_file"
 	cc_ambiguous_deps             = "cc_ambiguous_deps"
 	cc_use_builtin_bzlmod_index   = "cc_use_builtin_bzlmod_index"
+	cc_use_embedded_index         = "cc_use_embedded_index"
 	cc_search                     = "cc_search"
 	cc_generate                   = "cc_generate"
 	cc_generate_proto             = "cc_generate_proto"
 	cc_unresolved_deps            = "cc_unresolved_deps"
 	cc_parsing_errors             = "cc_parsing_errors"
 	cc_platform                   = "cc_platform"
+	cc_define                     = "cc_define"
+	cc_undefine                   = "cc_undefine"
 	cc_include_prefix             = "cc_include_prefix"
 	cc_strip_include_prefix       = "cc_strip_include_prefix"
+	cc_preserve_include_prefix    = "cc_preserve_include_prefix"
+	cc_default_visibility         = "cc_default_visibility"
+	cc_internal_visibility        = "cc_internal_visibility"
+	cc_test_size                  = "cc_test_size"
+	cc_ignore_include             = "cc_ignore_include"
+	cc_implementation_deps        = "cc_implementation_deps"
+	cc_prefer_alias               = "cc_prefer_alias"
+	cc_transitive_header_deps     = "cc_transitive_header_deps"
+	cc_system_linkopts            = "cc_system_linkopts"
+	cc_system_linkopt             = "cc_system_linkopt"
+	cc_include_alias              = "cc_include_alias"
+	cc_include_prefix_dep         = "cc_include_prefix_dep"
+	cc_case_insensitive_includes  = "cc_case_insensitive_includes"
+	cc_rules_load                 = "cc_rules_load"
+	cc_trace_resolve              = "cc_trace_resolve"
+	cc_force_include              = "cc_force_include"
+	cc_index_precedence           = "cc_index_precedence"
+	cc_platform_variants          = "cc_platform_variants"
+	cc_validate_deps              = "cc_validate_deps"
+	cc_testonly_srcs              = "cc_testonly_srcs"
+	cc_macro_include_hints        = "cc_macro_include_hints"
+	cc_generated_comment          = "cc_generated_comment"
+	cc_resolve_ancestor_globs     = "cc_resolve_ancestor_globs"
+	cc_detect_dep_cycles          = "cc_detect_dep_cycles"
+	cc_precompiled_header         = "cc_precompiled_header"
+	cc_unknown_macro_value        = "cc_unknown_macro_value"
+	cc_strip_include_root         = "cc_strip_include_root"
+	cc_binary_shared_srcs         = "cc_binary_shared_srcs"
+	cc_library_naming             = "cc_library_naming"
+	cc_available_header           = "cc_available_header"
+	cc_public_dep                 = "cc_public_dep"
 )
 
 func (c *ccLanguage) KnownDirectives() []string {
 	return []string{
 		cc_group,
 		cc_group_unit_cycles,
+		cc_group_unit_min_size,
+		cc_preserve_rule_names,
+		cc_shard_srcs,
 		cc_group_subdirectory_src,
 		cc_group_subdirectory_include,
 		cc_group_subdirectory_test,
 		cc_indexfile,
+		cc_resolve_file,
 		cc_ambiguous_deps,
 		cc_use_builtin_bzlmod_index,
+		cc_use_embedded_index,
 		cc_search,
 		cc_generate,
 		cc_generate_proto,
 		cc_unresolved_deps,
 		cc_parsing_errors,
 		cc_platform,
+		cc_define,
+		cc_undefine,
 		cc_include_prefix,
 		cc_strip_include_prefix,
+		cc_preserve_include_prefix,
+		cc_default_visibility,
+		cc_internal_visibility,
+		cc_test_size,
+		cc_ignore_include,
+		cc_implementation_deps,
+		cc_prefer_alias,
+		cc_transitive_header_deps,
+		cc_system_linkopts,
+		cc_system_linkopt,
+		cc_include_alias,
+		cc_include_prefix_dep,
+		cc_case_insensitive_includes,
+		cc_rules_load,
+		cc_trace_resolve,
+		cc_force_include,
+		cc_index_precedence,
+		cc_platform_variants,
+		cc_validate_deps,
+		cc_testonly_srcs,
+		cc_macro_include_hints,
+		cc_generated_comment,
+		cc_resolve_ancestor_globs,
+		cc_detect_dep_cycles,
+		cc_precompiled_header,
+		cc_unknown_macro_value,
+		cc_strip_include_root,
+		cc_binary_shared_srcs,
+		cc_library_naming,
+		cc_available_header,
+		cc_public_dep,
 	}
 }
 
@@ -97,6 +199,42 @@ func (c *ccLanguage) Configure(config *config.Config, rel string, f *rule.File)
 			selectDirectiveChoice(&conf.groupingMode, sourceGroupingModes, d)
 		case cc_group_unit_cycles:
 			selectDirectiveChoice(&conf.groupsCycleHandlingMode, groupsCycleHandlingModes, d)
+		case cc_library_naming:
+			selectDirectiveChoice(&conf.libraryNaming, libraryNamings, d)
+		case cc_group_unit_min_size:
+			// Reset to not merging groups
+			if d.Value == "" {
+				conf.groupMinSize = 0
+				continue
+			}
+			if value, err := strconv.Atoi(d.Value); err == nil && value >= 0 {
+				conf.groupMinSize = value
+			} else {
+				log.Printf("gazelle_cc: invalid value for directive %v: %q, expected a non-negative integer", d.Key, d.Value)
+			}
+		case cc_preserve_rule_names:
+			parseBoolDirective(&conf.preserveRuleNames, d)
+		case cc_shard_srcs:
+			// Reset to not sharding libraries
+			if d.Value == "" {
+				conf.shardCount, conf.shardMinSrcs = 0, 0
+				continue
+			}
+			args := strings.Fields(d.Value)
+			values := make([]int, 0, len(args))
+			for _, arg := range args {
+				if value, err := strconv.Atoi(arg); err == nil && value >= 0 {
+					values = append(values, value)
+				}
+			}
+			if len(args) > 2 || len(values) != len(args) {
+				log.Printf("gazelle_cc: invalid value for directive %v: %q, expected the number of shards and optionally the minimal number of sources to shard", d.Key, d.Value)
+				continue
+			}
+			conf.shardCount, conf.shardMinSrcs = values[0], values[0]
+			if len(values) == 2 {
+				conf.shardMinSrcs = values[1]
+			}
 		case cc_group_subdirectory_src:
 			parsePatternListDirective(&conf.groupSubdirectorySrcPatterns, d.Key, d.Value)
 		case cc_group_subdirectory_include:
@@ -109,6 +247,8 @@ func (c *ccLanguage) Configure(config *config.Config, rel string, f *rule.File)
 			parseBoolDirective(&conf.generateProto, d)
 		case cc_use_builtin_bzlmod_index:
 			parseBoolDirective(&conf.useBuiltinBzlmodIndex, d)
+		case cc_use_embedded_index:
+			parseBoolDirective(&conf.useEmbeddedIndex, d)
 		case cc_indexfile:
 			// Reset existing indexfiles
 			if d.Value == "" {
@@ -126,6 +266,29 @@ func (c *ccLanguage) Configure(config *config.Config, rel string, f *rule.File)
 				continue
 			}
 			conf.dependencyIndexes = append(conf.dependencyIndexes, index)
+		case cc_resolve_file:
+			// Reset existing overrides
+			if d.Value == "" {
+				conf.resolveOverrides = nil
+				continue
+			}
+			if filepath.IsAbs(d.Value) {
+				log.Printf("gazelle_cc: absolute paths for %v directive are not allowed, %v would be ignored", d.Key, d.Value)
+				continue
+			}
+			path := filepath.Join(config.WorkDir, d.Value)
+			overrides, err := loadResolveOverrides(path)
+			if err != nil {
+				log.Printf("gazelle_cc: failed to load cc resolve overrides: %v, it would be ignored. Reason: %v", path, err)
+				continue
+			}
+			// Overrides inherited from parent directories are never modified in place
+			merged := maps.Clone(conf.resolveOverrides)
+			if merged == nil {
+				merged = make(map[string]label.Label, len(overrides))
+			}
+			maps.Copy(merged, overrides)
+			conf.resolveOverrides = merged
 		case cc_ambiguous_deps:
 			selectDirectiveChoice(&conf.ambiguousDepsMode, ambiguousDepsModes, d)
 		case cc_search:
@@ -210,10 +373,247 @@ func (c *ccLanguage) Configure(config *config.Config, rel string, f *rule.File)
 				constraint:     constraintLabel,
 				userDefinedEnv: macros,
 			}
+		case cc_define:
+			// Reset existing project macros
+			if d.Value == "" {
+				conf.definedMacros = nil
+				conf.undefinedMacros = nil
+				continue
+			}
+			for _, definition := range strings.Fields(d.Value) {
+				// NAME= undefines the macro
+				if name, value, ok := strings.Cut(definition, "="); ok && value == "" {
+					conf.undefineMacro(d, name)
+					continue
+				}
+				macros, err := parser.ParseMacros([]string{definition})
+				if err != nil {
+					log.Printf("gazelle_cc: invalid %v input for macro definition '%v': %v", d.Key, definition, err)
+					continue
+				}
+				for name, value := range macros {
+					if conf.definedMacros == nil {
+						conf.definedMacros = make(parser.Environment)
+					}
+					conf.definedMacros[name] = value
+					delete(conf.undefinedMacros, name)
+				}
+			}
+		case cc_undefine:
+			for _, name := range strings.Fields(d.Value) {
+				conf.undefineMacro(d, name)
+			}
 		case cc_include_prefix:
 			conf.ccIncludePrefix = d.Value
 		case cc_strip_include_prefix:
 			conf.ccStripIncludePrefix = d.Value
+		case cc_preserve_include_prefix:
+			parseBoolDirective(&conf.preserveIncludePrefix, d)
+		case cc_default_visibility:
+			// Reset to default visibility
+			if d.Value == "" {
+				conf.defaultVisibility = nil
+				continue
+			}
+			visibility := strings.Fields(d.Value)
+			for _, value := range visibility {
+				if _, err := label.Parse(value); err != nil {
+					log.Printf("gazelle_cc: invalid %v input for visibility label '%v': %v", d.Key, value, err)
+					visibility = nil
+					break
+				}
+			}
+			if visibility != nil {
+				conf.defaultVisibility = visibility
+			}
+		case cc_internal_visibility:
+			parseBoolDirective(&conf.restrictInternalVisibility, d)
+		case cc_implementation_deps:
+			parseBoolDirective(&conf.useImplementationDeps, d)
+		case cc_prefer_alias:
+			parseBoolDirective(&conf.preferAliases, d)
+		case cc_transitive_header_deps:
+			parseBoolDirective(&conf.transitiveHeaderDeps, d)
+		case cc_system_linkopts:
+			parseBoolDirective(&conf.systemLinkoptsEnabled, d)
+		case cc_system_linkopt:
+			fields := strings.Fields(d.Value)
+			if len(fields) == 0 {
+				log.Printf("gazelle_cc: %v: expected a system header followed by a list of linkopts", d.Key)
+				continue
+			}
+			if len(fields) == 1 {
+				// No linkopts, remove the existing mapping
+				delete(conf.systemHeaderLinkopts, fields[0])
+				continue
+			}
+			conf.systemHeaderLinkopts[fields[0]] = fields[1:]
+		case cc_available_header:
+			// Reset to the default headers
+			if d.Value == "" {
+				conf.availableHeaders = maps.Clone(defaultAvailableHeaders)
+				continue
+			}
+			for _, header := range strings.Fields(d.Value) {
+				if removed, ok := strings.CutPrefix(header, "-"); ok {
+					// Header defined in the repository, shadowing the system one
+					delete(conf.availableHeaders, removed)
+				} else {
+					conf.availableHeaders.Add(header)
+				}
+			}
+		case cc_test_size:
+			// Reset to not setting the size attribute
+			if d.Value == "" {
+				conf.testSize = ""
+				continue
+			}
+			selectDirectiveChoice(&conf.testSize, testSizes, d)
+		case cc_ignore_include:
+			// Reset existing patterns
+			if d.Value == "" {
+				conf.ignoredIncludes = nil
+				continue
+			}
+			if !doublestar.ValidatePattern(d.Value) {
+				log.Printf("gazelle_cc: %s: invalid glob pattern: %q", d.Key, d.Value)
+				continue
+			}
+			conf.ignoredIncludes = append(conf.ignoredIncludes, d.Value)
+		case cc_validate_deps:
+			parseBoolDirective(&conf.validateDeps, d)
+		case cc_macro_include_hints:
+			parseBoolDirective(&conf.macroIncludeHints, d)
+		case cc_generated_comment:
+			parseBoolDirective(&conf.generatedComment, d)
+		case cc_resolve_ancestor_globs:
+			parseBoolDirective(&conf.resolveAncestorGlobs, d)
+		case cc_detect_dep_cycles:
+			parseBoolDirective(&conf.detectDepCycles, d)
+		case cc_precompiled_header:
+			// Reset existing precompiled header names
+			if d.Value == "" {
+				conf.precompiledHeaders = nil
+				continue
+			}
+			for _, name := range strings.Fields(d.Value) {
+				if !fileNameIsHeader(name) || path.Base(name) != name {
+					log.Printf("gazelle_cc: %v: expected a header file name, got: %q", d.Key, name)
+					continue
+				}
+				if !slices.Contains(conf.precompiledHeaders, name) {
+					conf.precompiledHeaders = append(conf.precompiledHeaders, name)
+				}
+			}
+		case cc_platform_variants:
+			parseBoolDirective(&conf.platformVariants, d)
+		case cc_binary_shared_srcs:
+			parseBoolDirective(&conf.binarySharedSrcs, d)
+		case cc_unknown_macro_value:
+			if d.Value == "" {
+				conf.unknownMacroValue = unknownMacroValue_default
+				continue
+			}
+			selectDirectiveChoice(&conf.unknownMacroValue, unknownMacroValues, d)
+		case cc_index_precedence:
+			selectDirectiveChoice(&conf.indexPrecedence, indexPrecedences, d)
+		case cc_testonly_srcs:
+			// Reset existing patterns
+			if d.Value == "" {
+				conf.testonlySrcs = nil
+				continue
+			}
+			if !doublestar.ValidatePattern(d.Value) {
+				log.Printf("gazelle_cc: %s: invalid glob pattern: %q", d.Key, d.Value)
+				continue
+			}
+			conf.testonlySrcs = append(conf.testonlySrcs, d.Value)
+		case cc_force_include:
+			// Reset existing forced includes
+			if d.Value == "" {
+				conf.forcedIncludes = nil
+				continue
+			}
+			if path.IsAbs(d.Value) || path.Clean(d.Value) != d.Value {
+				log.Printf("gazelle_cc: %v: header path %q must be relative and clean", d.Key, d.Value)
+				continue
+			}
+			// Relative paths are resolved based on the package defining the directive
+			conf.forcedIncludes = append(conf.forcedIncludes, ccInclude{sourceFile: path.Join(rel, path.Base(f.Path)), path: d.Value})
+		case cc_include_alias:
+			// Reset existing aliases
+			if d.Value == "" {
+				conf.includeAliases = nil
+				continue
+			}
+			fields := strings.Fields(d.Value)
+			if len(fields) > 2 {
+				log.Printf("gazelle_cc: %v: expected an include path prefix optionally followed by its replacement, got: %q", d.Key, d.Value)
+				continue
+			}
+			alias := includeAlias{from: includepath.Normalize(fields[0])}
+			if len(fields) == 2 {
+				alias.to = includepath.Normalize(fields[1])
+			}
+			conf.includeAliases = append(conf.includeAliases, alias)
+		case cc_strip_include_root:
+			// Reset the inherited prefix
+			if d.Value == "" {
+				conf.stripIncludeRoot = ""
+				continue
+			}
+			root := includepath.Normalize(d.Value)
+			if root == "" || path.IsAbs(root) || root == ".." || strings.HasPrefix(root, "../") {
+				log.Printf("gazelle_cc: %v: include root prefix %q must be a relative path", d.Key, d.Value)
+				continue
+			}
+			conf.stripIncludeRoot = root
+		case cc_include_prefix_dep:
+			// Reset existing mappings
+			if d.Value == "" {
+				conf.includePrefixDeps = nil
+				continue
+			}
+			fields := strings.Fields(d.Value)
+			if len(fields) != 2 {
+				log.Printf("gazelle_cc: %v: expected an include path prefix followed by a label, got: %q", d.Key, d.Value)
+				continue
+			}
+			dep, err := label.Parse(fields[1])
+			if err != nil {
+				log.Printf("gazelle_cc: invalid %v input for label '%v': %v", d.Key, fields[1], err)
+				continue
+			}
+			conf.includePrefixDeps = append(conf.includePrefixDeps, includePrefixDep{
+				prefix: includepath.Normalize(fields[0]),
+				dep:    dep.Abs("", rel),
+			})
+		case cc_public_dep:
+			// Reset forced public dependencies
+			if d.Value == "" {
+				conf.publicDepIncludes = nil
+				conf.publicDepLabels = nil
+				continue
+			}
+			if !strings.HasPrefix(d.Value, "//") && !strings.HasPrefix(d.Value, "@") && !strings.HasPrefix(d.Value, ":") {
+				conf.publicDepIncludes = append(conf.publicDepIncludes, includepath.Normalize(d.Value))
+				continue
+			}
+			dep, err := label.Parse(d.Value)
+			if err != nil {
+				log.Printf("gazelle_cc: invalid %v input for label '%v': %v", d.Key, d.Value, err)
+				continue
+			}
+			conf.publicDepLabels = append(conf.publicDepLabels, dep.Abs("", rel))
+		case cc_case_insensitive_includes:
+			parseBoolDirective(&conf.caseInsensitiveIncludes, d)
+		case cc_trace_resolve:
+			parseBoolDirective(&conf.traceResolve, d)
+		case cc_rules_load:
+			// Already applied in CheckFlags if defined in the root build file
+			if rel != "" {
+				log.Printf("gazelle_cc: %v directive is supported only in the repository root build file, it would be ignored in %v", d.Key, f.Path)
+			}
 		}
 	}
 }
@@ -261,16 +661,35 @@ func parsePatternListDirective(patterns *[]string, key, value string) {
 type ccConfig struct {
 	// Defines how sources should be grouped when defining rules
 	groupingMode sourceGroupingMode
+	// Defines how libraries grouping all sources of the directory are named
+	libraryNaming libraryNaming
 	// Should rules with sources assigned to different targets be merged into single one if they define a cyclic dependency
 	groupsCycleHandlingMode groupsCycleHandlingMode
+	// Groups created under `cc_group unit` with less sources are merged into the only group depending on them, 0 disables merging
+	groupMinSize int
+	// Should existing rules keep their names and sources instead of being renamed or merged based on the computed groups
+	preserveRuleNames bool
+	// Number of cc_library shards the sources of large groups are split into, values lower than 2 disable sharding
+	// and 0 leaves existing shard rules unmanaged
+	shardCount int
+	// Groups with more non-header sources than this value are split into shards
+	shardMinSrcs int
 	// Control wheter built-in bzlmod based index file should be used
 	useBuiltinBzlmodIndex bool
+	// Control whether the dependency index embedded in the binary should be used
+	useEmbeddedIndex bool
+	// Defines whether rules defined in the repository or cc_indexfile indexes are preferred if both provide the same header
+	indexPrecedence indexPrecedence
+	// Should resolved dependencies referring to packages that don't exist in the repository be skipped
+	validateDeps bool
 	// Defines how to handle unresolved dependencies
 	unresolvedDepsMode errorReportingMode
 	// Defines how to handle C++ source parsing errors
 	parsingErrorsMode errorReportingMode
 	// User defined dependency indexes based on the filename
 	dependencyIndexes []index.DependencyIndex
+	// Hand-maintained mapping of include paths to labels loaded using cc_resolve_file, preferred over all indexes
+	resolveOverrides map[string]label.Label
 	// Defines how to handle ambiguous dependencies, that is headers resolved to multiple rules
 	ambiguousDepsMode ambiguousDepsMode
 	// List of 'gazelle:cc_search' directives, used to construct RelsToIndex.
@@ -281,16 +700,95 @@ type ccConfig struct {
 	generateProto bool
 	// Platforms for which os/arch specific selects should be generated
 	platforms map[platform.Platform]platformConfig
+	// Project macros defined using cc_define, assumed to be defined on all platforms
+	definedMacros parser.Environment
+	// Project macros undefined using cc_undefine, assumed to be not defined on any platform
+	undefinedMacros collections.Set[string]
+	// Value assumed for macros referenced in #if conditions which are not defined by any of the known sources
+	unknownMacroValue unknownMacroValue
+	// Should libraries with platform specific includes be generated as separate variant for each platform instead of using select()
+	platformVariants bool
+	// Should each cc_binary depend on the libraries of its package containing shared (non-main) sources
+	binarySharedSrcs bool
 	// Value of "include_prefix" attribute set in generated cc_library rules
 	ccIncludePrefix string
 	// Value of "strip_include_prefix" attribute set in generated cc_library rules
 	ccStripIncludePrefix string
+	// Should "include_prefix" and "strip_include_prefix" attributes of existing cc_library rules be kept when not set using directives
+	preserveIncludePrefix bool
 	// Glob patterns for subdirectories whose contents should be added to srcs (used in subdirectory mode)
 	groupSubdirectorySrcPatterns []string
 	// Glob patterns for subdirectories whose headers should be added to hdrs (used in subdirectory mode)
 	groupSubdirectoryIncludePatterns []string
 	// Glob patterns for subdirectories whose contents should be added to test srcs (used in subdirectory mode)
 	groupSubdirectoryTestPatterns []string
+	// Visibility set in generated rules when package does not define default_visibility
+	defaultVisibility []string
+	// Should cc_library rules under `internal` directories be visible only to the parent directory subtree
+	restrictInternalVisibility bool
+	// Value of "size" attribute set in generated cc_test rules, not set if empty
+	testSize testSize
+	// Glob patterns of include paths that should never be resolved to dependencies
+	ignoredIncludes []string
+	// Should headers listed in bodies of #define directives be resolved to dependencies when possible
+	macroIncludeHints bool
+	// Should generated rules be annotated with a comment stating they're managed by gazelle_cc
+	generatedComment bool
+	// Should includes be resolved to libraries of the nearest ancestor package defining headers using glob()
+	resolveAncestorGlobs bool
+	// Should cycles in the resolved dependencies between rules be reported
+	detectDepCycles bool
+	// File names of precompiled headers, e.g. pch.h, whose includes are propagated to rules including them
+	precompiledHeaders []string
+	// Glob patterns of repository-relative paths of test-support sources assigned to the testonly library
+	testonlySrcs []string
+	// Headers implicitly included by all sources, e.g. using '-include' compiler flag, defined using cc_force_include directive
+	forcedIncludes []ccInclude
+	// Include path prefixes replaced before resolving the include, defined using cc_include_alias directive
+	includeAliases []includeAlias
+	// Leading path prefix of includes mapping to the repository root, e.g. "myrepo" for #include "myrepo/lib/foo.h"
+	stripIncludeRoot string
+	// Dependencies providing all headers under an include path prefix, defined using cc_include_prefix_dep directive
+	includePrefixDeps []includePrefixDep
+	// Should includes be resolved to headers differing only in case when not resolved otherwise
+	caseInsensitiveIncludes bool
+	// Should each strategy attempted when resolving includes be logged, also enabled using -cc_trace_resolve flag
+	traceResolve bool
+	// Should dependencies of cc_library sources be assigned to "implementation_deps" instead of "deps"
+	useImplementationDeps bool
+	// Include paths whose dependencies are always assigned to "deps" of cc_library, set using gazelle:cc_public_dep
+	publicDepIncludes []string
+	// Absolute labels of dependencies always assigned to "deps" of cc_library, set using gazelle:cc_public_dep
+	publicDepLabels []label.Label
+	// Should resolved dependencies be replaced with local alias rules pointing to them
+	preferAliases bool
+	// Should cc_library rules depend directly on dependencies of header-only rules they include
+	transitiveHeaderDeps bool
+	// Should linkopts required by included system headers be added to generated rules
+	systemLinkoptsEnabled bool
+	// Linkopts required by system headers, e.g. "-pthread" for <thread>
+	systemHeaderLinkopts map[string][]string
+	// System headers provided by the toolchain or platform SDK, never resolved to a dependency
+	availableHeaders collections.Set[string]
+}
+
+type includeAlias struct {
+	// from is a slash-separated include path prefix, matched on path segment
+	// boundaries, e.g. "eigen3" matches "eigen3/Eigen/Core".
+	from string
+
+	// to is a slash-separated path replacing the matched prefix, the prefix is
+	// removed if empty.
+	to string
+}
+
+type includePrefixDep struct {
+	// prefix is a slash-separated include path prefix, matched on path segment
+	// boundaries, e.g. "zlib" matches "zlib/zlib.h".
+	prefix string
+
+	// dep is the absolute label of the rule providing all headers under prefix.
+	dep label.Label
 }
 
 type ccSearch struct {
@@ -312,8 +810,11 @@ func getCcConfig(c *config.Config) *ccConfig {
 func newCcConfig() *ccConfig {
 	return &ccConfig{
 		groupingMode:            groupSourcesByDirectory,
+		libraryNaming:           libraryNaming_base,
 		groupsCycleHandlingMode: mergeOnGroupsCycle,
 		useBuiltinBzlmodIndex:   true,
+		useEmbeddedIndex:        true,
+		indexPrecedence:         indexPrecedence_local,
 		unresolvedDepsMode:      errorReportingMode_warn,
 		parsingErrorsMode:       errorReportingMode_ignore,
 		ambiguousDepsMode:       ambiguousDepsMode_try_first,
@@ -321,18 +822,49 @@ func newCcConfig() *ccConfig {
 		generateCC:              true,
 		generateProto:           true,
 		platforms:               map[platform.Platform]platformConfig{},
+		useImplementationDeps:   true,
+		systemHeaderLinkopts:    maps.Clone(defaultSystemHeaderLinkopts),
+		availableHeaders:        maps.Clone(defaultAvailableHeaders),
 	}
 }
 
+// Linkopts required by commonly used system headers with the GCC and Clang toolchains
+var defaultSystemHeaderLinkopts = map[string][]string{
+	"condition_variable":      {"-pthread"},
+	"future":                  {"-pthread"},
+	"pthread.h":               {"-pthread"},
+	"shared_mutex":            {"-pthread"},
+	"thread":                  {"-pthread"},
+	"atomic":                  {"-latomic"},
+	"filesystem":              {"-lstdc++fs"},
+	"experimental/filesystem": {"-lstdc++fs"},
+	"dlfcn.h":                 {"-ldl"},
+	"math.h":                  {"-lm"},
+	"cmath":                   {"-lm"},
+}
+
 func (conf *ccConfig) clone() *ccConfig {
 	copy := *conf
 	// No deep cloning of dependency indexes to reduce memory usage
 	copy.dependencyIndexes = conf.dependencyIndexes[:len(conf.dependencyIndexes):len(conf.dependencyIndexes)]
 	copy.ccSearch = conf.ccSearch[:len(conf.ccSearch):len(conf.ccSearch)]
 	copy.platforms = maps.Clone(conf.platforms)
+	copy.definedMacros = maps.Clone(conf.definedMacros)
+	copy.undefinedMacros = maps.Clone(conf.undefinedMacros)
+	copy.systemHeaderLinkopts = maps.Clone(conf.systemHeaderLinkopts)
+	copy.availableHeaders = maps.Clone(conf.availableHeaders)
 	copy.groupSubdirectorySrcPatterns = conf.groupSubdirectorySrcPatterns[:len(conf.groupSubdirectorySrcPatterns):len(conf.groupSubdirectorySrcPatterns)]
 	copy.groupSubdirectoryIncludePatterns = conf.groupSubdirectoryIncludePatterns[:len(conf.groupSubdirectoryIncludePatterns):len(conf.groupSubdirectoryIncludePatterns)]
 	copy.groupSubdirectoryTestPatterns = conf.groupSubdirectoryTestPatterns[:len(conf.groupSubdirectoryTestPatterns):len(conf.groupSubdirectoryTestPatterns)]
+	copy.defaultVisibility = conf.defaultVisibility[:len(conf.defaultVisibility):len(conf.defaultVisibility)]
+	copy.ignoredIncludes = conf.ignoredIncludes[:len(conf.ignoredIncludes):len(conf.ignoredIncludes)]
+	copy.forcedIncludes = conf.forcedIncludes[:len(conf.forcedIncludes):len(conf.forcedIncludes)]
+	copy.precompiledHeaders = conf.precompiledHeaders[:len(conf.precompiledHeaders):len(conf.precompiledHeaders)]
+	copy.testonlySrcs = conf.testonlySrcs[:len(conf.testonlySrcs):len(conf.testonlySrcs)]
+	copy.includeAliases = conf.includeAliases[:len(conf.includeAliases):len(conf.includeAliases)]
+	copy.includePrefixDeps = conf.includePrefixDeps[:len(conf.includePrefixDeps):len(conf.includePrefixDeps)]
+	copy.publicDepIncludes = conf.publicDepIncludes[:len(conf.publicDepIncludes):len(conf.publicDepIncludes)]
+	copy.publicDepLabels = conf.publicDepLabels[:len(conf.publicDepLabels):len(conf.publicDepLabels)]
 	return &copy
 }
 
@@ -343,15 +875,33 @@ func defaultCcSearch() []ccSearch {
 	return []ccSearch{{}}
 }
 
+// undefineMacro marks the project macro as not defined, overriding its previous definition.
+func (conf *ccConfig) undefineMacro(d rule.Directive, name string) {
+	if _, err := parser.ParseMacros([]string{name}); err != nil {
+		log.Printf("gazelle_cc: invalid %v input for macro name '%v': %v", d.Key, name, err)
+		return
+	}
+	delete(conf.definedMacros, name)
+	if conf.undefinedMacros == nil {
+		conf.undefinedMacros = make(collections.Set[string])
+	}
+	conf.undefinedMacros.Add(name)
+}
+
 type platformConfig struct {
 	platform       platform.Platform
 	constraint     label.Label
 	userDefinedEnv parser.Environment
 }
 
-func (pc platformConfig) getPlatformEnvironment() parser.Environment {
+// Returns macros defined on the platform. Project macros override well known platform macros and are overridden by macros defined for the platform.
+func (pc platformConfig) getPlatformEnvironment(definedMacros parser.Environment, undefinedMacros collections.Set[string]) parser.Environment {
 	env := make(parser.Environment)
 	maps.Copy(env, platform.KnownPlatformEnv[pc.platform])
+	for name := range undefinedMacros {
+		delete(env, name)
+	}
+	maps.Copy(env, definedMacros)
 	maps.Copy(env, pc.userDefinedEnv)
 	return env
 }
@@ -359,11 +909,194 @@ func (pc platformConfig) getPlatformEnvironment() parser.Environment {
 func (conf *ccConfig) getPlatformEnvironments() map[platform.Platform]parser.Environment {
 	result := map[platform.Platform]parser.Environment{}
 	for platform, config := range conf.platforms {
-		result[platform] = config.getPlatformEnvironment()
+		result[platform] = config.getPlatformEnvironment(conf.definedMacros, conf.undefinedMacros)
+	}
+	return result
+}
+
+// Returns names of macros referenced in #if conditions of the source whose
+// value is unknown, i.e. not set using cc_define, cc_undefine or cc_platform,
+// and not being one of well known platform macros. Returns nil unless
+// gazelle:cc_unknown_macro_value is set.
+func (conf *ccConfig) unknownMacros(sourceInfo parser.SourceInfo) collections.Set[string] {
+	if conf.unknownMacroValue == unknownMacroValue_default {
+		return nil
+	}
+	result := sourceInfo.ConditionMacros()
+	for name := range result {
+		if conf.isKnownMacro(name) {
+			delete(result, name)
+		}
+	}
+	return result
+}
+
+func (conf *ccConfig) isKnownMacro(name string) bool {
+	if _, defined := conf.definedMacros[name]; defined || conf.undefinedMacros.Contains(name) || platform.IsKnownPlatformMacro(name) {
+		return true
+	}
+	for _, pc := range conf.platforms {
+		if _, defined := pc.userDefinedEnv[name]; defined {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the environments in which conditions are evaluated for a platform,
+// one for each value of unknown macros assumed by gazelle:cc_unknown_macro_value.
+// Unknown macros are not defined in the platform environment by default.
+func (conf *ccConfig) unknownMacroEnvironments(env parser.Environment, unknownMacros collections.Set[string]) []parser.Environment {
+	if len(unknownMacros) == 0 {
+		return []parser.Environment{env}
+	}
+	assumingDefined := make(parser.Environment, len(env)+len(unknownMacros))
+	maps.Copy(assumingDefined, env)
+	for name := range unknownMacros {
+		assumingDefined[name] = 1
+	}
+	switch conf.unknownMacroValue {
+	case unknownMacroValue_one:
+		return []parser.Environment{assumingDefined}
+	case unknownMacroValue_both:
+		return []parser.Environment{env, assumingDefined}
+	default:
+		return []parser.Environment{env}
+	}
+}
+
+// Returns includes of the source which are not disabled by project macros,
+// taking into account the value assumed for unknown macros. Includes depending
+// on unknown macros are kept when both values are assumed.
+func (conf *ccConfig) collectLiveIncludes(sourceInfo parser.SourceInfo, unknownMacros collections.Set[string]) []parser.IncludeDirective {
+	env, undefined := conf.definedMacros, conf.undefinedMacros
+	switch {
+	case len(unknownMacros) == 0:
+	case conf.unknownMacroValue == unknownMacroValue_zero:
+		undefined = make(collections.Set[string], len(conf.undefinedMacros)+len(unknownMacros))
+		maps.Copy(undefined, conf.undefinedMacros)
+		maps.Copy(undefined, unknownMacros)
+	case conf.unknownMacroValue == unknownMacroValue_one:
+		env = make(parser.Environment, len(conf.definedMacros)+len(unknownMacros))
+		maps.Copy(env, conf.definedMacros)
+		for name := range unknownMacros {
+			env[name] = 1
+		}
 	}
+	return sourceInfo.CollectLiveIncludesAssuming(env, undefined)
+}
+
+// Returns the configured platforms targeting Windows, sorted
+func (conf *ccConfig) windowsPlatforms() []platform.Platform {
+	var result []platform.Platform
+	for p := range conf.platforms {
+		if p.IsWindows() {
+			result = append(result, p)
+		}
+	}
+	slices.SortFunc(result, platform.Compare)
 	return result
 }
 
+// Returns visibility of generated cc_library rules in the package, public unless configured otherwise
+func (conf *ccConfig) libraryVisibility(rel string) []string {
+	if conf.restrictInternalVisibility {
+		if parent, ok := internalPackageParent(rel); ok {
+			return []string{label.New("", parent, "__subpackages__").String()}
+		}
+	}
+	if len(conf.defaultVisibility) > 0 {
+		return conf.defaultVisibility
+	}
+	return []string{"//visibility:public"}
+}
+
+// Returns the parent directory of the innermost `internal` segment of the package path,
+// e.g. `foo` for `foo/internal/bar`. Similarly to Go internal packages these are meant to be used only within the parent directory subtree.
+func internalPackageParent(rel string) (string, bool) {
+	segments := strings.Split(rel, "/")
+	for i := len(segments) - 1; i >= 0; i-- {
+		if segments[i] == "internal" {
+			return path.Join(segments[:i]...), true
+		}
+	}
+	return "", false
+}
+
+// Returns whether the include path matches any of patterns defined using cc_ignore_include directive
+func (conf *ccConfig) isIgnoredInclude(includePath string) bool {
+	for _, pattern := range conf.ignoredIncludes {
+		if doublestar.MatchUnvalidated(pattern, includePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the repository-relative path of the source matches any of patterns defined using cc_testonly_srcs directive
+func (conf *ccConfig) isTestonlySource(rel string) bool {
+	for _, pattern := range conf.testonlySrcs {
+		if doublestar.MatchUnvalidated(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the include path with its prefix replaced according to cc_include_alias directives.
+// The longest matching prefix is used, or the latest defined one if there are multiple.
+// The path is returned unchanged if no alias matches.
+func (conf *ccConfig) aliasedIncludePath(includePath string) string {
+	var matched *includeAlias
+	var matchedRest string
+	for i, alias := range conf.includeAliases {
+		rest, ok := includepath.TrimPrefix(includePath, alias.from)
+		if !ok {
+			continue
+		}
+		if matched == nil || len(alias.from) >= len(matched.from) {
+			matched = &conf.includeAliases[i]
+			matchedRest = rest
+		}
+	}
+	if matched == nil {
+		return includePath
+	}
+	if aliased := path.Join(matched.to, matchedRest); aliased != "" {
+		return aliased
+	}
+	return includePath
+}
+
+// Returns the repository-root relative path of the include with the prefix
+// defined using cc_strip_include_root removed, and true if the prefix matches.
+func (conf *ccConfig) strippedIncludeRootPath(includePath string) (string, bool) {
+	if conf.stripIncludeRoot == "" {
+		return "", false
+	}
+	stripped, ok := includepath.TrimPrefix(includePath, conf.stripIncludeRoot)
+	return stripped, ok && stripped != ""
+}
+
+// Returns the dependency mapped to the include path using cc_include_prefix_dep directives.
+// The longest matching prefix is used, or the latest defined one if there are multiple.
+// Only paths of files under the prefix directory are matched, never the prefix itself.
+func (conf *ccConfig) includePrefixDep(includePath string) (label.Label, bool) {
+	var matched *includePrefixDep
+	for i, mapping := range conf.includePrefixDeps {
+		if rest, ok := includepath.TrimPrefix(includePath, mapping.prefix); !ok || rest == "" {
+			continue
+		}
+		if matched == nil || len(mapping.prefix) >= len(matched.prefix) {
+			matched = &conf.includePrefixDeps[i]
+		}
+	}
+	if matched == nil {
+		return label.NoLabel, false
+	}
+	return matched.dep, true
+}
+
 func (conf *ccConfig) matchesSubdirectoryIncludePatterns(name string) bool {
 	return conf.matchesSubdirectoryPatterns(name, conf.groupSubdirectoryIncludePatterns, "include")
 }
@@ -406,15 +1139,66 @@ const (
 	groupSourcesBySubdirectory sourceGroupingMode = "subdirectory"
 )
 
+type libraryNaming string
+
+var libraryNamings = []libraryNaming{libraryNaming_base, libraryNaming_path}
+
+const (
+	// Named after the directory, e.g. bar for foo/bar
+	libraryNaming_base libraryNaming = "base"
+	// Named after the path of the directory, e.g. foo_bar for foo/bar
+	libraryNaming_path libraryNaming = "path"
+)
+
 type groupsCycleHandlingMode string
 
-var groupsCycleHandlingModes = []groupsCycleHandlingMode{mergeOnGroupsCycle, warnOnGroupsCycle}
+var groupsCycleHandlingModes = []groupsCycleHandlingMode{mergeOnGroupsCycle, warnOnGroupsCycle, sharedLibOnGroupsCycle}
 
 const (
 	// All groups forming a cycle would be merged into a single one
 	mergeOnGroupsCycle groupsCycleHandlingMode = "merge"
 	// Don't modify rules forming a cycle, let user handle it manually
 	warnOnGroupsCycle groupsCycleHandlingMode = "warn"
+	// Headers of groups forming a cycle would be extracted into a shared group
+	// that remaining sources of these groups depend on
+	sharedLibOnGroupsCycle groupsCycleHandlingMode = "shared"
+)
+
+type indexPrecedence string
+
+var indexPrecedences = []indexPrecedence{indexPrecedence_local, indexPrecedence_index}
+
+const (
+	// Prefer rules defined in the repository over cc_indexfile indexes
+	indexPrecedence_local indexPrecedence = "local"
+	// Prefer cc_indexfile indexes over rules defined in the repository
+	indexPrecedence_index indexPrecedence = "index"
+)
+
+type unknownMacroValue string
+
+var unknownMacroValues = []unknownMacroValue{unknownMacroValue_zero, unknownMacroValue_one, unknownMacroValue_both}
+
+const (
+	// Includes depending on unknown macros are kept, but are not assigned to any of the platforms
+	unknownMacroValue_default unknownMacroValue = ""
+	// Unknown macros are not defined, matching the preprocessor
+	unknownMacroValue_zero unknownMacroValue = "0"
+	// Unknown macros are defined as 1, e.g. assuming all optional features are enabled
+	unknownMacroValue_one unknownMacroValue = "1"
+	// Includes reachable when unknown macros are either not defined or defined as 1 are kept
+	unknownMacroValue_both unknownMacroValue = "both"
+)
+
+type rulesLoadMode string
+
+var rulesLoadModes = []rulesLoadMode{rulesLoad_rulesCc, rulesLoad_native}
+
+const (
+	// Load rules using load("@rules_cc//cc:defs.bzl", ...) statements
+	rulesLoad_rulesCc rulesLoadMode = "rules_cc"
+	// Use native rules, without any load statements
+	rulesLoad_native rulesLoadMode = "native"
 )
 
 type errorReportingMode string
@@ -430,6 +1214,18 @@ const (
 	errorReportingMode_error errorReportingMode = "error"
 )
 
+type testSize string
+
+var testSizes = []testSize{testSize_small, testSize_medium, testSize_large, testSize_infer}
+
+const (
+	testSize_small  testSize = "small"
+	testSize_medium testSize = "medium"
+	testSize_large  testSize = "large"
+	// Use small size for tests defined in a single file, medium otherwise
+	testSize_infer testSize = "infer"
+)
+
 type ambiguousDepsMode string
 
 var ambiguousDepsModes = []ambiguousDepsMode{
@@ -437,6 +1233,7 @@ var ambiguousDepsModes = []ambiguousDepsMode{
 	ambiguousDepsMode_warn,
 	ambiguousDepsMode_try_first,
 	ambiguousDepsMode_force_first,
+	ambiguousDepsMode_todo,
 }
 
 const (
@@ -448,6 +1245,8 @@ const (
 	ambiguousDepsMode_try_first ambiguousDepsMode = "try_first"
 	// Always resolve the first dependency from the list
 	ambiguousDepsMode_force_first ambiguousDepsMode = "force_first"
+	// Do not add any dependency, list the candidates in a TODO comment above the rule
+	ambiguousDepsMode_todo ambiguousDepsMode = "todo"
 )
 
 // splitQuoted splits the string s around each instance of one or more consecutive
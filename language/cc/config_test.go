@@ -17,6 +17,7 @@ package cc
 import (
 	"testing"
 
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/stretchr/testify/require"
 )
 
@@ -95,3 +96,110 @@ func TestSplitQuoted(t *testing.T) {
 		})
 	}
 }
+
+func TestInternalPackageParent(t *testing.T) {
+	testCases := []struct {
+		description    string
+		rel            string
+		expectedParent string
+		expectedFound  bool
+	}{
+		{description: "root package", rel: "", expectedFound: false},
+		{description: "regular package", rel: "foo/bar", expectedFound: false},
+		{description: "segment containing internal", rel: "foo/internal_bar", expectedFound: false},
+		{description: "top-level internal", rel: "internal", expectedParent: "", expectedFound: true},
+		{description: "nested internal package", rel: "foo/internal/bar", expectedParent: "foo", expectedFound: true},
+		{description: "innermost internal wins", rel: "foo/internal/bar/internal/baz", expectedParent: "foo/internal/bar", expectedFound: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			parent, found := internalPackageParent(tc.rel)
+			require.Equal(t, tc.expectedFound, found)
+			require.Equal(t, tc.expectedParent, parent)
+		})
+	}
+}
+
+func TestIsIgnoredInclude(t *testing.T) {
+	conf := newCcConfig()
+	conf.ignoredIncludes = []string{"config.h", "gen/*.h", "**/*.pch"}
+
+	testCases := []struct {
+		description string
+		include     string
+		expected    bool
+	}{
+		{description: "exact match", include: "config.h", expected: true},
+		{description: "glob match", include: "gen/version.h", expected: true},
+		{description: "recursive glob match", include: "a/b/prefix.pch", expected: true},
+		{description: "glob does not match nested directories", include: "gen/sub/version.h", expected: false},
+		{description: "no match", include: "lib/config.h", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			require.Equal(t, tc.expected, conf.isIgnoredInclude(tc.include))
+		})
+	}
+}
+
+func TestAliasedIncludePath(t *testing.T) {
+	conf := newCcConfig()
+	conf.includeAliases = []includeAlias{
+		{from: "eigen3"},
+		{from: "python3.11", to: "python"},
+		{from: "python3.11/internal", to: "python/cpython"},
+		{from: "legacy/config.h", to: "config.h"},
+	}
+
+	testCases := []struct {
+		description string
+		include     string
+		expected    string
+	}{
+		{description: "strip prefix", include: "eigen3/Eigen/Core", expected: "Eigen/Core"},
+		{description: "replace prefix", include: "python3.11/Python.h", expected: "python/Python.h"},
+		{description: "longest prefix wins", include: "python3.11/internal/pycore.h", expected: "python/cpython/pycore.h"},
+		{description: "whole path", include: "legacy/config.h", expected: "config.h"},
+		{description: "prefix not matching path segment", include: "eigen3_extra/foo.h", expected: "eigen3_extra/foo.h"},
+		{description: "stripping whole path", include: "eigen3", expected: "eigen3"},
+		{description: "no match", include: "Eigen/Core", expected: "Eigen/Core"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			require.Equal(t, tc.expected, conf.aliasedIncludePath(tc.include))
+		})
+	}
+}
+
+func TestIncludePrefixDep(t *testing.T) {
+	zlib := label.New("zlib", "", "zlib")
+	minizip := label.New("", "third_party/minizip", "minizip")
+	conf := newCcConfig()
+	conf.includePrefixDeps = []includePrefixDep{
+		{prefix: "zlib", dep: zlib},
+		{prefix: "zlib/contrib/minizip", dep: minizip},
+	}
+
+	testCases := []struct {
+		description string
+		include     string
+		expected    label.Label
+		found       bool
+	}{
+		{description: "matching prefix", include: "zlib/zlib.h", expected: zlib, found: true},
+		{description: "longest prefix wins", include: "zlib/contrib/minizip/zip.h", expected: minizip, found: true},
+		{description: "prefix not matching path segment", include: "zlib-ng/zlib.h", expected: label.NoLabel},
+		{description: "prefix itself", include: "zlib", expected: label.NoLabel},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dep, found := conf.includePrefixDep(tc.include)
+			require.Equal(t, tc.found, found)
+			require.Equal(t, tc.expected, dep)
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"log"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/bazelbuild/bazel-gazelle/label"
+)
+
+// Records the dependencies resolved for the rule, used to detect cycles
+// between rules after resolving dependencies of all rules.
+func (lang *ccLanguage) recordResolvedDeps(from label.Label, deps ...platformDepsBuilder) {
+	resolved := make(collections.Set[label.Label])
+	for _, builder := range deps {
+		for dep := range builder.all {
+			resolved.Add(dep.Abs(from.Repo, from.Pkg))
+		}
+	}
+	lang.resolvedDeps[from] = resolved.SortedValues(compareLabels)
+}
+
+// Warns about each cycle in the dependencies between the rules resolved when
+// enabled by gazelle:cc_detect_dep_cycles. Cycles are typically created by
+// includes between directories, which are not taken into account when
+// grouping sources of a single directory. Bazel would refuse to build rules
+// depending on each other, so the dependencies need to be fixed manually.
+func (lang *ccLanguage) warnAboutDependencyCycles() {
+	for _, cycle := range findDependencyCycles(lang.resolvedDeps) {
+		log.Printf("gazelle_cc: found dependency cycle between rules: %v", strings.Join(collections.MapSlice(cycle, label.Label.String), " -> "))
+	}
+}
+
+// Returns the cycles found in the dependency graph, each cycle starts and ends
+// with the same label. Only dependencies between the keys of the graph are
+// taken into account. Each cycle is reported once, the graph is traversed in
+// order of labels, so the result is deterministic.
+func findDependencyCycles(graph map[label.Label][]label.Label) [][]label.Label {
+	const (
+		unvisited = iota
+		onPath
+		visited
+	)
+	state := make(map[label.Label]int, len(graph))
+	var path []label.Label
+	var cycles [][]label.Label
+
+	var visit func(node label.Label)
+	visit = func(node label.Label) {
+		state[node] = onPath
+		path = append(path, node)
+		for _, dep := range graph[node] {
+			if _, exists := graph[dep]; !exists {
+				continue
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case onPath:
+				start := slices.Index(path, dep)
+				cycles = append(cycles, append(slices.Clone(path[start:]), dep))
+			}
+		}
+		path = path[:len(path)-1]
+		state[node] = visited
+	}
+
+	for _, node := range slices.SortedFunc(maps.Keys(graph), compareLabels) {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+	return cycles
+}
+
+func compareLabels(a, b label.Label) int {
+	return strings.Compare(a.String(), b.String())
+}
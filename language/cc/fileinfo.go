@@ -17,11 +17,14 @@ package cc
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/includepath"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/parser"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/platform"
 	"github.com/bazelbuild/bazel-gazelle/language"
@@ -52,6 +55,10 @@ const (
 	// testSrcKind is an implementation file (.cc) that is in a test directory
 	// or has "test" in its name.
 	testSrcKind
+
+	// libResourceKind is a Windows resource script (.rc) compiled into the
+	// library only on platforms targeting Windows.
+	libResourceKind
 )
 
 // fileInfo collects metadata about an individual source or header file.
@@ -77,14 +84,24 @@ func (c *ccLanguage) getFileInfo(
 	name string,
 	subdirKind subdirKind) (fileInfo, error) {
 
-	if !hasMatchingExtension(name, ccExtensions) {
+	conf := getCcConfig(args.Config)
+	isResource := hasMatchingExtension(name, resourceExtensions)
+	switch {
+	case isResource:
+		// Resource scripts are ignored unless any of the platforms targets Windows
+		if len(conf.windowsPlatforms()) == 0 {
+			return fileInfo{}, errUnmatchedExtension
+		}
+	case !hasMatchingExtension(name, ccExtensions):
 		return fileInfo{}, errUnmatchedExtension
 	}
-	conf := getCcConfig(args.Config)
 	filePath := filepath.Join(args.Dir, name)
-	sourceInfo, err := parser.ParseSourceFile(filePath)
-	if err != nil {
-		return fileInfo{}, err
+	var sourceInfo parser.SourceInfo
+	if !isUnpreprocessedAssembly(name) {
+		var err error
+		if sourceInfo, err = parser.ParseSourceFile(filePath); err != nil {
+			return fileInfo{}, err
+		}
 	}
 
 	for _, parseErr := range sourceInfo.Errors {
@@ -92,36 +109,44 @@ func (c *ccLanguage) getFileInfo(
 	}
 
 	// Evaluate the directives and search for platform specific include paths
-	// We do it for each enabled platform using it's unique set of macros
-	platformIncludes := map[string][]platform.Platform{}
-	for platform, macros := range platformEnvs {
-		reachable := sourceInfo.CollectReachableIncludes(macros)
-		for _, include := range reachable {
-			platformIncludes[include.Path] = append(platformIncludes[include.Path], platform)
-		}
-	}
+	unknownMacros := conf.unknownMacros(sourceInfo)
+	platformIncludes := conf.includeActivePlatforms(sourceInfo, platformEnvs, unknownMacros)
 
-	// Assign all includes found in the directives
-	includeDirectives := sourceInfo.CollectIncludes()
-	includes := make([]ccInclude, len(includeDirectives))
-	for i, include := range sourceInfo.CollectIncludes() {
+	// Assign all includes found in the directives, except the ones in
+	// statically disabled blocks, e.g. #if 0, or disabled by project macros.
+	// Files included multiple times are resolved only once.
+	includeDirectives := parser.DeduplicateIncludes(conf.collectLiveIncludes(sourceInfo, unknownMacros))
+	includes := make([]ccInclude, 0, len(includeDirectives))
+	for _, include := range includeDirectives {
 		usedByPlatforms := platformIncludes[include.Path]
+		if isResource {
+			// Resource scripts, and so their includes, are used only on Windows
+			usedByPlatforms = collections.FilterSlice(usedByPlatforms, platform.Platform.IsWindows)
+			if len(usedByPlatforms) == 0 {
+				continue
+			}
+		}
 		isPlatformSpecific := len(usedByPlatforms) != len(platformEnvs)
-		includes[i] = ccInclude{
+		includes = append(includes, ccInclude{
 			sourceFile:         path.Join(args.Rel, name),
 			lineNumber:         include.LineNumber,
-			path:               path.Clean(include.Path),
+			path:               includepath.Normalize(include.Path),
 			isSystemInclude:    include.IsSystem,
 			isPlatformSpecific: isPlatformSpecific,
 			platforms:          usedByPlatforms,
-		}
+		})
+	}
+	if conf.macroIncludeHints {
+		includes = appendMacroIncludeHints(includes, sourceInfo, path.Join(args.Rel, name))
 	}
 
 	base := path.Base(name)
 	stem := base[:len(base)-len(path.Ext(base))]
 	isTest := strings.HasPrefix(stem, "test") || strings.HasSuffix(stem, "test")
 	var kind fileKind
-	if subdirKind != noSubdir {
+	if isResource {
+		kind = libResourceKind
+	} else if subdirKind != noSubdir {
 		// In subdirectory mode, classify files mostly based on their directory
 		// names. File extensions are less important.
 		switch {
@@ -163,6 +188,45 @@ func (c *ccLanguage) getFileInfo(
 	}, nil
 }
 
+// appendMacroIncludeHints appends headers listed in bodies of #define
+// directives of the source, skipping the ones already included.
+func appendMacroIncludeHints(includes []ccInclude, sourceInfo parser.SourceInfo, sourceFile string) []ccInclude {
+	for _, hint := range sourceInfo.CollectMacroIncludeHints() {
+		hintPath := includepath.Normalize(hint.Path)
+		if slices.ContainsFunc(includes, func(include ccInclude) bool { return include.path == hintPath }) {
+			continue
+		}
+		includes = append(includes, ccInclude{
+			sourceFile:      sourceFile,
+			lineNumber:      hint.LineNumber,
+			path:            hintPath,
+			isSystemInclude: hint.IsSystem,
+			isMacroHint:     true,
+		})
+	}
+	return includes
+}
+
+// includeActivePlatforms returns the platforms on which each include path of
+// the source is reached, the same platforms are used for select() arms of its
+// dependency. Directives are evaluated separately for each platform, starting
+// with its environment, extended by each value assumed for unknown macros.
+// Macros defined, undefined, pushed or popped in the source are applied in
+// order of the directives. Platforms of each include path are sorted.
+func (conf *ccConfig) includeActivePlatforms(sourceInfo parser.SourceInfo, platformEnvs map[platform.Platform]parser.Environment, unknownMacros collections.Set[string]) map[string][]platform.Platform {
+	result := map[string][]platform.Platform{}
+	for _, p := range slices.SortedFunc(maps.Keys(platformEnvs), platform.Compare) {
+		for _, env := range conf.unknownMacroEnvironments(platformEnvs[p], unknownMacros) {
+			for _, include := range sourceInfo.CollectReachableIncludes(env) {
+				if !slices.Contains(result[include.Path], p) {
+					result[include.Path] = append(result[include.Path], p)
+				}
+			}
+		}
+	}
+	return result
+}
+
 type subdirKind byte
 
 const (
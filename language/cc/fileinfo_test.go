@@ -0,0 +1,314 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/index"
+	"github.com/EngFlow/gazelle_cc/language/internal/cc/parser"
+	"github.com/EngFlow/gazelle_cc/language/internal/cc/platform"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIncludeActivePlatforms(t *testing.T) {
+	mustCreatePlatform := func(os platform.OS, arch platform.Arch) platform.Platform {
+		p, err := platform.Create(os, arch)
+		require.NoError(t, err)
+		return p
+	}
+	linuxAmd64 := mustCreatePlatform("linux", "x86_64")
+	linuxArm64 := mustCreatePlatform("linux", "aarch64")
+	macosArm64 := mustCreatePlatform("osx", "aarch64")
+	windowsAmd64 := mustCreatePlatform("windows", "x86_64")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "os.cc"), []byte(`
+#include "common.h"
+#if defined(_WIN32)
+#  include "windows.h"
+#  define USE_WIN_THREADS
+#elif defined(__APPLE__)
+#  include "apple.h"
+#else
+#  include "posix.h"
+#  ifdef __aarch64__
+#    include "arm64.h"
+#  else
+#    include "other_arch.h"
+#  endif
+#endif
+#ifdef USE_WIN_THREADS
+#  include "win_threads.h"
+#endif
+#pragma push_macro("USE_WIN_THREADS")
+#undef USE_WIN_THREADS
+#ifndef USE_WIN_THREADS
+#  include "no_win_threads.h"
+#endif
+#pragma pop_macro("USE_WIN_THREADS")
+#if defined(USE_WIN_THREADS) || HAS_POSIX_THREADS
+#  include "threads.h"
+#endif
+#ifdef ENABLE_LOGGING
+#  include "logging.h"
+#endif
+`), 0o644))
+
+	c := config.New()
+	lang := NewLanguage().(*ccLanguage)
+	lang.Configure(c, "", &rule.File{Directives: []rule.Directive{
+		{Key: cc_platform, Value: "linux x86_64 @platforms//os:linux HAS_POSIX_THREADS"},
+		{Key: cc_platform, Value: "linux aarch64 //platforms:linux_arm64"},
+		{Key: cc_platform, Value: "osx aarch64 @platforms//os:macos HAS_POSIX_THREADS"},
+		{Key: cc_platform, Value: "windows x86_64 @platforms//os:windows"},
+		{Key: cc_define, Value: "ENABLE_LOGGING"},
+	}})
+	conf := getCcConfig(c)
+	sourceInfo, err := parser.ParseSourceFile(filepath.Join(dir, "os.cc"))
+	require.NoError(t, err)
+	require.Empty(t, sourceInfo.Errors)
+
+	allPlatforms := []platform.Platform{linuxArm64, linuxAmd64, macosArm64, windowsAmd64}
+	expected := map[string][]platform.Platform{
+		"common.h":         allPlatforms,
+		"windows.h":        {windowsAmd64},
+		"apple.h":          {macosArm64},
+		"posix.h":          {linuxArm64, linuxAmd64},
+		"arm64.h":          {linuxArm64},
+		"other_arch.h":     {linuxAmd64},
+		"win_threads.h":    {windowsAmd64},
+		"no_win_threads.h": allPlatforms,
+		"threads.h":        {linuxAmd64, macosArm64, windowsAmd64},
+		"logging.h":        allPlatforms,
+	}
+	platformEnvs := conf.getPlatformEnvironments()
+	assert.Equal(t, expected, conf.includeActivePlatforms(sourceInfo, platformEnvs, nil))
+
+	// Platforms of includes used for select() arms are the same
+	fi, err := lang.getFileInfo(language.GenerateArgs{Config: c, Dir: dir}, platformEnvs, "os.cc", noSubdir)
+	require.NoError(t, err)
+	includePlatforms := make(map[string][]platform.Platform)
+	for _, include := range fi.includes {
+		includePlatforms[include.path] = include.platforms
+		assert.Equal(t, len(include.platforms) != len(allPlatforms), include.isPlatformSpecific, include.path)
+	}
+	assert.Equal(t, expected, includePlatforms)
+}
+
+func TestGetFileInfoMacroIncludeHints(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "deps.h"), []byte(`
+#include "base.h"
+#define MY_DEPS "base.h" "a.h" <b/b.h>
+#define VERSION "1.0"
+`), 0o644))
+
+	for _, enabled := range []bool{false, true} {
+		t.Run(fmt.Sprintf("enabled=%v", enabled), func(t *testing.T) {
+			c := config.New()
+			lang := NewLanguage().(*ccLanguage)
+			lang.Configure(c, "lib", nil)
+			getCcConfig(c).macroIncludeHints = enabled
+
+			fi, err := lang.getFileInfo(language.GenerateArgs{Config: c, Dir: dir, Rel: "lib"}, nil, "deps.h", noSubdir)
+			require.NoError(t, err)
+
+			expected := []ccInclude{
+				{sourceFile: "lib/deps.h", lineNumber: 2, path: "base.h"},
+			}
+			if enabled {
+				expected = append(expected,
+					ccInclude{sourceFile: "lib/deps.h", lineNumber: 3, path: "a.h", isMacroHint: true},
+					ccInclude{sourceFile: "lib/deps.h", lineNumber: 3, path: "b/b.h", isSystemInclude: true, isMacroHint: true},
+				)
+			}
+			assert.Equal(t, expected, fi.includes)
+		})
+	}
+}
+
+func TestGetFileInfoDefinedMacros(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "alloc.cc"), []byte(`
+#if USE_CUSTOM_ALLOCATOR
+#include "custom.h"
+#else
+#include "default.h"
+#endif
+#if defined(_WIN32) && !NO_WINDOWS_ALLOCATOR
+#include "windows.h"
+#endif
+`), 0o644))
+
+	testCases := []struct {
+		defines  string
+		expected map[string]bool // include path -> is platform specific
+	}{
+		{
+			defines:  "",
+			expected: map[string]bool{"custom.h": true, "default.h": false, "windows.h": true},
+		},
+		{
+			defines:  "USE_CUSTOM_ALLOCATOR",
+			expected: map[string]bool{"custom.h": false, "windows.h": true},
+		},
+		{
+			defines:  "USE_CUSTOM_ALLOCATOR=0 NO_WINDOWS_ALLOCATOR",
+			expected: map[string]bool{"default.h": false},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.defines, func(t *testing.T) {
+			c := config.New()
+			lang := NewLanguage().(*ccLanguage)
+			lang.Configure(c, "", &rule.File{Directives: []rule.Directive{
+				{Key: cc_platform, Value: "linux x86_64 @platforms//os:linux"},
+				{Key: cc_platform, Value: "windows x86_64 @platforms//os:windows"},
+				{Key: cc_define, Value: tc.defines},
+			}})
+			conf := getCcConfig(c)
+
+			fi, err := lang.getFileInfo(language.GenerateArgs{Config: c, Dir: dir}, conf.getPlatformEnvironments(), "alloc.cc", noSubdir)
+			require.NoError(t, err)
+
+			includes := make(map[string]bool)
+			for _, include := range fi.includes {
+				includes[include.path] = include.isPlatformSpecific
+			}
+			assert.Equal(t, tc.expected, includes)
+		})
+	}
+}
+
+func TestGetFileInfoUnknownMacroValue(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "trace.cc"), []byte(`
+#include "common.h"
+#ifdef ENABLE_TRACING
+#include "tracing.h"
+#else
+#include "no_tracing.h"
+#endif
+#if defined(_WIN32)
+#include "windows.h"
+#endif
+`), 0o644))
+
+	testCases := []struct {
+		value    string
+		expected map[string]bool // include path -> is platform specific
+	}{
+		{
+			value:    "",
+			expected: map[string]bool{"common.h": false, "tracing.h": true, "no_tracing.h": false, "windows.h": true},
+		},
+		{
+			value:    "0",
+			expected: map[string]bool{"common.h": false, "no_tracing.h": false, "windows.h": true},
+		},
+		{
+			value:    "1",
+			expected: map[string]bool{"common.h": false, "tracing.h": false, "windows.h": true},
+		},
+		{
+			value:    "both",
+			expected: map[string]bool{"common.h": false, "tracing.h": false, "no_tracing.h": false, "windows.h": true},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("value=%q", tc.value), func(t *testing.T) {
+			c := config.New()
+			lang := NewLanguage().(*ccLanguage)
+			lang.Configure(c, "", &rule.File{Directives: []rule.Directive{
+				{Key: cc_platform, Value: "linux x86_64 @platforms//os:linux"},
+				{Key: cc_platform, Value: "windows x86_64 @platforms//os:windows"},
+				{Key: cc_unknown_macro_value, Value: tc.value},
+			}})
+			conf := getCcConfig(c)
+
+			fi, err := lang.getFileInfo(language.GenerateArgs{Config: c, Dir: dir}, conf.getPlatformEnvironments(), "trace.cc", noSubdir)
+			require.NoError(t, err)
+
+			includes := make(map[string]bool)
+			for _, include := range fi.includes {
+				includes[include.path] = include.isPlatformSpecific
+			}
+			assert.Equal(t, tc.expected, includes)
+		})
+	}
+}
+
+func TestGetFileInfoRepeatedIncludes(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "app.cc"), []byte(`
+#include "lib/lib.h"
+#ifdef USE_EXTRAS
+#include "lib/lib.h"
+#endif
+#include "lib/lib.h"
+`), 0o644))
+
+	c := config.New()
+	(&resolve.Configurer{}).RegisterFlags(flag.NewFlagSet("test", flag.ContinueOnError), "", c)
+	lang := NewLanguage().(*ccLanguage)
+	lang.Configure(c, "app", nil)
+	conf := getCcConfig(c)
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+	lib := label.New("", "lib", "lib")
+	conf.dependencyIndexes = []index.DependencyIndex{{"lib/lib.h": {lib}}}
+
+	fi, err := lang.getFileInfo(language.GenerateArgs{Config: c, Dir: dir, Rel: "app"}, nil, "app.cc", noSubdir)
+	require.NoError(t, err)
+	// The first occurrence is kept for diagnostics
+	assert.Equal(t, []ccInclude{{sourceFile: "app/app.cc", lineNumber: 2, path: "lib/lib.h"}}, fi.includes)
+
+	ix := resolve.NewRuleIndex(nil)
+	ix.Finish()
+	deps := lang.resolveIncludes(c, ix, rule.NewRule("cc_binary", "app"), label.New("", "app", "app"), fi.includes, collections.Set[label.Label]{})
+	assert.Equal(t, collections.SetOf(lib), deps.all)
+	assert.Equal(t, int64(1), lang.resolveStats.count(resolvedViaDepIndex))
+}
+
+func TestUndefineMacro(t *testing.T) {
+	c := config.New()
+	lang := NewLanguage().(*ccLanguage)
+	lang.Configure(c, "", &rule.File{Directives: []rule.Directive{
+		{Key: cc_define, Value: "FOO BAR=2 BAZ"},
+		{Key: cc_undefine, Value: "FOO _WIN32"},
+		{Key: cc_define, Value: "BAZ="},
+	}})
+	conf := getCcConfig(c)
+	assert.Equal(t, parser.Environment{"BAR": 2}, conf.definedMacros)
+	assert.ElementsMatch(t, []string{"FOO", "_WIN32", "BAZ"}, conf.undefinedMacros.Values())
+
+	windows, err := platform.Create(platform.OS("windows"), platform.Arch("x86_64"))
+	require.NoError(t, err)
+	env := platformConfig{platform: windows, userDefinedEnv: parser.Environment{"BAR": 3}}.getPlatformEnvironment(conf.definedMacros, conf.undefinedMacros)
+	assert.NotContains(t, env, "_WIN32")
+	assert.Equal(t, 3, env["BAR"])
+}
@@ -16,11 +16,14 @@ package cc
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"maps"
+	"os"
 	"path"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
@@ -28,7 +31,7 @@ import (
 	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/language"
 	"github.com/bazelbuild/bazel-gazelle/rule"
-	"github.com/bazelbuild/bazel-gazelle/walk"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 func (c *ccLanguage) GenerateRules(args language.GenerateArgs) (result language.GenerateResult) {
@@ -36,9 +39,13 @@ func (c *ccLanguage) GenerateRules(args language.GenerateArgs) (result language.
 		if args.File != nil || len(args.OtherGen) > 0 || len(result.Gen) > 0 {
 			c.buildFileDirRels.Add(args.Rel)
 		}
+		if c.verify {
+			c.recordVerifiedBuildFile(args, result)
+		}
 	}()
 
 	conf := getCcConfig(args.Config)
+	c.collectAliases(args)
 
 	if shouldSkipSubdirectory(args) {
 		return language.GenerateResult{}
@@ -46,6 +53,7 @@ func (c *ccLanguage) GenerateRules(args language.GenerateArgs) (result language.
 
 	fileInfos := c.collectFileInfos(args)
 	rulesInfo := extractRulesInfo(args)
+	c.collectPrecompiledHeaders(args, fileInfos)
 
 	// The order of rules generation matters - name conflict and renaming is based on result.Gen content
 	result.RelsToIndex = c.listRelsToIndex(args, fileInfos)
@@ -57,8 +65,24 @@ func (c *ccLanguage) GenerateRules(args language.GenerateArgs) (result language.
 
 	consumedProtoFiles := generateProtoLibraryRules(args, &result)
 	c.generateBinaryRules(args, fileInfos, rulesInfo, &result)
-	c.generateLibraryRules(args, fileInfos, rulesInfo, consumedProtoFiles, &result)
-	c.generateTestRules(args, fileInfos, rulesInfo, &result)
+	// Headers of prebuilt libraries are owned by their cc_import rules, they should never be regrouped
+	if err := c.generateLibraryRules(args, fileInfos, rulesInfo, consumedProtoFiles.Join(rulesInfo.importedHeaders), &result); err != nil {
+		log.Printf("gazelle_cc: failed to generate rules in %v, the directory would be skipped. Reason: %v", args.Rel, err)
+		return language.GenerateResult{}
+	}
+	for _, err := range findDuplicateSourceAssignments(args.Rel, result.Gen) {
+		log.Printf("gazelle_cc: %v", err)
+	}
+	if conf.binarySharedSrcs {
+		linkSharedSources(args, result)
+	}
+	if err := c.generateTestRules(args, fileInfos, rulesInfo, &result); err != nil {
+		log.Printf("gazelle_cc: failed to generate rules in %v, the directory would be skipped. Reason: %v", args.Rel, err)
+		return language.GenerateResult{}
+	}
+
+	addForcedIncludes(args, conf.forcedIncludes, &result)
+	linkExistingRules(args, rulesInfo, result.Gen)
 
 	// None of the rules generated above can be empty - it's guaranteed by generating them only if sources exists
 	// However we need to inspect for existing rules that are no longer matching any files
@@ -67,6 +91,86 @@ func (c *ccLanguage) GenerateRules(args language.GenerateArgs) (result language.
 	return result
 }
 
+// Adds headers defined using cc_force_include to the includes of all generated
+// rules compiling C/C++ sources. These are included implicitly by each source,
+// the library owning them is added to deps of each rule.
+func addForcedIncludes(args language.GenerateArgs, forcedIncludes []ccInclude, result *language.GenerateResult) {
+	if len(forcedIncludes) == 0 {
+		return
+	}
+	for i, r := range result.Gen {
+		switch resolveCCRuleKind(r.Kind(), args.Config) {
+		case "cc_library", "cc_binary", "cc_test":
+			imports := result.Imports[i].(ccImports)
+			imports.hdrIncludes = slices.Concat(imports.hdrIncludes, forcedIncludes)
+			result.Imports[i] = imports
+		}
+	}
+}
+
+// Records includes of headers named in gazelle:cc_precompiled_header, so that
+// these can be propagated to the rules including them when resolving deps.
+func (c *ccLanguage) collectPrecompiledHeaders(args language.GenerateArgs, fileInfos []fileInfo) {
+	conf := getCcConfig(args.Config)
+	if len(conf.precompiledHeaders) == 0 {
+		return
+	}
+	for _, fi := range fileInfos {
+		if fileNameIsHeader(fi.name) && slices.Contains(conf.precompiledHeaders, path.Base(fi.name)) {
+			c.precompiledHeaderIncludes[path.Join(args.Rel, fi.name)] = fi.includes
+		}
+	}
+}
+
+// Makes each cc_binary generated in the package depend on the libraries of the
+// package containing shared (non-main) sources, e.g. helpers used by multiple
+// binaries without a header. Such sources would not be linked otherwise, as
+// dependencies are resolved using includes only. Test libraries are never
+// linked, sharded libraries and platform variants are linked using their facade.
+func linkSharedSources(args language.GenerateArgs, result language.GenerateResult) {
+	generated := make(map[string]*rule.Rule, len(result.Gen))
+	for _, r := range result.Gen {
+		generated[r.Name()] = r
+	}
+	hasSharedSources := func(r *rule.Rule) bool {
+		if resolveCCRuleKind(r.Kind(), args.Config) != "cc_library" || ruleAttrBool(r, "testonly", false) {
+			return false
+		}
+		if _, isFacade := r.PrivateAttr(ccShardsKey).([]label.Label); isFacade {
+			return true
+		}
+		return slices.ContainsFunc(r.AttrStrings("srcs"), func(src string) bool { return !fileNameIsHeader(src) })
+	}
+
+	var libraries []label.Label
+	for _, r := range result.Gen {
+		switch {
+		case r.Kind() == platformVariantsFacadeKind:
+			// Variants share the same sources
+			variants, ok := platformVariantsOf(r)
+			if !ok || len(variants) == 0 || generated[variants[0]] == nil || !hasSharedSources(generated[variants[0]]) {
+				continue
+			}
+		case r.PrivateAttr(ccVariantFacadeKey) != nil:
+			// Platform variant, linked using its facade
+			continue
+		default:
+			if _, isShard := r.PrivateAttr(ccShardFacadeKey).(label.Label); isShard || !hasSharedSources(r) {
+				continue
+			}
+		}
+		libraries = append(libraries, label.Label{Name: r.Name(), Relative: true})
+	}
+	if len(libraries) == 0 {
+		return
+	}
+	for _, r := range result.Gen {
+		if resolveCCRuleKind(r.Kind(), args.Config) == "cc_binary" {
+			r.SetPrivateAttr(ccSharedSrcsKey, libraries)
+		}
+	}
+}
+
 // shouldSkipSubdirectory returns true if we're in
 // `# gazelle:cc_group subdirectory` mode, this directory doesn't have a
 // build file, and this directory's name matches one of the patterns
@@ -87,6 +191,29 @@ func shouldSkipSubdirectory(args language.GenerateArgs) bool {
 		conf.matchesSubdirectoryTestPatterns(name)
 }
 
+// collectAliases registers alias rules defined in the existing build file, so
+// that dependencies could be resolved to them when cc_prefer_alias is enabled.
+// If multiple aliases point to the same rule the lexicographically first one is used.
+func (c *ccLanguage) collectAliases(args language.GenerateArgs) {
+	if args.File == nil {
+		return
+	}
+	for _, r := range args.File.Rules {
+		if r.Kind() != "alias" {
+			continue
+		}
+		actual, err := label.Parse(r.AttrString("actual"))
+		if err != nil {
+			continue
+		}
+		actual = actual.Abs(args.Config.RepoName, args.Rel)
+		alias := label.New(args.Config.RepoName, args.Rel, r.Name())
+		if existing, ok := c.aliases[actual]; !ok || alias.String() < existing.String() {
+			c.aliases[actual] = alias
+		}
+	}
+}
+
 // extractImports returns two lists of include directives read from the
 // given list of files. The lists contain includes from headers and source
 // files so that deps and implementation_deps attributes can be generated
@@ -123,26 +250,75 @@ func extractImports(rel string, fileInfos []fileInfo) ccImports {
 	return imports
 }
 
-func splitSourcesIntoGroups(args language.GenerateArgs, fileInfos []fileInfo) sourceGroups {
+// Returns the id of the group containing all sources of the directory.
+func directoryGroupId(args language.GenerateArgs) groupId {
+	groupName := args.Rel
+	if getCcConfig(args.Config).libraryNaming == libraryNaming_path {
+		// Names unique within the repository, groupId is named after its last path segment
+		groupName = strings.ReplaceAll(groupName, "/", "_")
+	}
+	if groupName == "" {
+		// We're in the top-level directory, try use repo name
+		groupName = args.Config.RepoName
+	}
+	// Last, not deterministic, fallback - the repository directory name
+	if groupName == "" {
+		groupName = filepath.Base(args.Dir)
+	}
+	return groupId(groupName)
+}
+
+func splitSourcesIntoGroups(args language.GenerateArgs, rulesInfo rulesInfo, fileInfos []fileInfo) (sourceGroups, error) {
 	conf := getCcConfig(args.Config)
 	var srcGroups sourceGroups
 	switch conf.groupingMode {
 	case groupSourcesByDirectory, groupSourcesBySubdirectory:
 		// All sources grouped together
-		groupName := args.Rel
-		if groupName == "" {
-			// We're in the top-level directory, try use repo name
-			groupName = args.Config.RepoName
+		srcGroups = sourceGroups{directoryGroupId(args): {sources: fileInfos}}
+	case groupSourcesByUnit:
+		var err error
+		stripIncludePrefix, includePrefix := rulesInfo.includePrefixes(args, "")
+		if srcGroups, err = groupSourcesByUnits(args.Rel, stripIncludePrefix, includePrefix, fileInfos, conf.groupsCycleHandlingMode); err != nil {
+			return nil, err
 		}
-		// Last, not deterministic, fallback - the repository directory name
-		if groupName == "" {
-			groupName = filepath.Base(args.Dir)
+		srcGroups.mergeSmallGroups(conf.groupMinSize)
+	}
+	return srcGroups, nil
+}
+
+// Writes the dependency graph of sources grouped by units as JSON file to the
+// directory set using -cc_dump_source_graph flag, so that grouping decisions can be inspected.
+func (c *ccLanguage) dumpSourceGraph(args language.GenerateArgs, rulesInfo rulesInfo, fileInfos []fileInfo, name string) {
+	conf := getCcConfig(args.Config)
+	if c.sourceGraphDumpDir == "" || conf.groupingMode != groupSourcesByUnit {
+		return
+	}
+	stripIncludePrefix, includePrefix := rulesInfo.includePrefixes(args, "")
+	content, err := dumpSourceGroupsGraph(args.Rel, stripIncludePrefix, includePrefix, fileInfos, conf.groupsCycleHandlingMode)
+	if err != nil {
+		log.Printf("gazelle_cc: failed to serialize source graph of %v: %v", args.Rel, err)
+		return
+	}
+	dumpFile := filepath.Join(c.sourceGraphDumpDir, filepath.FromSlash(args.Rel), name+".source_graph.json")
+	if err := os.MkdirAll(filepath.Dir(dumpFile), 0o755); err != nil {
+		log.Printf("gazelle_cc: failed to write source graph of %v: %v", args.Rel, err)
+		return
+	}
+	if err := os.WriteFile(dumpFile, content, 0o644); err != nil {
+		log.Printf("gazelle_cc: failed to write source graph of %v: %v", args.Rel, err)
+	}
+}
+
+// Links the generated rules with the existing rules they would be merged into,
+// allowing to update the comments managed by gazelle_cc when resolving them.
+func linkExistingRules(args language.GenerateArgs, rulesInfo rulesInfo, rules []*rule.Rule) {
+	for _, r := range rules {
+		existing, ok := rulesInfo.definedRules[r.Name()]
+		if !ok || resolveCCRuleKind(existing.Kind(), args.Config) != resolveCCRuleKind(r.Kind(), args.Config) {
+			continue
 		}
-		srcGroups = sourceGroups{groupId(groupName): {sources: fileInfos}}
-	case groupSourcesByUnit:
-		srcGroups = groupSourcesByUnits(args.Rel, conf.ccStripIncludePrefix, conf.ccIncludePrefix, fileInfos)
+		r.SetPrivateAttr(ccExistingRuleKey, existingRuleRef{file: args.File, rule: existing})
 	}
-	return srcGroups
 }
 
 // Get all dependencies (public and private) of the given rule as absolute labels.
@@ -175,30 +351,48 @@ func newOrExistingRule(kind string, ruleName string, srcGroups sourceGroups, rul
 	return newRule
 }
 
-func setVisibilityIfNeeded(rule *rule.Rule, buildFile *rule.File) {
+// Sets the visibility attribute unless it's empty or the package defines default_visibility
+func setVisibilityIfNeeded(rule *rule.Rule, buildFile *rule.File, visibility []string) {
+	if len(visibility) == 0 {
+		return
+	}
 	if buildFile == nil || !buildFile.HasDefaultVisibility() {
-		rule.SetAttr("visibility", []string{"//visibility:public"})
+		rule.SetAttr("visibility", visibility)
 	}
 }
 
-func (c *ccLanguage) generateLibraryRules(args language.GenerateArgs, fileInfos []fileInfo, rulesInfo rulesInfo, excludedSources collections.Set[string], result *language.GenerateResult) {
+func (c *ccLanguage) generateLibraryRules(args language.GenerateArgs, fileInfos []fileInfo, rulesInfo rulesInfo, excludedSources collections.Set[string], result *language.GenerateResult) error {
 	conf := getCcConfig(args.Config)
 	// Ignore files that might have been consumed by other rules
-	var libFiles []fileInfo
+	var libFiles, testonlyFiles []fileInfo
 	for _, fi := range fileInfos {
 		if excludedSources.Contains(fi.name) {
 			continue
 		}
-		if fi.kind != libSrcKind && fi.kind != libHdrKind {
+		if fi.kind != libSrcKind && fi.kind != libHdrKind && fi.kind != libResourceKind {
+			continue
+		}
+		if conf.isTestonlySource(path.Join(args.Rel, fi.name)) {
+			testonlyFiles = append(testonlyFiles, fi)
 			continue
 		}
 		libFiles = append(libFiles, fi)
 	}
 	if len(libFiles) == 0 {
-		return
+		c.generateTestonlyLibraryRule(args, rulesInfo, testonlyFiles, result)
+		return nil
+	}
+	srcGroups, err := splitSourcesIntoGroups(args, rulesInfo, libFiles)
+	if err != nil {
+		return err
+	}
+	c.dumpSourceGraph(args, rulesInfo, libFiles, "library")
+	var transitiveIncludes map[string][]ccInclude
+	if conf.transitiveHeaderDeps {
+		// Computed before adjusting groups to existing rules which might invalidate dependencies between groups
+		transitiveIncludes = srcGroups.headerOnlyDepsIncludes(args.Rel)
 	}
-	srcGroups := splitSourcesIntoGroups(args, libFiles)
-	ambigiousRuleAssignments := srcGroups.adjustToExistingRules(rulesInfo)
+	ambigiousRuleAssignments := srcGroups.adjustToExistingRules(args, rulesInfo)
 
 	for _, groupId := range srcGroups.groupIds() {
 		group := srcGroups[groupId]
@@ -210,7 +404,9 @@ func (c *ccLanguage) generateLibraryRules(args language.GenerateArgs, fileInfos
 
 		// Deal with rules that conflict with existing defintions
 		if ruleNames := ambigiousRuleAssignments[groupId]; len(ruleNames) > 1 {
-			if !c.handleAmbigiousRulesAssignment(args, conf, rulesInfo, newRule, result, *group, ruleNames) {
+			if handled, err := c.handleAmbigiousRulesAssignment(args, conf, rulesInfo, newRule, result, *group, ruleNames); err != nil {
+				return err
+			} else if !handled {
 				continue // Failed to handle issue, skip this group. New rule could have been modified
 			}
 		}
@@ -219,36 +415,247 @@ func (c *ccLanguage) generateLibraryRules(args language.GenerateArgs, fileInfos
 		// to groups as we cannot read them, so we have less information available to us to process
 		// them.
 		srcs, hdrs := rulesInfo.genFilesInRule(newRule)
+		shards := shardSources(group.sources, conf.shardCount, conf.shardMinSrcs)
 
-		// Assign sources to groups
+		// Assign sources to groups, sources of sharded groups are assigned to their shards
+		var headers []fileInfo
+		var resources []string
 		for _, fi := range group.sources {
 			switch fi.kind {
 			case libSrcKind:
-				srcs = append(srcs, fi.name)
+				if shards == nil {
+					srcs = append(srcs, fi.name)
+				}
 			case libHdrKind:
 				hdrs = append(hdrs, fi.name)
+				headers = append(headers, fi)
+			case libResourceKind:
+				resources = append(resources, fi.name)
 			}
 		}
-		if len(srcs) > 0 {
-			newRule.SetAttr("srcs", srcs)
+		stripIncludePrefix, includePrefix := rulesInfo.includePrefixes(args, newRule.Name())
+		setAttrs := func(r *rule.Rule) {
+			if len(resources) > 0 {
+				r.SetAttr("srcs", sourcesWithResources(conf, srcs, resources))
+			} else {
+				rulesInfo.setSourcesAttr(args, r, "srcs", srcs)
+			}
+			rulesInfo.setSourcesAttr(args, r, "hdrs", hdrs)
+			setVisibilityIfNeeded(r, args.File, conf.libraryVisibility(args.Rel))
+			if includePrefix != "" {
+				r.SetAttr("include_prefix", includePrefix)
+			}
+			if stripIncludePrefix != "" {
+				r.SetAttr("strip_include_prefix", stripIncludePrefix)
+			}
+		}
+		setAttrs(newRule)
+
+		imports := extractImports(args.Rel, group.sources)
+		if shards != nil {
+			// Facade of shards holds only the headers
+			imports = extractImports(args.Rel, headers)
+		}
+		if conf.transitiveHeaderDeps {
+			// Header-only rules cannot have private dependencies, all of them are required by dependent rules
+			if group.isHeaderOnly() || shards != nil {
+				imports.hdrIncludes = appendTransitiveIncludes(imports.hdrIncludes, group.sources, transitiveIncludes)
+			} else {
+				imports.srcIncludes = appendTransitiveIncludes(imports.srcIncludes, group.sources, transitiveIncludes)
+			}
+		}
+		if conf.platformVariants && shards == nil && len(conf.platforms) > 0 && imports.hasPlatformSpecificIncludes() {
+			c.generatePlatformVariantRules(args, rulesInfo, newRule, imports, setAttrs, result)
+			continue
 		}
+		c.removeStalePlatformVariants(rulesInfo, newRule.Name(), result)
+		result.Gen = append(result.Gen, newRule)
+		result.Imports = append(result.Imports, imports)
+		c.generateShardRules(args, rulesInfo, newRule, shards, headers, hdrs, transitiveIncludes, result)
+	}
+	// Test-support library is defined after the libraries of production sources
+	c.generateTestonlyLibraryRule(args, rulesInfo, testonlyFiles, result)
+	return nil
+}
+
+// Returns the sources of a library followed by Windows resource scripts,
+// selected only on the platforms targeting Windows.
+func sourcesWithResources(conf *ccConfig, srcs, resources []string) ccPlatformStringsExprs {
+	constrained := make(map[label.Label][]string)
+	for _, p := range conf.windowsPlatforms() {
+		constrained[conf.platforms[p].constraint] = resources
+	}
+	return newCcPlatformSourcesExprs(srcs, constrained)
+}
+
+// Generates a single testonly cc_library rule containing test-support sources
+// matching cc_testonly_srcs patterns, so that they're never exposed by
+// production libraries.
+func (c *ccLanguage) generateTestonlyLibraryRule(args language.GenerateArgs, rulesInfo rulesInfo, testonlyFiles []fileInfo, result *language.GenerateResult) {
+	if len(testonlyFiles) == 0 {
+		return
+	}
+	conf := getCcConfig(args.Config)
+	ruleName := directoryGroupId(args).toRuleName() + "_testonly"
+	newRule := newOrExistingRule("cc_library", ruleName, nil, rulesInfo, args)
+	srcs, hdrs := rulesInfo.genFilesInRule(newRule)
+	var resources []string
+	for _, fi := range testonlyFiles {
+		switch fi.kind {
+		case libSrcKind:
+			srcs = append(srcs, fi.name)
+		case libHdrKind:
+			hdrs = append(hdrs, fi.name)
+		case libResourceKind:
+			resources = append(resources, fi.name)
+		}
+	}
+	if len(resources) > 0 {
+		newRule.SetAttr("srcs", sourcesWithResources(conf, srcs, resources))
+	} else {
+		rulesInfo.setSourcesAttr(args, newRule, "srcs", srcs)
+	}
+	rulesInfo.setSourcesAttr(args, newRule, "hdrs", hdrs)
+	newRule.SetAttr("testonly", true)
+	setVisibilityIfNeeded(newRule, args.File, conf.libraryVisibility(args.Rel))
+	stripIncludePrefix, includePrefix := rulesInfo.includePrefixes(args, newRule.Name())
+	if includePrefix != "" {
+		newRule.SetAttr("include_prefix", includePrefix)
+	}
+	if stripIncludePrefix != "" {
+		newRule.SetAttr("strip_include_prefix", stripIncludePrefix)
+	}
+	result.Gen = append(result.Gen, newRule)
+	result.Imports = append(result.Imports, extractImports(args.Rel, testonlyFiles))
+}
+
+// Splits non-header sources of the group into the given number of shards of
+// similar size, keeping the order of sources. Returns nil if the group has no
+// more than minSrcs sources and should not be sharded.
+func shardSources(sources []fileInfo, count, minSrcs int) [][]fileInfo {
+	var srcs []fileInfo
+	for _, fi := range sources {
+		if fi.kind == libSrcKind {
+			srcs = append(srcs, fi)
+		}
+	}
+	if count < 2 || len(srcs) <= minSrcs || len(srcs) < 2 {
+		return nil
+	}
+	slices.SortFunc(srcs, func(a, b fileInfo) int { return strings.Compare(a.name, b.name) })
+	count = min(count, len(srcs))
+	shards := make([][]fileInfo, 0, count)
+	for i := range count {
+		shards = append(shards, srcs[i*len(srcs)/count:(i+1)*len(srcs)/count])
+	}
+	return shards
+}
+
+// Returns the name of k-th shard (1-based) of the facade library.
+func shardRuleName(facade string, k int) string {
+	return fmt.Sprintf("%s_shard_%d", facade, k)
+}
+
+// Returns the name of the facade library if ruleName is a name of its shard.
+func shardFacadeName(ruleName string) (string, bool) {
+	idx := strings.LastIndex(ruleName, "_shard_")
+	if idx <= 0 {
+		return "", false
+	}
+	if k, err := strconv.Atoi(ruleName[idx+len("_shard_"):]); err != nil || k < 1 {
+		return "", false
+	}
+	return ruleName[:idx], true
+}
+
+// Generates cc_library rules for the shards of the facade library. Each shard
+// defines its part of the sources and the headers of the facade as
+// textual_hdrs, required to compile them. The facade depends on all of its
+// shards. Existing shards no longer used by the facade are removed unless
+// sharding is disabled.
+func (c *ccLanguage) generateShardRules(
+	args language.GenerateArgs,
+	rulesInfo rulesInfo,
+	facade *rule.Rule,
+	shards [][]fileInfo,
+	headers []fileInfo,
+	hdrs []string,
+	transitiveIncludes map[string][]ccInclude,
+	result *language.GenerateResult) {
+	conf := getCcConfig(args.Config)
+	shardLabels := make([]label.Label, 0, len(shards))
+	for i, shardSrcs := range shards {
+		shardName := shardRuleName(facade.Name(), i+1)
+		shardLabels = append(shardLabels, label.Label{Name: shardName, Relative: true})
+		shard := rule.NewRule("cc_library", shardName)
+		if existing, ok := rulesInfo.definedRules[shardName]; ok {
+			if _, exists := args.Config.AliasMap[existing.Kind()]; exists {
+				shard.SetKind(existing.Kind())
+			}
+			shard.SetPrivateAttr(ccExistingDepsKey, getAllRuleDeps(existing, args.Config.RepoName, args.Rel))
+			// textual_hdrs is not mergeable, headers of the facade need to be updated in place
+			if len(hdrs) > 0 {
+				existing.SetAttr("textual_hdrs", hdrs)
+			}
+		}
+		shard.SetPrivateAttr(ccShardFacadeKey, label.Label{Name: facade.Name(), Relative: true})
+		rulesInfo.setSourcesAttr(args, shard, "srcs", toRelativePaths(shardSrcs))
 		if len(hdrs) > 0 {
-			newRule.SetAttr("hdrs", hdrs)
+			shard.SetAttr("textual_hdrs", hdrs)
 		}
-		setVisibilityIfNeeded(newRule, args.File)
-		if conf.ccIncludePrefix != "" {
-			newRule.SetAttr("include_prefix", conf.ccIncludePrefix)
+		for _, attr := range []string{"include_prefix", "strip_include_prefix"} {
+			if value := facade.AttrString(attr); value != "" {
+				shard.SetAttr(attr, value)
+			}
 		}
-		if conf.ccStripIncludePrefix != "" {
-			newRule.SetAttr("strip_include_prefix", conf.ccStripIncludePrefix)
+
+		files := slices.Concat(shardSrcs, headers)
+		imports := extractImports(args.Rel, files)
+		if conf.transitiveHeaderDeps {
+			imports.srcIncludes = appendTransitiveIncludes(imports.srcIncludes, files, transitiveIncludes)
 		}
+		result.Gen = append(result.Gen, shard)
+		result.Imports = append(result.Imports, imports)
+	}
+	if len(shardLabels) > 0 {
+		facade.SetPrivateAttr(ccShardsKey, shardLabels)
+	}
+	if conf.shardCount == 0 {
+		// Sharding is disabled, rules named like shards are not managed
+		return
+	}
+	for _, name := range slices.Sorted(maps.Keys(rulesInfo.definedRules)) {
+		if facadeName, ok := shardFacadeName(name); ok && facadeName == facade.Name() && !hasRuleWithName(name, result.Gen) {
+			result.Empty = append(result.Empty, rule.NewRule(rulesInfo.definedRules[name].Kind(), name))
+		}
+	}
+}
 
-		result.Gen = append(result.Gen, newRule)
-		result.Imports = append(result.Imports, extractImports(args.Rel, group.sources))
+// Appends includes of header-only dependencies of given sources, skipping
+// includes which were already added.
+func appendTransitiveIncludes(includes []ccInclude, sources []fileInfo, transitiveIncludes map[string][]ccInclude) []ccInclude {
+	type includeKey struct {
+		sourceFile string
+		lineNumber int
+	}
+	seen := make(collections.Set[includeKey])
+	for _, include := range includes {
+		seen.Add(includeKey{include.sourceFile, include.lineNumber})
 	}
+	for _, fi := range sources {
+		for _, include := range transitiveIncludes[fi.name] {
+			key := includeKey{include.sourceFile, include.lineNumber}
+			if !seen.Contains(key) {
+				seen.Add(key)
+				includes = append(includes, include)
+			}
+		}
+	}
+	return includes
 }
 
 func (c *ccLanguage) generateBinaryRules(args language.GenerateArgs, fileInfos []fileInfo, rulesInfo rulesInfo, result *language.GenerateResult) {
+	conf := getCcConfig(args.Config)
 	mainSrcs := collections.FilterSlice(fileInfos, func(fi fileInfo) bool { return fi.kind == binSrcKind })
 	srcGroups := identitySourceGroups(mainSrcs)
 	for _, groupId := range srcGroups.groupIds() {
@@ -257,20 +664,25 @@ func (c *ccLanguage) generateBinaryRules(args language.GenerateArgs, fileInfos [
 		newRule := newOrExistingRule("cc_binary", ruleName, srcGroups, rulesInfo, args)
 		genSrcs, _ := rulesInfo.genFilesInRule(newRule)
 		newRule.SetAttr("srcs", append(genSrcs, toRelativePaths(group.sources)...))
+		setVisibilityIfNeeded(newRule, args.File, conf.defaultVisibility)
 		result.Gen = append(result.Gen, newRule)
 		result.Imports = append(result.Imports, extractImports(args.Rel, group.sources))
 	}
 }
 
-func (c *ccLanguage) generateTestRules(args language.GenerateArgs, fileInfos []fileInfo, rulesInfo rulesInfo, result *language.GenerateResult) {
+func (c *ccLanguage) generateTestRules(args language.GenerateArgs, fileInfos []fileInfo, rulesInfo rulesInfo, result *language.GenerateResult) error {
 	testSrcs := collections.FilterSlice(fileInfos, func(fi fileInfo) bool { return fi.kind == testSrcKind })
 	if len(testSrcs) == 0 {
-		return
+		return nil
 	}
 	// TODO: group tests by framework (unlikely but possible)
 	conf := getCcConfig(args.Config)
-	srcGroups := splitSourcesIntoGroups(args, testSrcs)
-	ambigiousRuleAssignments := srcGroups.adjustToExistingRules(rulesInfo)
+	srcGroups, err := splitSourcesIntoGroups(args, rulesInfo, testSrcs)
+	if err != nil {
+		return err
+	}
+	c.dumpSourceGraph(args, rulesInfo, testSrcs, "test")
+	ambigiousRuleAssignments := srcGroups.adjustToExistingRules(args, rulesInfo)
 
 	// If group A depends on group B then group B should be emitted as cc_library
 	testLibraryGroupIds := make(collections.Set[groupId])
@@ -354,6 +766,7 @@ func (c *ccLanguage) generateTestRules(args language.GenerateArgs, fileInfos []f
 		if len(srcs) > 0 {
 			newRule.SetAttr("srcs", srcs)
 		}
+		setVisibilityIfNeeded(newRule, args.File, conf.defaultVisibility)
 		result.Gen = append(result.Gen, newRule)
 		result.Imports = append(result.Imports, extractImports(args.Rel, group.sources))
 	}
@@ -373,7 +786,9 @@ func (c *ccLanguage) generateTestRules(args language.GenerateArgs, fileInfos []f
 
 		// Deal with rules that conflict with existing defintions
 		if ruleNames := ambigiousRuleAssignments[groupId]; len(ruleNames) > 0 {
-			if !c.handleAmbigiousRulesAssignment(args, conf, rulesInfo, newRule, result, *group, ruleNames) {
+			if handled, err := c.handleAmbigiousRulesAssignment(args, conf, rulesInfo, newRule, result, *group, ruleNames); err != nil {
+				return err
+			} else if !handled {
 				continue // Failed to handle issue, skip this group. New rule could have been modified
 			}
 		}
@@ -383,9 +798,25 @@ func (c *ccLanguage) generateTestRules(args language.GenerateArgs, fileInfos []f
 		if testRunnerRuleName != label.NoLabel {
 			newRule.SetPrivateAttr(ccTestRunnerDepKey, testRunnerRuleName)
 		}
+		if size := conf.testSize.forSources(group.sources); size != "" {
+			newRule.SetAttr("size", string(size))
+		}
+		setVisibilityIfNeeded(newRule, args.File, conf.defaultVisibility)
 		result.Gen = append(result.Gen, newRule)
 		result.Imports = append(result.Imports, extractImports(args.Rel, group.sources))
 	}
+	return nil
+}
+
+// Returns the size of cc_test rule defined by given sources, infers it based on number of sources if requested
+func (size testSize) forSources(srcs []fileInfo) testSize {
+	if size != testSize_infer {
+		return size
+	}
+	if len(srcs) == 1 {
+		return testSize_small
+	}
+	return testSize_medium
 }
 
 // Collects files that can be used to generate CC rules based on local context.
@@ -406,31 +837,39 @@ func (c *ccLanguage) collectFileInfos(args language.GenerateArgs) []fileInfo {
 		fileInfos = append(fileInfos, fi)
 	}
 
-	for _, name := range args.RegularFiles {
+	// Links escaping the repository are ignored, see symlinks.go
+	entries := followSymlinks(args.Config.RepoRoot, args.Rel, args.RegularFiles, args.Subdirs)
+	for _, name := range entries.regularFiles {
 		addFile(name, noSubdir)
 	}
 
 	if conf.groupingMode == groupSourcesBySubdirectory {
 		// TODO(#73): recursively collect files from subdirectories that don't have
 		// build files. For now, we only consider immediate subdirectories.
-		for _, subdir := range args.Subdirs {
+		addSubdir := func(subdir string, linked bool) {
 			subdirKind, err := checkSubdirKind(conf, c.buildFileDirRels, args.Rel, subdir)
 			if err != nil {
 				log.Printf("gazelle_cc: %v", err)
-				continue
+				return
 			}
 			if subdirKind == noSubdir {
-				continue
+				return
 			}
-			di, err := walk.GetDirInfo(path.Join(args.Rel, subdir))
+			di, err := listDir(args.Config.RepoRoot, path.Join(args.Rel, subdir), linked)
 			if err != nil {
 				log.Printf("gazelle_cc: %v", err)
-				continue
+				return
 			}
-			for _, name := range di.RegularFiles {
+			for _, name := range di.regularFiles {
 				addFile(path.Join(subdir, name), subdirKind)
 			}
 		}
+		for _, subdir := range entries.subdirs {
+			addSubdir(subdir, false)
+		}
+		for _, subdir := range entries.linkedDirs {
+			addSubdir(subdir, true)
+		}
 	}
 
 	return fileInfos
@@ -440,14 +879,20 @@ func (c *ccLanguage) collectFileInfos(args language.GenerateArgs) []fileInfo {
 // * merges with or renames group if all of it sources were previously assigned to existing rule
 // Returns ambigiousRuleAssignments defining a list of groupIds leading to ambigious assignment under the new state -
 // it typically happens when previously independant rules are now creating a cycle
-func (srcGroups *sourceGroups) adjustToExistingRules(rulesInfo rulesInfo) (ambigiousRuleAssignments map[groupId][]string) {
+func (srcGroups *sourceGroups) adjustToExistingRules(args language.GenerateArgs, rulesInfo rulesInfo) (ambigiousRuleAssignments map[groupId][]string) {
 	ambigiousRuleAssignments = make(map[groupId][]string)
+	if conf := getCcConfig(args.Config); conf.preserveRuleNames {
+		// Existing rules are kept as they are, there is nothing ambigious to resolve
+		stripIncludePrefix, includePrefix := rulesInfo.includePrefixes(args, "")
+		srcGroups.preserveExistingRules(args.Rel, stripIncludePrefix, includePrefix, rulesInfo.sourceAssignment)
+		return ambigiousRuleAssignments
+	}
 	// Dictionary of groups that previously were assignled to multiple rules
 	for id, group := range *srcGroups {
 		// Collect info about previous assignment of sources to rules creating this group
 		assignedToRules := make(map[string]bool)
 		for _, src := range group.sources {
-			if ruleName, ok := rulesInfo.groupAssignment[fileNameToGroupId(src.name)]; ok {
+			if ruleName, ok := rulesInfo.sourceAssignment[src.name]; ok {
 				assignedToRules[ruleName] = true
 			}
 		}
@@ -471,6 +916,7 @@ func (srcGroups *sourceGroups) adjustToExistingRules(rulesInfo rulesInfo) (ambig
 // Resolve conflicts when resolved sourceGroups do conflict with existing rule definitions.
 // It mostly deals with problems when sources creating a cyclic dependency are defined in multiple existing rules:
 // * if allowRulesMerge merges all rules refering to this group sources into a single rule
+// * if sources were extracted into a shared group under `cc_group unit` keeps existing rules and creates the shared rule
 // * otherwise warns user about cyclic deps and sets cyclic deps attributes to newRule and returns false
 // Returns true if successfully handled issues and it's possible to finalize creation of newRule
 func (c *ccLanguage) handleAmbigiousRulesAssignment(
@@ -480,10 +926,18 @@ func (c *ccLanguage) handleAmbigiousRulesAssignment(
 	newRule *rule.Rule,
 	result *language.GenerateResult,
 	group sourceGroup,
-	ambigiousRuleAssignments []string) (handled bool) {
+	ambigiousRuleAssignments []string) (handled bool, err error) {
+
+	if conf.groupsCycleHandlingMode == sharedLibOnGroupsCycle && conf.groupingMode == groupSourcesByUnit {
+		// Sources forming a cycle were extracted to a shared group, existing rules would keep their remaining sources
+		log.Printf("Rules %v defined in %v create a cyclic dependency, their sources %v would be extracted into a shared rule '%v'",
+			slices.Sorted(slices.Values(ambigiousRuleAssignments)), args.Dir, slices.Sorted(slices.Values(toRelativePaths(group.sources))), newRule.Name(),
+		)
+		return true, nil
+	}
 
 	switch conf.groupsCycleHandlingMode {
-	case mergeOnGroupsCycle:
+	case mergeOnGroupsCycle, sharedLibOnGroupsCycle:
 		// Merge rules creating a cyclic dependency into a single rule and remove old ones
 		var mergeReason string
 		switch conf.groupingMode {
@@ -492,7 +946,7 @@ func (c *ccLanguage) handleAmbigiousRulesAssignment(
 		case groupSourcesByUnit:
 			mergeReason = "create a cyclic dependency"
 		default:
-			log.Panicf("Unexpected groupingMode: %v", conf.groupingMode)
+			return false, fmt.Errorf("unexpected grouping mode: %v", conf.groupingMode)
 		}
 		log.Printf("Rules %v defined in %v %v, their sources %v would be merged into a single rule '%v'. "+
 			"To prevent automatic merging of rules set `# gazelle:%v %v`",
@@ -503,14 +957,14 @@ func (c *ccLanguage) handleAmbigiousRulesAssignment(
 			referedRule := rulesInfo.definedRules[referedRuleName]
 			if err := rule.SquashRules(referedRule, newRule, args.File.Path); err != nil {
 				log.Printf("Failed to join rules %v and %v defining a cyclic dependency: %v", referedRuleName, newRule.Name(), err)
-				return false // Skip processing these groups, keep existing rules unchanged
+				return false, nil // Skip processing these groups, keep existing rules unchanged
 			}
 			// Remove no longer exisitng rules
 			if referedRuleName != newRule.Name() && slices.Contains(group.subGroups, groupId(newRule.Name())) {
 				result.Empty = append(result.Empty, rule.NewRule(referedRule.Kind(), referedRule.Name()))
 			}
 		}
-		return true
+		return true, nil
 	case warnOnGroupsCycle:
 		// Merging was disabled by user, don't edit existing rules
 		slices.Sort(ambigiousRuleAssignments) // for deterministic output
@@ -538,10 +992,9 @@ func (c *ccLanguage) handleAmbigiousRulesAssignment(
 			}
 			result.Imports = append(result.Imports, extractImports(args.Rel, ruleSources))
 		}
-		return false // Skip processing these groups, keep existing rules unchanged
+		return false, nil // Skip processing these groups, keep existing rules unchanged
 	default:
-		log.Panicf("Unknown group cycle handling mode: %v", conf.groupsCycleHandlingMode)
-		return false
+		return false, fmt.Errorf("unknown group cycle handling mode: %v", conf.groupsCycleHandlingMode)
 	}
 }
 
@@ -566,6 +1019,11 @@ func (c *ccLanguage) findEmptyRules(args language.GenerateArgs, fileInfos []file
 			// proto_library was removed (via args.OtherEmpty)
 			continue
 		}
+		if resolveCCRuleKind(r.Kind(), args.Config) == "cc_import" {
+			// Prebuilt libraries are never generated, their headers and
+			// binaries are maintained by the user.
+			continue
+		}
 
 		// Preserve the rule if at least one of its file still exists, even if
 		// that file is also assigned to another rule.
@@ -613,22 +1071,41 @@ type rulesInfo struct {
 	definedRules map[string]*rule.Rule
 	// Sources previously assigned to cc rules, key is the existing name of the rule
 	ccRuleSources map[string]collections.Set[string]
-	// Mapping between groupId created from file name and existing rule name to which it was previously assigned
-	groupAssignment map[groupId]string
+	// Mapping between source file name and existing rule name to which it was previously assigned
+	sourceAssignment map[string]string
+	// Headers exposed by existing cc_import rules
+	importedHeaders collections.Set[string]
 	// Set of generated file names
-	genFiles 	 collections.Set[string]
+	genFiles collections.Set[string]
+	// Mapping between existing platform variants of libraries and the name of the facade selecting them
+	platformVariants map[string]string
+	// Files listed in srcs of existing filegroup rules, key is the name of the filegroup
+	filegroups map[string][]string
 }
 
 func extractRulesInfo(args language.GenerateArgs) rulesInfo {
 	info := rulesInfo{
-		definedRules:    make(map[string]*rule.Rule),
-		ccRuleSources:   make(map[string]collections.Set[string]),
-		groupAssignment: make(map[groupId]string),
-		genFiles:        collections.ToSet(args.GenFiles),
+		definedRules:     make(map[string]*rule.Rule),
+		ccRuleSources:    make(map[string]collections.Set[string]),
+		sourceAssignment: make(map[string]string),
+		importedHeaders:  make(collections.Set[string]),
+		genFiles:         collections.ToSet(args.GenFiles),
+		platformVariants: make(map[string]string),
+		filegroups:       make(map[string][]string),
 	}
 	if args.File == nil {
 		return info
 	}
+	// Filegroups might be defined after the rules referencing them in sources
+	for _, rule := range args.File.Rules {
+		if rule.Kind() == "filegroup" {
+			srcs, err := readListOrGlob(args.Config, rule, args.Rel, "srcs")
+			if err != nil {
+				log.Printf("gazelle_cc: failed to read srcs of rule %v in %v: %v", rule.Name(), args.File.Path, err)
+			}
+			info.filegroups[rule.Name()] = srcs
+		}
+	}
 	for _, rule := range args.File.Rules {
 		ruleName := rule.Name()
 		info.definedRules[ruleName] = rule
@@ -638,22 +1115,92 @@ func extractRulesInfo(args language.GenerateArgs) rulesInfo {
 					info.ccRuleSources[ruleName] = make(collections.Set[string])
 				}
 				info.ccRuleSources[ruleName].Add(filename)
-				info.groupAssignment[fileNameToGroupId(filename)] = ruleName
+				info.sourceAssignment[filename] = ruleName
 			}
 		}
+		// Sources might be defined using glob() or local filegroups, expand them to find the assigned files
+		ruleSources := func(attrName string) []string {
+			srcs, err := readListOrGlob(args.Config, rule, args.Rel, attrName)
+			if err != nil {
+				log.Printf("gazelle_cc: failed to read %v of rule %v in %v: %v", attrName, ruleName, args.File.Path, err)
+			}
+			return info.expandFilegroups(srcs, args.Rel)
+		}
 		switch resolveCCRuleKind(rule.Kind(), args.Config) {
 		case "cc_library":
-			assignSources(rule.AttrStrings("srcs"))
-			assignSources(rule.AttrStrings("hdrs"))
+			assignSources(ruleSources("srcs"))
+			assignSources(ruleSources("hdrs"))
 		case "cc_binary":
-			assignSources(rule.AttrStrings("srcs"))
+			assignSources(ruleSources("srcs"))
 		case "cc_test":
-			assignSources(rule.AttrStrings("srcs"))
+			assignSources(ruleSources("srcs"))
+		case "cc_import":
+			info.importedHeaders.AddSlice(ruleSources("hdrs"))
+		case platformVariantsFacadeKind:
+			// Other aliases are ignored by platformVariantsOf
+			if variants, ok := platformVariantsOf(rule); ok {
+				for _, variant := range variants {
+					info.platformVariants[variant] = ruleName
+				}
+			}
+		}
+	}
+	// Sources of platform variants are owned by the library they're replacing
+	for filename, ruleName := range info.sourceAssignment {
+		if facade, ok := info.platformVariants[ruleName]; ok {
+			info.sourceAssignment[filename] = facade
+		}
+	}
+	if getCcConfig(args.Config).shardCount > 0 {
+		// Sources of shards are owned by their facade library
+		for filename, ruleName := range info.sourceAssignment {
+			if facade, ok := shardFacadeName(ruleName); ok && info.definedRules[facade] != nil {
+				info.sourceAssignment[filename] = facade
+			}
 		}
 	}
 	return info
 }
 
+// Replaces the labels of filegroups defined in the package, e.g. srcs = [":sources"],
+// with the files they contain, other entries are kept as they are. Filegroups
+// referencing other local filegroups are expanded recursively.
+func (info *rulesInfo) expandFilegroups(srcs []string, pkg string) []string {
+	var expand func(srcs []string, visited collections.Set[string]) []string
+	expand = func(srcs []string, visited collections.Set[string]) []string {
+		var result []string
+		for _, src := range srcs {
+			name, ok := localFilegroupName(src, pkg)
+			files, exists := info.filegroups[name]
+			if !ok || !exists {
+				result = append(result, src)
+				continue
+			}
+			if visited.Contains(name) {
+				continue
+			}
+			visited.Add(name)
+			result = append(result, expand(files, visited)...)
+		}
+		return result
+	}
+	return expand(srcs, make(collections.Set[string]))
+}
+
+// Returns the name of the rule if the source is a label of a target in the
+// given package, e.g. ":sources" or "//pkg:sources". Plain file names are
+// not treated as labels.
+func localFilegroupName(src, pkg string) (string, bool) {
+	if !strings.HasPrefix(src, ":") && !strings.HasPrefix(src, "//") {
+		return "", false
+	}
+	lbl, err := label.Parse(src)
+	if err != nil || !(lbl.Relative || lbl.Repo == "" && lbl.Pkg == pkg) {
+		return "", false
+	}
+	return lbl.Name, true
+}
+
 func resolveCCRuleKind(kind string, config *config.Config) string {
 	if target, ok := config.AliasMap[kind]; ok {
 		return target
@@ -669,7 +1216,11 @@ func resolveCCRuleKind(kind string, config *config.Config) string {
 // Return list of existing rules of kind or with matching kind mapping
 func (info *rulesInfo) existingRulesOfKind(kind string, c *config.Config) []*rule.Rule {
 	rules := make([]*rule.Rule, 0, len(info.ccRuleSources))
-	for _, rule := range info.definedRules {
+	for name, rule := range info.definedRules {
+		if _, isVariant := info.platformVariants[name]; isVariant {
+			// Variants are matched together with their facade
+			continue
+		}
 		if resolveCCRuleKind(rule.Kind(), c) == kind {
 			rules = append(rules, rule)
 		}
@@ -695,6 +1246,36 @@ func (info *rulesInfo) matchExistingRule(kind, name string, srcGroups sourceGrou
 	return nil
 }
 
+// includePrefixes returns the values of strip_include_prefix and include_prefix
+// attributes of the cc_library rule with the given name, also used to resolve
+// includes between sources of the directory when ruleName is empty.
+//
+// These are defined using cc_strip_include_prefix and cc_include_prefix
+// directives. Under cc_preserve_include_prefix, when neither is set, the values
+// of the existing rule with the given name are used instead. For new rules and
+// for resolving includes, values shared by all existing cc_library rules are used.
+func (info *rulesInfo) includePrefixes(args language.GenerateArgs, ruleName string) (stripIncludePrefix, includePrefix string) {
+	conf := getCcConfig(args.Config)
+	if !conf.preserveIncludePrefix || conf.ccStripIncludePrefix != "" || conf.ccIncludePrefix != "" {
+		return conf.ccStripIncludePrefix, conf.ccIncludePrefix
+	}
+	existingRules := info.existingRulesOfKind("cc_library", args.Config)
+	for _, r := range existingRules {
+		if r.Name() == ruleName {
+			return r.AttrString("strip_include_prefix"), r.AttrString("include_prefix")
+		}
+	}
+	for i, r := range existingRules {
+		strip, include := r.AttrString("strip_include_prefix"), r.AttrString("include_prefix")
+		if i > 0 && (strip != stripIncludePrefix || include != includePrefix) {
+			// Existing rules disagree, values are preserved only in their own rules
+			return "", ""
+		}
+		stripIncludePrefix, includePrefix = strip, include
+	}
+	return stripIncludePrefix, includePrefix
+}
+
 // genFilesInRule returns the list of generated files and headers in the given rule.
 //
 // The returned lists are new, and can be mutated.
@@ -716,6 +1297,77 @@ func (info *rulesInfo) genFilesInRule(rule *rule.Rule) (genSrcs, genHdrs []strin
 	return genSrcs, genHdrs
 }
 
+// setSourcesAttr sets the list of sources in the given attribute of the rule.
+// If the existing rule defines this attribute using glob() or references local
+// filegroups it would be preserved as long as it matches exactly the same
+// files, otherwise it would be replaced with the explicit list of sources.
+func (info *rulesInfo) setSourcesAttr(args language.GenerateArgs, rule *rule.Rule, attrName string, srcs []string) {
+	if existingRule, ok := info.definedRules[rule.Name()]; ok && existingRule.Kind() == rule.Kind() {
+		if existing := existingRule.AttrStrings(attrName); slices.ContainsFunc(existing, info.isFilegroupRef(args.Rel)) {
+			rule.SetAttr(attrName, globOrSources{
+				sources:     srcs,
+				globMatches: maps.Equal(collections.ToSet(info.expandFilegroups(existing, args.Rel)), collections.ToSet(srcs)),
+			})
+			return
+		}
+		if glob, ok := ruleGlobAttr(existingRule, attrName); ok {
+			matched, err := expandGlob(args.Config, args.Rel, glob)
+			if err == nil {
+				rule.SetAttr(attrName, globOrSources{
+					sources:     srcs,
+					globMatches: maps.Equal(collections.ToSet(matched), collections.ToSet(srcs)),
+				})
+				return
+			}
+		}
+	}
+	if len(srcs) > 0 {
+		rule.SetAttr(attrName, srcs)
+	}
+}
+
+// Returns a predicate checking if the source is a label of a filegroup defined in the package
+func (info *rulesInfo) isFilegroupRef(pkg string) func(src string) bool {
+	return func(src string) bool {
+		name, ok := localFilegroupName(src, pkg)
+		_, exists := info.filegroups[name]
+		return ok && exists
+	}
+}
+
+func ruleGlobAttr(r *rule.Rule, attrName string) (rule.GlobValue, bool) {
+	expr := r.Attr(attrName)
+	if expr == nil {
+		return rule.GlobValue{}, false
+	}
+	return rule.ParseGlobExpr(expr)
+}
+
+// globOrSources is a value of sources attribute merged with existing glob()
+// expression or list referencing filegroups. The existing expression is kept
+// if it still matches the sources.
+type globOrSources struct {
+	sources     []string
+	globMatches bool
+}
+
+var _ rule.BzlExprValue = globOrSources{}
+var _ rule.Merger = globOrSources{}
+
+func (g globOrSources) BzlExpr() bzl.Expr {
+	return rule.ExprFromValue(g.sources)
+}
+
+func (g globOrSources) Merge(other bzl.Expr) bzl.Expr {
+	if g.globMatches {
+		return other
+	}
+	if len(g.sources) == 0 {
+		return nil
+	}
+	return g.BzlExpr()
+}
+
 func hasRuleWithName(name string, rules []*rule.Rule) bool {
 	return slices.ContainsFunc(rules, func(rule *rule.Rule) bool {
 		return rule.Name() == name
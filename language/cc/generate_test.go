@@ -0,0 +1,270 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"flag"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/merger"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/bazelbuild/bazel-gazelle/walk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Fixtures from testdata which are not checked by TestGenerateRulesIdempotency,
+// with the reason why running Gazelle on their output changes it.
+var nonIdempotentFixtures = map[string]string{
+	"rules_with_no_sources": "header in srcs of an existing rule is added to srcs of the merged rule only in the first run",
+}
+
+func TestGenerateRulesIdempotency(t *testing.T) {
+	entries, err := os.ReadDir("testdata")
+	require.NoError(t, err)
+	for _, entry := range entries {
+		fixture := entry.Name()
+		if !isGoldenFixture(filepath.Join("testdata", fixture)) {
+			continue
+		}
+		t.Run(fixture, func(t *testing.T) {
+			if reason, ok := nonIdempotentFixtures[fixture]; ok {
+				t.Skip(reason)
+			}
+			repoRoot := t.TempDir()
+			copyFixture(t, filepath.Join("testdata", fixture), repoRoot)
+
+			firstRun := runGenerationForTest(t, repoRoot)
+			secondRun := runGenerationForTest(t, repoRoot)
+			assert.Equal(t, firstRun, secondRun, "second run should not change any build file")
+		})
+	}
+}
+
+// Returns true for directories of golden tests, containing the root module file.
+func isGoldenFixture(dir string) bool {
+	for _, name := range []string{"MODULE.bazel", "WORKSPACE"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Copies the golden test directory using its BUILD.in files as the initial build files.
+func copyFixture(t *testing.T, src, dst string) {
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		switch filepath.Base(rel) {
+		case "BUILD.out", "README.md", "expectedStderr.txt", "expectedStdout.txt":
+			return nil
+		case "BUILD.in":
+			rel = filepath.Join(filepath.Dir(rel), "BUILD.bazel")
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0o644)
+	})
+	require.NoError(t, err)
+}
+
+// Runs rules generation and dependency resolution on the whole repository the
+// same way as 'gazelle update' does, using only this extension. Returns the
+// content of each build file after it was written, keyed by its path relative
+// to the repository root.
+func runGenerationForTest(t *testing.T, repoRoot string) map[string]string {
+	return runGenerationWithLanguage(t, NewLanguage().(*ccLanguage), repoRoot)
+}
+
+// Like runGenerationForTest, using the given extension configured with the additional flags.
+func runGenerationWithLanguage(t *testing.T, lang *ccLanguage, repoRoot string, args ...string) map[string]string {
+	kinds := lang.Kinds()
+	cexts := []config.Configurer{&config.CommonConfigurer{}, &walk.Configurer{}, &resolve.Configurer{}, lang}
+
+	c := config.New()
+	flags := flag.NewFlagSet("gazelle", flag.ContinueOnError)
+	for _, cext := range cexts {
+		cext.RegisterFlags(flags, "update", c)
+	}
+	require.NoError(t, flags.Parse(append([]string{"-repo_root=" + repoRoot}, args...)))
+	for _, cext := range cexts {
+		require.NoError(t, cext.CheckFlags(flags, c))
+	}
+	if c.ModuleToApparentName == nil {
+		c.ModuleToApparentName = func(string) string { return "" }
+	}
+
+	type visit struct {
+		c       *config.Config
+		rel     string
+		file    *rule.File
+		rules   []*rule.Rule
+		imports []any
+		empty   []*rule.Rule
+	}
+	var visits []visit
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, pkgRel string) resolve.Resolver {
+		if _, ok := kinds[r.Kind()]; ok {
+			return lang
+		}
+		return nil
+	})
+	walk.Walk(c, cexts, []string{repoRoot}, walk.VisitAllUpdateSubdirsMode, func(dir, rel string, c *config.Config, update bool, f *rule.File, subdirs, regularFiles, genFiles []string) {
+		if f != nil {
+			lang.Fix(c, f)
+		}
+		result := lang.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          dir,
+			Rel:          rel,
+			File:         f,
+			Subdirs:      subdirs,
+			RegularFiles: regularFiles,
+			GenFiles:     genFiles,
+		})
+		if f == nil {
+			if len(result.Gen) == 0 {
+				return
+			}
+			f = rule.EmptyFile(filepath.Join(dir, "BUILD.bazel"), rel)
+		}
+		merger.MergeFile(f, result.Empty, result.Gen, merger.PreResolve, kinds, c.AliasMap)
+		for _, r := range f.Rules {
+			ix.AddRule(c, r, f)
+		}
+		visits = append(visits, visit{c: c, rel: rel, file: f, rules: result.Gen, imports: result.Imports, empty: result.Empty})
+	})
+	ix.Finish()
+
+	buildFiles := make(map[string]string)
+	for _, v := range visits {
+		for i, r := range v.rules {
+			lang.Resolve(v.c, ix, nil, r, v.imports[i], label.New(v.c.RepoName, v.rel, r.Name()))
+		}
+		merger.MergeFile(v.file, v.empty, v.rules, merger.PostResolve, kinds, v.c.AliasMap)
+		merger.FixLoads(v.file, lang.ApparentLoads(v.c.ModuleToApparentName))
+		content := v.file.Format()
+		// In verify mode gazelle exits before writing the build files if any of them is out of date
+		if !lang.verify {
+			require.NoError(t, os.WriteFile(v.file.Path, content, 0o644))
+		}
+
+		rel, err := filepath.Rel(repoRoot, v.file.Path)
+		require.NoError(t, err)
+		buildFiles[filepath.ToSlash(rel)] = string(content)
+	}
+	require.NotEmpty(t, buildFiles)
+	return buildFiles
+}
+
+func TestExpandFilegroups(t *testing.T) {
+	info := rulesInfo{filegroups: map[string][]string{
+		"sources": {"a.cc", "b.cc"},
+		"all":     {":sources", "c.cc"},
+		"cycle":   {":cycle", "d.cc"},
+	}}
+	assert.Equal(t, []string{"a.cc", "b.cc", "main.cc"}, info.expandFilegroups([]string{":sources", "main.cc"}, "pkg"))
+	assert.Equal(t, []string{"a.cc", "b.cc", "c.cc"}, info.expandFilegroups([]string{"//pkg:all"}, "pkg"))
+	assert.Equal(t, []string{"d.cc"}, info.expandFilegroups([]string{":cycle"}, "pkg"))
+	// Targets of other packages and unknown rules are not expanded
+	assert.Equal(t, []string{"//other:sources", ":unknown", "sources"}, info.expandFilegroups([]string{"//other:sources", ":unknown", "sources"}, "pkg"))
+}
+
+func TestSymlinkedSources(t *testing.T) {
+	repoRoot := t.TempDir()
+	outside := t.TempDir()
+	writeFile := func(path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	writeFile(filepath.Join(repoRoot, "MODULE.bazel"), `module(name = "test")`)
+	writeFile(filepath.Join(repoRoot, "BUILD.bazel"), "# gazelle:cc_group subdirectory\n")
+	writeFile(filepath.Join(repoRoot, "third_party", "BUILD.bazel"), "# gazelle:cc_generate false\n")
+	writeFile(filepath.Join(repoRoot, "third_party", "vendor", "vendor.h"), "int vendor();\n")
+	writeFile(filepath.Join(outside, "outside.h"), "int outside();\n")
+	writeFile(filepath.Join(repoRoot, "lib", "BUILD.bazel"), `
+cc_library(
+    name = "lib",
+    srcs = ["lib.cc"],
+    hdrs = glob(["include/*.h"]),
+)
+`)
+	writeFile(filepath.Join(repoRoot, "lib", "lib.cc"), "#include \"lib/include/vendor.h\"\n")
+	writeFile(filepath.Join(repoRoot, "app", "main.cc"), "#include \"lib/include/vendor.h\"\n\nint main() { return vendor(); }\n")
+	// Directory of vendored headers linked within the repository, and a header outside of it
+	require.NoError(t, os.Symlink(filepath.Join("..", "third_party", "vendor"), filepath.Join(repoRoot, "lib", "include")))
+	require.NoError(t, os.Symlink(filepath.Join(outside, "outside.h"), filepath.Join(repoRoot, "lib", "outside.h")))
+
+	buildFiles := runGenerationForTest(t, repoRoot)
+	// Headers of the linked directory match the existing glob, so it's kept
+	assert.Contains(t, buildFiles["lib/BUILD.bazel"], `hdrs = glob(["include/*.h"])`)
+	assert.NotContains(t, buildFiles["lib/BUILD.bazel"], "outside.h")
+	assert.Contains(t, buildFiles["app/BUILD.bazel"], `deps = ["//lib"]`)
+}
+
+func TestVerifyBuildFiles(t *testing.T) {
+	repoRoot := t.TempDir()
+	copyFixture(t, filepath.Join("testdata", "glob_srcs"), repoRoot)
+	buildFilePath := filepath.Join(repoRoot, "BUILD.bazel")
+	original, err := os.ReadFile(buildFilePath)
+	require.NoError(t, err)
+
+	// The existing build file does not define the binary yet, it's reported but never written
+	lang := NewLanguage().(*ccLanguage)
+	runGenerationWithLanguage(t, lang, repoRoot, "-cc_verify")
+	outdated := lang.findOutdatedBuildFiles()
+	require.Len(t, outdated, 1)
+	assert.Equal(t, "BUILD.bazel", outdated[0].path)
+	assert.Contains(t, outdated[0].diff, "--- BUILD.bazel\n+++ BUILD.bazel\n")
+	assert.Contains(t, outdated[0].diff, "+cc_binary(\n")
+	content, err := os.ReadFile(buildFilePath)
+	require.NoError(t, err)
+	assert.Equal(t, string(original), string(content))
+
+	// Build files written by the regular run are up to date
+	runGenerationWithLanguage(t, NewLanguage().(*ccLanguage), repoRoot)
+	lang = NewLanguage().(*ccLanguage)
+	runGenerationWithLanguage(t, lang, repoRoot, "-cc_verify")
+	assert.Empty(t, lang.findOutdatedBuildFiles())
+
+	// New build files are reported without a diff, and never created
+	require.NoError(t, os.MkdirAll(filepath.Join(repoRoot, "tools"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(repoRoot, "tools", "tool.cc"), []byte("int main() { return 0; }\n"), 0o644))
+	lang = NewLanguage().(*ccLanguage)
+	runGenerationWithLanguage(t, lang, repoRoot, "-cc_verify")
+	outdated = lang.findOutdatedBuildFiles()
+	require.Len(t, outdated, 1)
+	assert.Equal(t, "tools/BUILD.bazel", outdated[0].path)
+	assert.NoFileExists(t, filepath.Join(repoRoot, "tools", "BUILD.bazel"))
+}
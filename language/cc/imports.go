@@ -25,23 +25,62 @@ import (
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/label"
 	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
-	"github.com/bazelbuild/bazel-gazelle/walk"
 	"github.com/bmatcuk/doublestar/v4"
 )
 
 // resolve.Resolver method
-func (*ccLanguage) Imports(config *config.Config, rule *rule.Rule, buildFile *rule.File) []resolve.ImportSpec {
+func (lang *ccLanguage) Imports(config *config.Config, rule *rule.Rule, buildFile *rule.File) []resolve.ImportSpec {
+	if visibility, ok := ruleVisibility(rule, buildFile); ok {
+		lang.indexedRulesVisibility[label.New(config.RepoName, buildFile.Pkg, rule.Name())] = visibility
+	}
+	var imports []resolve.ImportSpec
 	switch rule.Kind() {
 	case "cc_proto_library", "cc_grpc_library":
-		return generateProtoImportSpecs(rule, buildFile)
+		imports = generateProtoImportSpecs(rule, buildFile)
 	case "cc_import", "cc_library", "cc_shared_library", "cc_static_library":
-		return generateLibraryImportSpecs(config, rule, buildFile.Pkg)
-	default:
-		return nil
+		imports = generateLibraryImportSpecs(config, rule, buildFile.Pkg)
+		if facade, ok := platformVariantFacade(rule, buildFile); ok {
+			lang.platformVariantFacades[label.New(config.RepoName, buildFile.Pkg, rule.Name())] = label.New(config.RepoName, buildFile.Pkg, facade)
+		}
+		if glob, ok := ruleGlobAttr(rule, "hdrs"); ok && len(glob.Patterns) > 0 {
+			lang.globHeaderLibraries[buildFile.Pkg] = append(lang.globHeaderLibraries[buildFile.Pkg], globHeaderLibrary{
+				label: label.New(config.RepoName, buildFile.Pkg, rule.Name()),
+				glob:  glob,
+			})
+		}
+	}
+	for _, imp := range imports {
+		folded := strings.ToLower(imp.Imp)
+		if lang.caseFoldedImports[folded] == nil {
+			lang.caseFoldedImports[folded] = make(collections.Set[string])
+		}
+		lang.caseFoldedImports[folded].Add(imp.Imp)
+	}
+	return imports
+}
+
+// Library defining its headers using glob() in the hdrs attribute
+type globHeaderLibrary struct {
+	label label.Label
+	glob  rule.GlobValue
+}
+
+// Returns true if the header path, relative to the package of the library, is
+// matched by any of the glob patterns and none of the excludes. Unlike the
+// expanded glob it matches also headers that are not present in the
+// repository when running gazelle, e.g. generated ones.
+func (lib globHeaderLibrary) owns(header string) bool {
+	matches := func(patterns []string) bool {
+		return slices.ContainsFunc(patterns, func(pattern string) bool {
+			matched, err := doublestar.Match(pattern, header)
+			return err == nil && matched
+		})
 	}
+	return matches(lib.glob.Patterns) && !matches(lib.glob.Excludes)
 }
 
 func generateLibraryImportSpecs(config *config.Config, rule *rule.Rule, pkg string) []resolve.ImportSpec {
@@ -85,8 +124,9 @@ func appendHeaderImportSpecs(imports []resolve.ImportSpec, header, pkg string, a
 			relativeTo = pkg
 		}
 		// Ensure the prefix ends with path separator to distinguish include=foo hdrs=[foo.h, foo/bar.h]
-		// It was already cleaned so there won't be duplicate path seperators here
-		relativeTo = relativeTo + string(filepath.Separator)
+		// It was already cleaned so there won't be duplicate path seperators here. Header paths always use
+		// slashes, OS specific separator would never match on Windows.
+		relativeTo = relativeTo + "/"
 		relativePath, matching := strings.CutPrefix(fullyQualifiedPath, relativeTo)
 		if !matching {
 			// If the include directory is not relative to canonical form it's would be simply ignored.
@@ -189,15 +229,20 @@ func readListOrGlob(config *config.Config, r *rule.Rule, pkg, attrName string) (
 	if globValue, ok := rule.ParseGlobExpr(expr); ok {
 		return expandGlob(config, pkg, globValue)
 	}
+	// Sources selected on some platforms only, e.g. Windows resource scripts
+	if platformStrings, err := parseCcPlatformStringsExprs(expr); err == nil {
+		return platformStrings.values(), nil
+	}
 	return nil, nil
 }
 
 // expandGlob expands the glob patterns in the given glob value relative to
 // relPath. It returns a sorted list of paths that match the patterns, excluding
 // those that match the excludes. The paths are relative to relPath, and they
-// are sorted in lexicographical order. It does not use I/O, it uses cached
-// directory info obtained from walk.GetDirInfo so it might panic if the
-// directory was not walked before.
+// are sorted in lexicographical order. It uses cached directory info obtained
+// from walk.GetDirInfo so it might panic if the directory was not walked
+// before. Symbolic links are followed only within the repository, linked
+// directories not walked by Gazelle are read from the file system.
 func expandGlob(config *config.Config, pkg string, glob rule.GlobValue) ([]string, error) {
 	if len(glob.Patterns) == 0 {
 		return nil, nil
@@ -221,15 +266,23 @@ func expandGlob(config *config.Config, pkg string, glob rule.GlobValue) ([]strin
 
 	// Traverse the file tree using walk.GetDirInfo and collect all matching files
 	var matched []string
-	var traverse func(string)
-	traverse = func(current_subdir string) {
-		di, err := walk.GetDirInfo(current_subdir)
+	// Resolved paths of traversed directories, links might create cycles
+	visited := make(collections.Set[string])
+	var traverse func(string, bool)
+	traverse = func(current_subdir string, linked bool) {
+		if resolved, ok := resolveWithinRepo(config.RepoRoot, filepath.Join(config.RepoRoot, current_subdir)); ok {
+			if visited.Contains(resolved) {
+				return
+			}
+			visited.Add(resolved)
+		}
+		di, err := listDir(config.RepoRoot, current_subdir, linked)
 		if err != nil {
 			return // swallow errors
 		}
 
 		// When walking the subdirectories, we need to exclude dirs containing BUILD files
-		if current_subdir != pkg && slices.ContainsFunc(di.RegularFiles, config.IsValidBuildFileName) {
+		if current_subdir != pkg && slices.ContainsFunc(di.regularFiles, config.IsValidBuildFileName) {
 			return // BUILD file found, stop walking
 		}
 
@@ -245,17 +298,20 @@ func expandGlob(config *config.Config, pkg string, glob rule.GlobValue) ([]strin
 			return
 		}
 
-		matched = slices.Grow(matched, len(di.RegularFiles))
-		matched = slices.AppendSeq(matched, collections.FilterMapSeq(slices.Values(di.RegularFiles), fileMatcher))
+		matched = slices.Grow(matched, len(di.regularFiles))
+		matched = slices.AppendSeq(matched, collections.FilterMapSeq(slices.Values(di.regularFiles), fileMatcher))
 
 		// Traverse the subdirectories
-		for _, subdir := range di.Subdirs {
-			traverse(filepath.Join(current_subdir, subdir))
+		for _, subdir := range di.subdirs {
+			traverse(filepath.Join(current_subdir, subdir), linked)
+		}
+		for _, subdir := range di.linkedDirs {
+			traverse(filepath.Join(current_subdir, subdir), true)
 		}
 	}
 
 	// Start traversal from the package directory
-	traverse(pkg)
+	traverse(pkg, false)
 
 	sort.Strings(matched)
 	return matched, nil
@@ -39,15 +39,27 @@ const (
 	languageName       = "cc"
 	ccTestRunnerDepKey = "_test_runner"
 	ccExistingDepsKey  = "_existing_deps"
+	ccShardsKey        = "_shards"
+	ccShardFacadeKey   = "_shard_facade"
+	ccSharedSrcsKey    = "_shared_srcs"
+	ccVariantFacadeKey = "_variant_facade"
+	ccExistingRuleKey  = "_existing_rule"
 )
 
 type (
 	ccLanguage struct {
 		// Index of header includes parsed from Bazel Central Registry
 		bzlmodBuiltInIndex ccDependencyIndex
+		// Index of header includes embedded in the binary, consulted after indexes defined by gazelle:cc_indexfile
+		embeddedIndex index.DependencyIndex
 		// Set of missing bazel_dep modules referenced in includes but not defined
 		// Used for deduplication of missing modul_dep warnings
 		notFoundBzlModDeps collections.Set[string]
+		// Set of include paths resolved to different labels by the rule index and by cc_indexfile indexes
+		// Used for deduplication of conflict warnings
+		reportedIndexConflicts collections.Set[string]
+		// Mapping between platform variants of libraries and the facade selecting them, populated in Imports
+		platformVariantFacades map[label.Label]label.Label
 		// Set of relative paths to directories that already have build files or
 		// will have build files populated by rules from this extension or
 		// others that ran earlier. Populated by Configure (called in pre-order)
@@ -55,6 +67,37 @@ type (
 		buildFileDirRels collections.Set[string]
 		// List of collected errors, reported together at once after the dependency resolution
 		collectedErrors []error
+		// Visibility of indexed rules, populated by Imports and used to warn
+		// about dependencies which might not be visible to the dependent rule
+		indexedRulesVisibility map[label.Label][]string
+		// Maps lower-cased include paths to the indexed include paths of rules, populated by Imports and used
+		// to resolve includes differing only in case when enabled by gazelle:cc_case_insensitive_includes
+		caseFoldedImports map[string]collections.Set[string]
+		// Libraries defining headers using glob() keyed by their package, populated by Imports and used to resolve
+		// includes of headers which were not indexed when enabled by gazelle:cc_resolve_ancestor_globs
+		globHeaderLibraries map[string][]globHeaderLibrary
+		// Resolved dependencies of rules, populated by Resolve when enabled by gazelle:cc_detect_dep_cycles
+		// and used to warn about cycles between rules after resolving dependencies of all rules
+		resolvedDeps map[label.Label][]label.Label
+		// Includes of precompiled headers keyed by their repository-relative path, populated by GenerateRules for
+		// headers named in gazelle:cc_precompiled_header and propagated to rules including them in Resolve
+		precompiledHeaderIncludes map[string][]ccInclude
+		// Maps labels of rules to local alias rules pointing to them, populated by GenerateRules
+		aliases map[label.Label]label.Label
+		// Defines whether cc rules are loaded from rules_cc or native, set using gazelle:cc_rules_load in the root build file
+		rulesLoad rulesLoadMode
+		// Directory to which source dependency graphs are dumped, set using -cc_dump_source_graph flag
+		sourceGraphDumpDir string
+		// Should each strategy attempted when resolving includes be logged, set using -cc_trace_resolve flag
+		traceResolve bool
+		// Should the counts of includes resolved by each source be printed at the end, set using -cc_resolve_stats flag
+		printResolveStats bool
+		// Counts of includes resolved by each source, accumulated when resolving includes
+		resolveStats resolveStats
+		// Should generation fail without writing build files if any of them would be changed, set using -cc_verify flag
+		verify bool
+		// Build files visited by GenerateRules in verify mode, checked for changes after resolving dependencies
+		verifiedBuildFiles []verifiedBuildFile
 	}
 	ccInclude struct {
 		// File where this include was found
@@ -69,6 +112,8 @@ type (
 		isPlatformSpecific bool
 		// List of platforms that matched the include #if condition. Empty when shared by all platforms or unreachable by any configured platform
 		platforms []platform.Platform
+		// True for headers listed in a #define directive body instead of an include, resolved only if possible
+		isMacroHint bool
 	}
 	ccImports struct {
 		// #include directives found in header files, including those listed in "srcs" directories
@@ -99,9 +144,19 @@ func (imports ccImports) allIncludes() []ccInclude {
 
 func NewLanguage() language.Language {
 	return &ccLanguage{
-		bzlmodBuiltInIndex: loadBuiltInBzlModDependenciesIndex(),
-		notFoundBzlModDeps: make(collections.Set[string]),
-		buildFileDirRels:   make(collections.Set[string]),
+		bzlmodBuiltInIndex:        loadBuiltInBzlModDependenciesIndex(),
+		embeddedIndex:             loadEmbeddedDependencyIndex(),
+		notFoundBzlModDeps:        make(collections.Set[string]),
+		reportedIndexConflicts:    make(collections.Set[string]),
+		platformVariantFacades:    make(map[label.Label]label.Label),
+		buildFileDirRels:          make(collections.Set[string]),
+		indexedRulesVisibility:    make(map[label.Label][]string),
+		caseFoldedImports:         make(map[string]collections.Set[string]),
+		globHeaderLibraries:       make(map[string][]globHeaderLibrary),
+		resolvedDeps:              make(map[label.Label][]label.Label),
+		precompiledHeaderIncludes: make(map[string][]ccInclude),
+		aliases:                   make(map[label.Label]label.Label),
+		rulesLoad:                 rulesLoad_rulesCc,
 	}
 }
 
@@ -177,31 +232,60 @@ func (c *ccLanguage) Loads() []rule.LoadInfo {
 	panic("ApparentLoads should be called instead")
 }
 
-func (*ccLanguage) ApparentLoads(moduleToApparentName func(string) string) []rule.LoadInfo {
-	apparentOrDefaultName := func(moduleName, defaultName string) string {
-		if module := moduleToApparentName(moduleName); module != "" {
-			return module
-		} else {
-			return defaultName
-		}
-	}
-
-	return []rule.LoadInfo{
+func (lang *ccLanguage) ApparentLoads(moduleToApparentName func(string) string) []rule.LoadInfo {
+	loads := []rule.LoadInfo{
 		{
-			Name:    fmt.Sprintf("@%s//cc:defs.bzl", apparentOrDefaultName("rules_cc", "rules_cc")),
-			Symbols: ccRuleDefs,
-		},
-		{
-			Name:    fmt.Sprintf("@%s//bazel:cc_proto_library.bzl", apparentOrDefaultName("protobuf", "com_google_protobuf")),
+			Name:    fmt.Sprintf("@%s//bazel:cc_proto_library.bzl", apparentOrDefaultName(moduleToApparentName, "protobuf", "com_google_protobuf")),
 			Symbols: []string{"cc_proto_library"},
 		},
 		{
-			Name:    fmt.Sprintf("@%s//bazel:cc_grpc_library.bzl", apparentOrDefaultName("grpc", "com_github_grpc_grpc")),
+			Name:    fmt.Sprintf("@%s//bazel:cc_grpc_library.bzl", apparentOrDefaultName(moduleToApparentName, "grpc", "com_github_grpc_grpc")),
 			Symbols: []string{"cc_grpc_library"},
 		},
 	}
+	if lang.rulesLoad == rulesLoad_native {
+		// Native rules don't need to be loaded
+		return loads
+	}
+	return slices.Concat([]rule.LoadInfo{{
+		Name:    rulesCcDefsLoadName(moduleToApparentName),
+		Symbols: ccRuleDefs,
+	}}, loads)
+}
+
+// Returns the apparent name of the module in the root module, or the given
+// default name if the module is not a direct dependency.
+func apparentOrDefaultName(moduleToApparentName func(string) string, moduleName, defaultName string) string {
+	if module := moduleToApparentName(moduleName); module != "" {
+		return module
+	}
+	return defaultName
+}
+
+// Returns the label of the rules_cc file defining the cc rules, as loaded in build files.
+func rulesCcDefsLoadName(moduleToApparentName func(string) string) string {
+	return fmt.Sprintf("@%s//cc:defs.bzl", apparentOrDefaultName(moduleToApparentName, "rules_cc", "rules_cc"))
+}
+
+func (lang *ccLanguage) Fix(c *config.Config, f *rule.File) {
+	if lang.rulesLoad != rulesLoad_native {
+		return
+	}
+	// Loads of native rules are not known to Gazelle, remove them so that they're not kept unused.
+	// Only rules_cc is matched, other files defining cc rules may contain custom macros.
+	rulesCcLoad := rulesCcDefsLoadName(c.ModuleToApparentName)
+	for _, load := range f.Loads {
+		if load.Name() != rulesCcLoad {
+			continue
+		}
+		for _, symbol := range ccRuleDefs {
+			load.Remove(symbol)
+		}
+		if load.IsEmpty() {
+			load.Delete()
+		}
+	}
 }
-func (*ccLanguage) Fix(c *config.Config, f *rule.File) {}
 
 func (lang *ccLanguage) handleReportedError(mode errorReportingMode, err error) {
 	switch mode {
@@ -212,10 +296,20 @@ func (lang *ccLanguage) handleReportedError(mode errorReportingMode, err error)
 	}
 }
 
-var sourceExtensions = []string{".c", ".cc", ".cpp", ".cxx", ".c++", ".S"}
+var sourceExtensions = []string{".c", ".cc", ".cpp", ".cxx", ".c++", ".S", ".sx", ".s"}
 var headerExtensions = []string{".h", ".hh", ".hpp", ".hxx"}
 var ccExtensions = append(sourceExtensions, headerExtensions...)
 
+// Windows resource scripts, added to sources only if any of the platforms targets Windows
+var resourceExtensions = []string{".rc"}
+
+// Returns true for assembly sources which are not run through the C preprocessor,
+// their includes are never extracted. Unlike other extensions it's case-sensitive,
+// .S files are preprocessed.
+func isUnpreprocessedAssembly(filename string) bool {
+	return filepath.Ext(filename) == ".s"
+}
+
 func hasMatchingExtension(filename string, extensions []string) bool {
 	ext := filepath.Ext(filename)
 	for _, validExt := range extensions {
@@ -237,15 +331,50 @@ func loadBuiltInBzlModDependenciesIndex() ccDependencyIndex {
 	return index
 }
 
+// Index in the same format as files loaded using gazelle:cc_indexfile,
+// e.g. a snapshot of the Bazel Central Registry. Empty by default, custom
+// distributions of gazelle_cc can replace it to provide a default index.
+//
+//go:embed embedded.ccindex
+var embeddedDependencyIndex []byte
+
+func loadEmbeddedDependencyIndex() index.DependencyIndex {
+	var result index.DependencyIndex
+	if err := json.Unmarshal(embeddedDependencyIndex, &result); err != nil {
+		log.Printf("gazelle_cc: failed to load embedded cc dependencies index, it would be ignored. Reason: %v", err)
+		return index.DependencyIndex{}
+	}
+	return result
+}
+
+// Loads the index file defined using gazelle:cc_indexfile, gzip compressed files are decompressed transparently.
 func loadUserProvidedDependencyIndex(file string) (index.DependencyIndex, error) {
-	data, err := os.ReadFile(file)
+	result, err := index.LoadFile(file)
 	if err != nil {
 		return index.DependencyIndex{}, err
 	}
+	return result, nil
+}
 
-	var result index.DependencyIndex
-	err = json.Unmarshal(data, &result)
-	return result, err
+// Loads the file defined using gazelle:cc_resolve_file, a JSON object mapping include paths to labels.
+func loadResolveOverrides(file string) (map[string]label.Label, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var rawLabels map[string]string
+	if err := json.Unmarshal(data, &rawLabels); err != nil {
+		return nil, err
+	}
+	overrides := make(map[string]label.Label, len(rawLabels))
+	for includePath, target := range rawLabels {
+		decoded, err := label.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label of %q: %w", includePath, err)
+		}
+		overrides[includePath] = decoded
+	}
+	return overrides, nil
 }
 
 func unmarshalDependencyIndex(data []byte) (ccDependencyIndex, error) {
@@ -267,6 +396,16 @@ func unmarshalDependencyIndex(data []byte) (ccDependencyIndex, error) {
 func (*ccLanguage) Before(context.Context) {}
 func (*ccLanguage) DoneGeneratingRules()   {}
 func (c *ccLanguage) AfterResolvingDeps(context.Context) {
+	c.warnAboutDependencyCycles()
+	if c.printResolveStats {
+		log.Printf("gazelle_cc: include resolution summary: %v", &c.resolveStats)
+	}
+	if c.verify {
+		for _, outdated := range c.findOutdatedBuildFiles() {
+			fmt.Print(outdated.diff)
+			c.collectedErrors = append(c.collectedErrors, fmt.Errorf("build file %v is out of date", outdated.path))
+		}
+	}
 	if len(c.collectedErrors) > 0 {
 		log.Printf("Found %d error(s):", len(c.collectedErrors))
 		for _, err := range c.collectedErrors {
@@ -16,6 +16,7 @@ package cc
 
 import (
 	"fmt"
+	"maps"
 	"slices"
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
@@ -57,6 +58,23 @@ func newCcPlatformStringsExprs(
 var _ rule.BzlExprValue = ccPlatformStringsExprs{}
 var _ rule.Merger = ccPlatformStringsExprs{}
 
+// Creates an expression of sources compiled on all platforms, followed by a
+// select of sources compiled only on the platforms matching the conditions.
+func newCcPlatformSourcesExprs(generic []string, constrained map[label.Label][]string) ccPlatformStringsExprs {
+	var result ccPlatformStringsExprs
+	if len(generic) > 0 {
+		result.genericDeps = rule.ExprFromValue(generic).(*bzl.ListExpr)
+	}
+	if len(constrained) > 0 {
+		conditions := make(map[string][]string, len(constrained))
+		for condition, srcs := range constrained {
+			conditions[condition.String()] = slices.Sorted(slices.Values(srcs))
+		}
+		result.constrainedDeps = stringsMapToDictExpr(conditions)
+	}
+	return result
+}
+
 func labelsSetToStringSlice(labels collections.Set[label.Label]) []string {
 	labelToString := func(l label.Label) string { return l.String() }
 	return slices.Sorted(collections.MapSeq(labels.All(), labelToString))
@@ -69,16 +87,40 @@ func labelsSetToListExpr(labels collections.Set[label.Label]) *bzl.ListExpr {
 	return rule.SortedStrings(labelsSetToStringSlice(labels)).BzlExpr().(*bzl.ListExpr)
 }
 
+// Creates a select dict expression with conditions sorted by their label,
+// followed by always included default condition. Values of each condition are
+// sorted, so the output does not depend on the order of resolved dependencies.
 func labelsMapToDictExpr(labels map[label.Label]collections.Set[label.Label]) *bzl.DictExpr {
 	if len(labels) == 0 {
 		return nil
 	}
-	stringMap := make(map[string][]string, len(labels)+1)
-	stringMap[selectDefaultKey] = nil // always include default condition
-	for key, value := range labels {
-		stringMap[key.String()] = labelsSetToStringSlice(value)
+	values := make(map[string][]string, len(labels))
+	for condition, deps := range labels {
+		values[condition.String()] = labelsSetToStringSlice(deps)
+	}
+	return stringsMapToDictExpr(values)
+}
+
+// Creates a select dict expression with the given values of conditions sorted
+// by their keys, followed by always included default condition.
+func stringsMapToDictExpr(values map[string][]string) *bzl.DictExpr {
+	dict := &bzl.DictExpr{List: make([]*bzl.KeyValueExpr, 0, len(values)+1), ForceMultiLine: true}
+	for _, key := range slices.Sorted(maps.Keys(values)) {
+		if key == selectDefaultKey {
+			continue
+		}
+		value := rule.ExprFromValue(values[key]).(*bzl.ListExpr)
+		value.ForceMultiLine = true
+		dict.List = append(dict.List, &bzl.KeyValueExpr{
+			Key:   &bzl.StringExpr{Value: key},
+			Value: value,
+		})
 	}
-	return rule.SelectStringListValue(stringMap).BzlExpr().(*bzl.CallExpr).List[0].(*bzl.DictExpr)
+	dict.List = append(dict.List, &bzl.KeyValueExpr{
+		Key:   &bzl.StringExpr{Value: selectDefaultKey},
+		Value: rule.ExprFromValue(values[selectDefaultKey]),
+	})
+	return dict
 }
 
 func (ps ccPlatformStringsExprs) makeSelectExpr() bzl.Expr {
@@ -126,6 +168,31 @@ func (ps ccPlatformStringsExprs) Merge(other bzl.Expr) bzl.Expr {
 	return ps.BzlExpr()
 }
 
+// Returns the string values of both generic and constrained expressions, in
+// order of their occurrence.
+func (ps ccPlatformStringsExprs) values() []string {
+	var lists []*bzl.ListExpr
+	if ps.genericDeps != nil {
+		lists = append(lists, ps.genericDeps)
+	}
+	if ps.constrainedDeps != nil {
+		for _, kv := range ps.constrainedDeps.List {
+			if list, ok := kv.Value.(*bzl.ListExpr); ok {
+				lists = append(lists, list)
+			}
+		}
+	}
+	var result []string
+	for _, list := range lists {
+		for _, elem := range list.List {
+			if str, ok := elem.(*bzl.StringExpr); ok {
+				result = append(result, str.Value)
+			}
+		}
+	}
+	return result
+}
+
 func parseSelectExpr(expr *bzl.CallExpr) (*bzl.DictExpr, error) {
 	function, ok := expr.X.(*bzl.Ident)
 	if !ok || function.Name != selectFunctionName || len(expr.List) != 1 {
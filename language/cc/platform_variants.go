@@ -0,0 +1,199 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/EngFlow/gazelle_cc/language/internal/cc/platform"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
+)
+
+// Kind of the rule selecting the platform variant of a library, named after the library.
+// It's not registered in Kinds, as other aliases are never managed by this extension,
+// existing facades are updated and removed directly in the build file instead.
+const platformVariantsFacadeKind = "alias"
+
+// Returns true if any of the includes is reachable only on some of the platforms.
+func (imports ccImports) hasPlatformSpecificIncludes() bool {
+	return slices.ContainsFunc(imports.allIncludes(), func(include ccInclude) bool { return include.isPlatformSpecific })
+}
+
+// Returns the includes reachable on the given platform. All of them are shared
+// by the platform variant of the library, so they're no longer platform specific.
+func (imports ccImports) forPlatform(p platform.Platform) ccImports {
+	filter := func(includes []ccInclude) []ccInclude {
+		var result []ccInclude
+		for _, include := range includes {
+			if include.isPlatformSpecific && !slices.Contains(include.platforms, p) {
+				continue
+			}
+			include.isPlatformSpecific = false
+			include.platforms = nil
+			result = append(result, include)
+		}
+		return result
+	}
+	return ccImports{hdrIncludes: filter(imports.hdrIncludes), srcIncludes: filter(imports.srcIncludes)}
+}
+
+// Returns the suffix of the library variant for the given platform. The name
+// of the OS is used, unless multiple platforms share the same OS.
+func platformVariantSuffix(p platform.Platform, platforms []platform.Platform) string {
+	sameOS := func(other platform.Platform) bool { return other.OS == p.OS }
+	if slices.ContainsFunc(platforms, func(other platform.Platform) bool { return other != p && sameOS(other) }) {
+		return strings.ReplaceAll(fmt.Sprintf("%s_%s", p.OS, p.Arch), "-", "_")
+	}
+	return string(p.OS)
+}
+
+// Returns the names of the library variants selected by the existing facade
+// rule with the given name, or false if there is no such facade.
+func existingPlatformVariants(rulesInfo rulesInfo, facadeName string) ([]string, bool) {
+	facade, exists := rulesInfo.definedRules[facadeName]
+	if !exists {
+		return nil, false
+	}
+	return platformVariantsOf(facade)
+}
+
+// Returns the names of local rules selected by the actual attribute of the
+// facade, or false if it's not selecting between platform variants. Only
+// aliases selecting rules named <facade>_<platform>, as generated by this
+// extension, are treated as facades.
+func platformVariantsOf(facade *rule.Rule) ([]string, bool) {
+	if facade.Kind() != platformVariantsFacadeKind {
+		return nil, false
+	}
+	call, ok := facade.Attr("actual").(*bzl.CallExpr)
+	if !ok {
+		return nil, false
+	}
+	dict, err := parseSelectExpr(call)
+	if err != nil {
+		return nil, false
+	}
+	var variants []string
+	for _, kv := range dict.List {
+		value, ok := kv.Value.(*bzl.StringExpr)
+		if !ok || !strings.HasPrefix(value.Value, ":"+facade.Name()+"_") {
+			return nil, false
+		}
+		variants = append(variants, strings.TrimPrefix(value.Value, ":"))
+	}
+	return variants, true
+}
+
+// Returns the name of the facade selecting between platform variants if the
+// rule is one of them.
+func platformVariantFacade(r *rule.Rule, buildFile *rule.File) (string, bool) {
+	if buildFile == nil {
+		return "", false
+	}
+	for _, other := range buildFile.Rules {
+		if variants, ok := platformVariantsOf(other); ok && slices.Contains(variants, r.Name()) {
+			return other.Name(), true
+		}
+	}
+	return "", false
+}
+
+// Generates a cc_library rule for each platform defined using cc_platform,
+// compatible only with the OS of that platform, and an alias named after the
+// library selecting the variant of the target platform. Dependencies of each
+// variant are based only on includes reachable on its platform.
+//
+// The setAttrs function sets the sources and other attributes shared by the
+// variants. Existing rules conflicting with the facade and variants of
+// platforms no longer defined are removed.
+func (c *ccLanguage) generatePlatformVariantRules(
+	args language.GenerateArgs,
+	rulesInfo rulesInfo,
+	library *rule.Rule,
+	imports ccImports,
+	setAttrs func(variant *rule.Rule),
+	result *language.GenerateResult) {
+	conf := getCcConfig(args.Config)
+	platforms := slices.SortedFunc(maps.Keys(conf.platforms), platform.Compare)
+	conditions := &bzl.DictExpr{ForceMultiLine: true}
+	var variants []*rule.Rule
+	var variantsImports []any
+	for _, p := range platforms {
+		variantName := fmt.Sprintf("%s_%s", library.Name(), platformVariantSuffix(p, platforms))
+		variant := rule.NewRule(library.Kind(), variantName)
+		if existing, ok := rulesInfo.definedRules[variantName]; ok {
+			variant.SetPrivateAttr(ccExistingDepsKey, getAllRuleDeps(existing, args.Config.RepoName, args.Rel))
+		}
+		setAttrs(variant)
+		variant.SetAttr("target_compatible_with", []string{fmt.Sprintf("@platforms//os:%s", p.OS)})
+		variant.SetPrivateAttr(ccVariantFacadeKey, label.Label{Name: library.Name(), Relative: true})
+		variants = append(variants, variant)
+		variantsImports = append(variantsImports, imports.forPlatform(p))
+		conditions.List = append(conditions.List, &bzl.KeyValueExpr{
+			Key:   &bzl.StringExpr{Value: conf.platforms[p].constraint.String()},
+			Value: &bzl.StringExpr{Value: ":" + variantName},
+		})
+	}
+	slices.SortFunc(conditions.List, func(a, b *bzl.KeyValueExpr) int {
+		return strings.Compare(a.Key.(*bzl.StringExpr).Value, b.Key.(*bzl.StringExpr).Value)
+	})
+
+	facade := rule.NewRule(platformVariantsFacadeKind, library.Name())
+	facade.SetAttr("actual", &bzl.CallExpr{
+		X:    &bzl.Ident{Name: selectFunctionName},
+		List: []bzl.Expr{conditions},
+	})
+	setVisibilityIfNeeded(facade, args.File, conf.libraryVisibility(args.Rel))
+	if existing, ok := rulesInfo.definedRules[facade.Name()]; ok {
+		if _, isFacade := platformVariantsOf(existing); isFacade {
+			// The actual attribute of aliases is not mergeable, see platformVariantsFacadeKind
+			existing.SetAttr("actual", facade.Attr("actual"))
+		}
+	}
+	result.Gen = append(append(result.Gen, facade), variants...)
+	result.Imports = append(append(result.Imports, ccImports{}), variantsImports...)
+
+	if existing, ok := rulesInfo.definedRules[facade.Name()]; ok && existing.Kind() != platformVariantsFacadeKind {
+		// The library is replaced by the facade
+		result.Empty = append(result.Empty, rule.NewRule(existing.Kind(), existing.Name()))
+	}
+	c.removeStalePlatformVariants(rulesInfo, facade.Name(), result)
+}
+
+// Removes existing variants selected by the facade with the given name which
+// were not generated, also removing the facade from the build file unless it
+// was generated.
+func (c *ccLanguage) removeStalePlatformVariants(rulesInfo rulesInfo, facadeName string, result *language.GenerateResult) {
+	variants, ok := existingPlatformVariants(rulesInfo, facadeName)
+	if !ok {
+		return
+	}
+	for _, name := range variants {
+		if existing, exists := rulesInfo.definedRules[name]; exists && !hasRuleWithName(name, result.Gen) {
+			result.Empty = append(result.Empty, rule.NewRule(existing.Kind(), name))
+		}
+	}
+	if !slices.ContainsFunc(result.Gen, func(r *rule.Rule) bool {
+		return r.Name() == facadeName && r.Kind() == platformVariantsFacadeKind
+	}) {
+		rulesInfo.definedRules[facadeName].Delete()
+	}
+}
@@ -77,21 +77,21 @@ func makeRelativeLabel(rule *rule.Rule) label.Label {
 	return label.Label{Name: rule.Name(), Relative: true}
 }
 
-func generateCcProtoLibraryRule(protoLibraryRule *rule.Rule, pbHeaders []string, buildFile *rule.File) *rule.Rule {
+func generateCcProtoLibraryRule(protoLibraryRule *rule.Rule, pbHeaders []string, buildFile *rule.File, visibility []string) *rule.Rule {
 	rule := newEmptyCcProtoLibraryRule(protoLibraryRule.Name())
 	rule.SetAttr("deps", []label.Label{makeRelativeLabel(protoLibraryRule)})
 	rule.SetPrivateAttr(ccProtoLibraryHeadersKey, pbHeaders)
-	setVisibilityIfNeeded(rule, buildFile)
+	setVisibilityIfNeeded(rule, buildFile, visibility)
 	return rule
 }
 
-func generateCcGrpcLibraryRule(protoLibraryRule, ccProtoLibraryRule *rule.Rule, grpcHeaders []string, buildFile *rule.File) *rule.Rule {
+func generateCcGrpcLibraryRule(protoLibraryRule, ccProtoLibraryRule *rule.Rule, grpcHeaders []string, buildFile *rule.File, visibility []string) *rule.Rule {
 	rule := newEmptyCcGrpcLibraryRule(protoLibraryRule.Name())
 	rule.SetAttr("srcs", []label.Label{makeRelativeLabel(protoLibraryRule)})
 	rule.SetAttr("deps", []label.Label{makeRelativeLabel(ccProtoLibraryRule)})
 	rule.SetAttr("grpc_only", true)
 	rule.SetPrivateAttr(ccProtoLibraryHeadersKey, grpcHeaders)
-	setVisibilityIfNeeded(rule, buildFile)
+	setVisibilityIfNeeded(rule, buildFile, visibility)
 	return rule
 }
 
@@ -107,6 +107,7 @@ func generateCcGrpcLibraryRule(protoLibraryRule, ccProtoLibraryRule *rule.Rule,
 // See language/cc/testdata/protobuf_filter_generated test for an example.
 func generateProtoLibraryRules(args language.GenerateArgs, result *language.GenerateResult) collections.Set[string] {
 	consumedProtoFiles := make(collections.Set[string])
+	visibility := getCcConfig(args.Config).libraryVisibility(args.Rel)
 	if !shouldGenerateProtoLibraryRules(args.Config) {
 		// Don't create or delete proto rules in this mode. All "*.pb.h",
 		// "*.pb.cc", would be added to cc_library
@@ -122,12 +123,12 @@ func generateProtoLibraryRules(args language.GenerateArgs, result *language.Gene
 		pbHeaders, pbSources, grpcHeaders, grpcSources := getGeneratedFilesFromProtoPackage(protoPackage)
 		consumedProtoFiles.AddSlice(pbHeaders).AddSlice(pbSources).AddSlice(grpcHeaders).AddSlice(grpcSources)
 
-		ccProtoLibraryRule := generateCcProtoLibraryRule(protoLibraryRule, pbHeaders, args.File)
+		ccProtoLibraryRule := generateCcProtoLibraryRule(protoLibraryRule, pbHeaders, args.File, visibility)
 		result.Gen = append(result.Gen, ccProtoLibraryRule)
 		result.Imports = append(result.Imports, ccImports{})
 
 		if protoPackage.HasServices {
-			ccGrpcLibraryRule := generateCcGrpcLibraryRule(protoLibraryRule, ccProtoLibraryRule, grpcHeaders, args.File)
+			ccGrpcLibraryRule := generateCcGrpcLibraryRule(protoLibraryRule, ccProtoLibraryRule, grpcHeaders, args.File, visibility)
 			result.Gen = append(result.Gen, ccGrpcLibraryRule)
 			result.Imports = append(result.Imports, ccImports{})
 		}
@@ -18,15 +18,23 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"maps"
+	"os"
 	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/includepath"
 	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/pathtools"
 	"github.com/bazelbuild/bazel-gazelle/repo"
 	"github.com/bazelbuild/bazel-gazelle/resolve"
 	"github.com/bazelbuild/bazel-gazelle/rule"
+	bzl "github.com/bazelbuild/buildtools/build"
 )
 
 // resolve.Resolver methods
@@ -40,6 +48,104 @@ func (lang *ccLanguage) Resolve(c *config.Config, ix *resolve.RuleIndex, rc *rep
 	if len(privateDeps.all) > 0 {
 		r.SetAttr("implementation_deps", privateDeps.build())
 	}
+	conf := getCcConfig(c)
+	switch resolveCCRuleKind(r.Kind(), c) {
+	case "cc_library", "cc_binary", "cc_test":
+		if conf.systemLinkoptsEnabled {
+			addSystemLinkopts(r, systemLinkopts(imports.(ccImports).allIncludes(), conf.systemHeaderLinkopts))
+		}
+		if conf.generatedComment {
+			addGeneratedComment(r, conf.groupingMode)
+		}
+		if conf.detectDepCycles {
+			lang.recordResolvedDeps(from, publicDeps, privateDeps)
+		}
+	}
+	updateExistingRuleComments(r)
+}
+
+// Returns the comment added above rules generated by gazelle_cc if enabled by
+// cc_generated_comment, stating they're managed by gazelle_cc and how their
+// sources were grouped.
+func generatedComment(groupingMode sourceGroupingMode) string {
+	return fmt.Sprintf("# Generated by gazelle_cc (cc_group %s)", groupingMode)
+}
+
+func addGeneratedComment(r *rule.Rule, groupingMode sourceGroupingMode) {
+	r.AddComment(generatedComment(groupingMode))
+}
+
+// Rule defined in the existing BUILD file into which the generated rule would be merged.
+type existingRuleRef struct {
+	file *rule.File
+	rule *rule.Rule
+}
+
+// Returns true for comments added above the rules by gazelle_cc, which are
+// recomputed in each run. Only comments in the exact format written by
+// gazelle_cc are matched, other comments are always kept.
+func isManagedComment(comment string) bool {
+	return isAmbiguousDependencyComment(comment) || slices.ContainsFunc(sourceGroupingModes, func(mode sourceGroupingMode) bool {
+		return comment == generatedComment(mode)
+	})
+}
+
+// Gazelle doesn't copy comments of the generated rule when merging it into the
+// existing rule. Comments managed by gazelle_cc are updated in the existing rule
+// directly instead: the new ones are added after the comments written by the
+// user, the ones no longer added to the generated rule are removed.
+func updateExistingRuleComments(r *rule.Rule) {
+	existing, ok := r.PrivateAttr(ccExistingRuleKey).(existingRuleRef)
+	if !ok || existing.rule.ShouldKeep() {
+		return
+	}
+	current := existing.rule.Comments()
+	isStale := func(comment string) bool { return isManagedComment(comment) && !slices.Contains(r.Comments(), comment) }
+	if !slices.ContainsFunc(current, isStale) {
+		for _, comment := range r.Comments() {
+			if !slices.Contains(current, comment) {
+				existing.rule.AddComment(comment)
+			}
+		}
+		return
+	}
+	// rule.Rule only allows adding comments, stale comments are removed from the
+	// statement of the existing rule, which is otherwise left unchanged.
+	comments := slices.Concat(slices.DeleteFunc(slices.Clone(current), isManagedComment), r.Comments())
+	for _, stmt := range existing.file.File.Stmt {
+		if call, ok := stmt.(*bzl.CallExpr); ok {
+			if callRule := (&bzl.Rule{Call: call}); callRule.Kind() == existing.rule.Kind() && callRule.Name() == existing.rule.Name() {
+				call.Comments.Before = collections.MapSlice(comments, func(token string) bzl.Comment { return bzl.Comment{Token: token} })
+				return
+			}
+		}
+	}
+}
+
+// Returns the linkopts required by system includes, preserving the order of includes.
+func systemLinkopts(includes []ccInclude, headerLinkopts map[string][]string) []string {
+	var linkopts []string
+	for _, include := range includes {
+		if include.isSystemInclude {
+			linkopts = concatUnique(linkopts, headerLinkopts[include.path])
+		}
+	}
+	return linkopts
+}
+
+// Appends linkopts missing in the rule. Existing linkopts are never removed,
+// the attribute is not modified if it's not a plain list of strings.
+func addSystemLinkopts(r *rule.Rule, linkopts []string) {
+	if len(linkopts) == 0 {
+		return
+	}
+	existing := r.AttrStrings("linkopts")
+	if r.Attr("linkopts") != nil && existing == nil {
+		return
+	}
+	if merged := concatUnique(existing, linkopts); len(merged) > len(existing) {
+		r.SetAttr("linkopts", merged)
+	}
 }
 
 func (lang *ccLanguage) resolveDeps(
@@ -56,6 +162,18 @@ func (lang *ccLanguage) resolveDeps(
 	default:
 		publicDeps = lang.resolveCcGenericRuleDeps(c, ix, r, imports, from)
 	}
+	// Binaries link libraries of shared sources of their package, see gazelle:cc_binary_shared_srcs
+	if libraries, ok := r.PrivateAttr(ccSharedSrcsKey).([]label.Label); ok {
+		for _, library := range libraries {
+			publicDeps.addGeneric(library)
+		}
+	}
+	// Facade of a sharded library links all of its shards
+	if shards, ok := r.PrivateAttr(ccShardsKey).([]label.Label); ok {
+		for _, shard := range shards {
+			publicDeps.addGeneric(shard)
+		}
+	}
 	return
 }
 
@@ -65,11 +183,26 @@ func (lang *ccLanguage) resolveCcLibraryDeps(
 	r *rule.Rule,
 	imports ccImports,
 	from label.Label) (publicDeps, privateDeps platformDepsBuilder) {
+	conf := getCcConfig(c)
+	if !conf.useImplementationDeps {
+		publicDeps = lang.resolveCcGenericRuleDeps(c, ix, r, imports, from)
+		return
+	}
 	// Only cc_library has 'implementation_deps' attribute If dependency is
 	// added by header (via "deps") ensure it would not be duplicated inside
-	// "implementation_deps".
-	publicDeps = lang.resolveIncludes(c, ix, r, from, imports.hdrIncludes, collections.Set[label.Label]{})
-	privateDeps = lang.resolveIncludes(c, ix, r, from, imports.srcIncludes, publicDeps.all)
+	// "implementation_deps". Includes forced into "deps" using
+	// gazelle:cc_public_dep are resolved as if included by headers.
+	isPublic := func(include ccInclude) bool { return slices.Contains(conf.publicDepIncludes, include.path) }
+	hdrIncludes := slices.Concat(imports.hdrIncludes, collections.FilterSlice(imports.srcIncludes, isPublic))
+	srcIncludes := slices.DeleteFunc(slices.Clone(imports.srcIncludes), isPublic)
+
+	publicDeps = lang.resolveIncludes(c, ix, r, from, hdrIncludes, collections.Set[label.Label]{})
+	privateDeps = lang.resolveIncludes(c, ix, r, from, srcIncludes, publicDeps.all)
+	for dep := range privateDeps.all {
+		if slices.Contains(conf.publicDepLabels, dep.Abs(from.Repo, from.Pkg)) {
+			privateDeps.moveTo(&publicDeps, dep)
+		}
+	}
 	return
 }
 
@@ -111,19 +244,40 @@ func (lang *ccLanguage) resolveIncludes(
 	ccConfig := getCcConfig(c)
 	result := newPlatformDepsBuilder()
 
-	for _, include := range includes {
+	for _, include := range lang.appendPrecompiledHeaderIncludes(includes) {
 		if path.IsAbs(include.path) || filepath.IsAbs(include.path) {
 			// Don't try to resolve absolute paths, even within the repo.
 			continue
 		}
+		if ccConfig.isIgnoredInclude(include.path) {
+			// Explicitly excluded from resolution by the user
+			continue
+		}
+		if include.isSystemInclude && ccConfig.availableHeaders.Contains(include.path) && !hasResolveOverride(c, include.path) {
+			// Provided by the toolchain or platform SDK, unless the user mapped it to a dependency
+			continue
+		}
 
 		resolvedLabel, err := lang.resolveSingleInclude(c, ix, r, from, include)
 		if !lang.handleIncludeResolutionError(c, include, resolvedLabel, err) {
 			continue
 		}
+		if ccConfig.validateDeps && !lang.packageExists(c, resolvedLabel) {
+			// Typically caused by a stale index, Bazel would fail to load the package
+			log.Printf("gazelle_cc: %v: dependency %v refers to a package that does not exist, it would be skipped - %v", from, resolvedLabel, include)
+			continue
+		}
 
 		// Successfully resolved
+		if alias, ok := lang.aliases[resolvedLabel]; ok && ccConfig.preferAliases {
+			resolvedLabel = alias
+		}
+		lang.warnIfNotVisible(resolvedLabel, from, include)
 		resolvedLabel = resolvedLabel.Rel(from.Repo, from.Pkg)
+		if facade, ok := r.PrivateAttr(ccShardFacadeKey).(label.Label); ok && resolvedLabel == facade {
+			// Shards define headers of their facade in textual_hdrs, depending on it would create a cycle
+			continue
+		}
 		if !excluded.Contains(resolvedLabel) {
 			result.addResolved(resolvedLabel, ccConfig, include)
 		}
@@ -132,10 +286,76 @@ func (lang *ccLanguage) resolveIncludes(
 	return result
 }
 
+// Returns true if the include path is mapped to a dependency using gazelle:resolve
+// or cc_resolve_file directives, these take precedence over any other resolution.
+func hasResolveOverride(c *config.Config, includePath string) bool {
+	for _, imp := range []string{includePath, getCcConfig(c).aliasedIncludePath(includePath)} {
+		if _, ok := resolve.FindRuleWithOverride(c, resolve.ImportSpec{Lang: languageName, Imp: imp}, languageName); ok {
+			return true
+		}
+		if _, ok := getCcConfig(c).resolveOverrides[imp]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Appends includes of precompiled headers, defined using gazelle:cc_precompiled_header,
+// to the given includes referring to them. Sources using a precompiled header
+// typically rely on the headers it includes without including them directly,
+// so their libraries need to be dependencies of the including rule as well.
+// Precompiled headers including other precompiled headers are expanded once.
+func (lang *ccLanguage) appendPrecompiledHeaderIncludes(includes []ccInclude) []ccInclude {
+	if len(lang.precompiledHeaderIncludes) == 0 {
+		return includes
+	}
+	expanded := make(collections.Set[string])
+	for i := 0; i < len(includes); i++ {
+		include := includes[i]
+		if include.isMacroHint {
+			continue
+		}
+		candidates := []string{includepath.Normalize(include.path)}
+		if !include.isSystemInclude {
+			candidates = slices.Insert(candidates, 0, includepath.Normalize(path.Join(include.sourceDirectory(), include.path)))
+		}
+		for _, candidate := range candidates {
+			if pchIncludes, ok := lang.precompiledHeaderIncludes[candidate]; ok {
+				if !expanded.Contains(candidate) {
+					expanded.Add(candidate)
+					// Never modify the includes of the rule passed by the caller
+					includes = append(slices.Clip(includes), pchIncludes...)
+				}
+				break
+			}
+		}
+	}
+	return includes
+}
+
+// Returns true if the package of the label exists or would be created by Gazelle.
+// Only packages of the main repository are checked, labels of other repositories
+// are always assumed to be valid.
+func (lang *ccLanguage) packageExists(c *config.Config, l label.Label) bool {
+	if l.Relative || (l.Repo != "" && l.Repo != c.RepoName) || lang.buildFileDirRels.Contains(l.Pkg) {
+		return true
+	}
+	for _, name := range c.ValidBuildFileNames {
+		if info, err := os.Stat(filepath.Join(c.RepoRoot, filepath.FromSlash(l.Pkg), name)); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
 // Attempts to resolve a single include directive to a rule label. It tries
 // multiple resolution strategies in order:
-//  1. Fully qualified path (repository-root relative) for non-system includes
-//  2. Exact path using the include directive as-is
+//  1. Fully qualified path (repository-root relative) for non-system includes,
+//     with '..' components of paths relative to the including source resolved
+//  2. Exact path using the include directive as-is, with its prefix replaced
+//     if matching any of cc_include_alias directives
+//  3. Repository-root relative path with the prefix defined using
+//     cc_strip_include_root removed
 func (lang *ccLanguage) resolveSingleInclude(
 	c *config.Config,
 	ix *resolve.RuleIndex,
@@ -145,16 +365,33 @@ func (lang *ccLanguage) resolveSingleInclude(
 	resolvedLabel := label.NoLabel
 	err := errUnresolved
 
-	// 1. Try resolve using fully qualified path (repository-root relative)
+	// 1. Try resolve using fully qualified path (repository-root relative).
+	// Paths with '..' components leaving the repository root can't refer to its headers,
+	// they're never looked up in any of the steps.
 	if !include.isSystemInclude {
-		relPath := filepath.Join(include.sourceDirectory(), include.path)
-		resolvedLabel, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: relPath}, include)
+		relPath := includepath.Normalize(path.Join(include.sourceDirectory(), include.path))
+		if !includepath.EscapesRoot(relPath) {
+			resolvedLabel, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: relPath}, include)
+		}
 	}
 
 	// 2. Try resolve using exact path - using the exact include directive
 	if errors.Is(err, errUnresolved) {
 		// Retry to resolve if external dependency was defined using quotes instead of braces
-		resolvedLabel, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: include.path}, include)
+		includePath := getCcConfig(c).aliasedIncludePath(include.path)
+		if !includepath.EscapesRoot(includePath) {
+			resolvedLabel, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: includePath}, include)
+		}
+	}
+
+	// 3. Try resolve using the path relative to the configured repository root prefix
+	if errors.Is(err, errUnresolved) {
+		if strippedPath, ok := getCcConfig(c).strippedIncludeRootPath(include.path); ok && !includepath.EscapesRoot(strippedPath) {
+			resolvedLabel, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: strippedPath}, include)
+		}
+	}
+	if errors.Is(err, errUnresolved) {
+		lang.resolveStats.add(unresolvedInclude)
 	}
 
 	return resolvedLabel, err
@@ -191,8 +428,8 @@ func (lang *ccLanguage) handleIncludeResolutionError(
 		// Ignore: the rule exists, but it should not be added as a dependency
 		return false
 	case errors.Is(err, errUnresolved):
-		// Warn about unresolved non-system include directives
-		if !include.isSystemInclude {
+		// Warn about unresolved non-system include directives, headers listed in macros are only hints
+		if !include.isSystemInclude && !include.isMacroHint {
 			lang.handleReportedError(getCcConfig(c).unresolvedDepsMode, err)
 		}
 		return false
@@ -201,6 +438,17 @@ func (lang *ccLanguage) handleIncludeResolutionError(
 	return resolvedLabel != label.NoLabel
 }
 
+// Warns if the resolved dependency is known not to be visible to the "from"
+// rule. The check is best-effort, it only uses visibility of rules registered
+// in Imports and never prevents adding the dependency.
+func (lang *ccLanguage) warnIfNotVisible(dependency label.Label, from label.Label, include ccInclude) {
+	visibility, known := lang.indexedRulesVisibility[dependency]
+	if !known || isVisibleTo(visibility, dependency, from) {
+		return
+	}
+	log.Printf("%v: dependency %v might not be visible, check its visibility attribute - %v", from, dependency, include)
+}
+
 func containsMultipleRepos(labels []label.Label) bool {
 	if len(labels) > 1 {
 		firstRepo := labels[0].Repo
@@ -244,6 +492,9 @@ func resolveAmbiguousDependency(
 			fallthrough
 		case ambiguousDepsMode_warn:
 			return label.NoLabel, fmt.Errorf("%v: %w - %v resolved to %v; don't know which one to use", from, errAmbiguousImport, include, resolvedDeps)
+		case ambiguousDepsMode_todo:
+			addAmbiguousDependencyComment(r, include, resolvedDeps)
+			return label.NoLabel, nil
 		default:
 			// Silently ignore the ambiguous dependency.
 			return label.NoLabel, nil
@@ -251,14 +502,44 @@ func resolveAmbiguousDependency(
 	}
 }
 
+// Adds a TODO comment above the rule listing the candidates for the ambiguous
+// include, so one of them can be chosen manually. Candidates already listed in
+// the existing deps are respected in the next runs, see resolveAmbiguousDependency,
+// and the comment is then removed from the existing rule.
+func addAmbiguousDependencyComment(r *rule.Rule, include ccInclude, candidates []label.Label) {
+	comment := fmt.Sprintf("# TODO: %q%s%v",
+		include.path, ambiguousDependencyCommentMarker, strings.Join(collections.MapSlice(candidates, label.Label.String), ", "))
+	if !slices.Contains(r.Comments(), comment) {
+		r.AddComment(comment)
+	}
+}
+
+// Part of the TODO comment listing candidates of the ambiguous include, distinguishing it from other TODO comments
+const ambiguousDependencyCommentMarker = " is provided by multiple targets, add one of them to deps: "
+
+// Matches the TODO comments added by addAmbiguousDependencyComment
+var ambiguousDependencyCommentPattern = regexp.MustCompile(`^# TODO: "(?:[^"\\]|\\.)*"` + regexp.QuoteMeta(ambiguousDependencyCommentMarker) + `\S+(?:, \S+)*$`)
+
+func isAmbiguousDependencyComment(comment string) bool {
+	return ambiguousDependencyCommentPattern.MatchString(comment)
+}
+
 // Tries to resolve given importSpec, looking for an external rule other than the source "from" label, using the following strategies:
 //  1. Using gazelle:resolve override if defined.
 //  2. Using imports registered in Imports.
-//  3. Using dependency indexes defined by gazelle:cc_indexfile.
-//  4. Using built-in bzlmod index if enabled by gazelle:cc_use_builtin_bzlmod_index.
+//  3. Using dependency indexes defined by gazelle:cc_indexfile, before the
+//     imports registered in Imports if set by gazelle:cc_index_precedence.
+//  4. Using dependency index embedded in the binary if enabled by gazelle:cc_use_embedded_index.
+//  5. Using built-in bzlmod index if enabled by gazelle:cc_use_builtin_bzlmod_index.
+//  6. Using include path prefixes mapped to a single rule by gazelle:cc_include_prefix_dep.
+//  7. Using libraries of the nearest ancestor package defining the header using glob() if enabled by gazelle:cc_resolve_ancestor_globs.
+//  8. Using imports registered in Imports differing only in case if enabled by gazelle:cc_case_insensitive_includes.
 //
 // Returns the resolved label, optionally with a wrapped one of 'err*' errors.
 // For errUnresolved the returned label is label.NoLabel.
+//
+// If enabled by -cc_trace_resolve flag or gazelle:cc_trace_resolve directive
+// the outcome of each attempted strategy is logged.
 func (lang *ccLanguage) resolveImportSpec(
 	c *config.Config,
 	ix *resolve.RuleIndex,
@@ -266,29 +547,110 @@ func (lang *ccLanguage) resolveImportSpec(
 	from label.Label,
 	importSpec resolve.ImportSpec,
 	include ccInclude) (label.Label, error) {
+	var trace *resolveTrace
+	if lang.traceResolve || getCcConfig(c).traceResolve {
+		trace = &resolveTrace{from: from, importSpec: importSpec, include: include}
+	}
+	resolvedLabel, source, err := lang.tracedResolveImportSpec(c, ix, r, from, importSpec, include, trace)
+	// Only labels added as dependencies are counted, e.g. ambiguous or missing module dependencies are not.
+	// Unresolved includes are counted by resolveSingleInclude, as it might retry using another path
+	if err == nil && resolvedLabel != label.NoLabel {
+		lang.resolveStats.add(source)
+	}
+	return trace.log(resolvedLabel, err)
+}
+
+func (lang *ccLanguage) tracedResolveImportSpec(
+	c *config.Config,
+	ix *resolve.RuleIndex,
+	r *rule.Rule,
+	from label.Label,
+	importSpec resolve.ImportSpec,
+	include ccInclude,
+	trace *resolveTrace) (label.Label, resolutionSource, error) {
 	conf := getCcConfig(c)
 	// Resolve the gazele:resolve overrides if defined
 	if resolvedLabel, ok := resolve.FindRuleWithOverride(c, importSpec, languageName); ok {
-		return resolvedLabel, nil
+		trace.step("resolve override", "matched %v", resolvedLabel)
+		return resolvedLabel, resolvedViaOverride, nil
 	}
+	trace.step("resolve override", "no match")
 
-	// Resolve using imports registered in Imports
-	if importedRules := ix.FindRulesByImportWithConfig(c, importSpec, languageName); len(importedRules) > 0 {
-		// Any self-import should immediately stop the resolution
-		for _, searchResult := range importedRules {
-			if searchResult.IsSelfImport(from) {
-				return from, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
-			}
+	if dep, ok := conf.resolveOverrides[importSpec.Imp]; ok {
+		if dep == from {
+			trace.step("cc_resolve_file", "self-import")
+			return from, resolvedViaOverride, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
 		}
+		trace.step("cc_resolve_file", "matched %v", dep)
+		return dep, resolvedViaOverride, nil
+	}
+	if len(conf.resolveOverrides) > 0 {
+		trace.step("cc_resolve_file", "no match")
+	}
 
-		resolvedDeps := collections.MapSlice(importedRules, func(r resolve.FindResult) label.Label { return r.Label })
-		return resolveAmbiguousDependency(resolvedDeps, conf.ambiguousDepsMode, r, from, include)
+	// Resolve using imports registered in Imports
+	importedRules := ix.FindRulesByImportWithConfig(c, importSpec, languageName)
+	// Any self-import should immediately stop the resolution
+	for _, searchResult := range importedRules {
+		if searchResult.IsSelfImport(from) {
+			trace.step("rule index", "self-import")
+			return from, resolvedViaRuleIndex, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
+		}
+	}
+	var localDeps []label.Label
+	for _, searchResult := range importedRules {
+		dep := searchResult.Label
+		if facade, ok := lang.platformVariantFacades[dep]; ok {
+			// Platform variants of a library are used only through their facade
+			dep = facade
+		}
+		if !slices.Contains(localDeps, dep) {
+			localDeps = append(localDeps, dep)
+		}
+	}
+	if len(localDeps) > 0 {
+		trace.step("rule index", "found %v", localDeps)
+	} else {
+		trace.step("rule index", "no match")
 	}
 
-	for _, index := range conf.dependencyIndexes {
+	var indexedDeps []label.Label
+	for i, index := range conf.dependencyIndexes {
 		if resolvedDeps, exists := index[importSpec.Imp]; exists {
-			return resolveAmbiguousDependency(resolvedDeps, conf.ambiguousDepsMode, r, from, include)
+			trace.step(fmt.Sprintf("cc_indexfile #%d", i+1), "found %v", resolvedDeps)
+			indexedDeps = resolvedDeps
+			break
 		}
+		trace.step(fmt.Sprintf("cc_indexfile #%d", i+1), "no match")
+	}
+
+	preferredDeps, otherDeps := localDeps, indexedDeps
+	preferredSource, otherSource := resolvedViaRuleIndex, resolvedViaDepIndex
+	if conf.indexPrecedence == indexPrecedence_index {
+		preferredDeps, otherDeps = indexedDeps, localDeps
+		preferredSource, otherSource = resolvedViaDepIndex, resolvedViaRuleIndex
+	}
+	if len(localDeps) > 0 && len(indexedDeps) > 0 {
+		lang.warnIndexConflict(importSpec.Imp, localDeps, indexedDeps, conf.indexPrecedence)
+	}
+	if len(preferredDeps) > 0 {
+		dep, err := resolveAmbiguousDependency(preferredDeps, conf.ambiguousDepsMode, r, from, include)
+		return dep, preferredSource, err
+	}
+	if len(otherDeps) > 0 {
+		dep, err := resolveAmbiguousDependency(otherDeps, conf.ambiguousDepsMode, r, from, include)
+		return dep, otherSource, err
+	}
+
+	if conf.useEmbeddedIndex {
+		if resolvedDeps, exists := lang.embeddedIndex[importSpec.Imp]; exists {
+			trace.step("embedded index", "found %v", resolvedDeps)
+			dep, err := resolveAmbiguousDependency(resolvedDeps, conf.ambiguousDepsMode, r, from, include)
+			return dep, resolvedViaDepIndex, err
+		}
+		trace.step("embedded index", "no match")
+	} else {
+		trace.step("embedded index", "disabled")
 	}
 
 	if conf.useBuiltinBzlmodIndex {
@@ -296,14 +658,137 @@ func (lang *ccLanguage) resolveImportSpec(
 			// Empty apparentName means that there is no such a repository added by bazel_dep
 			if apparentName := c.ModuleToApparentName(result.Repo); apparentName != "" {
 				result.Repo = apparentName
-				return result, nil
+				trace.step("builtin bzlmod index", "found %v", result)
+				return result, resolvedViaBzlmod, nil
 			} else {
-				return result, fmt.Errorf("%v: %w - %v resolved to %v, but 'bazel_dep(name = \"%v\")' is missing", from, errMissingModuleDependency, include, result, result.Repo)
+				trace.step("builtin bzlmod index", "found %v, missing bazel_dep", result)
+				return result, resolvedViaBzlmod, fmt.Errorf("%v: %w - %v resolved to %v, but 'bazel_dep(name = \"%v\")' is missing", from, errMissingModuleDependency, include, result, result.Repo)
+			}
+		}
+		trace.step("builtin bzlmod index", "no match")
+	} else {
+		trace.step("builtin bzlmod index", "disabled")
+	}
+
+	if dep, ok := conf.includePrefixDep(importSpec.Imp); ok {
+		if dep == from {
+			trace.step("cc_include_prefix_dep", "self-import")
+			return from, resolvedViaOverride, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
+		}
+		trace.step("cc_include_prefix_dep", "matched %v", dep)
+		return dep, resolvedViaOverride, nil
+	}
+	trace.step("cc_include_prefix_dep", "no match")
+
+	if conf.resolveAncestorGlobs {
+		if resolvedDeps := lang.findAncestorGlobLibraries(importSpec.Imp); len(resolvedDeps) > 0 {
+			if slices.Contains(resolvedDeps, from) {
+				trace.step("ancestor glob", "self-import")
+				return from, resolvedViaRuleIndex, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
+			}
+			trace.step("ancestor glob", "found %v", resolvedDeps)
+			dep, err := resolveAmbiguousDependency(resolvedDeps, conf.ambiguousDepsMode, r, from, include)
+			return dep, resolvedViaRuleIndex, err
+		}
+		trace.step("ancestor glob", "no match")
+	}
+
+	if conf.caseInsensitiveIncludes {
+		var importedRules []resolve.FindResult
+		for _, imp := range lang.caseFoldedImports[strings.ToLower(importSpec.Imp)].SortedValues(strings.Compare) {
+			if imp != importSpec.Imp {
+				importedRules = append(importedRules, ix.FindRulesByImportWithConfig(c, resolve.ImportSpec{Lang: languageName, Imp: imp}, languageName)...)
+			}
+		}
+		if len(importedRules) > 0 {
+			for _, searchResult := range importedRules {
+				if searchResult.IsSelfImport(from) {
+					trace.step("case-insensitive rule index", "self-import")
+					return from, resolvedViaRuleIndex, fmt.Errorf("%v: %w - %v", from, errSelfImport, include)
+				}
 			}
+			// The same rule might be found using multiple spellings of the include path
+			resolvedDeps := collections.CollectToSet(collections.MapSeq(slices.Values(importedRules), func(r resolve.FindResult) label.Label { return r.Label })).
+				SortedValues(compareLabels)
+			trace.step("case-insensitive rule index", "found %v", resolvedDeps)
+			dep, err := resolveAmbiguousDependency(resolvedDeps, conf.ambiguousDepsMode, r, from, include)
+			return dep, resolvedViaRuleIndex, err
 		}
+		trace.step("case-insensitive rule index", "no match")
 	}
 
-	return label.NoLabel, fmt.Errorf("%v: %w - %v", from, errUnresolved, include)
+	return label.NoLabel, unresolvedInclude, fmt.Errorf("%v: %w - %v", from, errUnresolved, include)
+}
+
+// Returns the libraries of the nearest ancestor package of the header which
+// define it using glob() in hdrs, e.g. when the header is generated or its
+// directory was not walked. Packages above the one containing the header are
+// not searched, as globs never match files of subpackages.
+func (lang *ccLanguage) findAncestorGlobLibraries(includePath string) []label.Label {
+	dir := includePath
+	for dir != "" {
+		if dir = path.Dir(dir); dir == "." {
+			dir = ""
+		}
+		header := strings.TrimPrefix(includePath, dir+"/")
+		var libraries []label.Label
+		for _, lib := range lang.globHeaderLibraries[dir] {
+			if lib.owns(header) {
+				libraries = append(libraries, lib.label)
+			}
+		}
+		if len(libraries) > 0 || lang.buildFileDirRels.Contains(dir) {
+			return libraries
+		}
+	}
+	return nil
+}
+
+// Warns once per include path if the rules indexed in the repository and
+// cc_indexfile indexes provide the same header using different labels, e.g.
+// when a copy of an external library is vendored in the repository.
+func (lang *ccLanguage) warnIndexConflict(includePath string, localDeps, indexedDeps []label.Label, precedence indexPrecedence) {
+	if maps.Equal(collections.ToSet(localDeps), collections.ToSet(indexedDeps)) || lang.reportedIndexConflicts.Contains(includePath) {
+		return
+	}
+	lang.reportedIndexConflicts.Add(includePath)
+	used, ignored := localDeps, indexedDeps
+	if precedence == indexPrecedence_index {
+		used, ignored = indexedDeps, localDeps
+	}
+	log.Printf("gazelle_cc: header %q is provided by rules %v defined in the repository and by %v defined in cc_indexfile, using %v and ignoring %v. "+
+		"Set `# gazelle:%v` to select which one should be preferred",
+		includePath, localDeps, indexedDeps, used, ignored, cc_index_precedence)
+}
+
+// Collects the outcome of each strategy attempted by resolveImportSpec. A nil
+// trace ignores all the steps, it's used when tracing is disabled.
+type resolveTrace struct {
+	from       label.Label
+	importSpec resolve.ImportSpec
+	include    ccInclude
+	steps      []string
+}
+
+func (t *resolveTrace) step(strategy, format string, args ...any) {
+	if t == nil {
+		return
+	}
+	t.steps = append(t.steps, fmt.Sprintf("%v: %v", strategy, fmt.Sprintf(format, args...)))
+}
+
+// Logs the collected steps together with the final result of the resolution,
+// which is returned unchanged.
+func (t *resolveTrace) log(resolved label.Label, err error) (label.Label, error) {
+	if t == nil {
+		return resolved, err
+	}
+	outcome := fmt.Sprintf("resolved to %v", resolved)
+	if err != nil {
+		outcome = fmt.Sprintf("failed: %v", err)
+	}
+	log.Printf("gazelle_cc: %v: resolving %q (%v)\n  %v\n  => %v", t.from, t.importSpec.Imp, t.include, strings.Join(t.steps, "\n  "), outcome)
+	return resolved, err
 }
 
 type platformDepsBuilder struct {
@@ -338,6 +823,25 @@ func (b *platformDepsBuilder) addConstrained(condition label.Label, dependency l
 	deps.Add(dependency)
 }
 
+// Moves the dependency together with its platform constraints to the other builder.
+func (b *platformDepsBuilder) moveTo(other *platformDepsBuilder, dependency label.Label) {
+	delete(b.all, dependency)
+	if b.generic.Contains(dependency) {
+		delete(b.generic, dependency)
+		other.addGeneric(dependency)
+	}
+	for condition, deps := range b.constrained {
+		if !deps.Contains(dependency) {
+			continue
+		}
+		delete(deps, dependency)
+		if len(deps) == 0 {
+			delete(b.constrained, condition)
+		}
+		other.addConstrained(condition, dependency)
+	}
+}
+
 // Pseudo-label for Bazel select() function, considered to match if no other
 // condition matches.
 var defaultCondition = label.New("", "conditions", "default")
@@ -377,3 +881,55 @@ func (b *platformDepsBuilder) build() ccPlatformStringsExprs {
 
 	return newCcPlatformStringsExprs(b.generic, b.constrained)
 }
+
+// Returns the visibility of the rule, either explicitly defined or inherited
+// from package default_visibility. Returns false if visibility cannot be
+// determined statically, e.g. when defined using a select or a variable.
+func ruleVisibility(r *rule.Rule, buildFile *rule.File) ([]string, bool) {
+	if r.Attr("visibility") != nil {
+		visibility := r.AttrStrings("visibility")
+		return visibility, visibility != nil
+	}
+	for _, fileRule := range buildFile.Rules {
+		if fileRule.Kind() == "package" && fileRule.Attr("default_visibility") != nil {
+			visibility := fileRule.AttrStrings("default_visibility")
+			return visibility, visibility != nil
+		}
+	}
+	return []string{"//visibility:private"}, true
+}
+
+// Checks if a rule with given visibility is visible to the "from" rule. Rules
+// are always visible within the same package. Visibility specified using
+// package_group targets cannot be evaluated and is assumed to be visible.
+func isVisibleTo(visibility []string, target label.Label, from label.Label) bool {
+	if target.Repo == from.Repo && target.Pkg == from.Pkg {
+		return true
+	}
+	for _, value := range visibility {
+		visibilityLabel, err := label.Parse(value)
+		if err != nil {
+			return true
+		}
+		// Relative labels, e.g. :__subpackages__, refer to the package of the target
+		visibilityLabel = visibilityLabel.Abs(target.Repo, target.Pkg)
+		switch {
+		case visibilityLabel.Pkg == "visibility" && visibilityLabel.Name == "public":
+			return true
+		case visibilityLabel.Pkg == "visibility" && visibilityLabel.Name == "private":
+			continue
+		case visibilityLabel.Name == "__pkg__":
+			if from.Pkg == visibilityLabel.Pkg {
+				return true
+			}
+		case visibilityLabel.Name == "__subpackages__":
+			if pathtools.HasPrefix(from.Pkg, visibilityLabel.Pkg) {
+				return true
+			}
+		default:
+			// Possibly a package_group
+			return true
+		}
+	}
+	return false
+}
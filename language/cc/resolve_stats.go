@@ -0,0 +1,60 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Source of the label an include was resolved to, counted in resolveStats
+type resolutionSource int
+
+const (
+	// Rules of the repository registered in Imports, including case-insensitive and ancestor glob matches
+	resolvedViaRuleIndex resolutionSource = iota
+	// Index files defined using gazelle:cc_indexfile or the embedded index
+	resolvedViaDepIndex
+	// Builtin index of the Bazel Central Registry modules
+	resolvedViaBzlmod
+	// gazelle:resolve, gazelle:cc_resolve_file or gazelle:cc_include_prefix_dep
+	resolvedViaOverride
+	// None of the strategies found a library providing the header
+	unresolvedInclude
+)
+
+// Counts of includes resolved by each source during the Gazelle run, printed
+// at the end when enabled by -cc_resolve_stats flag. Safe for concurrent use.
+type resolveStats struct {
+	counts [unresolvedInclude + 1]atomic.Int64
+}
+
+func (s *resolveStats) add(source resolutionSource) {
+	s.counts[source].Add(1)
+}
+
+func (s *resolveStats) count(source resolutionSource) int64 {
+	return s.counts[source].Load()
+}
+
+// One-line summary of the counts, giving a quick sense of the index coverage
+func (s *resolveStats) String() string {
+	return fmt.Sprintf("%d via rule index, %d via dependency index, %d via bzlmod, %d via override, %d unresolved",
+		s.count(resolvedViaRuleIndex),
+		s.count(resolvedViaDepIndex),
+		s.count(resolvedViaBzlmod),
+		s.count(resolvedViaOverride),
+		s.count(unresolvedInclude))
+}
@@ -15,18 +15,36 @@
 package cc
 
 import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/index"
+	"github.com/bazelbuild/bazel-gazelle/config"
 	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/resolve"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/bazelbuild/buildtools/build"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestPlatformDepsBuilder(t *testing.T) {
 	lib_a := label.New("", "pkg", "lib_a")
 	lib_b := label.New("", "pkg", "lib_b")
+	lib_c := label.New("", "pkg", "lib_c")
 	platform := label.New("", "platforms", "linux")
+	otherPlatform := label.New("", "platforms", "macos")
 	type constrained struct{ cond, dep label.Label }
 
 	testCases := []struct {
@@ -92,6 +110,29 @@ select({
         "//pkg:lib_a",
     ],
     "//conditions:default": [],
+})
+			`,
+		},
+		{
+			description: "sorted_conditions_and_deps",
+			genericDeps: []label.Label{lib_c, lib_a},
+			constrainedDeps: []constrained{
+				{cond: otherPlatform, dep: lib_b},
+				{cond: platform, dep: lib_b},
+				{cond: otherPlatform, dep: lib_a},
+			},
+			expectedExpr: `
+[
+    "//pkg:lib_a",
+    "//pkg:lib_c",
+] + select({
+    "//platforms:linux": [
+        "//pkg:lib_b",
+    ],
+    "//platforms:macos": [
+        "//pkg:lib_b",
+    ],
+    "//conditions:default": [],
 })
 			`,
 		},
@@ -116,3 +157,1086 @@ select({
 		})
 	}
 }
+
+func TestIsVisibleTo(t *testing.T) {
+	target := label.New("", "lib", "target")
+	testCases := []struct {
+		visibility []string
+		from       label.Label
+		expected   bool
+	}{
+		{visibility: []string{"//visibility:public"}, from: label.New("", "app", "main"), expected: true},
+		{visibility: []string{"//visibility:private"}, from: label.New("", "app", "main"), expected: false},
+		{visibility: []string{"//visibility:private"}, from: label.New("", "lib", "other"), expected: true},
+		{visibility: []string{"//app:__pkg__"}, from: label.New("", "app", "main"), expected: true},
+		{visibility: []string{"//app:__pkg__"}, from: label.New("", "app/sub", "main"), expected: false},
+		{visibility: []string{"//lib:__subpackages__"}, from: label.New("", "lib/sub", "main"), expected: true},
+		{visibility: []string{"//lib:__subpackages__"}, from: label.New("", "library", "main"), expected: false},
+		{visibility: []string{"//:__subpackages__"}, from: label.New("", "app", "main"), expected: true},
+		{visibility: []string{"//other:__pkg__", "//app:__pkg__"}, from: label.New("", "app", "main"), expected: true},
+		// Relative to the package of the target
+		{visibility: []string{":__subpackages__"}, from: label.New("", "lib/sub", "main"), expected: true},
+		{visibility: []string{":__subpackages__"}, from: label.New("", "app", "main"), expected: false},
+		{visibility: []string{":__pkg__"}, from: label.New("", "app", "main"), expected: false},
+		// Package groups cannot be evaluated
+		{visibility: []string{"//groups:friends"}, from: label.New("", "app", "main"), expected: true},
+		{visibility: nil, from: label.New("", "app", "main"), expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v from %v", tc.visibility, tc.from), func(t *testing.T) {
+			assert.Equal(t, tc.expected, isVisibleTo(tc.visibility, target, tc.from))
+		})
+	}
+}
+
+func TestSystemLinkopts(t *testing.T) {
+	includes := []ccInclude{
+		{path: "cmath", isSystemInclude: true},
+		{path: "thread", isSystemInclude: true},
+		{path: "vector", isSystemInclude: true},
+		{path: "future", isSystemInclude: true},
+		// Only system includes are taken into account
+		{path: "atomic", isSystemInclude: false},
+	}
+	assert.Equal(t, []string{"-lm", "-pthread"}, systemLinkopts(includes, defaultSystemHeaderLinkopts))
+	assert.Empty(t, systemLinkopts(includes, map[string][]string{}))
+}
+
+func TestAddSystemLinkopts(t *testing.T) {
+	testCases := []struct {
+		name     string
+		existing any
+		linkopts []string
+		expected []string
+	}{
+		{name: "no linkopts", existing: nil, linkopts: nil, expected: nil},
+		{name: "new linkopts", existing: nil, linkopts: []string{"-pthread"}, expected: []string{"-pthread"}},
+		{name: "merged linkopts", existing: []string{"-Wl,--as-needed", "-pthread"}, linkopts: []string{"-pthread", "-ldl"}, expected: []string{"-Wl,--as-needed", "-pthread", "-ldl"}},
+		{name: "non-literal linkopts", existing: &build.Ident{Name: "LINKOPTS"}, linkopts: []string{"-pthread"}, expected: nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rule.NewRule("cc_binary", "main")
+			if tc.existing != nil {
+				r.SetAttr("linkopts", tc.existing)
+			}
+			addSystemLinkopts(r, tc.linkopts)
+			assert.Equal(t, tc.expected, r.AttrStrings("linkopts"))
+		})
+	}
+}
+
+// Creates the configuration using the given ccConfig and the rule index of all
+// rules defined in the given build files, used to resolve includes in tests.
+func newResolveTestEnv(conf *ccConfig, buildFiles ...*rule.File) (*config.Config, *resolve.RuleIndex, *ccLanguage) {
+	lang := NewLanguage().(*ccLanguage)
+	c := config.New()
+	(&resolve.Configurer{}).RegisterFlags(flag.NewFlagSet("test", flag.ContinueOnError), "", c)
+	c.Exts[languageName] = conf
+	ix := resolve.NewRuleIndex(func(r *rule.Rule, pkgRel string) resolve.Resolver { return lang })
+	for _, buildFile := range buildFiles {
+		for _, r := range buildFile.Rules {
+			ix.AddRule(c, r, buildFile)
+		}
+	}
+	ix.Finish()
+	return c, ix, lang
+}
+
+func TestResolveSingleIncludeWithAlias(t *testing.T) {
+	eigen := label.New("eigen", "", "eigen")
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	conf.dependencyIndexes = []index.DependencyIndex{{"Eigen/Core": {eigen}}}
+	c, ix, lang := newResolveTestEnv(conf)
+	r := rule.NewRule("cc_library", "app")
+
+	include := ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "eigen3/Eigen/Core", isSystemInclude: true}
+	_, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.ErrorIs(t, err, errUnresolved)
+
+	conf.includeAliases = []includeAlias{{from: "eigen3"}}
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, eigen, resolved)
+
+	// Quoted includes relative to the source are resolved before applying aliases
+	include.isSystemInclude = false
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, eigen, resolved)
+}
+
+func TestResolveSingleIncludeWithStrippedIncludeRoot(t *testing.T) {
+	from := label.New("", "app", "app")
+	foo := label.New("", "lib", "foo")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+
+	buildFile := rule.EmptyFile("lib/BUILD.bazel", "lib")
+	lib := rule.NewRule("cc_library", "foo")
+	lib.SetAttr("hdrs", []string{"foo.h"})
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	r := rule.NewRule("cc_library", "app")
+
+	for _, include := range []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "myrepo/lib/foo.h"},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "myrepo/lib/foo.h", isSystemInclude: true},
+	} {
+		conf.stripIncludeRoot = ""
+		_, err := lang.resolveSingleInclude(c, ix, r, from, include)
+		assert.ErrorIs(t, err, errUnresolved)
+
+		conf.stripIncludeRoot = "myrepo"
+		resolved, err := lang.resolveSingleInclude(c, ix, r, from, include)
+		assert.NoError(t, err)
+		assert.Equal(t, foo, resolved)
+	}
+
+	// The prefix is matched on whole path segments
+	_, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 3, path: "myrepo_v2/lib/foo.h"})
+	assert.ErrorIs(t, err, errUnresolved)
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 4, path: "myrepo"})
+	assert.ErrorIs(t, err, errUnresolved)
+
+	// Includes resolved using the unmodified path take precedence
+	vendored := label.New("", "third_party/myrepo", "myrepo")
+	conf.dependencyIndexes = []index.DependencyIndex{{"myrepo/lib/foo.h": {vendored}}}
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 5, path: "myrepo/lib/foo.h"})
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, resolved)
+}
+
+func TestResolveSingleIncludeWithParentDirectory(t *testing.T) {
+	from := label.New("", "a/b", "b")
+	util := label.New("", "a/common", "util")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+
+	buildFile := rule.EmptyFile("a/common/BUILD.bazel", "a/common")
+	lib := rule.NewRule("cc_library", "util")
+	lib.SetAttr("hdrs", []string{"util.h"})
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	r := rule.NewRule("cc_library", "b")
+
+	for _, includePath := range []string{"../common/util.h", "../../a/common/util.h", "./../common/../common/util.h"} {
+		resolved, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "a/b/b.cc", lineNumber: 1, path: includePath})
+		assert.NoError(t, err, includePath)
+		assert.Equal(t, util, resolved, includePath)
+	}
+
+	// Paths leaving the repository root are never resolved, even if indexed as-is
+	escaping := "../../../common/util.h"
+	conf.dependencyIndexes = []index.DependencyIndex{{escaping: {label.New("", "common", "util")}}}
+	_, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "a/b/b.cc", lineNumber: 2, path: escaping})
+	assert.ErrorIs(t, err, errUnresolved)
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "a/b/b.cc", lineNumber: 3, path: escaping, isSystemInclude: true})
+	assert.ErrorIs(t, err, errUnresolved)
+	conf.stripIncludeRoot = "a"
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "a/b/b.cc", lineNumber: 4, path: "a/" + escaping})
+	assert.ErrorIs(t, err, errUnresolved)
+}
+
+func TestResolveIncludeToCcImport(t *testing.T) {
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+
+	buildFile := rule.EmptyFile("prebuilt/BUILD.bazel", "prebuilt")
+	prebuilt := rule.NewRule("cc_import", "zstd")
+	prebuilt.SetAttr("hdrs", []string{"zstd.h"})
+	prebuilt.SetAttr("static_library", "libzstd.a")
+	prebuilt.SetAttr("visibility", []string{"//visibility:public"})
+	prebuilt.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+
+	include := ccInclude{sourceFile: "app/app.h", lineNumber: 1, path: "prebuilt/zstd.h"}
+	testCases := []struct {
+		name                       string
+		kind                       string
+		imports                    ccImports
+		useImplementationDeps      bool
+		expectedDeps               []string
+		expectedImplementationDeps []string
+	}{
+		{
+			name:         "included from header",
+			kind:         "cc_library",
+			imports:      ccImports{hdrIncludes: []ccInclude{include}},
+			expectedDeps: []string{"//prebuilt:zstd"},
+		},
+		{
+			name:                       "included from source",
+			kind:                       "cc_library",
+			imports:                    ccImports{srcIncludes: []ccInclude{include}},
+			useImplementationDeps:      true,
+			expectedImplementationDeps: []string{"//prebuilt:zstd"},
+		},
+		{
+			name:         "included from source without implementation_deps",
+			kind:         "cc_library",
+			imports:      ccImports{srcIncludes: []ccInclude{include}},
+			expectedDeps: []string{"//prebuilt:zstd"},
+		},
+		{
+			name:                  "included from binary",
+			kind:                  "cc_binary",
+			imports:               ccImports{srcIncludes: []ccInclude{include}},
+			useImplementationDeps: true,
+			expectedDeps:          []string{"//prebuilt:zstd"},
+		},
+		{
+			name:                  "included from other cc_import",
+			kind:                  "cc_import",
+			imports:               ccImports{hdrIncludes: []ccInclude{include}},
+			useImplementationDeps: true,
+			expectedDeps:          []string{"//prebuilt:zstd"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.useImplementationDeps = tc.useImplementationDeps
+			r := rule.NewRule(tc.kind, from.Name)
+			lang.Resolve(c, ix, nil, r, tc.imports, from)
+			assert.Equal(t, tc.expectedDeps, r.AttrStrings("deps"))
+			assert.Equal(t, tc.expectedImplementationDeps, r.AttrStrings("implementation_deps"))
+		})
+	}
+}
+
+func TestResolvePublicDep(t *testing.T) {
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	conf.useImplementationDeps = true
+
+	buildFile := rule.EmptyFile("prebuilt/BUILD.bazel", "prebuilt")
+	for _, name := range []string{"zstd", "lz4"} {
+		prebuilt := rule.NewRule("cc_import", name)
+		prebuilt.SetAttr("hdrs", []string{name + ".h"})
+		prebuilt.SetAttr("static_library", "lib"+name+".a")
+		prebuilt.SetAttr("visibility", []string{"//visibility:public"})
+		prebuilt.Insert(buildFile)
+	}
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+
+	imports := ccImports{srcIncludes: []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "prebuilt/zstd.h"},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "prebuilt/lz4.h"},
+	}}
+	testCases := []struct {
+		name                       string
+		publicDepIncludes          []string
+		publicDepLabels            []label.Label
+		expectedDeps               []string
+		expectedImplementationDeps []string
+	}{
+		{
+			name:                       "no public deps",
+			expectedImplementationDeps: []string{"//prebuilt:lz4", "//prebuilt:zstd"},
+		},
+		{
+			name:                       "forced by include",
+			publicDepIncludes:          []string{"prebuilt/zstd.h"},
+			expectedDeps:               []string{"//prebuilt:zstd"},
+			expectedImplementationDeps: []string{"//prebuilt:lz4"},
+		},
+		{
+			name:                       "forced by label",
+			publicDepLabels:            []label.Label{label.New("", "prebuilt", "lz4")},
+			expectedDeps:               []string{"//prebuilt:lz4"},
+			expectedImplementationDeps: []string{"//prebuilt:zstd"},
+		},
+		{
+			name:              "forced by include and label",
+			publicDepIncludes: []string{"prebuilt/zstd.h"},
+			publicDepLabels:   []label.Label{label.New("", "prebuilt", "lz4")},
+			expectedDeps:      []string{"//prebuilt:lz4", "//prebuilt:zstd"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conf.publicDepIncludes = tc.publicDepIncludes
+			conf.publicDepLabels = tc.publicDepLabels
+			r := rule.NewRule("cc_library", from.Name)
+			lang.Resolve(c, ix, nil, r, imports, from)
+			assert.Equal(t, tc.expectedDeps, r.AttrStrings("deps"))
+			assert.Equal(t, tc.expectedImplementationDeps, r.AttrStrings("implementation_deps"))
+		})
+	}
+}
+
+func TestResolveSingleIncludeWithPrefixDep(t *testing.T) {
+	zlib := label.New("zlib", "", "zlib")
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	conf.includePrefixDeps = []includePrefixDep{{prefix: "zlib", dep: zlib}}
+	c, ix, lang := newResolveTestEnv(conf)
+	r := rule.NewRule("cc_library", "app")
+
+	testCases := []struct {
+		description string
+		include     ccInclude
+		expected    label.Label
+		expectedErr error
+	}{
+		{
+			description: "matching prefix",
+			include:     ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib/contrib/minizip/zip.h"},
+			expected:    zlib,
+		},
+		{
+			description: "matching prefix of system include",
+			include:     ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib/zlib.h", isSystemInclude: true},
+			expected:    zlib,
+		},
+		{
+			description: "non-matching sibling",
+			include:     ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib-ng/zlib.h"},
+			expected:    label.NoLabel,
+			expectedErr: errUnresolved,
+		},
+		{
+			description: "prefix itself",
+			include:     ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib", isSystemInclude: true},
+			expected:    label.NoLabel,
+			expectedErr: errUnresolved,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			resolved, err := lang.resolveSingleInclude(c, ix, r, from, tc.include)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.expected, resolved)
+		})
+	}
+
+	// Indexed headers take precedence over the prefix mapping
+	vendored := label.New("", "third_party/zlib", "zlib")
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib/zlib.h": {vendored}}}
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib/zlib.h"})
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, resolved)
+}
+
+func TestResolveSingleIncludeWithEmbeddedIndex(t *testing.T) {
+	embedded := label.New("zlib", "", "zlib")
+	local := label.New("", "third_party/zlib", "zlib")
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	c, ix, lang := newResolveTestEnv(conf)
+	lang.embeddedIndex = index.DependencyIndex{"zlib.h": {embedded}}
+	r := rule.NewRule("cc_library", "app")
+	include := ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true}
+
+	// Resolved using the embedded index when no local index provides the header
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, embedded, resolved)
+
+	// Local indexes take precedence over the embedded index
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib.h": {local}}}
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, local, resolved)
+
+	// Embedded index is not used when disabled
+	conf.dependencyIndexes = nil
+	conf.useEmbeddedIndex = false
+	_, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.ErrorIs(t, err, errUnresolved)
+}
+
+func TestResolveSingleIncludeCaseInsensitive(t *testing.T) {
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+
+	buildFile := rule.EmptyFile("lib/BUILD.bazel", "lib")
+	lib := rule.NewRule("cc_library", "foo")
+	lib.SetAttr("hdrs", []string{"foo.h"})
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	r := rule.NewRule("cc_library", "app")
+	include := ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "lib/Foo.h"}
+
+	// Case-sensitive by default
+	_, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.ErrorIs(t, err, errUnresolved)
+
+	conf.caseInsensitiveIncludes = true
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, label.New("", "lib", "foo"), resolved)
+
+	// Self-includes are still detected
+	_, err = lang.resolveSingleInclude(c, ix, lib, label.New("", "lib", "foo"), ccInclude{sourceFile: "lib/foo.cc", lineNumber: 1, path: "Foo.h"})
+	assert.ErrorIs(t, err, errSelfImport)
+
+	// Rules found using multiple spellings are listed once
+	buildFile = rule.EmptyFile("lib/BUILD.bazel", "lib")
+	for name, hdrs := range map[string][]string{"foo": {"foo.h", "FOO.h"}, "bar": {"fOo.h"}} {
+		lib := rule.NewRule("cc_library", name)
+		lib.SetAttr("hdrs", hdrs)
+		lib.Insert(buildFile)
+	}
+	conf.ambiguousDepsMode = ambiguousDepsMode_todo
+	c, ix, lang = newResolveTestEnv(conf, buildFile)
+	_, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`# TODO: "lib/Foo.h" is provided by multiple targets, add one of them to deps: //lib:bar, //lib:foo`,
+	}, r.Comments())
+}
+
+func TestResolveImportSpecTrace(t *testing.T) {
+	local := label.New("", "third_party/zlib", "zlib")
+	from := label.New("", "app", "app")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+	conf.traceResolve = true
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib.h": {local}}}
+	c, ix, lang := newResolveTestEnv(conf)
+	r := rule.NewRule("cc_library", "app")
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	resolved, err := lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: "zlib.h"},
+		ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true})
+	assert.NoError(t, err)
+	assert.Equal(t, local, resolved)
+	trace := output.String()
+	assert.Contains(t, trace, fmt.Sprintf(`%v: resolving "zlib.h"`, from))
+	assert.Contains(t, trace, fmt.Sprintf("resolve override: no match\n  rule index: no match\n  cc_indexfile #1: found [%v]\n  => resolved to %v", local, local))
+
+	output.Reset()
+	_, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: "missing.h"},
+		ccInclude{sourceFile: "app/app.cc", lineNumber: 2, path: "missing.h"})
+	assert.ErrorIs(t, err, errUnresolved)
+	trace = output.String()
+	assert.Contains(t, trace, fmt.Sprintf(`%v: resolving "missing.h"`, from))
+	assert.Contains(t, trace, "cc_indexfile #1: no match\n  embedded index: disabled\n  builtin bzlmod index: disabled\n  cc_include_prefix_dep: no match\n  => failed: ")
+
+	// Nothing is logged when tracing is disabled
+	output.Reset()
+	conf.traceResolve = false
+	_, err = lang.resolveImportSpec(c, ix, r, from, resolve.ImportSpec{Lang: languageName, Imp: "missing.h"},
+		ccInclude{sourceFile: "app/app.cc", lineNumber: 2, path: "missing.h"})
+	assert.ErrorIs(t, err, errUnresolved)
+	assert.Empty(t, output.String())
+}
+
+func TestResolveStats(t *testing.T) {
+	from := label.New("", "app", "app")
+	local := label.New("", "lib", "lib")
+	indexed := label.New("zlib", "", "zlib")
+
+	conf := newCcConfig()
+	conf.useEmbeddedIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	conf.ambiguousDepsMode = ambiguousDepsMode_warn
+	conf.dependencyIndexes = []index.DependencyIndex{{
+		"zlib.h":  {indexed},
+		"alloc.h": {label.New("", "alloc", "impl"), label.New("", "alloc", "mock")},
+	}}
+	conf.resolveOverrides = map[string]label.Label{"override.h": label.New("", "third_party", "override")}
+
+	buildFile := rule.EmptyFile("lib/BUILD.bazel", "lib")
+	lib := rule.NewRule("cc_library", "lib")
+	lib.SetAttr("hdrs", []string{"lib.h"})
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	c.ModuleToApparentName = func(module string) string {
+		if module == "abseil-cpp" {
+			return "" // bazel_dep is missing
+		}
+		return module
+	}
+	lang.bzlmodBuiltInIndex = ccDependencyIndex{
+		"fmt/core.h":         label.New("fmt", "", "fmt"),
+		"absl/strings/str.h": label.New("abseil-cpp", "absl/strings", "strings"),
+	}
+	r := rule.NewRule("cc_library", "app")
+
+	includes := []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "lib/lib.h"},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "zlib.h", isSystemInclude: true},
+		{sourceFile: "app/app.cc", lineNumber: 3, path: "fmt/core.h", isSystemInclude: true},
+		{sourceFile: "app/app.cc", lineNumber: 4, path: "override.h"},
+		{sourceFile: "app/app.cc", lineNumber: 5, path: "missing.h"},
+		{sourceFile: "app/app.cc", lineNumber: 6, path: "other/missing.h"},
+		// Resolved using the path relative to the source file after trying the repository-root relative one
+		{sourceFile: "lib/lib.cc", lineNumber: 1, path: "../lib/lib.h"},
+	}
+	for _, include := range includes {
+		_, err := lang.resolveSingleInclude(c, ix, r, from, include)
+		if !errors.Is(err, errUnresolved) {
+			assert.NoError(t, err, include)
+		}
+	}
+	// Self-imports and includes not added as dependencies are not counted
+	_, err := lang.resolveSingleInclude(c, ix, lib, local, ccInclude{sourceFile: "lib/lib.cc", lineNumber: 2, path: "lib.h"})
+	assert.ErrorIs(t, err, errSelfImport)
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 7, path: "alloc.h", isSystemInclude: true})
+	assert.ErrorIs(t, err, errAmbiguousImport)
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 8, path: "absl/strings/str.h", isSystemInclude: true})
+	assert.ErrorIs(t, err, errMissingModuleDependency)
+	conf.ambiguousDepsMode = ambiguousDepsMode_todo
+	_, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 9, path: "alloc.h", isSystemInclude: true})
+	assert.NoError(t, err)
+
+	assert.Equal(t, int64(2), lang.resolveStats.count(resolvedViaRuleIndex))
+	assert.Equal(t, int64(1), lang.resolveStats.count(resolvedViaDepIndex))
+	assert.Equal(t, int64(1), lang.resolveStats.count(resolvedViaBzlmod))
+	assert.Equal(t, int64(1), lang.resolveStats.count(resolvedViaOverride))
+	assert.Equal(t, int64(2), lang.resolveStats.count(unresolvedInclude))
+	assert.Equal(t, "2 via rule index, 1 via dependency index, 1 via bzlmod, 1 via override, 2 unresolved", lang.resolveStats.String())
+}
+
+func TestResolveSingleIncludeIndexConflict(t *testing.T) {
+	from := label.New("", "app", "app")
+	vendored := label.New("", "third_party/zlib", "zlib")
+	indexed := label.New("zlib", "", "zlib")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib.h": {indexed}}}
+
+	buildFile := rule.EmptyFile("third_party/zlib/BUILD.bazel", "third_party/zlib")
+	lib := rule.NewRule("cc_library", "zlib")
+	lib.SetAttr("hdrs", []string{"zlib.h"})
+	lib.SetAttr("strip_include_prefix", "/third_party/zlib")
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	r := rule.NewRule("cc_library", "app")
+	include := ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	// Rules defined in the repository are preferred by default
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, resolved)
+	assert.Contains(t, output.String(), `header "zlib.h" is provided by rules`)
+
+	// Conflict is reported only once
+	output.Reset()
+	conf.indexPrecedence = indexPrecedence_index
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, indexed, resolved)
+	assert.Empty(t, output.String())
+
+	// No conflict if both resolve to the same label
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib.h": {vendored}}}
+	lang.reportedIndexConflicts = make(collections.Set[string])
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, include)
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, resolved)
+	assert.Empty(t, output.String())
+}
+
+func TestResolveSingleIncludeWithResolveOverrides(t *testing.T) {
+	from := label.New("", "app", "app")
+	vendored := label.New("", "third_party/zlib", "zlib")
+	indexed := label.New("zlib", "", "zlib")
+	override := label.New("", "third_party/zlib", "zlib_patched")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.dependencyIndexes = []index.DependencyIndex{{"zlib.h": {indexed}, "zconf.h": {indexed}}}
+	conf.indexPrecedence = indexPrecedence_index
+	conf.resolveOverrides = map[string]label.Label{"zlib.h": override}
+
+	buildFile := rule.EmptyFile("third_party/zlib/BUILD.bazel", "third_party/zlib")
+	lib := rule.NewRule("cc_library", "zlib")
+	lib.SetAttr("hdrs", []string{"zlib.h"})
+	lib.SetAttr("strip_include_prefix", "/third_party/zlib")
+	lib.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+	r := rule.NewRule("cc_library", "app")
+
+	// Overrides are preferred over both rules defined in the repository and indexes
+	resolved, err := lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true})
+	assert.NoError(t, err)
+	assert.Equal(t, override, resolved)
+
+	// The rule defined in the repository loses to the override even if it takes precedence over indexes
+	conf.indexPrecedence = indexPrecedence_local
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true})
+	assert.NoError(t, err)
+	assert.Equal(t, override, resolved)
+	conf.resolveOverrides = nil
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "zlib.h", isSystemInclude: true})
+	assert.NoError(t, err)
+	assert.Equal(t, vendored, resolved)
+	conf.resolveOverrides = map[string]label.Label{"zlib.h": override}
+	conf.indexPrecedence = indexPrecedence_index
+
+	// Headers not listed in overrides are resolved using indexes
+	resolved, err = lang.resolveSingleInclude(c, ix, r, from, ccInclude{sourceFile: "app/app.cc", lineNumber: 2, path: "zconf.h", isSystemInclude: true})
+	assert.NoError(t, err)
+	assert.Equal(t, indexed, resolved)
+
+	// Self-imports are detected
+	_, err = lang.resolveSingleInclude(c, ix, r, override, ccInclude{sourceFile: "third_party/zlib/zlib.c", lineNumber: 1, path: "zlib.h", isSystemInclude: true})
+	assert.ErrorIs(t, err, errSelfImport)
+}
+
+func TestLoadResolveOverrides(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "overrides.json")
+	require.NoError(t, os.WriteFile(file, []byte(`{"zlib.h": "@zlib", "json/json.h": "//third_party/jsoncpp:json"}`), 0o644))
+	overrides, err := loadResolveOverrides(file)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]label.Label{
+		"zlib.h":      label.New("zlib", "", "zlib"),
+		"json/json.h": label.New("", "third_party/jsoncpp", "json"),
+	}, overrides)
+
+	require.NoError(t, os.WriteFile(file, []byte(`{"zlib.h": "@zlib//:invalid:label"}`), 0o644))
+	_, err = loadResolveOverrides(file)
+	assert.ErrorContains(t, err, `invalid label of "zlib.h"`)
+}
+
+func TestResolveIncludesSkipsMissingPackages(t *testing.T) {
+	from := label.New("", "app", "app")
+	existing := label.New("", "lib", "lib")
+	removed := label.New("", "removed", "removed")
+	external := label.New("zlib", "", "zlib")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.validateDeps = true
+	conf.dependencyIndexes = []index.DependencyIndex{{
+		"lib/lib.h":         {existing},
+		"removed/removed.h": {removed},
+		"zlib.h":            {external},
+	}}
+	c, ix, lang := newResolveTestEnv(conf)
+	c.RepoRoot = t.TempDir()
+	c.ValidBuildFileNames = []string{"BUILD.bazel", "BUILD"}
+	require.NoError(t, os.MkdirAll(filepath.Join(c.RepoRoot, "lib"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(c.RepoRoot, "lib", "BUILD.bazel"), nil, 0o644))
+	r := rule.NewRule("cc_library", "app")
+	includes := []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "lib/lib.h"},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "removed/removed.h"},
+		{sourceFile: "app/app.cc", lineNumber: 3, path: "zlib.h", isSystemInclude: true},
+	}
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	deps := lang.resolveIncludes(c, ix, r, from, includes, collections.Set[label.Label]{})
+	assert.ElementsMatch(t, []label.Label{existing, external}, slices.Collect(maps.Keys(deps.all)))
+	assert.Contains(t, output.String(), fmt.Sprintf("dependency %v refers to a package that does not exist", removed))
+
+	// Packages are not validated by default
+	conf.validateDeps = false
+	deps = lang.resolveIncludes(c, ix, r, from, includes, collections.Set[label.Label]{})
+	assert.ElementsMatch(t, []label.Label{existing, removed, external}, slices.Collect(maps.Keys(deps.all)))
+}
+
+func TestResolveIncludesOfPrecompiledHeader(t *testing.T) {
+	from := label.New("", "app", "app")
+	pch := label.New("", "pch", "pch")
+	util := label.New("", "util", "util")
+	logging := label.New("", "log", "log")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.dependencyIndexes = []index.DependencyIndex{{
+		"pch/pch.h":      {pch},
+		"util/strings.h": {util},
+		"log/pch.h":      {logging},
+		"log/log.h":      {logging},
+	}}
+	c, ix, lang := newResolveTestEnv(conf)
+	lang.precompiledHeaderIncludes["pch/pch.h"] = []ccInclude{
+		{sourceFile: "pch/pch.h", lineNumber: 1, path: "util/strings.h"},
+		// Nested precompiled headers are expanded once
+		{sourceFile: "pch/pch.h", lineNumber: 2, path: "pch.h"},
+	}
+	lang.precompiledHeaderIncludes["log/pch.h"] = []ccInclude{
+		{sourceFile: "log/pch.h", lineNumber: 1, path: "log.h"},
+		{sourceFile: "log/pch.h", lineNumber: 2, path: "pch/pch.h"},
+	}
+	r := rule.NewRule("cc_library", "app")
+
+	// Including the precompiled header pulls in the libraries it includes
+	includes := []ccInclude{{sourceFile: "app/app.cc", lineNumber: 1, path: "pch/pch.h"}}
+	deps := lang.resolveIncludes(c, ix, r, from, includes, collections.Set[label.Label]{})
+	assert.ElementsMatch(t, []label.Label{pch, util}, slices.Collect(maps.Keys(deps.all)))
+	assert.Len(t, includes, 1, "includes of the rule are not modified")
+
+	includes = []ccInclude{{sourceFile: "app/app.cc", lineNumber: 1, path: "log/pch.h"}}
+	deps = lang.resolveIncludes(c, ix, r, from, includes, collections.Set[label.Label]{})
+	assert.ElementsMatch(t, []label.Label{logging, pch, util}, slices.Collect(maps.Keys(deps.all)))
+
+	// Other headers are not expanded
+	includes = []ccInclude{{sourceFile: "app/app.cc", lineNumber: 1, path: "util/strings.h"}}
+	deps = lang.resolveIncludes(c, ix, r, from, includes, collections.Set[label.Label]{})
+	assert.ElementsMatch(t, []label.Label{util}, slices.Collect(maps.Keys(deps.all)))
+}
+
+func TestResolveIncludeViaIncludesAttribute(t *testing.T) {
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+
+	buildFile := rule.EmptyFile("third_party/foo/BUILD.bazel", "third_party/foo")
+	foo := rule.NewRule("cc_library", "foo")
+	foo.SetAttr("hdrs", []string{"include/ext/foo.h"})
+	foo.SetAttr("includes", []string{"include"})
+	foo.SetAttr("visibility", []string{"//visibility:public"})
+	foo.Insert(buildFile)
+	c, ix, lang := newResolveTestEnv(conf, buildFile)
+
+	testCases := []struct {
+		name         string
+		include      ccInclude
+		expectedDeps []string
+	}{
+		{
+			name:         "path relative to includes directory",
+			include:      ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "ext/foo.h"},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+		{
+			name:         "system include relative to includes directory",
+			include:      ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "ext/foo.h", isSystemInclude: true},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+		{
+			name:         "fully qualified path",
+			include:      ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "third_party/foo/include/ext/foo.h"},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+		{
+			name:    "path relative to a subdirectory of includes directory",
+			include: ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "foo.h"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := rule.NewRule("cc_binary", "app")
+			lang.Resolve(c, ix, nil, r, ccImports{srcIncludes: []ccInclude{tc.include}}, label.New("", "app", "app"))
+			assert.Equal(t, tc.expectedDeps, r.AttrStrings("deps"))
+		})
+	}
+}
+
+func TestResolveIncludeStylesOfIncludesExposedHeader(t *testing.T) {
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.useEmbeddedIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+
+	newBuildFile := func(pkg, name string, hdrs []string, includes []string) *rule.File {
+		buildFile := rule.EmptyFile(path.Join(pkg, "BUILD.bazel"), pkg)
+		r := rule.NewRule("cc_library", name)
+		r.SetAttr("hdrs", hdrs)
+		if len(includes) > 0 {
+			r.SetAttr("includes", includes)
+		}
+		r.SetAttr("visibility", []string{"//visibility:public"})
+		r.Insert(buildFile)
+		return buildFile
+	}
+	c, ix, lang := newResolveTestEnv(conf,
+		// Exposes "config/version.h" using includes, which Bazel passes as -isystem
+		newBuildFile("third_party/foo", "foo", []string{"include/config/version.h"}, []string{"include"}),
+		// Defines header of the same name next to the including source
+		newBuildFile("app", "config", []string{"config/version.h"}, nil),
+	)
+
+	testCases := []struct {
+		name         string
+		include      ccInclude
+		expectedDeps []string
+	}{
+		{
+			name:         "angle-bracket include of includes-exposed header",
+			include:      ccInclude{sourceFile: "tools/tool.cc", lineNumber: 1, path: "config/version.h", isSystemInclude: true},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+		{
+			name:         "quoted include of includes-exposed header",
+			include:      ccInclude{sourceFile: "tools/tool.cc", lineNumber: 1, path: "config/version.h"},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+		{
+			// Quoted includes are searched relative to the including file first
+			name:         "quoted include shadowed by header next to the source",
+			include:      ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "config/version.h"},
+			expectedDeps: []string{":config"},
+		},
+		{
+			// Angle-bracket includes are never searched relative to the including file
+			name:         "angle-bracket include not shadowed by header next to the source",
+			include:      ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: "config/version.h", isSystemInclude: true},
+			expectedDeps: []string{"//third_party/foo"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			from := label.New("", path.Dir(tc.include.sourceFile), "bin")
+			r := rule.NewRule("cc_binary", "bin")
+			lang.Resolve(c, ix, nil, r, ccImports{srcIncludes: []ccInclude{tc.include}}, from)
+			assert.Equal(t, tc.expectedDeps, r.AttrStrings("deps"))
+		})
+	}
+}
+
+func TestResolveAmbiguousDependencyTodo(t *testing.T) {
+	impl := label.New("", "alloc", "impl")
+	mock := label.New("", "alloc", "mock")
+	include := ccInclude{sourceFile: "app/main.cc", lineNumber: 1, path: "alloc/alloc.h"}
+	r := rule.NewRule("cc_binary", "main")
+
+	for range 2 {
+		dep, err := resolveAmbiguousDependency([]label.Label{impl, mock}, ambiguousDepsMode_todo, r, label.New("", "app", "main"), include)
+		assert.NoError(t, err)
+		assert.Equal(t, label.NoLabel, dep)
+	}
+	assert.Equal(t, []string{
+		`# TODO: "alloc/alloc.h" is provided by multiple targets, add one of them to deps: //alloc:impl, //alloc:mock`,
+	}, r.Comments())
+
+	// The candidate chosen manually is kept
+	r = rule.NewRule("cc_binary", "main")
+	r.SetPrivateAttr(ccExistingDepsKey, collections.SetOf(mock))
+	dep, err := resolveAmbiguousDependency([]label.Label{impl, mock}, ambiguousDepsMode_todo, r, label.New("", "app", "main"), include)
+	assert.NoError(t, err)
+	assert.Equal(t, mock, dep)
+	assert.Empty(t, r.Comments())
+}
+
+func TestResolveAddsGeneratedComment(t *testing.T) {
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.groupingMode = groupSourcesByUnit
+	c, ix, lang := newResolveTestEnv(conf)
+	from := label.New("", "app", "app")
+
+	r := rule.NewRule("cc_library", "app")
+	lang.Resolve(c, ix, nil, r, ccImports{}, from)
+	assert.Empty(t, r.Comments())
+
+	conf.generatedComment = true
+	r = rule.NewRule("cc_library", "app")
+	lang.Resolve(c, ix, nil, r, ccImports{}, from)
+	assert.Equal(t, []string{"# Generated by gazelle_cc (cc_group unit)"}, r.Comments())
+
+	// Only cc rules are annotated
+	alias := rule.NewRule("alias", "app_alias")
+	lang.Resolve(c, ix, nil, alias, ccImports{}, from)
+	assert.Empty(t, alias.Comments())
+}
+
+func TestIsManagedComment(t *testing.T) {
+	assert.True(t, isManagedComment("# Generated by gazelle_cc (cc_group unit)"))
+	assert.True(t, isManagedComment(`# TODO: "util.h" is provided by multiple targets, add one of them to deps: //lib:impl, //lib:mock`))
+	// Comments written by the user are never managed
+	assert.False(t, isManagedComment("# Generated by gazelle_cc (cc_group unit), then edited manually"))
+	assert.False(t, isManagedComment("# Generated by gazelle_cc at first"))
+	assert.False(t, isManagedComment(`# TODO: "util.h" is provided by multiple targets, add one of them to deps: pick the impl`))
+}
+
+func TestResolveSingleIncludeViaAncestorGlobLibrary(t *testing.T) {
+	from := label.New("", "app", "app")
+	generated := label.New("", "a/b", "generated")
+	all := label.New("", "a", "all")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	c, ix, lang := newResolveTestEnv(conf)
+	lang.buildFileDirRels.AddSlice([]string{"", "a", "a/b", "a/b/pkg", "app"})
+	lang.globHeaderLibraries["a/b"] = []globHeaderLibrary{
+		{label: generated, glob: rule.GlobValue{Patterns: []string{"**/*.h"}, Excludes: []string{"internal/**"}}},
+	}
+	lang.globHeaderLibraries["a"] = []globHeaderLibrary{
+		{label: all, glob: rule.GlobValue{Patterns: []string{"**/*.h"}}},
+	}
+	r := rule.NewRule("cc_library", "app")
+	resolveInclude := func(includePath string, from label.Label) (label.Label, error) {
+		include := ccInclude{sourceFile: "app/app.cc", lineNumber: 1, path: includePath, isSystemInclude: true}
+		return lang.resolveSingleInclude(c, ix, r, from, include)
+	}
+
+	// Opt-in
+	_, err := resolveInclude("a/b/c/foo.h", from)
+	assert.ErrorIs(t, err, errUnresolved)
+
+	conf.resolveAncestorGlobs = true
+	testCases := []struct {
+		includePath string
+		expected    label.Label
+	}{
+		// Nearest ancestor package owning the header
+		{includePath: "a/b/c/foo.h", expected: generated},
+		{includePath: "a/b/foo.h", expected: generated},
+		{includePath: "a/x/y/foo.h", expected: all},
+		// Globs don't match files of subpackages, or files excluded from the glob
+		{includePath: "a/b/pkg/foo.h", expected: label.NoLabel},
+		{includePath: "a/b/internal/foo.h", expected: label.NoLabel},
+		{includePath: "a/b/c/foo.inc", expected: label.NoLabel},
+		{includePath: "other/foo.h", expected: label.NoLabel},
+	}
+	for _, tc := range testCases {
+		resolved, err := resolveInclude(tc.includePath, from)
+		if tc.expected == label.NoLabel {
+			assert.ErrorIs(t, err, errUnresolved, "include: %v", tc.includePath)
+		} else {
+			assert.NoError(t, err, "include: %v", tc.includePath)
+		}
+		assert.Equal(t, tc.expected, resolved, "include: %v", tc.includePath)
+	}
+
+	_, err = resolveInclude("a/b/c/foo.h", generated)
+	assert.ErrorIs(t, err, errSelfImport)
+}
+
+func TestResolveRecordsDependencyCycles(t *testing.T) {
+	a := label.New("", "a", "a")
+	b := label.New("", "b", "b")
+	c1 := label.New("", "c", "c")
+	util := label.New("", "util", "util")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.detectDepCycles = true
+	// Includes between directories creating a cycle a -> b -> c -> a
+	conf.resolveOverrides = map[string]label.Label{"a/a.h": a, "b/b.h": b, "c/c.h": c1, "util/util.h": util}
+	c, ix, lang := newResolveTestEnv(conf)
+
+	resolveRule := func(from label.Label, hdrIncludes, srcIncludes []string) {
+		toIncludes := func(paths []string) []ccInclude {
+			return collections.MapSlice(paths, func(p string) ccInclude {
+				return ccInclude{sourceFile: path.Join(from.Pkg, from.Name+".cc"), lineNumber: 1, path: p, isSystemInclude: true}
+			})
+		}
+		r := rule.NewRule("cc_library", from.Name)
+		lang.Resolve(c, ix, nil, r, ccImports{hdrIncludes: toIncludes(hdrIncludes), srcIncludes: toIncludes(srcIncludes)}, from)
+	}
+	resolveRule(a, []string{"b/b.h"}, []string{"util/util.h"})
+	resolveRule(b, nil, []string{"c/c.h"})
+	resolveRule(c1, []string{"a/a.h", "util/util.h"}, nil)
+	resolveRule(util, nil, nil)
+
+	assert.Equal(t, map[label.Label][]label.Label{
+		a:    {b, util},
+		b:    {c1},
+		c1:   {a, util},
+		util: nil,
+	}, lang.resolvedDeps)
+	assert.Equal(t, [][]label.Label{{a, b, c1, a}}, findDependencyCycles(lang.resolvedDeps))
+
+	// Not recorded unless enabled
+	conf.detectDepCycles = false
+	resolveRule(label.New("", "d", "d"), []string{"a/a.h"}, nil)
+	assert.NotContains(t, lang.resolvedDeps, label.New("", "d", "d"))
+}
+
+func TestFindDependencyCycles(t *testing.T) {
+	a, b, c, d := label.New("", "a", "a"), label.New("", "b", "b"), label.New("", "c", "c"), label.New("", "d", "d")
+	external := label.New("ext", "", "ext")
+
+	assert.Empty(t, findDependencyCycles(map[label.Label][]label.Label{
+		a: {b, c, external},
+		b: {c},
+		c: nil,
+	}))
+	// Self-dependency and independent cycles, dependencies outside of the graph are ignored
+	assert.Equal(t, [][]label.Label{{a, a}, {b, c, b}, {b, c, d, b}}, findDependencyCycles(map[label.Label][]label.Label{
+		a: {a, external},
+		b: {c},
+		c: {b, d},
+		d: {b},
+	}))
+}
+
+func TestResolveSkipsAvailableHeaders(t *testing.T) {
+	from := label.New("", "app", "app")
+	zlib := label.New("zlib", "", "zlib")
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.unresolvedDepsMode = errorReportingMode_ignore
+	// Index providing a header of the platform SDK, e.g. generated from a vendored copy
+	conf.dependencyIndexes = []index.DependencyIndex{{
+		"windows.h": {label.New("mingw", "", "headers")},
+		"zlib.h":    {zlib},
+	}}
+	c, ix, lang := newResolveTestEnv(conf)
+
+	imports := ccImports{srcIncludes: []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "windows.h", isSystemInclude: true},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "zlib.h", isSystemInclude: true},
+	}}
+	r := rule.NewRule("cc_binary", from.Name)
+	lang.Resolve(c, ix, nil, r, imports, from)
+	assert.Equal(t, []string{"@zlib//:zlib"}, r.AttrStrings("deps"))
+
+	// Headers removed from the set are resolved again
+	conf.availableHeaders = conf.availableHeaders.Diff(collections.ToSet([]string{"windows.h"}))
+	r = rule.NewRule("cc_binary", from.Name)
+	lang.Resolve(c, ix, nil, r, imports, from)
+	assert.Equal(t, []string{"@mingw//:headers", "@zlib//:zlib"}, r.AttrStrings("deps"))
+}
+
+func TestResolveOverridesWinOverAvailableHeaders(t *testing.T) {
+	from := label.New("", "app", "app")
+	imports := ccImports{srcIncludes: []ccInclude{
+		{sourceFile: "app/app.cc", lineNumber: 1, path: "windows.h", isSystemInclude: true},
+		{sourceFile: "app/app.cc", lineNumber: 2, path: "pthread.h", isSystemInclude: true},
+	}}
+
+	conf := newCcConfig()
+	conf.useBuiltinBzlmodIndex = false
+	conf.resolveOverrides = map[string]label.Label{"pthread.h": label.New("pthreads-win32", "", "pthread")}
+	c, ix, lang := newResolveTestEnv(conf)
+	(&resolve.Configurer{}).Configure(c, "", &rule.File{Directives: []rule.Directive{
+		{Key: "resolve", Value: "cc windows.h @mingw//:windows"},
+	}})
+
+	r := rule.NewRule("cc_binary", from.Name)
+	lang.Resolve(c, ix, nil, r, imports, from)
+	assert.Equal(t, []string{"@mingw//:windows", "@pthreads-win32//:pthread"}, r.AttrStrings("deps"))
+}
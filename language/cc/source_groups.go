@@ -15,7 +15,9 @@
 package cc
 
 import (
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"maps"
 	"path"
 	"path/filepath"
@@ -23,6 +25,9 @@ import (
 	"strings"
 
 	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/internal/includepath"
+	"github.com/bazelbuild/bazel-gazelle/label"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 )
 
 // groupId represents a unique identifier for a group of source files
@@ -97,19 +102,27 @@ func (g *sourceGroups) renameOrMergeWith(current groupId, replacement groupId) b
 
 // Groups source files based on headers and their dependencies
 // Splits input sources into non-recursive groups based on dependencies tracked using include directives.
-// The function panics if any of input sources is not defined sourceInfos map.
+// Returns an error if the sources cannot be consistently split into groups.
 // Header (.h) and it's corresponding implemention (.cc) are always grouped together.
 // Source files without corresponding headers are assigned to single-element groups and can never become dependency of any other group.
 // Each source file is guaranteed to be assigned to exactly 1 group.
-func groupSourcesByUnits(rel, stripIncludePrefix, includePrefix string, fileInfos []fileInfo) sourceGroups {
+// Under sharedLibOnGroupsCycle mode the headers of groups forming a cycle are extracted into a shared group,
+// remaining sources of these groups are kept in their own groups depending on the shared one.
+func groupSourcesByUnits(rel, stripIncludePrefix, includePrefix string, fileInfos []fileInfo, cycleHandlingMode groupsCycleHandlingMode) (sourceGroups, error) {
 	graph := buildDependencyGraph(rel, stripIncludePrefix, includePrefix, fileInfos)
 	sccs := graph.findStronglyConnectedComponents()
-	groups := splitIntoSourceGroups(fileInfos, sccs, graph)
+	groups, err := splitIntoSourceGroups(fileInfos, sccs, graph, cycleHandlingMode)
+	if err != nil {
+		return nil, err
+	}
 	groups.resolveGroupDependencies(graph)
-	groups.sort()             // Ensure deterministic output
-	groups.sourceToGroupIds() // Consistency check
+	groups.sort() // Ensure deterministic output
+	// Consistency check
+	if _, err := groups.sourceToGroupIds(); err != nil {
+		return nil, err
+	}
 
-	return groups
+	return groups, nil
 }
 
 // represents a node in the dependency graph.
@@ -164,7 +177,7 @@ func buildDependencyGraph(rel, stripIncludePrefix, includePrefix string, fileInf
 				node.adjacency.Add(id)
 				continue
 			}
-			relInclude := path.Join(path.Dir(file.name), include.path)
+			relInclude := includepath.Normalize(path.Join(path.Dir(file.name), include.path))
 			if id, ok := includeToGroup[relInclude]; ok {
 				node.adjacency.Add(id)
 			}
@@ -224,50 +237,84 @@ func (graph *sourceDependencyGraph) findStronglyConnectedComponents() [][]groupI
 	return sccs
 }
 
+// Returns the name of the group extracting headers of cyclic groups, <name>_shared
+// or if it's already used by other sources, e.g. a_shared.h, followed by the first
+// free number suffix.
+func uniqueSharedGroupName(name groupId, graph sourceDependencyGraph, groups sourceGroups) groupId {
+	candidate := name + "_shared"
+	for i := 2; ; i++ {
+		_, inGraph := graph[candidate]
+		_, inGroups := groups[candidate]
+		if !inGraph && !inGroups {
+			return candidate
+		}
+		candidate = groupId(fmt.Sprintf("%v_shared_%d", name, i))
+	}
+}
+
 // Merges sources assigned to each componenet ([]groupId) into a sourceGrops
-// Panics if any groupId defined in fileGroups is not defined in graph
-func splitIntoSourceGroups(fileInfos []fileInfo, fileGroups [][]groupId, graph sourceDependencyGraph) sourceGroups {
+// Under sharedLibOnGroupsCycle mode components with multiple groups are split into a shared group of headers and groups of remaining sources instead.
+// Returns an error if any groupId defined in fileGroups is not defined in graph.
+func splitIntoSourceGroups(fileInfos []fileInfo, fileGroups [][]groupId, graph sourceDependencyGraph, cycleHandlingMode groupsCycleHandlingMode) (sourceGroups, error) {
 	nameToFileInfo := make(map[string]fileInfo, len(fileInfos))
 	for _, fi := range fileInfos {
 		nameToFileInfo[fi.name] = fi
 	}
+	toFileInfos := func(names []string) []fileInfo {
+		return collections.MapSlice(names, func(name string) fileInfo {
+			return nameToFileInfo[name]
+		})
+	}
 	groups := make(sourceGroups, len(fileGroups))
 
 	for _, sourcesGroup := range fileGroups {
 		var groupSources []string
 		for _, groupId := range sourcesGroup {
-			groupSources = append(groupSources, graph[groupId].sources...)
+			node, exists := graph[groupId]
+			if !exists {
+				return nil, fmt.Errorf("group %v is not defined in the dependency graph", groupId)
+			}
+			groupSources = append(groupSources, node.sources...)
+		}
+		if _, hdrs := partitionCSources(groupSources); len(sourcesGroup) > 1 && len(hdrs) > 0 && cycleHandlingMode == sharedLibOnGroupsCycle {
+			sharedGroupName := uniqueSharedGroupName(selectGroupName(hdrs), graph, groups)
+			groups[sharedGroupName] = &sourceGroup{sources: toFileInfos(hdrs), subGroups: sourcesGroup}
+			for _, groupId := range sourcesGroup {
+				if srcs, _ := partitionCSources(graph[groupId].sources); len(srcs) > 0 {
+					groups[groupId] = &sourceGroup{sources: toFileInfos(srcs)}
+				}
+			}
+			continue
 		}
 		groupName := selectGroupName(groupSources)
-		groupFileInfos := collections.MapSlice(groupSources, func(name string) fileInfo {
-			return nameToFileInfo[name]
-		})
-		groups[groupName] = &sourceGroup{sources: groupFileInfos}
+		groups[groupName] = &sourceGroup{sources: toFileInfos(groupSources)}
 		if len(sourcesGroup) > 1 { // Set subgroups only if multiple groups defined
 			groups[groupName].subGroups = sourcesGroup
 		}
 	}
-	return groups
+	return groups, nil
 }
 
 // Assigns to each source group a list of its direct dependencies (sourceGroup.dependsOn)
 func (groups *sourceGroups) resolveGroupDependencies(graph sourceDependencyGraph) {
-	headerToGroupId := make(map[string]groupId)
+	// Graph nodes might be split between multiple groups, dependency on node is a dependency on the group defining its headers
+	nodeToGroupId := make(map[groupId]groupId)
 	for id, group := range *groups {
 		for _, file := range group.sources {
-			if fileNameIsHeader(file.name) {
-				headerToGroupId[file.name] = id
+			node := fileNameToGroupId(file.name)
+			if _, exists := nodeToGroupId[node]; !exists || fileNameIsHeader(file.name) {
+				nodeToGroupId[node] = id
 			}
 		}
 	}
 
-	for _, group := range *groups {
+	for id, group := range *groups {
 		dependencies := make(map[groupId]bool)
 		for _, file := range group.sources {
-			depId := fileNameToGroupId(file.name)
-			for dep := range graph[depId].adjacency {
-				if dep != depId {
-					dependencies[dep] = true
+			node := fileNameToGroupId(file.name)
+			for dep := range collections.SetOf(node).Join(graph[node].adjacency) {
+				if depGroupId := nodeToGroupId[dep]; depGroupId != id {
+					dependencies[depGroupId] = true
 				}
 			}
 		}
@@ -277,19 +324,183 @@ func (groups *sourceGroups) resolveGroupDependencies(graph sourceDependencyGraph
 	}
 }
 
+// Merges groups with less than minSize sources into the only group depending on
+// them, so that loosely coupled directories don't result in many tiny rules.
+// Merging is repeated as long as possible, so small chains of groups are
+// merged upwards. Groups used by multiple other groups are never merged, as it
+// could introduce a cycle. Since groups don't form cycles, merging a group into
+// its only dependant never does.
+func (groups *sourceGroups) mergeSmallGroups(minSize int) {
+	subGroupsOf := func(id groupId) []groupId {
+		if subGroups := (*groups)[id].subGroups; len(subGroups) > 0 {
+			return subGroups
+		}
+		return []groupId{id}
+	}
+
+	for merged := true; merged; {
+		merged = false
+		dependants := make(map[groupId][]groupId)
+		for _, id := range groups.groupIds() {
+			for _, dep := range (*groups)[id].dependsOn {
+				dependants[dep] = append(dependants[dep], id)
+			}
+		}
+		for _, id := range groups.groupIds() {
+			group := (*groups)[id]
+			if len(group.sources) >= minSize || len(dependants[id]) != 1 {
+				continue
+			}
+			target := dependants[id][0]
+			targetGroup := (*groups)[target]
+			targetGroup.subGroups = slices.Concat(subGroupsOf(target), subGroupsOf(id))
+			targetGroup.sources = slices.Concat(targetGroup.sources, group.sources)
+			targetGroup.dependsOn = slices.DeleteFunc(concatUnique(targetGroup.dependsOn, group.dependsOn), func(dep groupId) bool {
+				return dep == id || dep == target
+			})
+			delete(*groups, id)
+			merged = true
+			break
+		}
+	}
+	groups.sort()
+}
+
+// Reassigns sources to groups named after the existing rules defining them, so
+// that existing rules are never renamed nor merged. Sources not defined by any
+// existing rule are added to the existing rule with the best include affinity:
+// the rule defining most of the other sources of the same unit, otherwise the
+// rule defining most of the sources they include, otherwise the rule defining
+// most of the sources of their group. Sources without affinity to any existing
+// rule keep their group. Ties are resolved using the rule name.
+func (groups *sourceGroups) preserveExistingRules(rel, stripIncludePrefix, includePrefix string, sourceAssignment map[string]string) {
+	var fileInfos []fileInfo
+	for _, id := range groups.groupIds() {
+		fileInfos = append(fileInfos, (*groups)[id].sources...)
+	}
+	graph := buildDependencyGraph(rel, stripIncludePrefix, includePrefix, fileInfos)
+
+	bestMatchingRule := func(sources []string) (groupId, bool) {
+		counts := make(map[string]int)
+		for _, src := range sources {
+			if ruleName, ok := sourceAssignment[src]; ok {
+				counts[ruleName]++
+			}
+		}
+		var best string
+		for _, ruleName := range slices.Sorted(maps.Keys(counts)) {
+			if best == "" || counts[ruleName] > counts[best] {
+				best = ruleName
+			}
+		}
+		return groupId(best), best != ""
+	}
+
+	preserved := make(sourceGroups)
+	for _, id := range groups.groupIds() {
+		group := (*groups)[id]
+		for _, src := range group.sources {
+			target, ok := bestMatchingRule([]string{src.name})
+			if !ok {
+				node := graph[fileNameToGroupId(src.name)]
+				var includedSources []string
+				for dep := range node.adjacency {
+					includedSources = append(includedSources, graph[dep].sources...)
+				}
+				if target, ok = bestMatchingRule(node.sources); !ok {
+					if target, ok = bestMatchingRule(includedSources); !ok {
+						if target, ok = bestMatchingRule(toRelativePaths(group.sources)); !ok {
+							target = id
+						}
+					}
+				}
+			}
+			if _, exists := preserved[target]; !exists {
+				preserved[target] = &sourceGroup{}
+			}
+			preserved[target].sources = append(preserved[target].sources, src)
+		}
+	}
+	preserved.resolveGroupDependencies(graph)
+	preserved.sort()
+	*groups = preserved
+}
+
+// Returns true if the group contains only header files
+func (group *sourceGroup) isHeaderOnly() bool {
+	return !slices.ContainsFunc(group.sources, func(fi fileInfo) bool { return !fileNameIsHeader(fi.name) })
+}
+
+// Collects, for each source file, the includes of header-only groups its group
+// depends on, following chains of header-only groups. Dependencies on other
+// groups stop the traversal. Groups are based on strongly connected components
+// so their dependencies cannot form a cycle, visited groups are tracked anyway
+// to guard against groups modified after resolving dependencies.
+func (groups sourceGroups) headerOnlyDepsIncludes(rel string) map[string][]ccInclude {
+	result := make(map[string][]ccInclude)
+	for id, group := range groups {
+		var includes []ccInclude
+		visited := collections.SetOf(id)
+		var visit func(current groupId)
+		visit = func(current groupId) {
+			for _, dep := range groups[current].dependsOn {
+				depGroup, exists := groups[dep]
+				if !exists || visited.Contains(dep) || !depGroup.isHeaderOnly() {
+					continue
+				}
+				visited.Add(dep)
+				includes = append(includes, extractImports(rel, depGroup.sources).hdrIncludes...)
+				visit(dep)
+			}
+		}
+		visit(id)
+		for _, fi := range group.sources {
+			result[fi.name] = includes
+		}
+	}
+	return result
+}
+
+var errDuplicateSourceAssignment = errors.New("source file assigned to multiple rules")
+
 // Generates a map of sourceFiles and their corresponsing groupId.
-// Panics if source file is assigned to multiple groups
-func (groups *sourceGroups) sourceToGroupIds() map[string]groupId {
+// Returns errDuplicateSourceAssignment if source file is assigned to multiple groups
+func (groups *sourceGroups) sourceToGroupIds() (map[string]groupId, error) {
 	sourceToGroupId := map[string]groupId{}
-	for id, group := range *groups {
-		for _, file := range group.sources {
+	for _, id := range groups.groupIds() {
+		for _, file := range (*groups)[id].sources {
 			if previous, exists := sourceToGroupId[file.name]; exists {
-				log.Panicf("Inconsistent source groups, file %v assigned to both groups %v and %v", file, previous, id)
+				return nil, fmt.Errorf("%w: %v assigned to both groups %v and %v", errDuplicateSourceAssignment, file.name, previous, id)
 			}
 			sourceToGroupId[file.name] = id
 		}
 	}
-	return sourceToGroupId
+	return sourceToGroupId, nil
+}
+
+// Finds sources and headers listed by more than one of the generated cc_library rules, which would be rejected by Bazel.
+// Platform variants of a library share its sources, each of them is compatible with a distinct platform.
+// Returns an errDuplicateSourceAssignment for each such file, in the order of the rules.
+func findDuplicateSourceAssignments(rel string, rules []*rule.Rule) []error {
+	var errs []error
+	owners := make(map[string]string)
+	for _, r := range rules {
+		if r.Kind() != "cc_library" {
+			continue
+		}
+		owner := r.Name()
+		if facade, ok := r.PrivateAttr(ccVariantFacadeKey).(label.Label); ok {
+			owner = facade.Name
+		}
+		for _, file := range slices.Concat(r.AttrStrings("srcs"), r.AttrStrings("hdrs")) {
+			if previous, exists := owners[file]; exists && previous != owner {
+				errs = append(errs, fmt.Errorf("%v: %w - %v listed by both %v and %v", rel, errDuplicateSourceAssignment, file, previous, r.Name()))
+				continue
+			}
+			owners[file] = owner
+		}
+	}
+	return errs
 }
 
 // Selects a name for the group based on its lexographically first source file name, prefers headers over remaining kinds of files
@@ -355,3 +566,67 @@ func concatUnique[T comparable](arr1, arr2 []T) []T {
 
 	return result
 }
+
+// sourceGraphDump is a JSON serializable snapshot of the dependency graph of
+// sources, its strongly connected components and the resulting source groups.
+// All lists are sorted to ensure deterministic output.
+type sourceGraphDump struct {
+	Nodes      []sourceGraphNodeDump `json:"nodes"`
+	Components [][]groupId           `json:"components"`
+	Groups     []sourceGroupDump     `json:"groups"`
+}
+
+type sourceGraphNodeDump struct {
+	Id        groupId   `json:"id"`
+	Sources   []string  `json:"sources"`
+	Adjacency []groupId `json:"adjacency"`
+}
+
+type sourceGroupDump struct {
+	Id        groupId   `json:"id"`
+	Sources   []string  `json:"sources"`
+	DependsOn []groupId `json:"dependsOn"`
+	SubGroups []groupId `json:"subGroups,omitempty"`
+}
+
+// Serializes the dependency graph and source groups created by groupSourcesByUnits as JSON.
+func dumpSourceGroupsGraph(rel, stripIncludePrefix, includePrefix string, fileInfos []fileInfo, cycleHandlingMode groupsCycleHandlingMode) ([]byte, error) {
+	graph := buildDependencyGraph(rel, stripIncludePrefix, includePrefix, fileInfos)
+	groups, err := groupSourcesByUnits(rel, stripIncludePrefix, includePrefix, fileInfos, cycleHandlingMode)
+	if err != nil {
+		return nil, err
+	}
+
+	dump := sourceGraphDump{
+		Nodes:      []sourceGraphNodeDump{},
+		Components: [][]groupId{},
+		Groups:     []sourceGroupDump{},
+	}
+	for _, id := range slices.Sorted(maps.Keys(graph)) {
+		adjacency := slices.AppendSeq([]groupId{}, maps.Keys(graph[id].adjacency))
+		slices.Sort(adjacency)
+		dump.Nodes = append(dump.Nodes, sourceGraphNodeDump{
+			Id:        id,
+			Sources:   slices.Sorted(slices.Values(graph[id].sources)),
+			Adjacency: adjacency,
+		})
+	}
+	for _, component := range graph.findStronglyConnectedComponents() {
+		dump.Components = append(dump.Components, slices.Sorted(slices.Values(component)))
+	}
+	slices.SortFunc(dump.Components, slices.Compare)
+	for _, id := range groups.groupIds() {
+		group := groups[id]
+		dump.Groups = append(dump.Groups, sourceGroupDump{
+			Id:        id,
+			Sources:   toRelativePaths(group.sources),
+			DependsOn: append([]groupId{}, group.dependsOn...),
+			SubGroups: group.subGroups,
+		})
+	}
+	content, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(content, '\n'), nil
+}
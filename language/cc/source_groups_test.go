@@ -15,11 +15,19 @@
 package cc
 
 import (
+	"maps"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/bazelbuild/bazel-gazelle/config"
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSourceGroups(t *testing.T) {
@@ -28,6 +36,7 @@ func TestSourceGroups(t *testing.T) {
 		rel                string
 		stripIncludePrefix string
 		includePrefix      string
+		cycleHandlingMode  groupsCycleHandlingMode
 		input              []fileInfo
 		expected           []sourceGroupSummary
 	}{
@@ -203,12 +212,69 @@ func TestSourceGroups(t *testing.T) {
 				{id: "a", sources: []string{"a.cc", "a.h", "b.cc", "b.h"}},
 			},
 		},
+		{
+			desc:              "Extract headers forming a cycle into a shared group",
+			cycleHandlingMode: sharedLibOnGroupsCycle,
+			input: []fileInfo{
+				fileInfoForTest("a.h", "b.h"),
+				fileInfoForTest("a.cc", "a.h"),
+				fileInfoForTest("b.h", "a.h"),
+				fileInfoForTest("b.cc", "b.h"),
+				fileInfoForTest("c.h", "a.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.cc"}},
+				{id: "a_shared", sources: []string{"a.h", "b.h"}},
+				{id: "b", sources: []string{"b.cc"}},
+				{id: "c", sources: []string{"c.h"}},
+			},
+		},
+		{
+			desc:              "Extract headers of implementation based cycle into a shared group",
+			cycleHandlingMode: sharedLibOnGroupsCycle,
+			input: []fileInfo{
+				fileInfoForTest("a.h"),
+				fileInfoForTest("a.cc", "b.h"),
+				fileInfoForTest("b.h"),
+				fileInfoForTest("b.cc", "a.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.cc"}},
+				{id: "a_shared", sources: []string{"a.h", "b.h"}},
+				{id: "b", sources: []string{"b.cc"}},
+			},
+		},
+		{
+			desc:              "Use unique name of the shared group",
+			cycleHandlingMode: sharedLibOnGroupsCycle,
+			input: []fileInfo{
+				fileInfoForTest("a.h", "b.h"),
+				fileInfoForTest("b.h", "a.h"),
+				fileInfoForTest("a_shared.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a_shared", sources: []string{"a_shared.h"}},
+				{id: "a_shared_2", sources: []string{"a.h", "b.h"}},
+			},
+		},
+		{
+			desc:              "Merge cycle without headers even when shared group is requested",
+			cycleHandlingMode: sharedLibOnGroupsCycle,
+			input: []fileInfo{
+				fileInfoForTest("a.cc", "b.inc"),
+				fileInfoForTest("b.inc", "a.cc"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.cc", "b.inc"}},
+			},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.desc, func(t *testing.T) {
-			actual := summarizeSourceGroups(groupSourcesByUnits(tc.rel, tc.stripIncludePrefix, tc.includePrefix, tc.input))
-			assert.Equal(t, tc.expected, actual)
+			groups, err := groupSourcesByUnits(tc.rel, tc.stripIncludePrefix, tc.includePrefix, tc.input, tc.cycleHandlingMode)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, summarizeSourceGroups(groups))
 		})
 	}
 }
@@ -243,3 +309,365 @@ func fileInfoForTest(name string, includePaths ...string) fileInfo {
 		includes: includes,
 	}
 }
+
+func TestSharedSourceGroupDependencies(t *testing.T) {
+	groups, err := groupSourcesByUnits("", "", "", []fileInfo{
+		fileInfoForTest("a.h", "b.h"),
+		fileInfoForTest("a.cc", "a.h"),
+		fileInfoForTest("b.h", "a.h"),
+		fileInfoForTest("b.cc", "b.h"),
+	}, sharedLibOnGroupsCycle)
+	require.NoError(t, err)
+
+	assert.Equal(t, []groupId{"a_shared"}, groups["a"].dependsOn)
+	assert.Equal(t, []groupId{"a_shared"}, groups["b"].dependsOn)
+	assert.Empty(t, groups["a_shared"].dependsOn)
+}
+
+func TestUmbrellaHeaderSourceGroupDependencies(t *testing.T) {
+	groups, err := groupSourcesByUnits("mylib", "", "", []fileInfo{
+		fileInfoForTest("mylib.h", "mylib/a.h", "mylib/b.h", "c.h"),
+		fileInfoForTest("a.h"),
+		fileInfoForTest("a.cc", "mylib/a.h"),
+		fileInfoForTest("b.h", "mylib/a.h"),
+		fileInfoForTest("b.cc", "mylib/b.h"),
+		fileInfoForTest("c.h"),
+		fileInfoForTest("c.cc", "c.h"),
+	}, mergeOnGroupsCycle)
+	require.NoError(t, err)
+
+	assert.Equal(t, []sourceGroupSummary{
+		{id: "a", sources: []string{"a.cc", "a.h"}},
+		{id: "b", sources: []string{"b.cc", "b.h"}},
+		{id: "c", sources: []string{"c.cc", "c.h"}},
+		{id: "mylib", sources: []string{"mylib.h"}},
+	}, summarizeSourceGroups(groups))
+	assert.Equal(t, []groupId{"a", "b", "c"}, groups["mylib"].dependsOn)
+	assert.Equal(t, []groupId{"a"}, groups["b"].dependsOn)
+	assert.Empty(t, groups["a"].dependsOn)
+	assert.Empty(t, groups["c"].dependsOn)
+}
+
+func TestMergeSmallSourceGroups(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		minSize  int
+		input    []fileInfo
+		expected []sourceGroupSummary
+	}{
+		{
+			desc:    "Small leaf group merged into its only dependant",
+			minSize: 2,
+			input: []fileInfo{
+				fileInfoForTest("util.h"),
+				fileInfoForTest("a.h", "util.h"),
+				fileInfoForTest("a.cc", "a.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.cc", "a.h", "util.h"}},
+			},
+		},
+		{
+			desc:    "Groups not smaller than the threshold are kept",
+			minSize: 2,
+			input: []fileInfo{
+				fileInfoForTest("util.h"),
+				fileInfoForTest("util.cc", "util.h"),
+				fileInfoForTest("a.h", "util.h"),
+				fileInfoForTest("a.cc", "a.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.cc", "a.h"}},
+				{id: "util", sources: []string{"util.cc", "util.h"}},
+			},
+		},
+		{
+			desc:    "Small group used by multiple groups is kept",
+			minSize: 2,
+			input: []fileInfo{
+				fileInfoForTest("util.h"),
+				fileInfoForTest("a.h", "util.h"),
+				fileInfoForTest("b.h", "util.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.h"}},
+				{id: "b", sources: []string{"b.h"}},
+				{id: "util", sources: []string{"util.h"}},
+			},
+		},
+		{
+			desc:    "Chain of small groups merged upwards until reaching the threshold",
+			minSize: 2,
+			input: []fileInfo{
+				fileInfoForTest("a.h"),
+				fileInfoForTest("b.h", "a.h"),
+				fileInfoForTest("c.h", "b.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "b", sources: []string{"a.h", "b.h"}},
+				{id: "c", sources: []string{"c.h"}},
+			},
+		},
+		{
+			desc:    "Chain of small groups merged into the top-most group",
+			minSize: 3,
+			input: []fileInfo{
+				fileInfoForTest("a.h"),
+				fileInfoForTest("b.h", "a.h"),
+				fileInfoForTest("c.h", "b.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "c", sources: []string{"a.h", "b.h", "c.h"}},
+			},
+		},
+		{
+			desc:    "Merging disabled",
+			minSize: 0,
+			input: []fileInfo{
+				fileInfoForTest("util.h"),
+				fileInfoForTest("a.h", "util.h"),
+			},
+			expected: []sourceGroupSummary{
+				{id: "a", sources: []string{"a.h"}},
+				{id: "util", sources: []string{"util.h"}},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			groups, err := groupSourcesByUnits("", "", "", tc.input, mergeOnGroupsCycle)
+			require.NoError(t, err)
+			groups.mergeSmallGroups(tc.minSize)
+			assert.Equal(t, tc.expected, summarizeSourceGroups(groups))
+		})
+	}
+}
+
+func TestMergeSmallSourceGroupsDependencies(t *testing.T) {
+	groups, err := groupSourcesByUnits("", "", "", []fileInfo{
+		fileInfoForTest("base.h"),
+		fileInfoForTest("base.cc", "base.h"),
+		fileInfoForTest("util.h", "base.h"),
+		fileInfoForTest("a.h", "util.h", "base.h"),
+		fileInfoForTest("a.cc", "a.h"),
+		fileInfoForTest("b.h", "base.h"),
+		fileInfoForTest("b.cc", "b.h"),
+	}, mergeOnGroupsCycle)
+	require.NoError(t, err)
+	groups.mergeSmallGroups(2)
+
+	assert.Equal(t, []sourceGroupSummary{
+		{id: "a", sources: []string{"a.cc", "a.h", "util.h"}},
+		{id: "b", sources: []string{"b.cc", "b.h"}},
+		{id: "base", sources: []string{"base.cc", "base.h"}},
+	}, summarizeSourceGroups(groups))
+	assert.Equal(t, []groupId{"base"}, groups["a"].dependsOn)
+	assert.Equal(t, []groupId{"a", "util"}, groups["a"].subGroups)
+	assert.Equal(t, []groupId{"base"}, groups["b"].dependsOn)
+	assert.Empty(t, groups["base"].dependsOn)
+}
+
+func TestDumpSourceGroupsGraph(t *testing.T) {
+	input := []fileInfo{
+		fileInfoForTest("a.h", "b.h"),
+		fileInfoForTest("a.cc", "a.h"),
+		fileInfoForTest("b.h", "a.h"),
+		fileInfoForTest("b.cc", "b.h"),
+		fileInfoForTest("c.h", "a.h"),
+		fileInfoForTest("c.cc", "c.h", "d.h"),
+		fileInfoForTest("d.h"),
+		fileInfoForTest("main.cc", "c.h"),
+	}
+	expected, err := os.ReadFile(filepath.Join("testdata", "source_graph", "source_graph.golden.json"))
+	require.NoError(t, err)
+
+	// Output must not depend on the map iteration order
+	for range 10 {
+		dump, err := dumpSourceGroupsGraph("", "", "", input, sharedLibOnGroupsCycle)
+		require.NoError(t, err)
+		assert.Equal(t, string(expected), string(dump))
+	}
+}
+
+func TestHeaderOnlyDepsIncludes(t *testing.T) {
+	groups, err := groupSourcesByUnits("", "", "", []fileInfo{
+		fileInfoForTest("lib.h"),
+		fileInfoForTest("lib.cc", "lib.h", "a.h"),
+		fileInfoForTest("a.h", "b.h"),
+		// Header-only cycle merged into single group
+		fileInfoForTest("b.h", "c.h", "ext/ext.h", "b_cycle.h"),
+		fileInfoForTest("b_cycle.h", "b.h"),
+		fileInfoForTest("c.h", "d.h"),
+		fileInfoForTest("c.cc", "c.h"),
+		fileInfoForTest("d.h"),
+	}, mergeOnGroupsCycle)
+	require.NoError(t, err)
+
+	includePaths := func(includes []ccInclude) []string {
+		return collections.MapSlice(includes, func(include ccInclude) string { return include.path })
+	}
+	actual := groups.headerOnlyDepsIncludes("")
+	assert.Equal(t, []string{"b.h", "c.h", "ext/ext.h"}, includePaths(actual["lib.cc"]))
+	assert.Equal(t, []string{"b.h", "c.h", "ext/ext.h"}, includePaths(actual["lib.h"]))
+	assert.Equal(t, []string{"c.h", "ext/ext.h"}, includePaths(actual["a.h"]))
+	// Traversal stops at groups with sources
+	assert.Empty(t, actual["b.h"])
+	assert.Empty(t, actual["c.h"])
+	assert.Empty(t, actual["d.h"])
+}
+
+func TestDuplicateSourceAssignment(t *testing.T) {
+	groups := sourceGroups{
+		"a": {sources: []fileInfo{fileInfoForTest("a.h"), fileInfoForTest("common.h")}},
+		"b": {sources: []fileInfo{fileInfoForTest("b.h"), fileInfoForTest("common.h")}},
+	}
+	assert.NotPanics(t, func() {
+		_, err := groups.sourceToGroupIds()
+		assert.ErrorIs(t, err, errDuplicateSourceAssignment)
+	})
+
+	newLibrary := func(name string, srcs, hdrs []string) *rule.Rule {
+		r := rule.NewRule("cc_library", name)
+		r.SetAttr("srcs", srcs)
+		r.SetAttr("hdrs", hdrs)
+		return r
+	}
+	test := rule.NewRule("cc_test", "a_test")
+	test.SetAttr("srcs", []string{"a.cc"})
+	rules := []*rule.Rule{
+		newLibrary("a", []string{"a.cc"}, []string{"a.h", "common.h"}),
+		newLibrary("b", []string{"b.cc"}, []string{"b.h", "common.h"}),
+		test,
+	}
+	errs := findDuplicateSourceAssignments("pkg", rules)
+	require.Len(t, errs, 1)
+	assert.ErrorIs(t, errs[0], errDuplicateSourceAssignment)
+	assert.ErrorContains(t, errs[0], "common.h listed by both a and b")
+
+	assert.Empty(t, findDuplicateSourceAssignments("pkg", rules[:1]))
+}
+
+func TestInconsistentSourceGroups(t *testing.T) {
+	testCases := []struct {
+		desc              string
+		input             []fileInfo
+		cycleHandlingMode groupsCycleHandlingMode
+	}{
+		{
+			desc:              "duplicated source",
+			input:             []fileInfo{fileInfoForTest("a.h"), fileInfoForTest("a.cc", "a.h"), fileInfoForTest("a.h")},
+			cycleHandlingMode: mergeOnGroupsCycle,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.desc, func(t *testing.T) {
+			assert.NotPanics(t, func() {
+				groups, err := groupSourcesByUnits("", "", "", tc.input, tc.cycleHandlingMode)
+				assert.Error(t, err)
+				assert.Nil(t, groups)
+			})
+		})
+	}
+}
+
+func TestGenerateRulesSkipsInconsistentSourceGroups(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.h":  "",
+		"a.cc": "#include \"a.h\"\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	c := config.New()
+	lang := NewLanguage().(*ccLanguage)
+	lang.Configure(c, "lib", nil)
+	conf := getCcConfig(c)
+	conf.groupingMode = groupSourcesByUnit
+
+	var result language.GenerateResult
+	assert.NotPanics(t, func() {
+		result = lang.GenerateRules(language.GenerateArgs{
+			Config: c,
+			Dir:    dir,
+			Rel:    "lib",
+			// Duplicated source
+			RegularFiles: append(slices.Sorted(maps.Keys(files)), "a.h"),
+		})
+	})
+	assert.Empty(t, result.Gen)
+	assert.Empty(t, result.Imports)
+}
+
+func TestGenerateRulesIgnoresExcludedSources(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.h":  "",
+		"a.cc": "#include \"a.h\"\n#include \"b.h\"\n",
+		"b.h":  "",
+		"b.cc": "#include \"a.h\"\n#include \"b.h\"\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644))
+	}
+
+	generatedRules := func(regularFiles []string) map[string][]string {
+		c := config.New()
+		lang := NewLanguage().(*ccLanguage)
+		lang.Configure(c, "lib", nil)
+		conf := getCcConfig(c)
+		conf.groupingMode = groupSourcesByUnit
+		result := lang.GenerateRules(language.GenerateArgs{
+			Config:       c,
+			Dir:          dir,
+			Rel:          "lib",
+			RegularFiles: regularFiles,
+		})
+		rules := make(map[string][]string)
+		for _, r := range result.Gen {
+			rules[r.Name()] = slices.Sorted(slices.Values(slices.Concat(r.AttrStrings("srcs"), r.AttrStrings("hdrs"))))
+		}
+		return rules
+	}
+
+	// b.cc creates a cycle between units, merging them together
+	assert.Equal(t, map[string][]string{
+		"a": {"a.cc", "a.h", "b.cc", "b.h"},
+	}, generatedRules([]string{"a.cc", "a.h", "b.cc", "b.h"}))
+	// Files excluded using gazelle:exclude are not passed in RegularFiles and never become a part of the dependency graph
+	assert.Equal(t, map[string][]string{
+		"a": {"a.cc", "a.h"},
+		"b": {"b.h"},
+	}, generatedRules([]string{"a.cc", "a.h", "b.h"}))
+}
+
+func TestPreserveExistingRules(t *testing.T) {
+	groups, err := groupSourcesByUnits("", "", "", []fileInfo{
+		fileInfoForTest("a.h"),
+		fileInfoForTest("a.cc", "a.h"),
+		fileInfoForTest("b.h", "a.h"),
+		fileInfoForTest("b.cc", "b.h"),
+		fileInfoForTest("c.cc", "a.h", "b.h"),
+		fileInfoForTest("d.h"),
+	}, mergeOnGroupsCycle)
+	require.NoError(t, err)
+
+	groups.preserveExistingRules("", "", "", map[string]string{
+		"a.h":  "core",
+		"a.cc": "core",
+		"b.h":  "core",
+		"b.cc": "extra",
+	})
+
+	assert.Equal(t, []sourceGroupSummary{
+		{id: "core", sources: []string{"a.cc", "a.h", "b.h", "c.cc"}},
+		{id: "d", sources: []string{"d.h"}},
+		{id: "extra", sources: []string{"b.cc"}},
+	}, summarizeSourceGroups(groups))
+	assert.Equal(t, []groupId{"core"}, groups["extra"].dependsOn)
+	assert.Empty(t, groups["core"].dependsOn)
+	assert.Empty(t, groups["d"].dependsOn)
+}
@@ -0,0 +1,139 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/walk"
+)
+
+// Symbolic links found in the source tree are followed only if they point
+// within the repository, e.g. vendored directories of headers linked into a
+// package. Links escaping the repository and broken links are ignored. The
+// same policy is used when collecting sources of the generated rules and when
+// expanding glob() patterns of existing rules, so the headers assigned to
+// rules, and thus indexed for resolution, don't depend on how rules are
+// defined. Directories walked by Gazelle are listed using its cache, linked
+// directories which Gazelle did not walk are read from the file system.
+
+// Files and subdirectories of a directory after applying the symlink policy
+type dirEntries struct {
+	regularFiles []string
+	subdirs      []string
+	// Subdirectories which are links to directories, they might not be walked by Gazelle
+	linkedDirs []string
+}
+
+type symlinkKind int
+
+const (
+	notSymlink symlinkKind = iota
+	linkToFile
+	linkToDir
+	// Broken links or links escaping the repository
+	ignoredLink
+)
+
+// Lists the directory relative to the repository root. Linked directories
+// and their subdirectories need to be listed with linked set to true, as they
+// might not be walked by Gazelle.
+func listDir(repoRoot, rel string, linked bool) (dirEntries, error) {
+	var regularFiles, subdirs []string
+	if linked {
+		entries, err := os.ReadDir(filepath.Join(repoRoot, filepath.FromSlash(rel)))
+		if err != nil {
+			return dirEntries{}, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				subdirs = append(subdirs, entry.Name())
+			} else {
+				regularFiles = append(regularFiles, entry.Name())
+			}
+		}
+	} else {
+		di, err := walk.GetDirInfo(rel)
+		if err != nil {
+			return dirEntries{}, err
+		}
+		regularFiles, subdirs = di.RegularFiles, di.Subdirs
+	}
+	return followSymlinks(repoRoot, rel, regularFiles, subdirs), nil
+}
+
+// Applies the symlink policy to the entries of the directory relative to the
+// repository root. Links are classified based on their target, links to
+// directories listed as regular files are moved to linkedDirs.
+func followSymlinks(repoRoot, rel string, regularFiles, subdirs []string) dirEntries {
+	var result dirEntries
+	for _, name := range regularFiles {
+		switch classifySymlink(repoRoot, path.Join(rel, name)) {
+		case notSymlink, linkToFile:
+			result.regularFiles = append(result.regularFiles, name)
+		case linkToDir:
+			result.linkedDirs = append(result.linkedDirs, name)
+		}
+	}
+	for _, name := range subdirs {
+		switch classifySymlink(repoRoot, path.Join(rel, name)) {
+		case notSymlink:
+			result.subdirs = append(result.subdirs, name)
+		case linkToDir:
+			result.linkedDirs = append(result.linkedDirs, name)
+		}
+	}
+	return result
+}
+
+func classifySymlink(repoRoot, rel string) symlinkKind {
+	file := filepath.Join(repoRoot, filepath.FromSlash(rel))
+	info, err := os.Lstat(file)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return notSymlink
+	}
+	target, ok := resolveWithinRepo(repoRoot, file)
+	if !ok {
+		return ignoredLink
+	}
+	if info, err := os.Stat(target); err != nil {
+		return ignoredLink
+	} else if info.IsDir() {
+		return linkToDir
+	}
+	return linkToFile
+}
+
+// Returns the path of the file after following all symbolic links, or false
+// if it does not exist or is not within the repository.
+func resolveWithinRepo(repoRoot, file string) (string, bool) {
+	root, err := filepath.EvalSymlinks(repoRoot)
+	if err != nil {
+		return "", false
+	}
+	target, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(root, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return target, true
+}
@@ -0,0 +1,3 @@
+#pragma once
+
+#define ASM_ALIGN 4
@@ -0,0 +1,2 @@
+# Not preprocessed, the directive below is a comment for the assembler
+#include "missing.h"
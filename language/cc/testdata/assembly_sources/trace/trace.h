@@ -0,0 +1,3 @@
+#pragma once
+
+#define TRACE_ENABLED 1
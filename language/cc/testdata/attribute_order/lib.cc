@@ -0,0 +1,4 @@
+#include "lib.h"
+#include "util.h"
+
+int lib() { return util(); }
@@ -0,0 +1,3 @@
+#include "util.h"
+
+int util() { return 0; }
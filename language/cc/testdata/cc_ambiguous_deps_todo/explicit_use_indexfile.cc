@@ -0,0 +1,4 @@
+#include <boost/thread/thread.hpp>
+#include <version.h>
+
+int main() {}
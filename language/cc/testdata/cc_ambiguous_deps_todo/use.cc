@@ -0,0 +1,3 @@
+#include "ambiguous/header.h"
+
+int main() {}
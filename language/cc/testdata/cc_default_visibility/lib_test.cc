@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int main() { return answer() == 42 ? 0 : 1; }
@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int main() { return answer(); }
@@ -0,0 +1,3 @@
+#pragma once
+
+void* custom_allocator_alloc(int size);
@@ -0,0 +1,3 @@
+#pragma once
+
+void* default_allocator_alloc(int size);
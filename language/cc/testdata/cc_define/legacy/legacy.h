@@ -0,0 +1,3 @@
+#pragma once
+
+void* legacy_alloc(int size);
@@ -0,0 +1,13 @@
+#if USE_CUSTOM_ALLOCATOR
+#include "custom_allocator/custom_allocator.h"
+#else
+#include "default_allocator/default_allocator.h"
+#endif
+
+#if LEGACY_API
+#include "legacy/legacy.h"
+#endif
+
+#ifdef UNKNOWN_FEATURE
+#include <unknown_feature.h>
+#endif
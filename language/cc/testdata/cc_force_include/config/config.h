@@ -0,0 +1,3 @@
+#pragma once
+
+#define LIB_VALUE 42
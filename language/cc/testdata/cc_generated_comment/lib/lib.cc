@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int lib() { return 0; }
@@ -0,0 +1,6 @@
+#include "app.h"
+#include "format.h"
+#include "log.h"
+#include "version.h"
+
+int app() { return log(format(0)) + version(); }
@@ -0,0 +1,4 @@
+#include "format.h"
+#include "trim.h"
+
+int format(int x) { return trim(x); }
@@ -0,0 +1,4 @@
+#include "log.h"
+#include "version.h"
+
+int log(int x) { return x + version(); }
@@ -0,0 +1,3 @@
+#pragma once
+
+inline int trim(int x) { return x; }
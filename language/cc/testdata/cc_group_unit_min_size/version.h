@@ -0,0 +1,3 @@
+#pragma once
+
+inline int version() { return 1; }
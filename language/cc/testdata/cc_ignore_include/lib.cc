@@ -0,0 +1,6 @@
+#include <config.h>
+#include "gen/version.h"
+#include "lib.h"
+#include "missing.h"
+
+int lib() { return VERSION; }
@@ -0,0 +1,4 @@
+#include "lib.h"
+#include "gen/build_info.h"
+
+int main() { return lib(); }
@@ -0,0 +1 @@
+#include "dep.h"
\ No newline at end of file
@@ -0,0 +1,5 @@
+#include "compress.h"
+
+#include "prebuilt/zstd.h"
+
+int compress(int level) { return ZSTD_compress(level); }
@@ -0,0 +1,5 @@
+#pragma once
+
+#include "prebuilt/zstd.h"
+
+inline int level() { return 3; }
@@ -0,0 +1,3 @@
+#include "zstd.h"
+
+int compress_default() { return ZSTD_compress(3); }
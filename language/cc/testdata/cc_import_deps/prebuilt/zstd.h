@@ -0,0 +1,3 @@
+#pragma once
+
+int ZSTD_compress(int level);
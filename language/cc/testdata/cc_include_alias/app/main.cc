@@ -0,0 +1,7 @@
+#include <eigen3/Eigen/dense.h>
+#include "vendor/v2/util/strings.h"
+
+int main() {
+  Eigen::Matrix matrix;
+  return strings();
+}
@@ -0,0 +1,5 @@
+#pragma once
+
+namespace Eigen {
+struct Matrix {};
+}  // namespace Eigen
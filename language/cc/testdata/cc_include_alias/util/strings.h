@@ -0,0 +1,3 @@
+#pragma once
+
+inline int strings() { return 0; }
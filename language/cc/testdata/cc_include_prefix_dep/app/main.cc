@@ -0,0 +1,6 @@
+#include <zlib/zlib.h>
+#include <zlib/contrib/minizip/unzip.h>
+
+int main() {
+  return 0;
+}
@@ -0,0 +1,4 @@
+#include "foo/foo.h"
+#include "foo/internal/bar/bar.h"
+
+int foo() { return bar(); }
@@ -0,0 +1,3 @@
+#include "foo/internal/bar/bar.h"
+
+int bar() { return 1; }
@@ -0,0 +1,4 @@
+#include "baz/bar/lib.h"
+#include "foo/bar/lib.h"
+
+int main() { return foo_bar() + baz_bar(); }
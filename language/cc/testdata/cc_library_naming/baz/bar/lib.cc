@@ -0,0 +1,3 @@
+#include "baz/bar/lib.h"
+
+int baz_bar() { return 0; }
@@ -0,0 +1,3 @@
+#include "foo/bar/lib.h"
+
+int foo_bar() { return 0; }
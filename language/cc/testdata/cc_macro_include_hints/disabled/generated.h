@@ -0,0 +1,4 @@
+#pragma once
+
+// Headers included by code generated using this macro
+#define GENERATED_DEPS "dep/dep.h" "not_indexed.h" <vector> "version 1.0"
@@ -0,0 +1,3 @@
+#include "net/socket.h"
+
+int main() { return open_socket(); }
@@ -0,0 +1,6 @@
+#include "pch/pch.h"
+
+int main() {
+  log("started");
+  return length("app");
+}
@@ -0,0 +1,4 @@
+#pragma once
+
+#include "log/log.h"
+#include "util/strings.h"
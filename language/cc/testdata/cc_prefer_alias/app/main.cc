@@ -0,0 +1,3 @@
+#include "lib/impl.h"
+
+int main() { return impl(); }
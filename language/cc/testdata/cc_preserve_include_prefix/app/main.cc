@@ -0,0 +1,3 @@
+#include "project/bar.h"
+
+int main() { return 0; }
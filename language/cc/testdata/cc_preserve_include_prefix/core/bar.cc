@@ -0,0 +1 @@
+#include "project/bar.h"
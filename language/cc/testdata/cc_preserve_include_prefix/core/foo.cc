@@ -0,0 +1,2 @@
+#include "project/foo.h"
+#include "project/bar.h"
@@ -0,0 +1,3 @@
+#include <zlib.h>
+#include <fmt/core.h>
+#include "vendored/json.h"
@@ -0,0 +1,2 @@
+#include "vendored/json.h"
+#include <zlib.h>
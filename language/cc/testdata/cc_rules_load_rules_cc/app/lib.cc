@@ -0,0 +1,3 @@
+#include "app/lib.h"
+
+int lib() { return 0; }
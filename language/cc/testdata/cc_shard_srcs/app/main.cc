@@ -0,0 +1,3 @@
+#include "lib/lib.h"
+
+int main() { return a() + b() + c() + d() + e(); }
@@ -0,0 +1,3 @@
+#include "lib/lib.h"
+
+int a() { return 0; }
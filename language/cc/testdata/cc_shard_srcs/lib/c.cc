@@ -0,0 +1,4 @@
+#include "lib/lib.h"
+#include "util/util.h"
+
+int c() { return util(); }
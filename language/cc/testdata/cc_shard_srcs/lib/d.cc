@@ -0,0 +1,3 @@
+#include "lib/lib.h"
+
+int d() { return 0; }
@@ -0,0 +1,3 @@
+#include "util/util.h"
+
+int util() { return 1; }
@@ -0,0 +1,9 @@
+#include <cmath>
+#include <thread>
+#include <vector>
+
+int main() {
+  std::thread t([] {});
+  t.join();
+  return static_cast<int>(std::sqrt(0.0));
+}
@@ -0,0 +1,5 @@
+#include "lib/lib.h"
+
+#include <thread>
+
+void run() { std::thread([] {}).join(); }
@@ -0,0 +1 @@
+int helper() { return 0; }
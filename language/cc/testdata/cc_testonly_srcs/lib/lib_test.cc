@@ -0,0 +1,4 @@
+#include "lib/lib.h"
+#include "lib/lib_test_util.h"
+
+int main() { return expect_lib(42) ? 0 : 1; }
@@ -0,0 +1,3 @@
+#include "lib/lib_test_util.h"
+
+bool expect_lib(int expected) { return lib() == expected; }
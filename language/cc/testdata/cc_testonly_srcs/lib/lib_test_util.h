@@ -0,0 +1,5 @@
+#pragma once
+
+#include "lib/lib.h"
+
+bool expect_lib(int expected);
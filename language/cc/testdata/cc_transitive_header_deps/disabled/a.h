@@ -0,0 +1,5 @@
+#pragma once
+
+#include "disabled/b.h"
+
+inline int a() { return b(); }
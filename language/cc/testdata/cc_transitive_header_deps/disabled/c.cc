@@ -0,0 +1,3 @@
+#include "disabled/c.h"
+
+int c() { return 0; }
@@ -0,0 +1,4 @@
+#include "disabled/lib.h"
+#include "disabled/a.h"
+
+int lib() { return a(); }
@@ -0,0 +1,5 @@
+#pragma once
+
+#include "enabled/b.h"
+
+inline int a() { return b(); }
@@ -0,0 +1,6 @@
+#pragma once
+
+#include "enabled/c.h"
+#include "ext/ext.h"
+
+inline int b() { return c() + ext(); }
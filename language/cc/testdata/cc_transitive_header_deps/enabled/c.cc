@@ -0,0 +1,3 @@
+#include "enabled/c.h"
+
+int c() { return 0; }
@@ -0,0 +1,4 @@
+#include "enabled/lib.h"
+#include "enabled/a.h"
+
+int lib() { return a(); }
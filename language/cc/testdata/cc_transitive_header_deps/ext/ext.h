@@ -0,0 +1,3 @@
+#pragma once
+
+inline int ext() { return 0; }
@@ -0,0 +1,5 @@
+#include "lib/private.h"
+#include "lib/restricted.h"
+#include "lib/public.h"
+
+int main() { return 0; }
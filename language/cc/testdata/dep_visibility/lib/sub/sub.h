@@ -0,0 +1,4 @@
+#include "lib/private.h"
+#include "lib/restricted.h"
+
+int sub();
@@ -0,0 +1,4 @@
+#include "a.h"
+#include "b.h"
+
+int a_size() { return sizeof(A) + sizeof(B); }
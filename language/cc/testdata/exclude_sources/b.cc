@@ -0,0 +1,4 @@
+#include "a.h"
+#include "b.h"
+
+int b_size() { return sizeof(A) + sizeof(B); }
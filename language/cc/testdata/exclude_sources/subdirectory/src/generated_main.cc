@@ -0,0 +1,4 @@
+#include "include/public.h"
+#include "src/impl.h"
+
+int main() { return api() + impl(); }
@@ -0,0 +1,3 @@
+#include "src/impl.h"
+
+int impl() { return 0; }
@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int twice() { return 2 * answer(); }
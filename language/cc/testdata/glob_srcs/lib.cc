@@ -0,0 +1,3 @@
+#include "lib.h"
+
+int answer() { return 42; }
@@ -0,0 +1,3 @@
+#pragma once
+
+#define EXTRA 1
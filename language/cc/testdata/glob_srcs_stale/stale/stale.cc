@@ -0,0 +1,4 @@
+#include "stale/stale.h"
+#include "stale/extra.h"
+
+int stale() { return EXTRA; }
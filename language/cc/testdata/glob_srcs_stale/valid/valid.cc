@@ -0,0 +1,4 @@
+#include "valid/valid.h"
+#include "valid/extra.h"
+
+int valid() { return EXTRA; }
@@ -0,0 +1,5 @@
+#pragma once
+
+#include "socket.h"
+
+int resolve(const char* host);
@@ -0,0 +1,4 @@
+#include "socket.h"
+#include "../util/log.h"
+
+int connect(const char* host) { return log(length(host)); }
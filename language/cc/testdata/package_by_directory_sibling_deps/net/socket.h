@@ -0,0 +1,5 @@
+#pragma once
+
+#include "util/strings.h"
+
+int connect(const char* host);
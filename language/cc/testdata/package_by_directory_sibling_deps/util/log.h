@@ -0,0 +1,3 @@
+#pragma once
+
+int log(int value);
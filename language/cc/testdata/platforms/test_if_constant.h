@@ -0,0 +1,11 @@
+#include "shared/api.h"
+
+#if 0
+#include "select/win.h"
+#endif
+
+#if 1
+#include "select/unix.h"
+#else
+#include "select/macos.h"
+#endif
@@ -0,0 +1,15 @@
+#include "deps/zlib.h"
+#include "deps/common.h"
+
+#ifdef _WIN32
+#include "deps/win_threads.h"
+#include "deps/win_fs.h"
+#elif defined(__APPLE__)
+#include "deps/posix.h"
+#include "deps/macos.h"
+#else
+#include "deps/posix.h"
+#include "deps/linux.h"
+#endif
+
+int main() { return 0; }
@@ -0,0 +1,3 @@
+#include "mylib/mylib.h"
+
+int main() { return a() + b() + c(); }
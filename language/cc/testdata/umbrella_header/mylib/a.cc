@@ -0,0 +1,3 @@
+#include "mylib/a.h"
+
+int a() { return 0; }
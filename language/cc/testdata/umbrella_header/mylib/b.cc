@@ -0,0 +1,3 @@
+#include "mylib/b.h"
+
+int b() { return 0; }
@@ -0,0 +1,3 @@
+#include "mylib/c.h"
+
+int c() { return 0; }
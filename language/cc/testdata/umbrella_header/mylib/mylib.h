@@ -0,0 +1,5 @@
+#pragma once
+
+#include "mylib/a.h"
+#include "mylib/b.h"
+#include "mylib/c.h"
@@ -0,0 +1,3 @@
+#include "b.h"
+
+int b_size() { return sizeof(B); }
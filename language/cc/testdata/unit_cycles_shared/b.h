@@ -0,0 +1,6 @@
+#pragma once
+#include "a.h"
+
+struct B {
+  A* a;
+};
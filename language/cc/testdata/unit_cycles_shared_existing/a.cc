@@ -0,0 +1,3 @@
+#include "a.h"
+
+int a_size() { return sizeof(A); }
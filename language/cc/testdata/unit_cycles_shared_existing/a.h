@@ -0,0 +1,7 @@
+#pragma once
+#include "b.h"
+
+struct B;
+struct A {
+  B* b;
+};
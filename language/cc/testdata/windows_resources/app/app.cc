@@ -0,0 +1,3 @@
+#include "app.h"
+
+void run() {}
@@ -0,0 +1,3 @@
+#pragma once
+
+#define ICON_APP_PATH "icons\\app.ico"
@@ -0,0 +1,105 @@
+// Copyright 2026 EngFlow Inc. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cc
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/bazelbuild/bazel-gazelle/language"
+	"github.com/bazelbuild/bazel-gazelle/rule"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// Build file visited in verify mode, enabled using -cc_verify flag
+type verifiedBuildFile struct {
+	// Path of the build file, relative to the repository root
+	path string
+	// Existing build file, modified in place by Gazelle when merging the generated rules. Nil if the file does not exist.
+	file *rule.File
+	// Formatted content of the existing build file before merging the generated rules
+	original []byte
+}
+
+// Build file which would be changed by Gazelle, reported in verify mode
+type outdatedBuildFile struct {
+	// Path of the build file, relative to the repository root
+	path string
+	// Unified diff between the existing and the expected content of the build file
+	diff string
+}
+
+// Records the content of the build file before the generated rules are merged
+// into it, so that changes can be detected after resolving dependencies.
+// Directories without a build file are recorded only if any rules were
+// generated, as Gazelle would create a new build file for them.
+func (c *ccLanguage) recordVerifiedBuildFile(args language.GenerateArgs, result language.GenerateResult) {
+	if args.File == nil {
+		if len(result.Gen) == 0 {
+			return
+		}
+		name := "BUILD.bazel"
+		if len(args.Config.ValidBuildFileNames) > 0 {
+			name = args.Config.ValidBuildFileNames[0]
+		}
+		c.verifiedBuildFiles = append(c.verifiedBuildFiles, verifiedBuildFile{path: filepath.ToSlash(filepath.Join(args.Rel, name))})
+		return
+	}
+	rel, err := filepath.Rel(args.Config.RepoRoot, args.File.Path)
+	if err != nil {
+		rel = args.File.Path
+	}
+	c.verifiedBuildFiles = append(c.verifiedBuildFiles, verifiedBuildFile{
+		path:     filepath.ToSlash(rel),
+		file:     args.File,
+		original: args.File.Format(),
+	})
+}
+
+// Returns the build files recorded in verify mode which were changed by
+// merging the generated rules and resolving their dependencies, sorted by path.
+// Fixing load statements is not taken into account, as it happens only when
+// the build files are written.
+func (c *ccLanguage) findOutdatedBuildFiles() []outdatedBuildFile {
+	var outdated []outdatedBuildFile
+	for _, verified := range c.verifiedBuildFiles {
+		if verified.file == nil {
+			outdated = append(outdated, outdatedBuildFile{path: verified.path, diff: fmt.Sprintf("%v: new build file would be created\n", verified.path)})
+			continue
+		}
+		expected := verified.file.Format()
+		if bytes.Equal(verified.original, expected) {
+			continue
+		}
+		diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(verified.original)),
+			B:        difflib.SplitLines(string(expected)),
+			FromFile: verified.path,
+			ToFile:   verified.path,
+			Context:  3,
+		})
+		if err != nil {
+			diff = fmt.Sprintf("failed to compute diff: %v\n", err)
+		}
+		outdated = append(outdated, outdatedBuildFile{path: verified.path, diff: diff})
+	}
+	slices.SortFunc(outdated, func(a, b outdatedBuildFile) int {
+		return strings.Compare(a.path, b.path)
+	})
+	return outdated
+}
@@ -57,3 +57,26 @@ func (c Cursor) AdvancedBy(lookAhead string) Cursor {
 
 	return c
 }
+
+// Similar to AdvancedBy, but tab characters advance the column to the next multiple of tabWidth (plus 1, as columns
+// are 1-based), matching the position displayed by text editors. Non-positive tabWidth is equivalent to AdvancedBy.
+func (c Cursor) AdvancedByWithTabWidth(lookAhead string, tabWidth int) Cursor {
+	if tabWidth <= 0 {
+		return c.AdvancedBy(lookAhead)
+	}
+
+	tailBegin := 1 + strings.LastIndex(lookAhead, "\n")
+	if tailBegin > 0 {
+		c.Line += strings.Count(lookAhead, "\n")
+		c.Column = 1
+	}
+	for _, r := range lookAhead[tailBegin:] {
+		if r == '\t' {
+			c.Column += tabWidth - (c.Column-1)%tabWidth
+		} else {
+			c.Column++
+		}
+	}
+
+	return c
+}
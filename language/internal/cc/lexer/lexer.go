@@ -31,13 +31,16 @@ import (
 const (
 	kwDefined          = "defined"
 	reWideStringPrefix = `(?:|[LuU]|u8)`
+	// Backslash followed by a newline, joining two physical source lines
+	reLineSplice = `\\[\t\v\f\r ]*\n`
 )
 
 var (
-	reContinueLine           = regexp.MustCompile(`^\\[\t\v\f\r ]*\n`)
-	rePreprocessorSystemPath = regexp.MustCompile(`^<[\w-+./]+>`)
+	reContinueLine           = regexp.MustCompile(`^` + reLineSplice)
+	reLineSpliceAnywhere     = regexp.MustCompile(reLineSplice)
+	rePreprocessorSystemPath = regexp.MustCompile(`^<(?:[\w-+./]|` + reLineSplice + `)+>`)
 	reLiteralInteger         = regexp.MustCompile(`^(?i)0x[0-9a-f]+|0b[01]+|0[0-7]*|[1-9][0-9]*`)
-	reLiteralString          = regexp.MustCompile(`^` + reWideStringPrefix + `"(?:[^"\\\n]|\\.)*"`)
+	reLiteralString          = regexp.MustCompile(`^` + reWideStringPrefix + `"(?:[^"\\\n]|\\.|` + reLineSplice + `)*"`)
 	reLiteralRawStringBegin  = regexp.MustCompile(`^` + reWideStringPrefix + `R"([^()\\\s]{0,16})\(`)
 	reIdentifier             = regexp.MustCompile(`^(?i)[a-z_][a-z0-9_]*`)
 	reTokenBegin             = regexp.MustCompile(`[\s\\"/#=><!&|{}[\],();\w]`)
@@ -52,6 +55,7 @@ var (
 		{"elifdef", TokenType_PreprocessorElifdef},
 		{"include", TokenType_PreprocessorInclude},
 		{"define", TokenType_PreprocessorDefine},
+		{"pragma", TokenType_PreprocessorPragma},
 		{"ifndef", TokenType_PreprocessorIfndef},
 		{"endif", TokenType_PreprocessorEndif},
 		{"ifdef", TokenType_PreprocessorIfdef},
@@ -66,15 +70,30 @@ type (
 	Lexer struct {
 		dataLeft []byte
 		cursor   Cursor
+		tabWidth int
 	}
+	// Option customizes the behavior of the Lexer.
+	Option func(*Lexer)
 	lexeme struct {
 		tokenType TokenType
 		length    int
 	}
 )
 
-func NewLexer(sourceCode []byte) *Lexer {
-	return &Lexer{dataLeft: sourceCode, cursor: CursorInit}
+func NewLexer(sourceCode []byte, options ...Option) *Lexer {
+	lx := &Lexer{dataLeft: sourceCode, cursor: CursorInit}
+	for _, option := range options {
+		option(lx)
+	}
+	return lx
+}
+
+// WithTabWidth makes tab characters advance the column of token locations to
+// the next multiple of width, instead of counting them as a single column.
+func WithTabWidth(width int) Option {
+	return func(lx *Lexer) {
+		lx.tabWidth = width
+	}
 }
 
 // Find the index of the first non-whitespace character in the data slice.
@@ -112,6 +131,19 @@ func parseLiteralRawString(data []byte) int {
 	return beginIndex + endIndex + len(endSequence)
 }
 
+// Parse a preprocessor directive keyword following the '#' (or its '%:'
+// digraph) of the given length, possibly separated by whitespace. Returns an
+// empty lexeme if no known directive is found.
+func parsePreprocessorDirective(data []byte, hashLength int) lexeme {
+	begin := findNonWhitespace(data[hashLength:]) + hashLength
+	for _, directive := range preprocessorDirectives {
+		if bytes.HasPrefix(data[begin:], []byte(directive.keyword)) {
+			return lexeme{tokenType: directive.tokenType, length: begin + len(directive.keyword)}
+		}
+	}
+	return lexeme{}
+}
+
 // Update the lexer state accordingly to the extracted token content.
 func (lx *Lexer) consume(lxm lexeme) Token {
 	token := Token{
@@ -120,7 +152,7 @@ func (lx *Lexer) consume(lxm lexeme) Token {
 		Content:  string(lx.dataLeft[:lxm.length]),
 	}
 	lx.dataLeft = lx.dataLeft[lxm.length:]
-	lx.cursor = lx.cursor.AdvancedBy(token.Content)
+	lx.cursor = lx.cursor.AdvancedByWithTabWidth(token.Content, lx.tabWidth)
 	return token
 }
 
@@ -169,12 +201,11 @@ func (lx *Lexer) NextToken() Token {
 			}
 		}
 	case '#':
-		begin := findNonWhitespace(lx.dataLeft[1:]) + 1
-		for _, directive := range preprocessorDirectives {
-			if bytes.HasPrefix(lx.dataLeft[begin:], []byte(directive.keyword)) {
-				lxm = lexeme{tokenType: directive.tokenType, length: begin + len(directive.keyword)}
-				break
-			}
+		lxm = parsePreprocessorDirective(lx.dataLeft, 1)
+	case '%':
+		// digraph alternative of '#'
+		if bytes.HasPrefix(lx.dataLeft, []byte("%:")) {
+			lxm = parsePreprocessorDirective(lx.dataLeft, 2)
 		}
 	case '=':
 		if strings.HasPrefix(string(lx.dataLeft), "==") {
@@ -235,15 +266,26 @@ func (lx *Lexer) NextToken() Token {
 	}
 
 	if lxm.length == 0 {
-		// scan forward to some well-understood characters
+		// scan forward to some well-understood characters, or consume the rest
+		// of the input, so that every call makes progress even on NUL bytes or
+		// invalid UTF-8 sequences
 		if begin := reTokenBegin.FindIndex(lx.dataLeft[1:]); begin != nil {
 			lxm = lexeme{tokenType: TokenType_Unassigned, length: 1 + begin[0]}
+		} else {
+			lxm = lexeme{tokenType: TokenType_Unassigned, length: len(lx.dataLeft)}
 		}
 	}
 
 	return lx.consume(lxm)
 }
 
+// RemoveLineSplices returns the token content with all backslash-newline
+// sequences removed, e.g. for string literals and system include paths
+// spanning multiple physical lines.
+func RemoveLineSplices(content string) string {
+	return reLineSpliceAnywhere.ReplaceAllString(content, "")
+}
+
 // Iterate through the all tokens extracted from the input data.
 func (lx *Lexer) AllTokens() iter.Seq[Token] {
 	return func(yield func(Token) bool) {
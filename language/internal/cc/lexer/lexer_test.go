@@ -43,6 +43,18 @@ func TestNextToken(t *testing.T) {
 			input:    []byte("#   define VARIABLE 123"),
 			expected: Token{Type: TokenType_PreprocessorDefine, Location: CursorInit, Content: "#   define"},
 		},
+		{
+			input:    []byte("%:include <file.h>"),
+			expected: Token{Type: TokenType_PreprocessorInclude, Location: CursorInit, Content: "%:include"},
+		},
+		{
+			input:    []byte("%: ifdef X"),
+			expected: Token{Type: TokenType_PreprocessorIfdef, Location: CursorInit, Content: "%: ifdef"},
+		},
+		{
+			input:    []byte("#pragma once"),
+			expected: Token{Type: TokenType_PreprocessorPragma, Location: CursorInit, Content: "#pragma"},
+		},
 		{
 			input:    []byte("\n\n"),
 			expected: Token{Type: TokenType_Newline, Location: CursorInit, Content: "\n"},
@@ -87,6 +99,14 @@ func TestNextToken(t *testing.T) {
 			input:    []byte(`"I contain a '\\' backslash"`),
 			expected: Token{Type: TokenType_LiteralString, Location: CursorInit, Content: `"I contain a '\\' backslash"`},
 		},
+		{
+			input:    []byte("\"split \\\n string\""),
+			expected: Token{Type: TokenType_LiteralString, Location: CursorInit, Content: "\"split \\\n string\""},
+		},
+		{
+			input:    []byte("<sys/\\  \nsocket.h>"),
+			expected: Token{Type: TokenType_PreprocessorSystemPath, Location: CursorInit, Content: "<sys/\\  \nsocket.h>"},
+		},
 		{
 			input:    []byte(`L"wide string literal"`),
 			expected: Token{Type: TokenType_Unassigned, Location: CursorInit, Content: `L"wide string literal"`},
@@ -159,6 +179,12 @@ func TestNextToken(t *testing.T) {
 	}
 }
 
+func TestRemoveLineSplices(t *testing.T) {
+	assert.Equal(t, "<sys/socket.h>", RemoveLineSplices("<sys/\\\nsocket.h>"))
+	assert.Equal(t, `"foo.h"`, RemoveLineSplices("\"fo\\ \t\no\\\n.h\""))
+	assert.Equal(t, `"foo\\bar.h"`, RemoveLineSplices(`"foo\\bar.h"`))
+}
+
 func TestAllTokens(t *testing.T) {
 	testCases := []struct {
 		input    []byte
@@ -306,6 +332,137 @@ func TestAllTokens(t *testing.T) {
 	}
 }
 
+func TestAllTokensMalformedInput(t *testing.T) {
+	testCases := []struct {
+		description string
+		input       []byte
+		expected    []Token
+	}{
+		{
+			description: "NUL byte in the middle of identifier",
+			input:       []byte("ab\x00cd"),
+			expected: []Token{
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 1}, Content: "ab"},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 3}, Content: "\x00"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 4}, Content: "cd"},
+			},
+		},
+		{
+			description: "trailing NUL bytes",
+			input:       []byte("#include \"a.h\"\x00\x00"),
+			expected: []Token{
+				{Type: TokenType_PreprocessorInclude, Location: Cursor{Line: 1, Column: 1}, Content: "#include"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 9}, Content: " "},
+				{Type: TokenType_LiteralString, Location: Cursor{Line: 1, Column: 10}, Content: `"a.h"`},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 15}, Content: "\x00\x00"},
+			},
+		},
+		{
+			description: "lone continuation byte",
+			input:       []byte("int\x80;"),
+			expected: []Token{
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 1}, Content: "int"},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 4}, Content: "\x80"},
+				{Type: TokenType_Semicolon, Location: Cursor{Line: 1, Column: 5}, Content: ";"},
+			},
+		},
+		{
+			description: "lone continuation byte at the end of input",
+			input:       []byte("int\x80"),
+			expected: []Token{
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 1}, Content: "int"},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 4}, Content: "\x80"},
+			},
+		},
+		{
+			description: "multi-byte character at the end of input",
+			input:       []byte("x = 😎"),
+			expected: []Token{
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 1}, Content: "x"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 2}, Content: " "},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 3}, Content: "="},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 4}, Content: " "},
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 5}, Content: "😎"},
+			},
+		},
+		{
+			description: "truncated multi-byte sequence",
+			input:       []byte("\xf0\x9f\n#if"),
+			expected: []Token{
+				{Type: TokenType_Unassigned, Location: Cursor{Line: 1, Column: 1}, Content: "\xf0\x9f"},
+				{Type: TokenType_Newline, Location: Cursor{Line: 1, Column: 3}, Content: "\n"},
+				{Type: TokenType_PreprocessorIf, Location: Cursor{Line: 2, Column: 1}, Content: "#if"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			lx := NewLexer(tc.input)
+			assert.Equal(t, tc.expected, slices.Collect(lx.AllTokens()))
+		})
+	}
+}
+
+func TestAllTokensWithTabWidth(t *testing.T) {
+	input := []byte("\tint\tx;\n  \t#if")
+	testCases := []struct {
+		description string
+		tabWidth    int
+		expected    []Token
+	}{
+		{
+			description: "default counts tab as single column",
+			tabWidth:    0,
+			expected: []Token{
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 1}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 2}, Content: "int"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 5}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 6}, Content: "x"},
+				{Type: TokenType_Semicolon, Location: Cursor{Line: 1, Column: 7}, Content: ";"},
+				{Type: TokenType_Newline, Location: Cursor{Line: 1, Column: 8}, Content: "\n"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 2, Column: 1}, Content: "  \t"},
+				{Type: TokenType_PreprocessorIf, Location: Cursor{Line: 2, Column: 4}, Content: "#if"},
+			},
+		},
+		{
+			description: "tab width 4",
+			tabWidth:    4,
+			expected: []Token{
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 1}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 5}, Content: "int"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 8}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 9}, Content: "x"},
+				{Type: TokenType_Semicolon, Location: Cursor{Line: 1, Column: 10}, Content: ";"},
+				{Type: TokenType_Newline, Location: Cursor{Line: 1, Column: 11}, Content: "\n"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 2, Column: 1}, Content: "  \t"},
+				{Type: TokenType_PreprocessorIf, Location: Cursor{Line: 2, Column: 5}, Content: "#if"},
+			},
+		},
+		{
+			description: "tab width 8",
+			tabWidth:    8,
+			expected: []Token{
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 1}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 9}, Content: "int"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 1, Column: 12}, Content: "\t"},
+				{Type: TokenType_Identifier, Location: Cursor{Line: 1, Column: 17}, Content: "x"},
+				{Type: TokenType_Semicolon, Location: Cursor{Line: 1, Column: 18}, Content: ";"},
+				{Type: TokenType_Newline, Location: Cursor{Line: 1, Column: 19}, Content: "\n"},
+				{Type: TokenType_Whitespace, Location: Cursor{Line: 2, Column: 1}, Content: "  \t"},
+				{Type: TokenType_PreprocessorIf, Location: Cursor{Line: 2, Column: 9}, Content: "#if"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			lx := NewLexer(input, WithTabWidth(tc.tabWidth))
+			assert.Equal(t, tc.expected, slices.Collect(lx.AllTokens()))
+		})
+	}
+}
+
 func runBenchmark(b *testing.B, input []byte) {
 	b.Helper()
 	for b.Loop() {
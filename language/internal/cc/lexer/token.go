@@ -77,6 +77,7 @@ const (
 	TokenType_PreprocessorIfndef
 	TokenType_PreprocessorInclude
 	TokenType_PreprocessorIncludeNext
+	TokenType_PreprocessorPragma
 	TokenType_PreprocessorUndef
 
 	// Subset of expression operators.
@@ -151,6 +152,8 @@ func (t TokenType) String() string {
 		return "directive '#include'"
 	case TokenType_PreprocessorIncludeNext:
 		return "directive '#include_next'"
+	case TokenType_PreprocessorPragma:
+		return "directive '#pragma'"
 	case TokenType_PreprocessorUndef:
 		return "directive '#undef'"
 	case TokenType_OperatorEqual:
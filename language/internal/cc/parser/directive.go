@@ -31,18 +31,32 @@ type (
 		Path       string // Path of the included file
 		IsSystem   bool   // True if system include (angle brackets), false if user include (quotes)
 		LineNumber int    // Line number where this directive was found
+		// Number of conditional blocks enclosing this directive, 0 for top-level directives.
+		// Set only in SourceInfo.OrderedIncludes, the directive tree records nesting in its structure.
+		NestingDepth int
 	}
 	// DefineDirective represents a `#define` preprocessor directive, including
 	// the macro name and any replacement tokens.
 	DefineDirective struct {
-		Name string   // Name of the macro
-		Args []string // 0 or more tokens representing arguments of the #define directive
-		Body []string // 0 or more tokens representing body of the #define directive
+		Name       string   // Name of the macro
+		Args       []string // 0 or more tokens representing arguments of the #define directive
+		Body       []string // 0 or more tokens representing body of the #define directive
+		LineNumber int      // Line number where this directive was found
 	}
 	// UndefineDirective represents a `#undef` preprocessor directive i.e., the removal of a macro definition.
 	UndefineDirective struct {
 		Name string // Name of the macro to undefine
 	}
+	// PushMacroDirective represents a `#pragma push_macro("NAME")` directive,
+	// saving the current definition of the macro.
+	PushMacroDirective struct {
+		Name string // Name of the macro to save
+	}
+	// PopMacroDirective represents a `#pragma pop_macro("NAME")` directive,
+	// restoring the definition of the macro saved by the matching push_macro.
+	PopMacroDirective struct {
+		Name string // Name of the macro to restore
+	}
 	// IfBlock represents a conditional compilation block such as #if/#ifdef/#ifndef, along with
 	// any #elif and #else branches, and their nested directives.
 	IfBlock struct {
@@ -79,6 +93,12 @@ func (d DefineDirective) String() string {
 	return fmt.Sprintf("#define %s%s %s", d.Name, argsString, strings.Join(d.Body, " "))
 }
 func (d UndefineDirective) String() string { return fmt.Sprintf("#undef %s", d.Name) }
+func (d PushMacroDirective) String() string {
+	return fmt.Sprintf("#pragma push_macro(%q)", d.Name)
+}
+func (d PopMacroDirective) String() string {
+	return fmt.Sprintf("#pragma pop_macro(%q)", d.Name)
+}
 func (d IfBlock) String() string {
 	var out string
 	for _, br := range d.Branches {
@@ -19,6 +19,7 @@ import (
 	"log"
 	"strings"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/lexer"
 )
 
@@ -153,3 +154,140 @@ func booleanToInt(b bool) int {
 	}
 	return 0
 }
+
+// Simplify returns an expression equivalent to expr when evaluated as a
+// condition, see Evaluate. Negations are pushed down to the leaves using De
+// Morgan's laws, double negations are removed and constant subexpressions are
+// folded. The integer value of the result might differ from the original one,
+// e.g. !!X is simplified to X.
+func Simplify(expr Expr) Expr {
+	switch e := expr.(type) {
+	case Not:
+		return negate(Simplify(e.X))
+	case And:
+		l, r := Simplify(e.L), Simplify(e.R)
+		if c, ok := l.(ConstantInt); ok {
+			if c == 0 {
+				return ConstantInt(0)
+			}
+			return r
+		}
+		if c, ok := r.(ConstantInt); ok {
+			if c == 0 {
+				return ConstantInt(0)
+			}
+			return l
+		}
+		return And{L: l, R: r}
+	case Or:
+		l, r := Simplify(e.L), Simplify(e.R)
+		if c, ok := l.(ConstantInt); ok {
+			if c != 0 {
+				return ConstantInt(1)
+			}
+			return r
+		}
+		if c, ok := r.(ConstantInt); ok {
+			if c != 0 {
+				return ConstantInt(1)
+			}
+			return l
+		}
+		return Or{L: l, R: r}
+	case Compare:
+		// Operands are used as values, they're not simplified as conditions
+		_, leftConstant := e.Left.(ConstantInt)
+		_, rightConstant := e.Right.(ConstantInt)
+		if leftConstant && rightConstant {
+			return ConstantInt(e.Eval(nil))
+		}
+		return e
+	default:
+		return expr
+	}
+}
+
+// Substitute returns the expression with references to known macros replaced
+// by constants. Macros defined in env are replaced by their values, defined(X)
+// by 1, and macros listed in undefined are replaced by 0. References to other
+// macros are left unchanged, so the result can be folded using Simplify.
+func Substitute(expr Expr, env Environment, undefined collections.Set[string]) Expr {
+	switch e := expr.(type) {
+	case Ident:
+		if value, defined := env[string(e)]; defined {
+			return ConstantInt(value)
+		}
+		if undefined.Contains(string(e)) {
+			return ConstantInt(0)
+		}
+	case Defined:
+		if _, defined := env[string(e.Name)]; defined {
+			return ConstantInt(1)
+		}
+		if undefined.Contains(string(e.Name)) {
+			return ConstantInt(0)
+		}
+	case Not:
+		return Not{X: Substitute(e.X, env, undefined)}
+	case And:
+		return And{L: Substitute(e.L, env, undefined), R: Substitute(e.R, env, undefined)}
+	case Or:
+		return Or{L: Substitute(e.L, env, undefined), R: Substitute(e.R, env, undefined)}
+	case Compare:
+		return Compare{Left: Substitute(e.Left, env, undefined), Op: e.Op, Right: Substitute(e.Right, env, undefined)}
+	}
+	return expr
+}
+
+// collectMacroNames adds names of macros referenced in the expression to result.
+// Function-like macros are skipped, these are always assumed to be defined.
+func collectMacroNames(expr Expr, result collections.Set[string]) {
+	switch e := expr.(type) {
+	case Ident:
+		result.Add(string(e))
+	case Defined:
+		result.Add(string(e.Name))
+	case Not:
+		collectMacroNames(e.X, result)
+	case And:
+		collectMacroNames(e.L, result)
+		collectMacroNames(e.R, result)
+	case Or:
+		collectMacroNames(e.L, result)
+		collectMacroNames(e.R, result)
+	case Compare:
+		collectMacroNames(e.Left, result)
+		collectMacroNames(e.Right, result)
+	}
+}
+
+// negatedCompareOperators maps comparison operators to their logical negation.
+var negatedCompareOperators = map[lexer.TokenType]lexer.TokenType{
+	lexer.TokenType_OperatorEqual:          lexer.TokenType_OperatorNotEqual,
+	lexer.TokenType_OperatorNotEqual:       lexer.TokenType_OperatorEqual,
+	lexer.TokenType_OperatorLess:           lexer.TokenType_OperatorGreaterOrEqual,
+	lexer.TokenType_OperatorGreaterOrEqual: lexer.TokenType_OperatorLess,
+	lexer.TokenType_OperatorGreater:        lexer.TokenType_OperatorLessOrEqual,
+	lexer.TokenType_OperatorLessOrEqual:    lexer.TokenType_OperatorGreater,
+}
+
+// negate returns the simplified negation of already simplified expression.
+func negate(expr Expr) Expr {
+	switch e := expr.(type) {
+	case ConstantInt:
+		return ConstantInt(booleanToInt(e == 0))
+	case Not:
+		return e.X
+	case And:
+		return Or{L: negate(e.L), R: negate(e.R)}
+	case Or:
+		return And{L: negate(e.L), R: negate(e.R)}
+	case Compare:
+		if op, ok := negatedCompareOperators[e.Op]; ok {
+			return Compare{Left: e.Left, Op: op, Right: e.Right}
+		}
+		return Not{X: e}
+	default:
+		return Not{X: expr}
+	}
+}
@@ -17,6 +17,7 @@ package parser
 import (
 	"testing"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/lexer"
 	"github.com/stretchr/testify/assert"
 )
@@ -156,3 +157,83 @@ func TestExprEvaluation(t *testing.T) {
 		assert.ElementsMatch(t, tc.expected, availableInPresets, tc.name)
 	}
 }
+
+func TestSimplify(t *testing.T) {
+	a, b, c := Defined{Name: "A"}, Defined{Name: "B"}, Defined{Name: "C"}
+	cases := []struct {
+		name     string
+		expr     Expr
+		expected Expr
+	}{
+		{"leaf", a, a},
+		{"single negation", Not{X: a}, Not{X: a}},
+		{"double negation", Not{X: Not{X: a}}, a},
+		{"triple negation", Not{X: Not{X: Not{X: a}}}, Not{X: a}},
+		{"negated and", Not{X: And{L: a, R: b}}, Or{L: Not{X: a}, R: Not{X: b}}},
+		{"negated or", Not{X: Or{L: a, R: b}}, And{L: Not{X: a}, R: Not{X: b}}},
+		{
+			"nested negations",
+			Not{X: And{L: Not{X: a}, R: Or{L: b, R: Not{X: c}}}},
+			Or{L: a, R: And{L: Not{X: b}, R: c}},
+		},
+		{
+			"negated comparison",
+			Not{X: Compare{Left: Ident("V"), Op: lexer.TokenType_OperatorLess, Right: ConstantInt(2)}},
+			Compare{Left: Ident("V"), Op: lexer.TokenType_OperatorGreaterOrEqual, Right: ConstantInt(2)},
+		},
+		{"negated constant", Not{X: ConstantInt(0)}, ConstantInt(1)},
+		{"and with false", And{L: a, R: ConstantInt(0)}, ConstantInt(0)},
+		{"and with true", And{L: ConstantInt(1), R: a}, a},
+		{"or with true", Or{L: a, R: ConstantInt(2)}, ConstantInt(1)},
+		{"or with false", Or{L: ConstantInt(0), R: a}, a},
+		{
+			"constant comparison",
+			Or{L: a, R: Compare{Left: ConstantInt(1), Op: lexer.TokenType_OperatorGreater, Right: ConstantInt(2)}},
+			a,
+		},
+		{
+			// Comparison operands are values, !!X cannot be replaced with X
+			"comparison operands",
+			Compare{Left: Not{X: Not{X: Ident("V")}}, Op: lexer.TokenType_OperatorEqual, Right: ConstantInt(1)},
+			Compare{Left: Not{X: Not{X: Ident("V")}}, Op: lexer.TokenType_OperatorEqual, Right: ConstantInt(1)},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			simplified := Simplify(tc.expr)
+			assert.Equal(t, tc.expected, simplified)
+			for preset, macros := range macroPresets {
+				assert.Equal(t, Evaluate(tc.expr, macros), Evaluate(simplified, macros), preset)
+			}
+		})
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	env := Environment{"A": 1, "V": 3}
+	undefined := collections.SetOf("B")
+	cases := []struct {
+		name     string
+		expr     Expr
+		expected Expr
+	}{
+		{"defined macro", Defined{Name: "A"}, ConstantInt(1)},
+		{"undefined macro", Defined{Name: "B"}, ConstantInt(0)},
+		{"unknown macro", Defined{Name: "C"}, Defined{Name: "C"}},
+		{"macro value", Ident("V"), ConstantInt(3)},
+		{"undefined macro value", Ident("B"), ConstantInt(0)},
+		{
+			"nested",
+			Or{L: Not{X: Defined{Name: "B"}}, R: And{L: Defined{Name: "C"}, R: Compare{Left: Ident("V"), Op: lexer.TokenType_OperatorGreater, Right: ConstantInt(2)}}},
+			Or{L: Not{X: ConstantInt(0)}, R: And{L: Defined{Name: "C"}, R: Compare{Left: ConstantInt(3), Op: lexer.TokenType_OperatorGreater, Right: ConstantInt(2)}}},
+		},
+		{"function-like macro", Apply{Name: "A", Args: []Expr{Ident("V")}}, Apply{Name: "A", Args: []Expr{Ident("V")}}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, Substitute(tc.expr, env, undefined))
+		})
+	}
+}
@@ -24,6 +24,7 @@ package parser
 
 import (
 	"fmt"
+	"iter"
 	"os"
 	"regexp"
 	"slices"
@@ -43,15 +44,114 @@ func isRelevantTokenType(token lexer.Token) bool {
 	}
 }
 
+// alternativeOperators maps the alternative spellings of operators (keywords
+// in C++, macros defined by <iso646.h> in C) to their operator token types.
+var alternativeOperators = map[string]lexer.TokenType{
+	"and":    lexer.TokenType_OperatorLogicalAnd,
+	"or":     lexer.TokenType_OperatorLogicalOr,
+	"not":    lexer.TokenType_OperatorLogicalNot,
+	"not_eq": lexer.TokenType_OperatorNotEqual,
+}
+
+// Reclassify identifiers of the expression up to the end of line being an
+// alternative spelling of operators, so they are handled the same way as their
+// symbolic forms. Other directives are not affected, as the same names are
+// valid macro names in C, e.g. #define and &&
+func (p *parser) normalizeAlternativeOperators() {
+	for i := range p.tokensLeft {
+		token := &p.tokensLeft[i]
+		if token.Type == lexer.TokenType_Newline {
+			return
+		}
+		if token.Type == lexer.TokenType_Identifier {
+			if tokenType, ok := alternativeOperators[token.Content]; ok {
+				token.Type = tokenType
+			}
+		}
+	}
+}
+
+// ParseOptions controls optional behavior of ParseSourceWithOptions.
+type ParseOptions struct {
+	// Stop parsing directives at the first line of code, i.e. parse only the
+	// leading run of directives, comments and blank lines. Directives after
+	// the preamble are not reported, conditional blocks still open at its end
+	// are closed implicitly. Speeds up parsing of large files.
+	PreambleOnly bool
+	// Used together with PreambleOnly, scan the whole file for the main
+	// function. Otherwise HasMain is only set if main is defined in the preamble.
+	DetectMain bool
+}
+
 // ParseSource reads and parses C/C++ source, returning structured SourceInfo.
 func ParseSource(input []byte) SourceInfo {
+	return ParseSourceWithOptions(input, ParseOptions{})
+}
+
+// ParseSourceWithOptions reads and parses C/C++ source using the given options.
+func ParseSourceWithOptions(input []byte, options ParseOptions) SourceInfo {
 	allTokens := lexer.NewLexer(input).AllTokens()
 	filteredTokens := collections.FilterSeq(allTokens, isRelevantTokenType)
-	p := parser{tokensLeft: slices.Collect(filteredTokens)}
+	p := parser{}
+	if options.PreambleOnly {
+		p.tokensLeft, p.truncated = collectPreambleTokens(filteredTokens, options.DetectMain)
+	} else {
+		p.tokensLeft = slices.Collect(filteredTokens)
+	}
 	p.sourceInfo.Directives = p.parseDirectivesUntil(func(tokenType lexer.TokenType) bool { return tokenType == lexer.TokenType_EOF })
+	p.sourceInfo.OrderedIncludes = collectOrderedIncludes(p.sourceInfo.Directives)
 	return p.sourceInfo
 }
 
+// Collects tokens up to the first token of code outside of preprocessor
+// directives and reports whether the remaining input was skipped. With
+// detectMain the remaining tokens are collected as well, except directives
+// after the preamble, so the main function can still be detected.
+//
+// Linkage specification blocks, i.e. extern "C" { ... }, commonly wrapping
+// includes of C headers, are not treated as code, only their content is.
+func collectPreambleTokens(tokens iter.Seq[lexer.Token], detectMain bool) ([]lexer.Token, bool) {
+	var collected []lexer.Token
+	inDirective, truncated := false, false
+	// Number of tokens of the linkage specification read so far, and the number of its blocks left open
+	linkageTokens, openLinkageBlocks := 0, 0
+	for token := range tokens {
+		isDirective := token.Type.IsPreprocessorDirective() ||
+			// Directives unknown to the lexer, e.g. #error
+			token.Type == lexer.TokenType_Unassigned && (token.Content == "#" || token.Content == "%:")
+		if truncated {
+			if !isDirective {
+				collected = append(collected, token)
+			}
+			continue
+		}
+		switch {
+		case token.Type == lexer.TokenType_Newline:
+			inDirective = false
+		case isDirective:
+			inDirective = true
+		case inDirective:
+			// Arguments of the directive
+		case linkageTokens == 0 && token.Type == lexer.TokenType_Identifier && token.Content == "extern",
+			linkageTokens == 1 && token.Type == lexer.TokenType_LiteralString && (token.Content == `"C"` || token.Content == `"C++"`):
+			linkageTokens++
+		case linkageTokens == 2 && token.Type == lexer.TokenType_BraceLeft:
+			linkageTokens = 0
+			openLinkageBlocks++
+		case linkageTokens == 0 && openLinkageBlocks > 0 && token.Type == lexer.TokenType_BraceRight:
+			openLinkageBlocks--
+		default:
+			// Including declarations with linkage specification, e.g. extern "C" int foo();
+			truncated = true
+			if !detectMain {
+				return collected, true
+			}
+		}
+		collected = append(collected, token)
+	}
+	return collected, truncated
+}
+
 // ParseSourceFile opens filename and feeds its contents to the extractor.
 func ParseSourceFile(filename string) (SourceInfo, error) {
 	content, err := os.ReadFile(filename)
@@ -197,11 +297,25 @@ func parseUnaryBangOperator(p *parser) (Expr, error) {
 }
 
 func parseUnaryOpenParenthesis(p *parser) (Expr, error) {
-	p.nextToken()
+	openParenthesis := p.nextToken()
+	p.skipStrayCommas()
+	if p.peekToken() == lexer.TokenType_ParenthesisRight {
+		p.nextToken()
+		p.recordError(fmt.Errorf("%s: empty parentheses in expression", openParenthesis.Location))
+		return ConstantInt(0), nil
+	}
+
 	expr, err := p.parseExprPrecedence(precedenceLowest + 1)
 	if err != nil {
 		return nil, err
 	}
+	// Comma operator, the value of the group is the value of its last operand
+	for p.skipStrayCommas() && p.peekToken() != lexer.TokenType_ParenthesisRight {
+		expr, err = p.parseExprPrecedence(precedenceLowest + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
 	if _, err := p.expectNextToken(lexer.TokenType_ParenthesisRight); err != nil {
 		return nil, err
 	}
@@ -216,12 +330,12 @@ func (p *parser) parseIncludeDirective() (Directive, error) {
 	// Handle #include <system_include.h>
 	case lexer.TokenType_PreprocessorSystemPath:
 		pathToken := p.nextToken()
-		path := strings.TrimSuffix(strings.TrimPrefix(pathToken.Content, "<"), ">")
+		path := strings.TrimSuffix(strings.TrimPrefix(lexer.RemoveLineSplices(pathToken.Content), "<"), ">")
 		return IncludeDirective{Path: path, IsSystem: true, LineNumber: pathToken.Location.Line}, nil
 	// Handle #include "local_include.h"
 	case lexer.TokenType_LiteralString:
 		pathToken := p.nextToken()
-		path := strings.Trim(pathToken.Content, `"`)
+		path := strings.Trim(lexer.RemoveLineSplices(pathToken.Content), `"`)
 		return IncludeDirective{Path: path, IsSystem: false, LineNumber: pathToken.Location.Line}, nil
 	default:
 		return nil, fmt.Errorf("%s: expected %s or %s, got %s", p.location(), lexer.TokenType_PreprocessorSystemPath, lexer.TokenType_LiteralString, p.peekToken())
@@ -229,32 +343,49 @@ func (p *parser) parseIncludeDirective() (Directive, error) {
 }
 
 // parseDefinedExpr parses the `defined` operator for macro checks in #if
-// expressions.
+// expressions. The non-standard list form `defined A, B` is tolerated and
+// treated as an alternative of its operands, recording an error.
 func parseDefinedExpr(p *parser) (Expr, error) {
-	p.nextToken()
-	var name Ident
-	var err error
-	if p.peekToken() == lexer.TokenType_ParenthesisLeft {
+	definedToken := p.nextToken()
+	parenthesized := p.peekToken() == lexer.TokenType_ParenthesisLeft
+	if parenthesized {
 		p.nextToken()
-		name, err = p.parseIdent()
+		p.skipStrayCommas()
+		if p.peekToken() == lexer.TokenType_ParenthesisRight {
+			p.nextToken()
+			p.recordError(fmt.Errorf("%s: missing operand of defined operator", definedToken.Location))
+			return ConstantInt(0), nil
+		}
+	}
+
+	var result Expr
+	for {
+		name, err := p.parseIdent()
 		if err != nil {
 			return nil, err
 		}
-		if _, err := p.expectNextToken(lexer.TokenType_ParenthesisRight); err != nil {
-			return nil, err
+		if result == nil {
+			result = Defined{Name: name}
+		} else {
+			result = Or{L: result, R: Defined{Name: name}}
 		}
-	} else {
-		name, err = p.parseIdent()
-		if err != nil {
+		if !p.skipStrayCommas() || p.peekToken() != lexer.TokenType_Identifier {
+			break
+		}
+	}
+
+	if parenthesized {
+		if _, err := p.expectNextToken(lexer.TokenType_ParenthesisRight); err != nil {
 			return nil, err
 		}
 	}
-	return Defined{Name: name}, nil
+	return result, nil
 }
 
 type parser struct {
 	tokensLeft []lexer.Token // Tokens yet to be processed
 	sourceInfo SourceInfo    // Accumulated parser state
+	truncated  bool          // Input ends before the end of source, see ParseOptions.PreambleOnly
 }
 
 // Drop n tokens from the front of the input stream.
@@ -269,6 +400,24 @@ func (p *parser) dropNewlines() {
 	}
 }
 
+// Record a non-critical error encountered during parsing.
+func (p *parser) recordError(err error) {
+	p.sourceInfo.Errors = append(p.sourceInfo.Errors, err)
+}
+
+// Drop all comma tokens from the front of the input stream, recording an error
+// for unexpected commas. Returns true if any comma was dropped.
+func (p *parser) skipStrayCommas() bool {
+	if p.peekToken() != lexer.TokenType_Comma {
+		return false
+	}
+	p.recordError(fmt.Errorf("%s: unexpected %s in expression", p.location(), p.peekToken()))
+	for p.peekToken() == lexer.TokenType_Comma {
+		p.nextToken()
+	}
+	return true
+}
+
 // Return the next token type without consuming it, or TokenType_EOF if no
 // tokens are left.
 func (p *parser) peekToken() lexer.TokenType {
@@ -324,10 +473,11 @@ func (p *parser) parseDirectivesUntil(shouldStop func(token lexer.TokenType) boo
 			}
 		case p.peekToken().IsPreprocessorDirective():
 			directive, err := p.parseDirective()
-			if err == nil {
+			switch {
+			case err != nil:
+				p.recordError(err)
+			case directive != nil:
 				directives = append(directives, directive)
-			} else {
-				p.sourceInfo.Errors = append(p.sourceInfo.Errors, err)
 			}
 		default:
 			p.nextToken()
@@ -340,6 +490,7 @@ func (p *parser) parseDirectivesUntil(shouldStop func(token lexer.TokenType) boo
 // parseExpr parses a preprocessor expression (#if/#elif condition) as an Expr
 // AST.
 func (p *parser) parseExpr() (Expr, error) {
+	p.normalizeAlternativeOperators()
 	expr, err := p.parseExprPrecedence(precedenceLowest)
 
 	exprEnd := p.location()
@@ -473,6 +624,13 @@ func (p *parser) parseIfBlock() (IfBlock, error) {
 			p.nextToken()
 			return IfBlock{Branches: branches}, nil
 
+		case lexer.TokenType_EOF:
+			if p.truncated {
+				// Closing directive was skipped together with the rest of the source
+				return IfBlock{Branches: branches}, nil
+			}
+			fallthrough
+
 		default:
 			return IfBlock{}, fmt.Errorf("%s: missing %s for %s", lastBranchLocation, lexer.TokenType_PreprocessorEndif, lastBranchType)
 		}
@@ -482,7 +640,7 @@ func (p *parser) parseIfBlock() (IfBlock, error) {
 // parseDefineDirective parses a #define directive, capturing the macro name and
 // tokens.
 func (p *parser) parseDefineDirective() (DefineDirective, error) {
-	p.nextToken()
+	defineToken := p.nextToken()
 	ident, err := p.parseIdent()
 	if err != nil {
 		return DefineDirective{}, err
@@ -510,7 +668,7 @@ func (p *parser) parseDefineDirective() (DefineDirective, error) {
 			}
 		}
 	}
-	return DefineDirective{Name: ident.String(), Args: defineArgs, Body: p.readUntilNewline()}, nil
+	return DefineDirective{Name: ident.String(), Args: defineArgs, Body: p.readUntilNewline(), LineNumber: defineToken.Location.Line}, nil
 }
 
 // parseUndefineDirective parses a #undef directive and its macro name.
@@ -523,6 +681,43 @@ func (p *parser) parseUndefineDirective() (UndefineDirective, error) {
 	return UndefineDirective{Name: ident.String()}, nil
 }
 
+// parsePragmaDirective parses a #pragma directive. Only push_macro and
+// pop_macro pragmas affecting the macro definitions are returned, other pragmas
+// are skipped and result in a nil directive.
+func (p *parser) parsePragmaDirective() (Directive, error) {
+	p.nextToken()
+	if p.peekToken() != lexer.TokenType_Identifier {
+		p.readUntilNewline()
+		return nil, nil
+	}
+	pragma := p.nextToken().Content
+	if pragma != "push_macro" && pragma != "pop_macro" {
+		// Other pragmas never affect includes, e.g. once, pack, or MSVC code
+		// folding markers region and endregion followed by an optional
+		// free-form label, e.g. #pragma region Don't touch
+		p.readUntilNewline()
+		return nil, nil
+	}
+
+	var name string
+	for _, expected := range []lexer.TokenType{lexer.TokenType_ParenthesisLeft, lexer.TokenType_LiteralString, lexer.TokenType_ParenthesisRight} {
+		token, err := p.expectNextToken(expected)
+		if err != nil {
+			p.readUntilNewline()
+			return nil, err
+		}
+		if token.Type == lexer.TokenType_LiteralString {
+			name = strings.Trim(token.Content, `"`)
+		}
+	}
+	p.readUntilNewline()
+
+	if pragma == "push_macro" {
+		return PushMacroDirective{Name: name}, nil
+	}
+	return PopMacroDirective{Name: name}, nil
+}
+
 // parseDirective dispatches to the appropriate directive parser based on the
 // token.
 func (p *parser) parseDirective() (Directive, error) {
@@ -539,6 +734,8 @@ func (p *parser) parseDirective() (Directive, error) {
 		return p.parseDefineDirective()
 	case lexer.TokenType_PreprocessorUndef:
 		return p.parseUndefineDirective()
+	case lexer.TokenType_PreprocessorPragma:
+		return p.parsePragmaDirective()
 	default:
 		token := p.nextToken()
 		if isEndOfIfBranch(token.Type) {
@@ -15,6 +15,8 @@
 package parser
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/EngFlow/gazelle_cc/language/internal/cc/lexer"
@@ -40,6 +42,56 @@ func TestParseIncludes(t *testing.T) {
 				IncludeDirective{Path: "math.h", IsSystem: true, LineNumber: 4},
 			},
 		},
+		{
+			// Line continuations between the directive and the path or within the path
+			input: "#include \\\n  \"split_directive.h\"\n" +
+				"#include <sys/\\\nsocket.h>\n" +
+				"#include \"dir/\\  \nsplit_path.h\"\n",
+			expected: []Directive{
+				IncludeDirective{Path: "split_directive.h", LineNumber: 2},
+				IncludeDirective{Path: "sys/socket.h", IsSystem: true, LineNumber: 3},
+				IncludeDirective{Path: "dir/split_path.h", LineNumber: 5},
+			},
+		},
+		{
+			// Only pragmas affecting macro definitions are kept
+			input: `
+#pragma once
+#pragma push_macro("FOO")
+#pragma pack(push, 1)
+#pragma pop_macro("FOO")
+#pragma
+#pragma push_macro(FOO)
+#include "foo.h"
+`,
+			expected: []Directive{
+				PushMacroDirective{Name: "FOO"},
+				PopMacroDirective{Name: "FOO"},
+				IncludeDirective{Path: "foo.h", LineNumber: 8},
+			},
+			expectedErrors: []string{
+				`7:20: expected "string literal", got identifier`,
+			},
+		},
+		{
+			// MSVC code folding regions with and without labels are skipped
+			input: `
+#pragma region
+#include "a.h"
+#pragma endregion
+#pragma region Helpers: don't "touch" <this>
+#include "b.h"
+#pragma region Nested /* comment */ region // comment
+#include <c.h>
+#pragma endregion Nested
+#pragma endregion
+`,
+			expected: []Directive{
+				IncludeDirective{Path: "a.h", LineNumber: 3},
+				IncludeDirective{Path: "b.h", LineNumber: 6},
+				IncludeDirective{Path: "c.h", IsSystem: true, LineNumber: 8},
+			},
+		},
 		{
 			// Ignore malformed include
 			input: `
@@ -120,7 +172,7 @@ func TestParseIncludes(t *testing.T) {
 #define MACRO
 `,
 			expected: []Directive{
-				DefineDirective{Name: "MACRO", Args: []string{}, Body: []string{}},
+				DefineDirective{Name: "MACRO", Args: []string{}, Body: []string{}, LineNumber: 51},
 			},
 		},
 		{
@@ -192,6 +244,80 @@ func TestParseConditionalIncludes(t *testing.T) {
 				IncludeDirective{Path: "last.h", LineNumber: 13},
 			},
 		},
+		// regions spanning conditional branches don't affect the structure of the #if block
+		{
+			input: `
+#pragma region Platform specific
+#ifdef _WIN32
+#pragma region Windows
+#include <windows.h>
+#else
+#pragma endregion
+#include <unistd.h>
+#endif
+#pragma endregion
+`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Defined{Ident("_WIN32")},
+						Body:      []Directive{IncludeDirective{Path: "windows.h", IsSystem: true, LineNumber: 5}},
+					}, {
+						Kind: ElseBranch,
+						Body: []Directive{IncludeDirective{Path: "unistd.h", IsSystem: true, LineNumber: 8}},
+					},
+				}},
+			},
+		},
+		// linkage specification blocks don't affect the structure of the #if blocks they contain
+		{
+			input: `
+#ifdef __cplusplus
+extern "C" {
+#endif
+#include "api.h"
+#if defined(_WIN32)
+#include <windows.h>
+#else
+#include <unistd.h>
+#endif
+#ifdef __cplusplus
+}
+#endif
+extern "C++" {
+#ifdef USE_EXT
+#include "ext.h"
+#endif
+}
+`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{Kind: IfBranch, Condition: Defined{Ident("__cplusplus")}},
+				}},
+				IncludeDirective{Path: "api.h", LineNumber: 5},
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Defined{Ident("_WIN32")},
+						Body:      []Directive{IncludeDirective{Path: "windows.h", IsSystem: true, LineNumber: 7}},
+					}, {
+						Kind: ElseBranch,
+						Body: []Directive{IncludeDirective{Path: "unistd.h", IsSystem: true, LineNumber: 9}},
+					},
+				}},
+				IfBlock{Branches: []ConditionalBranch{
+					{Kind: IfBranch, Condition: Defined{Ident("__cplusplus")}},
+				}},
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Defined{Ident("USE_EXT")},
+						Body:      []Directive{IncludeDirective{Path: "ext.h", LineNumber: 16}},
+					},
+				}},
+			},
+		},
 		// whitespace between '#' and directive keyword
 		{
 			input: `
@@ -548,7 +674,7 @@ func TestParseConditionalIncludes(t *testing.T) {
 						Kind:      IfBranch,
 						Condition: Not{Defined{Ident("FOO_H")}},
 						Body: []Directive{
-							DefineDirective{Name: "FOO_H", Args: []string{}, Body: []string{}},
+							DefineDirective{Name: "FOO_H", Args: []string{}, Body: []string{}, LineNumber: 3},
 							IncludeDirective{Path: "bar.h", LineNumber: 4},
 							UndefineDirective{Name: "FOO_H"},
 						},
@@ -594,7 +720,7 @@ func TestParseConditionalIncludes(t *testing.T) {
 			#endif
 			`,
 			expected: []Directive{
-				DefineDirective{Name: "IS_EQUAL", Args: []string{"a", "b"}, Body: []string{"(", "(", "a", ")", "==", "(", "b", ")", ")"}},
+				DefineDirective{Name: "IS_EQUAL", Args: []string{"a", "b"}, Body: []string{"(", "(", "a", ")", "==", "(", "b", ")", ")"}, LineNumber: 2},
 				IfBlock{Branches: []ConditionalBranch{
 					{
 						Kind:      IfBranch,
@@ -663,6 +789,101 @@ func TestParseConditionalIncludes(t *testing.T) {
 				"3:11: expected integer literal or identifier, got newline",
 			},
 		},
+		{
+			// Recover from stray commas in defined operator
+			input: `
+			#if defined(FOO,) || defined(, BAR)
+			#include "foo.h"
+			#endif
+			`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Or{L: Defined{Ident("FOO")}, R: Defined{Ident("BAR")}},
+						Body: []Directive{
+							IncludeDirective{Path: "foo.h", LineNumber: 3},
+						},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"2:19: unexpected symbol ',' in expression",
+				"2:33: unexpected symbol ',' in expression",
+			},
+		},
+		{
+			// Recover from a list of operands in defined operator
+			input: `
+			#if defined A, B || defined(C,, D)
+			#include "foo.h"
+			#endif
+			`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind: IfBranch,
+						Condition: Or{
+							L: Or{L: Defined{Ident("A")}, R: Defined{Ident("B")}},
+							R: Or{L: Defined{Ident("C")}, R: Defined{Ident("D")}},
+						},
+						Body: []Directive{
+							IncludeDirective{Path: "foo.h", LineNumber: 3},
+						},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"2:17: unexpected symbol ',' in expression",
+				"2:33: unexpected symbol ',' in expression",
+			},
+		},
+		{
+			// Recover from empty groups
+			input: `
+			#if defined() || ((FOO,)) || ()
+			#include "foo.h"
+			#endif
+			`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Or{L: Or{L: ConstantInt(0), R: Ident("FOO")}, R: ConstantInt(0)},
+						Body: []Directive{
+							IncludeDirective{Path: "foo.h", LineNumber: 3},
+						},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"2:8: missing operand of defined operator",
+				"2:26: unexpected symbol ',' in expression",
+				"2:33: empty parentheses in expression",
+			},
+		},
+		{
+			// Comma operator in a parenthesized group
+			input: `
+			#if (FOO, BAR)
+			#include "foo.h"
+			#endif
+			`,
+			expected: []Directive{
+				IfBlock{Branches: []ConditionalBranch{
+					{
+						Kind:      IfBranch,
+						Condition: Ident("BAR"),
+						Body: []Directive{
+							IncludeDirective{Path: "foo.h", LineNumber: 3},
+						},
+					},
+				}},
+			},
+			expectedErrors: []string{
+				"2:12: unexpected symbol ',' in expression",
+			},
+		},
 		{
 			// Block starts with an invalid branch type
 			input: `
@@ -745,6 +966,42 @@ func TestParseConditionalIncludes(t *testing.T) {
 	}
 }
 
+func TestParseAlternativeOperators(t *testing.T) {
+	testCases := []struct {
+		description string
+		alternative string
+		symbolic    string
+	}{
+		{description: "and", alternative: "#if defined(X) and defined(Y)", symbolic: "#if defined(X) && defined(Y)"},
+		{description: "or", alternative: "#if defined X or defined Y", symbolic: "#if defined X || defined Y"},
+		{description: "not", alternative: "#if not defined(X)", symbolic: "#if !defined(X)"},
+		{description: "not_eq", alternative: "#if VERSION not_eq 2", symbolic: "#if VERSION != 2"},
+		{description: "mixed", alternative: "#if not (A and B) or C not_eq 1", symbolic: "#if !(A && B) || C != 1"},
+		{description: "digraph", alternative: "%:if defined(X)", symbolic: "#if defined(X)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			alternative := ParseSource([]byte(tc.alternative + "\n#include \"a.h\"\n#endif\n"))
+			symbolic := ParseSource([]byte(tc.symbolic + "\n#include \"a.h\"\n#endif\n"))
+			assert.Empty(t, alternative.Errors)
+			assert.Empty(t, symbolic.Errors)
+			assert.Equal(t, symbolic.Directives, alternative.Directives)
+			assert.Len(t, alternative.Directives, 1)
+		})
+	}
+
+	// Alternative spellings are valid macro names outside of #if and #elif expressions, e.g. in C without <iso646.h>
+	result := ParseSource([]byte("#define and &&\n#ifdef or\n#undef not\n#endif\n"))
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []Directive{
+		DefineDirective{Name: "and", Args: []string{}, Body: []string{"&&"}, LineNumber: 1},
+		IfBlock{Branches: []ConditionalBranch{
+			{Kind: IfBranch, Condition: Defined{Name: "or"}, Body: []Directive{UndefineDirective{Name: "not"}}},
+		}},
+	}, result.Directives)
+}
+
 func TestParseSourceHasMain(t *testing.T) {
 	testCases := []struct {
 		input    string
@@ -858,6 +1115,50 @@ func TestParseSourceHasMain(t *testing.T) {
 				return 0;
 			}`,
 		},
+		{
+			expected: true,
+			input: `
+			extern "C" {
+			int main(int argc, char** argv) {
+				return 0;
+			}
+			}`,
+		},
+		{
+			expected: true,
+			input:    `extern "C" int main(void) { return 0; }`,
+		},
+		{
+			expected: true,
+			input: `
+			extern "C++" {
+				namespace {
+					void helper() {}
+				}
+				int main() { helper(); }
+			}`,
+		},
+		{
+			// Scopes are not tracked, app::main is detected as well even though
+			// it's not the entry point of the program
+			expected: true,
+			input: `
+			namespace app {
+			int main() {
+				return 0;
+			}
+			} // namespace app`,
+		},
+		{
+			expected: false,
+			input: `
+			extern "C" {
+			int not_main(void);
+			}
+			namespace main_app {
+			int run();
+			}`,
+		},
 	}
 
 	for idx, tc := range testCases {
@@ -865,3 +1166,184 @@ func TestParseSourceHasMain(t *testing.T) {
 		assert.Equal(t, tc.expected, result.HasMain, "Test case %d, Input: %v", idx, tc.input)
 	}
 }
+
+func TestParseSourcePreambleOnly(t *testing.T) {
+	input := []byte(`// Copyright notice
+#pragma once
+#ifndef GUARD_H
+#define GUARD_H
+
+/* Includes */
+#include "a.h"
+#  include <b.h>
+#if defined(_WIN32)
+#include <windows.h>
+#endif
+
+namespace foo {
+#include "after_code.h"
+}
+
+int main() { return 0; }
+#endif
+`)
+	includePaths := func(sourceInfo SourceInfo) []string {
+		var paths []string
+		for _, include := range sourceInfo.CollectIncludes() {
+			paths = append(paths, include.Path)
+		}
+		return paths
+	}
+
+	full := ParseSource(input)
+	assert.Empty(t, full.Errors)
+	assert.Equal(t, []string{"a.h", "b.h", "windows.h", "after_code.h"}, includePaths(full))
+	assert.True(t, full.HasMain)
+
+	preamble := ParseSourceWithOptions(input, ParseOptions{PreambleOnly: true})
+	assert.Empty(t, preamble.Errors)
+	assert.Equal(t, []string{"a.h", "b.h", "windows.h"}, includePaths(preamble))
+	assert.False(t, preamble.HasMain)
+	// Include guard closed implicitly
+	assert.Len(t, preamble.Directives, 1)
+
+	withMain := ParseSourceWithOptions(input, ParseOptions{PreambleOnly: true, DetectMain: true})
+	assert.Empty(t, withMain.Errors)
+	assert.Equal(t, []string{"a.h", "b.h", "windows.h"}, includePaths(withMain))
+	assert.True(t, withMain.HasMain)
+
+	// Source consisting only of directives is parsed entirely
+	directivesOnly := []byte("#include \"a.h\"\n#if X\n#include \"b.h\"\n#endif\n")
+	assert.Equal(t, ParseSource(directivesOnly), ParseSourceWithOptions(directivesOnly, ParseOptions{PreambleOnly: true}))
+	// Unterminated blocks are still reported if the whole source was read
+	assert.NotEmpty(t, ParseSourceWithOptions([]byte("#if X\n#include \"a.h\"\n"), ParseOptions{PreambleOnly: true}).Errors)
+}
+
+func TestParseSourcePreambleOnlyLinkageSpecification(t *testing.T) {
+	input := []byte(`#pragma once
+#ifdef __cplusplus
+extern "C" {
+#endif
+#include "a.h"
+#if defined(_WIN32)
+#include <windows.h>
+#endif
+#ifdef __cplusplus
+}
+#endif
+extern "C"
+{
+#include "b.h"
+}
+
+extern "C" int run(void);
+#include "after_code.h"
+`)
+	full := ParseSource(input)
+	assert.Empty(t, full.Errors)
+	if !assert.Len(t, full.Directives, 6) {
+		return
+	}
+
+	// Linkage specification blocks are part of the preamble, unlike declarations
+	// with linkage specification, so all but the last include are reported
+	preamble := ParseSourceWithOptions(input, ParseOptions{PreambleOnly: true})
+	assert.Empty(t, preamble.Errors)
+	assert.Equal(t, full.Directives[:5], preamble.Directives)
+
+	// Closing brace without an open linkage specification block is code
+	unbalanced := ParseSourceWithOptions([]byte("}\n#include \"a.h\"\n"), ParseOptions{PreambleOnly: true})
+	assert.Empty(t, unbalanced.Directives)
+}
+
+func TestParseOrderedIncludes(t *testing.T) {
+	input := []byte(`#include "pch.h"
+#ifdef _WIN32
+#  include <windows.h>
+#  if defined(NTDDI_VERSION)
+#    include <versionhelpers.h>
+#  endif
+#elif defined(__APPLE__)
+#  include <TargetConditionals.h>
+#else
+#  include <unistd.h>
+#endif
+#include "last.h"
+`)
+	result := ParseSource(input)
+	assert.Empty(t, result.Errors)
+	assert.Equal(t, []IncludeDirective{
+		{Path: "pch.h", LineNumber: 1},
+		{Path: "windows.h", IsSystem: true, LineNumber: 3, NestingDepth: 1},
+		{Path: "versionhelpers.h", IsSystem: true, LineNumber: 5, NestingDepth: 2},
+		{Path: "TargetConditionals.h", IsSystem: true, LineNumber: 8, NestingDepth: 1},
+		{Path: "unistd.h", IsSystem: true, LineNumber: 10, NestingDepth: 1},
+		{Path: "last.h", LineNumber: 12},
+	}, result.OrderedIncludes)
+	// The directive tree is not affected
+	assert.Equal(t, IncludeDirective{Path: "last.h", LineNumber: 12}, result.Directives[2])
+}
+
+func TestParseResourceScriptIncludes(t *testing.T) {
+	// Windows resource scripts are run through the C preprocessor, only the
+	// directives are meaningful, the resource statements are not C code
+	input := []byte(`// Microsoft Visual C++ generated resource script.
+#include "resource.h"
+#include <winres.h>
+#ifdef APSTUDIO_INVOKED
+#include "afxres.rc"
+#endif
+
+IDI_APP ICON "res\\app.ico"
+
+STRINGTABLE
+BEGIN
+    IDS_TITLE "Don't panic\0"
+END
+
+VS_VERSION_INFO VERSIONINFO
+ FILEVERSION 1,0,0,1
+BEGIN
+    BLOCK "StringFileInfo"
+    BEGIN
+        VALUE "FileDescription", "App\0"
+    END
+END
+
+#include "version.rc"
+`)
+	result := ParseSource(input)
+	assert.Empty(t, result.Errors)
+	assert.False(t, result.HasMain)
+	assert.Equal(t, []IncludeDirective{
+		{Path: "resource.h", LineNumber: 2},
+		{Path: "winres.h", IsSystem: true, LineNumber: 3},
+		{Path: "afxres.rc", LineNumber: 5, NestingDepth: 1},
+		{Path: "version.rc", LineNumber: 24},
+	}, result.OrderedIncludes)
+}
+
+func benchmarkSource() []byte {
+	var source strings.Builder
+	for i := range 20 {
+		fmt.Fprintf(&source, "#include \"header_%d.h\"\n", i)
+	}
+	for i := range 2000 {
+		fmt.Fprintf(&source, "int function_%d(int x) {\n  return x * %d; // comment\n}\n", i, i)
+	}
+	return []byte(source.String())
+}
+
+func BenchmarkParseSource(b *testing.B) {
+	input := benchmarkSource()
+	for b.Loop() {
+		ParseSource(input)
+	}
+}
+
+func BenchmarkParseSourcePreambleOnly(b *testing.B) {
+	input := benchmarkSource()
+	for b.Loop() {
+		ParseSourceWithOptions(input, ParseOptions{PreambleOnly: true})
+	}
+}
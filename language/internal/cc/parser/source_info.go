@@ -14,11 +14,46 @@
 
 package parser
 
+import (
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/EngFlow/gazelle_cc/internal/collections"
+	"github.com/EngFlow/gazelle_cc/language/internal/cc/lexer"
+)
+
 // SourceInfo contains the structural information extracted from a C/C++ source file.
 type SourceInfo struct {
-	Directives []Directive // Top-level parsed preprocessor directives (may be nested)
-	HasMain    bool        // True if a main() function is detected
-	Errors     []error     // List of non-critical errors encountered during parsing
+	Directives      []Directive        // Top-level parsed preprocessor directives (may be nested)
+	OrderedIncludes []IncludeDirective // All include directives in source order, with their NestingDepth set
+	HasMain         bool               // True if a main() function is detected
+	Errors          []error            // List of non-critical errors encountered during parsing
+}
+
+// collectOrderedIncludes traverses the directive tree in source order and returns
+// all IncludeDirective instances, recording the number of enclosing conditional
+// blocks as NestingDepth.
+func collectOrderedIncludes(directives []Directive) []IncludeDirective {
+	var result []IncludeDirective
+	var walk func([]Directive, int)
+	walk = func(directives []Directive, depth int) {
+		for _, d := range directives {
+			switch v := d.(type) {
+			case IncludeDirective:
+				v.NestingDepth = depth
+				result = append(result, v)
+
+			case IfBlock:
+				for _, branch := range v.Branches {
+					walk(branch.Body, depth+1)
+				}
+			}
+		}
+	}
+	walk(directives, 0)
+	return result
 }
 
 // CollectIncludes recursively traverses the directive tree and returns all IncludeDirective
@@ -44,6 +79,181 @@ func (si SourceInfo) CollectIncludes() []IncludeDirective {
 	return result
 }
 
+// DeduplicateIncludes returns the includes with repeated includes of the same
+// file removed, e.g. included in multiple conditional branches or by accident.
+// Includes are identified by their path and kind, the first occurrence is kept
+// so its line number can be used in diagnostics.
+func DeduplicateIncludes(includes []IncludeDirective) []IncludeDirective {
+	type includeKey struct {
+		path     string
+		isSystem bool
+	}
+	seen := make(collections.Set[includeKey], len(includes))
+	return collections.FilterSlice(includes, func(include IncludeDirective) bool {
+		key := includeKey{include.Path, include.IsSystem}
+		if seen.Contains(key) {
+			return false
+		}
+		seen.Add(key)
+		return true
+	})
+}
+
+// CollectLiveIncludes works like CollectIncludes but skips includes which can
+// never be reached regardless of the defined macros, e.g. code disabled using
+// #if 0 or the #else branch of #if 1. Branch conditions are constant-folded
+// using Simplify.
+func (si SourceInfo) CollectLiveIncludes() []IncludeDirective {
+	return si.CollectLiveIncludesAssuming(nil, nil)
+}
+
+// CollectLiveIncludesAssuming works like CollectLiveIncludes, but additionally
+// assumes the macros from env are defined with the given values and the macros
+// listed in undefined are not defined, e.g. project-wide configuration macros.
+// Assumptions about macros defined or undefined in the source itself are
+// ignored, as their value depends on the position in the file.
+func (si SourceInfo) CollectLiveIncludesAssuming(env Environment, undefined collections.Set[string]) []IncludeDirective {
+	if len(env) > 0 || len(undefined) > 0 {
+		env, undefined = env.Clone(), maps.Clone(undefined)
+		for name := range si.modifiedMacros() {
+			delete(env, name)
+			delete(undefined, name)
+		}
+	}
+	var result []IncludeDirective
+	var walk func([]Directive)
+	walk = func(directives []Directive) {
+		for _, d := range directives {
+			switch v := d.(type) {
+			case IncludeDirective:
+				result = append(result, v)
+
+			case IfBlock:
+				for _, branch := range v.Branches {
+					if branch.Condition == nil {
+						walk(branch.Body)
+						break
+					}
+					if c, ok := Simplify(Substitute(branch.Condition, env, undefined)).(ConstantInt); ok {
+						if c == 0 {
+							continue
+						}
+						// Always taken, following branches are dead
+						walk(branch.Body)
+						break
+					}
+					walk(branch.Body)
+				}
+			}
+		}
+	}
+	walk(si.Directives)
+	return result
+}
+
+// ConditionMacros returns names of macros referenced in conditions of #if
+// blocks, e.g. FEATURE in #if FEATURE or #ifdef FEATURE. Macros defined or
+// undefined by the source itself are excluded, as their value depends on the
+// position in the file.
+func (si SourceInfo) ConditionMacros() collections.Set[string] {
+	result := make(collections.Set[string])
+	var walk func([]Directive)
+	walk = func(directives []Directive) {
+		for _, d := range directives {
+			if v, ok := d.(IfBlock); ok {
+				for _, branch := range v.Branches {
+					if branch.Condition != nil {
+						collectMacroNames(branch.Condition, result)
+					}
+					walk(branch.Body)
+				}
+			}
+		}
+	}
+	walk(si.Directives)
+	for name := range si.modifiedMacros() {
+		delete(result, name)
+	}
+	return result
+}
+
+// modifiedMacros returns names of macros defined or undefined by the directives of the source.
+func (si SourceInfo) modifiedMacros() collections.Set[string] {
+	result := make(collections.Set[string])
+	var walk func([]Directive)
+	walk = func(directives []Directive) {
+		for _, d := range directives {
+			switch v := d.(type) {
+			case DefineDirective:
+				result.Add(v.Name)
+			case UndefineDirective:
+				result.Add(v.Name)
+			case PopMacroDirective:
+				result.Add(v.Name)
+			case IfBlock:
+				for _, branch := range v.Branches {
+					walk(branch.Body)
+				}
+			}
+		}
+	}
+	walk(si.Directives)
+	return result
+}
+
+// Extensions of files recognized as headers when listed in macro bodies.
+var macroHintHeaderExtensions = []string{".h", ".hh", ".hpp", ".hxx", ".inc", ".inl"}
+
+// CollectMacroIncludeHints returns headers listed in bodies of #define
+// directives, e.g. #define MY_DEPS "a.h" <b.h>. These are not includes, but
+// hints of likely dependencies of sources expanding such macros, e.g. in
+// generated code. Only string literals and bracketed paths with a header
+// extension are taken into account. Each hint has the line number of the
+// #define directive it was found in.
+func (si SourceInfo) CollectMacroIncludeHints() []IncludeDirective {
+	var result []IncludeDirective
+	var walk func([]Directive)
+	walk = func(directives []Directive) {
+		for _, d := range directives {
+			switch v := d.(type) {
+			case DefineDirective:
+				for _, token := range v.Body {
+					if hint, ok := parseMacroIncludeHint(token); ok {
+						hint.LineNumber = v.LineNumber
+						result = append(result, hint)
+					}
+				}
+
+			case IfBlock:
+				for _, branch := range v.Branches {
+					walk(branch.Body)
+				}
+			}
+		}
+	}
+	walk(si.Directives)
+	return result
+}
+
+// parseMacroIncludeHint returns the include directive for a single token of a
+// macro body if it's a quoted or bracketed path to a header.
+func parseMacroIncludeHint(token string) (IncludeDirective, bool) {
+	token = lexer.RemoveLineSplices(token)
+	var hint IncludeDirective
+	switch {
+	case len(token) > 2 && strings.HasPrefix(token, `"`) && strings.HasSuffix(token, `"`):
+		hint = IncludeDirective{Path: strings.Trim(token, `"`)}
+	case len(token) > 2 && strings.HasPrefix(token, "<") && strings.HasSuffix(token, ">"):
+		hint = IncludeDirective{Path: token[1 : len(token)-1], IsSystem: true}
+	default:
+		return IncludeDirective{}, false
+	}
+	if strings.ContainsAny(hint.Path, " \t\\") || !slices.Contains(macroHintHeaderExtensions, path.Ext(hint.Path)) {
+		return IncludeDirective{}, false
+	}
+	return hint, true
+}
+
 // CollectIncludes recursively traverses the directive tree based on the successuflly evaluated conditions
 // and returns all found IncludeDirective instances. This allows consumers to extract
 // discovered #include directives based on given predefined environment
@@ -51,6 +261,8 @@ func (si SourceInfo) CollectReachableIncludes(environment Environment) []Include
 	var result []IncludeDirective
 	// Start with a copy of the provided macros, might be modified during evaluation
 	var env Environment = environment.Clone()
+	// Definitions saved using #pragma push_macro, nil if the macro was not defined
+	savedMacros := map[string][]*int{}
 	var walk func([]Directive)
 	walk = func(directives []Directive) {
 		for _, d := range directives {
@@ -80,6 +292,26 @@ func (si SourceInfo) CollectReachableIncludes(environment Environment) []Include
 			case UndefineDirective:
 				delete(env, v.Name)
 
+			case PushMacroDirective:
+				var saved *int
+				if value, defined := env[v.Name]; defined {
+					saved = &value
+				}
+				savedMacros[v.Name] = append(savedMacros[v.Name], saved)
+
+			case PopMacroDirective:
+				stack := savedMacros[v.Name]
+				if len(stack) == 0 {
+					// Nothing pushed, the definition is left unchanged
+					continue
+				}
+				savedMacros[v.Name] = stack[:len(stack)-1]
+				if saved := stack[len(stack)-1]; saved != nil {
+					env[v.Name] = *saved
+				} else {
+					delete(env, v.Name)
+				}
+
 			case IfBlock:
 				for _, branch := range v.Branches {
 					if branch.Condition == nil || Evaluate(branch.Condition, env) {
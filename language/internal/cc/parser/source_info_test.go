@@ -17,6 +17,7 @@ package parser
 import (
 	"testing"
 
+	"github.com/EngFlow/gazelle_cc/internal/collections"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -205,6 +206,78 @@ func TestCollectIncludesAndCollectReachableIncludes(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "push_macro and pop_macro restore definitions",
+			input: `
+				#define FOO 1
+				#pragma push_macro("FOO")
+				#undef FOO
+				#define FOO 2
+				#if FOO == 2
+				#include "redefined.h"
+				#endif
+				#pragma pop_macro("FOO")
+				#if FOO == 1
+				#include "restored.h"
+				#endif
+				#pragma push_macro("BAR")
+				#define BAR
+				#pragma pop_macro("BAR")
+				#ifdef BAR
+				#include "bar.h"
+				#endif
+			`,
+			wantAll: []IncludeDirective{
+				{Path: "redefined.h", LineNumber: 7},
+				{Path: "restored.h", LineNumber: 11},
+				{Path: "bar.h", LineNumber: 17},
+			},
+			reachCases: []macrosCase{
+				{
+					name: "undefined macro restored",
+					env:  Environment{},
+					want: []IncludeDirective{
+						{Path: "redefined.h", LineNumber: 7},
+						{Path: "restored.h", LineNumber: 11},
+					},
+				},
+				{
+					name: "defined macro restored",
+					env:  Environment{"BAR": 1},
+					want: []IncludeDirective{
+						{Path: "redefined.h", LineNumber: 7},
+						{Path: "restored.h", LineNumber: 11},
+						{Path: "bar.h", LineNumber: 17},
+					},
+				},
+			},
+		},
+		{
+			name: "pop_macro without push_macro is ignored",
+			input: `
+				#define FOO 1
+				#pragma pop_macro("FOO")
+				#pragma push_macro("FOO")
+				#pragma push_macro("FOO")
+				#undef FOO
+				#pragma pop_macro("FOO")
+				#undef FOO
+				#pragma pop_macro("FOO")
+				#ifdef FOO
+				#include "foo.h"
+				#endif
+			`,
+			wantAll: []IncludeDirective{
+				{Path: "foo.h", LineNumber: 11},
+			},
+			reachCases: []macrosCase{
+				{
+					name: "nested pushes",
+					env:  Environment{},
+					want: []IncludeDirective{{Path: "foo.h", LineNumber: 11}},
+				},
+			},
+		},
 		{
 			name: "undef macro disables include",
 			input: `
@@ -238,3 +311,241 @@ func TestCollectIncludesAndCollectReachableIncludes(t *testing.T) {
 		}
 	}
 }
+
+func TestCollectLiveIncludes(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name: "if 0 disables include",
+			input: `
+				#if 0
+				#include "dead.h"
+				#endif
+				#include "always.h"
+			`,
+			want: []string{"always.h"},
+		},
+		{
+			name: "if 1 skips else branch",
+			input: `
+				#if 1
+				#include "live.h"
+				#else
+				#include "dead.h"
+				#endif
+			`,
+			want: []string{"live.h"},
+		},
+		{
+			name: "if 0 takes else branch",
+			input: `
+				#if 0
+				#include "dead.h"
+				#elif FOO
+				#include "foo.h"
+				#else
+				#include "other.h"
+				#endif
+			`,
+			want: []string{"foo.h", "other.h"},
+		},
+		{
+			name: "constant folded conditions",
+			input: `
+				#if defined(FOO) && 0
+				#include "dead.h"
+				#elif !0 || defined(BAR)
+				#include "live.h"
+				#else
+				#include "dead_else.h"
+				#endif
+			`,
+			want: []string{"live.h"},
+		},
+		{
+			name: "nested if 0",
+			input: `
+				#ifdef FOO
+				#if 0
+				#include "dead.h"
+				#endif
+				#include "foo.h"
+				#endif
+			`,
+			want: []string{"foo.h"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, include := range ParseSource([]byte(tc.input)).CollectLiveIncludes() {
+				got = append(got, include.Path)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestDeduplicateIncludes(t *testing.T) {
+	input := `
+		#include "foo.h"
+		#ifdef _WIN32
+		#include "foo.h"
+		#include <foo.h>
+		#else
+		#include "bar.h"
+		#endif
+		#include "bar.h"
+	`
+	assert.Equal(t,
+		[]IncludeDirective{
+			{Path: "foo.h", LineNumber: 2},
+			{Path: "foo.h", IsSystem: true, LineNumber: 5},
+			{Path: "bar.h", LineNumber: 7},
+		},
+		DeduplicateIncludes(ParseSource([]byte(input)).CollectIncludes()))
+	assert.Empty(t, DeduplicateIncludes(nil))
+}
+
+func TestCollectLiveIncludesAssuming(t *testing.T) {
+	input := `
+		#if USE_CUSTOM_ALLOCATOR
+		#include "custom_allocator.h"
+		#else
+		#include <memory>
+		#endif
+		#ifndef NO_LOGGING
+		#include "logging.h"
+		#endif
+		#if defined(_WIN32) && USE_CUSTOM_ALLOCATOR
+		#include "windows_allocator.h"
+		#endif
+		#define LOCAL 0
+		#if LOCAL
+		#include "local.h"
+		#endif
+	`
+	tests := []struct {
+		name      string
+		env       Environment
+		undefined collections.Set[string]
+		want      []string
+	}{
+		{
+			name: "no assumptions",
+			want: []string{"custom_allocator.h", "memory", "logging.h", "windows_allocator.h", "local.h"},
+		},
+		{
+			name: "defined macro selects branch",
+			env:  Environment{"USE_CUSTOM_ALLOCATOR": 1},
+			want: []string{"custom_allocator.h", "logging.h", "windows_allocator.h", "local.h"},
+		},
+		{
+			name: "macro defined as zero",
+			env:  Environment{"USE_CUSTOM_ALLOCATOR": 0, "NO_LOGGING": 1},
+			want: []string{"memory", "local.h"},
+		},
+		{
+			name:      "undefined macro",
+			undefined: collections.SetOf("USE_CUSTOM_ALLOCATOR", "NO_LOGGING"),
+			want:      []string{"memory", "logging.h", "local.h"},
+		},
+		{
+			name:      "macros modified in source are ignored",
+			env:       Environment{"LOCAL": 1},
+			undefined: collections.SetOf("LOCAL"),
+			want:      []string{"custom_allocator.h", "memory", "logging.h", "windows_allocator.h", "local.h"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, include := range ParseSource([]byte(input)).CollectLiveIncludesAssuming(tc.env, tc.undefined) {
+				got = append(got, include.Path)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestConditionMacros(t *testing.T) {
+	input := `
+		#if USE_CUSTOM_ALLOCATOR && VERSION >= 2
+		#include "custom_allocator.h"
+		#elif !defined(NO_LOGGING) || HAS_FEATURE(logging)
+		#include "logging.h"
+		#endif
+		#ifdef _WIN32
+		#  ifndef WIN32_LEAN_AND_MEAN
+		#  define WIN32_LEAN_AND_MEAN
+		#  endif
+		#include <windows.h>
+		#endif
+	`
+	assert.Equal(t,
+		collections.SetOf("USE_CUSTOM_ALLOCATOR", "VERSION", "NO_LOGGING", "_WIN32"),
+		ParseSource([]byte(input)).ConditionMacros())
+}
+
+func TestCollectMacroIncludeHints(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []IncludeDirective
+	}{
+		{
+			name: "quoted and bracketed headers",
+			input: `
+				#define MY_DEPS "a.h" <lib/b.hpp>
+			`,
+			want: []IncludeDirective{
+				{Path: "a.h", LineNumber: 2},
+				{Path: "lib/b.hpp", IsSystem: true, LineNumber: 2},
+			},
+		},
+		{
+			name: "nested in conditional blocks",
+			input: `
+				#ifdef FOO
+				#define FOO_DEPS "foo.h"
+				#else
+				#define FOO_DEPS "no_foo.h"
+				#endif
+			`,
+			want: []IncludeDirective{
+				{Path: "foo.h", LineNumber: 3},
+				{Path: "no_foo.h", LineNumber: 5},
+			},
+		},
+		{
+			name: "tokens not looking like headers",
+			input: `
+				#define VERSION "1.2.3"
+				#define MESSAGE "see config.h for details"
+				#define GREATER(a, b) ((a) > (b))
+				#define NAME "data.json"
+				#define EMPTY
+			`,
+			want: nil,
+		},
+		{
+			name: "includes are not hints",
+			input: `
+				#include "a.h"
+				#define A_H
+			`,
+			want: nil,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, ParseSource([]byte(tc.input)).CollectMacroIncludeHints())
+		})
+	}
+}
@@ -43,6 +43,11 @@ func (p Platform) String() string {
 	return fmt.Sprintf("%s/%s", p.OS, p.Arch)
 }
 
+// Returns true if the platform targets Windows, e.g. can compile Windows resource scripts
+func (p Platform) IsWindows() bool {
+	return p.OS == windows
+}
+
 // Orders first by OS, then by Arch based on the string ordering
 func Compare(a, b Platform) int {
 	if d := cmp.Compare(a.OS, b.OS); d != 0 {
@@ -319,6 +324,18 @@ func init() {
 	addMacro("__riscv", archOsPlatforms(riscv64, riscvOS))
 }
 
+// IsKnownPlatformMacro returns true if the macro is defined by any of the well
+// known platform environments, e.g. _WIN32 or __linux__. The macro is known to
+// be undefined on other platforms.
+func IsKnownPlatformMacro(name string) bool {
+	for _, env := range KnownPlatformEnv {
+		if _, defined := env[name]; defined {
+			return true
+		}
+	}
+	return false
+}
+
 // addMacro adds a single macro to every platform in the list.
 func addMacroValue(name string, value int, platforms []Platform) {
 	for _, platform := range platforms {